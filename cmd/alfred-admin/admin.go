@@ -0,0 +1,545 @@
+package main
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/handler"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"alfred-bot/utils/clock"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// usageError means the command line was wrong, rather than the command failing.
+type usageError struct {
+	reason string
+}
+
+func (e *usageError) Error() string {
+	return e.reason
+}
+
+type command struct {
+	usage   string
+	summary string
+	run     func(a *admin, args []string) error
+	// readOnly commands don't take -dry-run.
+	readOnly bool
+}
+
+// commands is filled in by init, as the commands themselves refer to it.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"list":        {usage: "list [-json] [-channel <id>]", summary: "List rotas, in every channel or just one", run: (*admin).list, readOnly: true},
+		"show":        {usage: "show [-json] <channel> <rota>", summary: "Show everything stored about a rota", run: (*admin).show, readOnly: true},
+		"create":      {usage: "create [-json] [-dry-run] -members <ids> [-owners <ids>] [-duration <weeks>] <channel> <rota>", summary: "Create a rota", run: (*admin).create},
+		"update":      {usage: "update [-json] [-dry-run] [-members <ids>] [-owners <ids>] [-duration <weeks>] <channel> <rota>", summary: "Change a rota's members, owners or shift length", run: (*admin).update},
+		"delete":      {usage: "delete [-json] [-dry-run] <channel> <rota>", summary: "Delete a rota", run: (*admin).delete},
+		"handover":    {usage: "handover [-json] [-dry-run] [-to <id>] <channel> <rota>", summary: "Start a new shift now, for the next member or someone else", run: (*admin).handover},
+		"export":      {usage: "export [-channel <id>]", summary: "Print rotas as JSON, including secrets, for import", run: (*admin).export, readOnly: true},
+		"import":      {usage: "import [-json] [-dry-run] <file>", summary: "Create or update rotas from an export; - reads stdin", run: (*admin).importRotas},
+		"definitions": {usage: "definitions [-json] [-channel <id>]", summary: "Print rotas as a rota file, for plan and apply", run: (*admin).definitions, readOnly: true},
+		"plan":        {usage: "plan [-json] [-prune] <file>", summary: "Show what apply would change; - reads stdin", run: (*admin).planRotas, readOnly: true},
+		"convert":     {usage: "convert -from pagerduty|opsgenie -channel <id> [-json] [-users <file>] <file>", summary: "Turn a PagerDuty or Opsgenie schedule export into a rota file", run: (*admin).convert, readOnly: true},
+		"apply":       {usage: "apply [-json] [-dry-run] [-prune] <file>", summary: "Make the store match a rota file; - reads stdin", run: (*admin).applyRotas},
+	}
+}
+
+var order = []string{"list", "show", "create", "update", "delete", "handover", "export", "import", "definitions", "plan", "apply", "convert"}
+
+// admin runs maintenance commands directly against the store, without going
+// through Slack. A running bot picks up changes at its next handover or
+// periodic sweep.
+type admin struct {
+	handler handler.CommandHandler
+	clock   clock.Clock
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+	// users looks up email addresses in rota files. It's nil without a Slack
+	// token.
+	users  userDirectory
+	emails map[string]string
+}
+
+// options are the flags that every command takes.
+type options struct {
+	json   bool
+	dryRun bool
+}
+
+// change reports what a command did, or would do with -dry-run.
+type change struct {
+	Action    string      `json:"action"`
+	ChannelId string      `json:"channel_id"`
+	Name      string      `json:"name"`
+	DryRun    bool        `json:"dry_run"`
+	Rota      *rotaRecord `json:"rota,omitempty"`
+	// Changes are set by plan and apply.
+	Changes []fieldChange `json:"changes,omitempty"`
+}
+
+const (
+	actionCreate    = "create"
+	actionUpdate    = "update"
+	actionDelete    = "delete"
+	actionHandover  = "handover"
+	actionUnchanged = "unchanged"
+)
+
+var actionText = map[string][2]string{
+	actionCreate:    {"Created", "Would create"},
+	actionUpdate:    {"Updated", "Would update"},
+	actionDelete:    {"Deleted", "Would delete"},
+	actionHandover:  {"Handed over", "Would hand over"},
+	actionUnchanged: {"Unchanged", "Unchanged"},
+}
+
+func usage() string {
+	lines := []string{
+		"Usage: alfred-admin [config flags] <command> [flags] [args]",
+		"",
+		"Config flags are the same as the bot's; run alfred-admin -h to list them.",
+		"",
+		"Commands:",
+	}
+	for _, name := range order {
+		lines = append(lines, fmt.Sprintf("  %s\n        %s", commands[name].usage, commands[name].summary))
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func (a *admin) run(args []string) error {
+	if len(args) == 0 {
+		return &usageError{reason: "No command given."}
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return &usageError{reason: fmt.Sprintf("Unknown command %q.", args[0])}
+	}
+
+	return cmd.run(a, args[1:])
+}
+
+// parse reads a command's flags and checks it was given the expected number of
+// arguments.
+func (a *admin) parse(fs *flag.FlagSet, args []string, wantArgs int) (*options, error) {
+	opts := &options{}
+	fs.SetOutput(a.stderr)
+	fs.BoolVar(&opts.json, "json", false, "print JSON")
+	if !commands[fs.Name()].readOnly {
+		fs.BoolVar(&opts.dryRun, "dry-run", false, "show what would change without changing it")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, &usageError{reason: err.Error()}
+	}
+
+	if fs.NArg() != wantArgs {
+		return nil, &usageError{reason: fmt.Sprintf("Usage: alfred-admin %s", commands[fs.Name()].usage)}
+	}
+
+	return opts, nil
+}
+
+func (a *admin) list(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	channelId := fs.String("channel", "", "only list this channel's rotas")
+	opts, err := a.parse(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	records, err := a.records(*channelId)
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		return a.printJSON(records)
+	}
+
+	w := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANNEL\tROTA\tMEMBERS\tON CALL\tUNTIL")
+	for _, v := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", v.ChannelId, v.Name, strings.Join(v.Members, ","), orDash(v.OnCallMember), orDash(v.EndOfShift))
+	}
+
+	return w.Flush()
+}
+
+func (a *admin) show(args []string) error {
+	fs := flag.NewFlagSet("show", flag.ContinueOnError)
+	opts, err := a.parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	rotaDetails, err := a.getRota(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	record := newRotaRecord(rotaDetails)
+	if opts.json {
+		return a.printJSON(record)
+	}
+
+	alerts := "-"
+	if record.RoutingKey != "" {
+		alerts = "routing key set"
+	}
+
+	onCall := "no one"
+	if record.OnCallMember != "" {
+		onCall = fmt.Sprintf("%s from %s until %s", record.OnCallMember, record.StartOfShift, record.EndOfShift)
+	}
+	if record.OverriddenOnCallMember != "" {
+		onCall += fmt.Sprintf(", covering for %s", record.OverriddenOnCallMember)
+	}
+
+	w := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Channel:\t%s\n", record.ChannelId)
+	fmt.Fprintf(w, "Rota:\t%s\n", record.Name)
+	fmt.Fprintf(w, "Members:\t%s\n", orDash(strings.Join(record.Members, ", ")))
+	fmt.Fprintf(w, "Owners:\t%s\n", orDash(strings.Join(record.Owners, ", ")))
+	fmt.Fprintf(w, "Tiers:\t%s\n", orDash(strings.Join(record.Tiers, ", ")))
+	fmt.Fprintf(w, "Shift length:\t%d week(s)\n", record.DurationWeeks)
+	fmt.Fprintf(w, "Handover anchor:\t%s\n", orDash(record.HandoverAnchor))
+	fmt.Fprintf(w, "On call:\t%s\n", onCall)
+	fmt.Fprintf(w, "Alerts:\t%s\n", alerts)
+	fmt.Fprintf(w, "Webhooks:\t%d\n", len(record.Webhooks))
+
+	return w.Flush()
+}
+
+func (a *admin) create(args []string) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	members := fs.String("members", "", "comma-separated Slack user IDs, in rota order")
+	owners := fs.String("owners", "", "comma-separated Slack user IDs")
+	duration := fs.Int("duration", 1, "shift length in weeks")
+	opts, err := a.parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	existing, err := a.handler.GetRotaDetails(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	if existing != nil {
+		return fmt.Errorf("there's already a rota called %s in %s", fs.Arg(1), fs.Arg(0))
+	}
+
+	record := &rotaRecord{
+		ChannelId:     fs.Arg(0),
+		Name:          fs.Arg(1),
+		Members:       splitIds(*members),
+		Owners:        splitIds(*owners),
+		DurationWeeks: *duration,
+	}
+
+	return a.apply(opts, actionCreate, record, nil)
+}
+
+func (a *admin) update(args []string) error {
+	fs := flag.NewFlagSet("update", flag.ContinueOnError)
+	members := fs.String("members", "", "comma-separated Slack user IDs, in rota order")
+	owners := fs.String("owners", "", "comma-separated Slack user IDs; empty to remove them all")
+	duration := fs.Int("duration", 0, "shift length in weeks")
+	opts, err := a.parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	rotaDetails, err := a.getRota(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	existing := newRotaRecord(rotaDetails)
+	record := *existing
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "members":
+			record.Members = splitIds(*members)
+		case "owners":
+			record.Owners = splitIds(*owners)
+		case "duration":
+			record.DurationWeeks = *duration
+		}
+	})
+
+	return a.apply(opts, actionUpdate, &record, existing)
+}
+
+func (a *admin) delete(args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	opts, err := a.parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	rotaDetails, err := a.getRota(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	if !opts.dryRun {
+		err = a.handler.DeleteRota(rotaDetails.Pk, rotaDetails.RotaName())
+		if err != nil {
+			return err
+		}
+	}
+
+	return a.report(opts, []change{{Action: actionDelete, ChannelId: rotaDetails.Pk, Name: rotaDetails.RotaName(), DryRun: opts.dryRun}})
+}
+
+func (a *admin) handover(args []string) error {
+	fs := flag.NewFlagSet("handover", flag.ContinueOnError)
+	to := fs.String("to", "", "Slack user ID to hand over to (default the next member)")
+	opts, err := a.parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	rotaDetails, err := a.getRota(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	onCallMember := *to
+	switch {
+	case onCallMember == "" && rotaDetails.CurrOnCallMember == "":
+		return fmt.Errorf("%s isn't running, so pass -to to say who should start it", rotaDetails.RotaName())
+	case onCallMember == "":
+		onCallMember = rotaDetails.NextOnCallMember()
+	case !rotaDetails.HasMember(onCallMember):
+		return fmt.Errorf("%s isn't a member of %s", onCallMember, rotaDetails.RotaName())
+	}
+
+	if rotaDetails.Duration < 1 {
+		return fmt.Errorf("%s has no shift length; set one with update -duration", rotaDetails.RotaName())
+	}
+
+	startOfShift := a.clock.Now()
+	endOfShift := rotaDetails.EndOfFirstShift(startOfShift)
+
+	existing := newRotaRecord(rotaDetails)
+	record := *existing
+	record.OnCallMember = onCallMember
+	record.OverriddenOnCallMember = ""
+	record.StartOfShift = startOfShift.Format(time.RFC3339)
+	record.EndOfShift = endOfShift.Format(time.RFC3339)
+
+	return a.apply(opts, actionHandover, &record, existing)
+}
+
+func (a *admin) export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	channelId := fs.String("channel", "", "only export this channel's rotas")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	records, err := a.records(*channelId)
+	if err != nil {
+		return err
+	}
+
+	return a.printJSON(records)
+}
+
+func (a *admin) importRotas(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	opts, err := a.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	input := a.stdin
+	if fs.Arg(0) != "-" {
+		file, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	var records []*rotaRecord
+	decoder := json.NewDecoder(input)
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&records); err != nil {
+		return fmt.Errorf("could not read %s: %w", fs.Arg(0), err)
+	}
+
+	// Check everything before writing anything, so a bad file changes nothing.
+	var errs []error
+	seen := map[[2]string]bool{}
+	for _, v := range records {
+		errs = append(errs, v.validate())
+
+		key := [2]string{v.ChannelId, v.Name}
+		if seen[key] {
+			errs = append(errs, fmt.Errorf("%s in %s appears more than once", v.Name, v.ChannelId))
+		}
+		seen[key] = true
+	}
+
+	if err = errors.Join(errs...); err != nil {
+		return err
+	}
+
+	var changes []change
+	for _, v := range records {
+		rotaDetails, err := a.handler.GetRotaDetails(v.ChannelId, v.Name)
+		if err != nil {
+			return err
+		}
+
+		normalise(v)
+		c := change{Action: actionCreate, ChannelId: v.ChannelId, Name: v.Name, DryRun: opts.dryRun, Rota: v}
+		var existing *rotaRecord
+		if rotaDetails != nil {
+			existing = newRotaRecord(rotaDetails)
+			c.Action = actionUpdate
+			if reflect.DeepEqual(v, existing) {
+				c.Action = actionUnchanged
+			}
+		}
+
+		if !opts.dryRun && c.Action != actionUnchanged {
+			err = v.save(a.handler, existing)
+			if err != nil {
+				return err
+			}
+		}
+
+		changes = append(changes, c)
+	}
+
+	return a.report(opts, changes)
+}
+
+// apply validates and saves a record, unless this is a dry run, and reports
+// what changed.
+func (a *admin) apply(opts *options, action string, record *rotaRecord, existing *rotaRecord) error {
+	if err := record.validate(); err != nil {
+		return err
+	}
+
+	normalise(record)
+	if existing != nil && reflect.DeepEqual(record, existing) {
+		action = actionUnchanged
+	}
+
+	if !opts.dryRun && action != actionUnchanged {
+		err := record.save(a.handler, existing)
+		if err != nil {
+			return err
+		}
+	}
+
+	return a.report(opts, []change{{Action: action, ChannelId: record.ChannelId, Name: record.Name, DryRun: opts.dryRun, Rota: record}})
+}
+
+func (a *admin) report(opts *options, changes []change) error {
+	if opts.json {
+		if changes == nil {
+			changes = []change{}
+		}
+		return a.printJSON(changes)
+	}
+
+	for _, v := range changes {
+		text := actionText[v.Action][0]
+		if v.DryRun {
+			text = actionText[v.Action][1]
+		}
+
+		line := fmt.Sprintf("%s %s in %s", text, v.Name, v.ChannelId)
+		if v.Action == actionHandover {
+			line += fmt.Sprintf(": %s is on call until %s", v.Rota.OnCallMember, v.Rota.EndOfShift)
+		}
+		fmt.Fprintln(a.stdout, line)
+		for _, c := range v.Changes {
+			fmt.Fprintf(a.stdout, "    %s: %s -> %s\n", c.Field, orDash(c.From), orDash(c.To))
+		}
+	}
+
+	return nil
+}
+
+func (a *admin) records(channelId string) ([]*rotaRecord, error) {
+	rotas, err := a.handler.GetRotas()
+	if err != nil {
+		return nil, err
+	}
+
+	records := []*rotaRecord{}
+	for _, v := range rotas {
+		if channelId == "" || v.Pk == channelId {
+			records = append(records, newRotaRecord(v))
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].ChannelId != records[j].ChannelId {
+			return records[i].ChannelId < records[j].ChannelId
+		}
+		return records[i].Name < records[j].Name
+	})
+
+	return records, nil
+}
+
+func (a *admin) getRota(channelId string, rotaName string) (*rotadetails.RotaDetails, error) {
+	rotaDetails, err := a.handler.GetRotaDetails(channelId, rotaName)
+	if err != nil {
+		return nil, err
+	}
+
+	if rotaDetails == nil {
+		return nil, fmt.Errorf("there's no rota called %s in %s", rotaName, channelId)
+	}
+
+	return rotaDetails, nil
+}
+
+func (a *admin) printJSON(v interface{}) error {
+	encoder := json.NewEncoder(a.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func splitIds(ids string) []string {
+	var split []string
+	for _, v := range strings.Split(ids, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			split = append(split, v)
+		}
+	}
+
+	return split
+}
+
+func orDash(text string) string {
+	if text == "" {
+		return "-"
+	}
+
+	return text
+}
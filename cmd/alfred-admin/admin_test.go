@@ -0,0 +1,186 @@
+package main
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/handler"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/webhook"
+	"alfred-bot/utils/clock"
+	"bytes"
+	"encoding/json"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdmin(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Admin Suite")
+}
+
+var _ = Describe("Admin", func() {
+	var store *handler.MemoryHandler
+	var stdin *bytes.Buffer
+	var stdout *bytes.Buffer
+	var a *admin
+
+	now := time.Date(2022, time.March, 7, 9, 0, 0, 0, time.UTC)
+
+	run := func(args ...string) error {
+		stdout.Reset()
+		return a.run(args)
+	}
+
+	changes := func() []change {
+		var c []change
+		Expect(json.Unmarshal(stdout.Bytes(), &c)).To(Succeed())
+		return c
+	}
+
+	BeforeEach(func() {
+		fakeClock := clock.NewFake(now)
+		store = handler.NewMemoryHandler(fakeClock)
+		stdin = &bytes.Buffer{}
+		stdout = &bytes.Buffer{}
+		a = &admin{handler: store, clock: fakeClock, stdin: stdin, stdout: stdout, stderr: &bytes.Buffer{}}
+
+		Expect(store.SaveRotaDetails("C1", "payments", []string{"U1", "U2", "U3"}, "1")).To(Succeed())
+		Expect(store.SaveRotaDetails("C2", "search", []string{"U4"}, "2")).To(Succeed())
+	})
+
+	It("Lists and shows rotas", func() {
+		Expect(run("list")).To(Succeed())
+		Expect(stdout.String()).To(ContainSubstring("C1       payments  U1,U2,U3  -"))
+
+		Expect(run("list", "-json", "-channel", "C2")).To(Succeed())
+		var records []rotaRecord
+		Expect(json.Unmarshal(stdout.Bytes(), &records)).To(Succeed())
+		Expect(records).To(Equal([]rotaRecord{{ChannelId: "C2", Name: "search", Members: []string{"U4"}, DurationWeeks: 2}}))
+
+		Expect(run("show", "C1", "payments")).To(Succeed())
+		Expect(stdout.String()).To(MatchRegexp(`On call:\s+no one`))
+
+		Expect(run("show", "C1", "missing")).To(MatchError("there's no rota called missing in C1"))
+	})
+
+	It("Creates and updates rotas, changing nothing on a dry run", func() {
+		Expect(run("create", "-dry-run", "-members", "U5, U6", "C3", "infra")).To(Succeed())
+		Expect(stdout.String()).To(Equal("Would create infra in C3\n"))
+		Expect(store.GetRotaDetails("C3", "infra")).To(BeNil())
+
+		Expect(run("create", "-members", "U5,U6", "-owners", "U5", "C3", "infra")).To(Succeed())
+		Expect(stdout.String()).To(Equal("Created infra in C3\n"))
+		Expect(run("create", "-members", "U5", "C3", "infra")).To(MatchError("there's already a rota called infra in C3"))
+
+		Expect(run("update", "-json", "-duration", "3", "C3", "infra")).To(Succeed())
+		c := changes()
+		Expect(c).To(HaveLen(1))
+		Expect(c[0].Action).To(Equal(actionUpdate))
+		Expect(c[0].Rota.Owners).To(Equal([]string{"U5"}))
+
+		rotaDetails, _ := store.GetRotaDetails("C3", "infra")
+		Expect(rotaDetails.Members).To(Equal([]string{"U5", "U6"}))
+		Expect(rotaDetails.Duration).To(Equal(3))
+
+		Expect(run("update", "-duration", "3", "C3", "infra")).To(Succeed())
+		Expect(stdout.String()).To(Equal("Unchanged infra in C3\n"))
+
+		err := run("update", "-members", "", "C3", "infra")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("members must not be empty"))
+	})
+
+	It("Deletes rotas", func() {
+		Expect(run("delete", "-dry-run", "C1", "payments")).To(Succeed())
+		Expect(store.GetRotaDetails("C1", "payments")).ToNot(BeNil())
+
+		Expect(run("delete", "C1", "payments")).To(Succeed())
+		Expect(stdout.String()).To(Equal("Deleted payments in C1\n"))
+		Expect(store.GetRotaDetails("C1", "payments")).To(BeNil())
+	})
+
+	It("Hands over to the next member, or to someone chosen", func() {
+		Expect(run("handover", "C1", "payments")).To(MatchError("payments isn't running, so pass -to to say who should start it"))
+		Expect(run("handover", "-to", "U9", "C1", "payments")).To(MatchError("U9 isn't a member of payments"))
+
+		Expect(run("handover", "-to", "U1", "C1", "payments")).To(Succeed())
+		Expect(stdout.String()).To(Equal("Handed over payments in C1: U1 is on call until 2022-03-14T09:00:00Z\n"))
+
+		Expect(run("handover", "C1", "payments")).To(Succeed())
+		rotaDetails, _ := store.GetRotaDetails("C1", "payments")
+		Expect(rotaDetails.CurrOnCallMember).To(Equal("U2"))
+	})
+
+	It("Imports its own export without changing anything", func() {
+		Expect(run("handover", "-to", "U2", "C1", "payments")).To(Succeed())
+		Expect(store.OverrideOnCallMember("C1", "payments", "U3", "U2")).To(Succeed())
+		Expect(store.SaveRoutingKey("C1", "payments", "key")).To(Succeed())
+		Expect(store.SaveWebhooks("C1", "payments", []webhook.Webhook{{URL: "https://example.com/hook", Secret: "s3cret"}})).To(Succeed())
+
+		Expect(run("export")).To(Succeed())
+		Expect(stdout.String()).To(ContainSubstring(`"secret": "s3cret"`))
+		stdin.WriteString(stdout.String())
+
+		Expect(run("import", "-json", "-")).To(Succeed())
+		c := changes()
+		Expect(c).To(HaveLen(2))
+		Expect(c[0].Action).To(Equal(actionUnchanged))
+		Expect(c[1].Action).To(Equal(actionUnchanged))
+	})
+
+	It("Clears a routing key the import doesn't have", func() {
+		Expect(run("export", "-channel", "C1")).To(Succeed())
+		stdin.WriteString(stdout.String())
+		Expect(store.SaveRoutingKey("C1", "payments", "key")).To(Succeed())
+
+		Expect(run("import", "-")).To(Succeed())
+		rotaDetails, _ := store.GetRotaDetails("C1", "payments")
+		Expect(rotaDetails.RoutingKey).To(BeEmpty())
+	})
+
+	It("Restores an export into an empty store", func() {
+		Expect(run("handover", "-to", "U2", "C1", "payments")).To(Succeed())
+		Expect(store.OverrideOnCallMember("C1", "payments", "U3", "U2")).To(Succeed())
+		Expect(run("export", "-channel", "C1")).To(Succeed())
+		exported := stdout.String()
+
+		Expect(run("delete", "C1", "payments")).To(Succeed())
+		stdin.WriteString(exported)
+		Expect(run("import", "-")).To(Succeed())
+		Expect(stdout.String()).To(Equal("Created payments in C1\n"))
+
+		rotaDetails, _ := store.GetRotaDetails("C1", "payments")
+		Expect(rotaDetails.CurrOnCallMember).To(Equal("U3"))
+		Expect(rotaDetails.OverriddenOnCallMember).To(Equal("U2"))
+		Expect(rotaDetails.EndOfShift).To(Equal("Mon, 14 Mar 2022 09:00:00 +0000"))
+	})
+
+	It("Rejects a bad import without changing anything", func() {
+		stdin.WriteString(`[
+			{"channel_id": "C1", "name": "payments", "members": ["U1"], "duration_weeks": 1},
+			{"channel_id": "C1", "name": "payments", "members": [], "duration_weeks": 0, "on_call_member": "U1"}
+		]`)
+
+		err := run("import", "-")
+		Expect(err).To(HaveOccurred())
+		for _, v := range []string{"members must not be empty", "duration_weeks must be at least 1", "start_of_shift must be an RFC 3339 time", "appears more than once"} {
+			Expect(err.Error()).To(ContainSubstring(v))
+		}
+
+		rotaDetails, _ := store.GetRotaDetails("C1", "payments")
+		Expect(rotaDetails.Members).To(HaveLen(3))
+
+		stdin.Reset()
+		stdin.WriteString(`[{"channel_id": "C1", "name": "payments", "members": ["U1"], "duration_weeks": 1, "colour": "red"}]`)
+		err = run("import", "-")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring(`unknown field "colour"`))
+	})
+
+	It("Reports usage errors separately", func() {
+		var usageErr *usageError
+		Expect(run("frobnicate")).To(BeAssignableToTypeOf(usageErr))
+		Expect(run("show", "C1")).To(MatchError("Usage: alfred-admin " + commands["show"].usage))
+		Expect(strings.Contains(usage(), "import [-json] [-dry-run] <file>")).To(BeTrue())
+	})
+})
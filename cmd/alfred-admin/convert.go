@@ -0,0 +1,541 @@
+package main
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	hoursPerDay  = 24
+	hoursPerWeek = 7 * hoursPerDay
+)
+
+// layer is a rotation read from another tool's schedule, before it becomes a
+// rota or a rota's tiers.
+type layer struct {
+	name string
+	// members are email addresses, in rota order.
+	members []string
+	// turnHours is how long each member is on call for.
+	turnHours int
+	anchor    time.Time
+	// windows are when the layer is on call, if not all the time.
+	windows []rotadetails.Window
+}
+
+// conversion collects what couldn't be represented while converting.
+type conversion struct {
+	report []string
+}
+
+func (c *conversion) note(rotaName string, format string, a ...interface{}) {
+	c.report = append(c.report, fmt.Sprintf("%s: %s", rotaName, fmt.Sprintf(format, a...)))
+}
+
+func (a *admin) convert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	from := fs.String("from", "", "pagerduty or opsgenie")
+	channelId := fs.String("channel", "", "Slack channel ID for the rotas")
+	usersFile := fs.String("users", "", "PagerDuty users export, for schedules that don't include emails")
+	opts, err := a.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	if *channelId == "" {
+		return &usageError{reason: "-channel is required."}
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	c := &conversion{}
+	var definitions []*rotaDefinition
+	switch *from {
+	case "pagerduty":
+		emails := map[string]string{}
+		if *usersFile != "" {
+			emails, err = readPagerDutyUsers(*usersFile)
+			if err != nil {
+				return err
+			}
+		}
+		definitions, err = c.pagerDuty(data, emails, a.clock.Now())
+	case "opsgenie":
+		definitions, err = c.opsgenie(data, a.clock.Now())
+	default:
+		return &usageError{reason: fmt.Sprintf("Unknown source %q; use pagerduty or opsgenie.", *from)}
+	}
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", fs.Arg(0), err)
+	}
+
+	file := &rotaFile{Rotas: []*rotaDefinition{}}
+	for _, v := range definitions {
+		v.Channel = *channelId
+		a.resolveEmails(c, v)
+		if len(v.Members) == 0 {
+			c.note(v.Name, "has no members who are in Slack, so was skipped")
+			continue
+		}
+		file.Rotas = append(file.Rotas, v)
+	}
+
+	if len(c.report) > 0 {
+		fmt.Fprintln(a.stderr, "Couldn't be represented:")
+		for _, v := range c.report {
+			fmt.Fprintf(a.stderr, "  %s\n", v)
+		}
+	}
+
+	return a.printRotaFile(opts, file)
+}
+
+// resolveEmails swaps email addresses for Slack user IDs. Without a Slack token
+// the emails are kept, and apply looks them up later.
+func (a *admin) resolveEmails(c *conversion, definition *rotaDefinition) {
+	if a.users == nil {
+		return
+	}
+
+	for _, v := range []*[]string{&definition.Members, &definition.Tiers} {
+		var userIds []string
+		for _, email := range *v {
+			userId, err := a.userId(email)
+			if err != nil {
+				c.note(definition.Name, "left out %s, who isn't in Slack (%v)", email, err)
+				continue
+			}
+			userIds = append(userIds, userId)
+		}
+		*v = userIds
+	}
+
+	// Two emails may belong to the same person.
+	definition.Members = unique(definition.Members, nil)
+	definition.Tiers = unique(definition.Tiers, definition.Members)
+}
+
+// definition turns a schedule's layers into a rota. The first layer is the
+// rota, on call when it is; the members of any others become its tiers, as
+// their shifts can't be kept.
+func (c *conversion) definition(name string, timeZone string, layers []*layer) *rotaDefinition {
+	if len(layers) == 0 {
+		c.note(name, "has nothing to import, so was skipped")
+		return nil
+	}
+
+	base := layers[0]
+	definition := &rotaDefinition{Name: name, Members: unique(base.members, nil), DurationWeeks: 1}
+	if len(definition.Members) == 0 {
+		c.note(name, "has no members who could be imported, so was skipped")
+		return nil
+	}
+
+	if !base.anchor.IsZero() {
+		definition.HandoverAnchor = base.anchor.Format(time.RFC3339)
+	}
+
+	switch {
+	case base.turnHours > 0 && base.turnHours%hoursPerWeek == 0:
+		definition.DurationWeeks = base.turnHours / hoursPerWeek
+	case base.turnHours > 0:
+		definition.DurationWeeks = (base.turnHours + hoursPerWeek/2) / hoursPerWeek
+		if definition.DurationWeeks < 1 {
+			definition.DurationWeeks = 1
+		}
+		c.note(name, "hands over every %s, but rotas hand over in whole weeks, so it will hand over every %d week(s)", describeHours(base.turnHours), definition.DurationWeeks)
+	}
+
+	if len(base.windows) > 0 {
+		definition.Windows = base.windows
+		definition.TimeZone = timeZone
+		if _, err := time.LoadLocation(timeZone); err != nil {
+			definition.TimeZone = ""
+			c.note(name, "is in an unknown time zone %q, so its windows are in UTC", timeZone)
+		}
+	}
+
+	for _, v := range layers[1:] {
+		exclude := append(append([]string{}, definition.Members...), definition.Tiers...)
+		definition.Tiers = append(definition.Tiers, unique(v.members, exclude)...)
+		if len(v.windows) > 0 {
+			c.note(name, "%s became escalation tiers, but its shifts and windows weren't kept", v.name)
+		} else {
+			c.note(name, "%s became escalation tiers, but its shifts weren't kept", v.name)
+		}
+	}
+
+	return definition
+}
+
+// unique returns the members in order, without repeats or any in exclude.
+func unique(members []string, exclude []string) []string {
+	seen := map[string]bool{}
+	for _, v := range exclude {
+		seen[v] = true
+	}
+
+	var result []string
+	for _, v := range members {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
+
+func describeHours(hours int) string {
+	switch {
+	case hours%hoursPerWeek == 0:
+		return plural(hours/hoursPerWeek, "week")
+	case hours%hoursPerDay == 0:
+		return plural(hours/hoursPerDay, "day")
+	default:
+		return plural(hours, "hour")
+	}
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// PagerDuty's schedule API, as returned by GET /schedules/{id} or
+// GET /schedules.
+type pagerDutyExport struct {
+	Schedule  *pagerDutySchedule   `json:"schedule"`
+	Schedules []*pagerDutySchedule `json:"schedules"`
+}
+
+type pagerDutySchedule struct {
+	Name     string            `json:"name"`
+	TimeZone string            `json:"time_zone"`
+	Layers   []*pagerDutyLayer `json:"schedule_layers"`
+	Users    []*pagerDutyUser  `json:"users"`
+}
+
+type pagerDutyLayer struct {
+	Name                 string  `json:"name"`
+	End                  *string `json:"end"`
+	RotationVirtualStart string  `json:"rotation_virtual_start"`
+	TurnLengthSeconds    int     `json:"rotation_turn_length_seconds"`
+	Users                []struct {
+		User *pagerDutyUser `json:"user"`
+	} `json:"users"`
+	Restrictions []*pagerDutyRestriction `json:"restrictions"`
+}
+
+type pagerDutyUser struct {
+	Id      string `json:"id"`
+	Summary string `json:"summary"`
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+}
+
+type pagerDutyRestriction struct {
+	Type            string `json:"type"`
+	StartTimeOfDay  string `json:"start_time_of_day"`
+	StartDayOfWeek  int    `json:"start_day_of_week"`
+	DurationSeconds int    `json:"duration_seconds"`
+}
+
+// readPagerDutyUsers reads a GET /users export, mapping user IDs to emails.
+func readPagerDutyUsers(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var export struct {
+		Users []*pagerDutyUser `json:"users"`
+	}
+	if err = json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+
+	emails := map[string]string{}
+	for _, v := range export.Users {
+		emails[v.Id] = v.Email
+	}
+
+	return emails, nil
+}
+
+func (c *conversion) pagerDuty(data []byte, emails map[string]string, now time.Time) ([]*rotaDefinition, error) {
+	var export pagerDutyExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, err
+	}
+
+	schedules := export.Schedules
+	if export.Schedule != nil {
+		schedules = append(schedules, export.Schedule)
+	}
+	if len(schedules) == 0 {
+		return nil, fmt.Errorf("no schedules found")
+	}
+
+	var definitions []*rotaDefinition
+	for _, schedule := range schedules {
+		for _, v := range schedule.Users {
+			if v.Email != "" {
+				emails[v.Id] = v.Email
+			}
+		}
+
+		var layers []*layer
+		for _, v := range schedule.Layers {
+			if v.End != nil && *v.End != "" {
+				if end, err := time.Parse(time.RFC3339, *v.End); err == nil && !end.After(now) {
+					continue
+				}
+				c.note(schedule.Name, "%s stops at %s, but rotas run until they're stopped", v.Name, *v.End)
+			}
+
+			l := &layer{name: v.Name, turnHours: v.TurnLengthSeconds / 3600}
+			if v.TurnLengthSeconds%3600 != 0 {
+				c.note(schedule.Name, "%s hands over every %d seconds, which was rounded to whole hours", v.Name, v.TurnLengthSeconds)
+			}
+
+			anchor, err := time.Parse(time.RFC3339, v.RotationVirtualStart)
+			if err == nil {
+				l.anchor = anchor
+			}
+
+			for _, u := range v.Users {
+				if u.User == nil {
+					continue
+				}
+
+				email := u.User.Email
+				if email == "" {
+					email = emails[u.User.Id]
+				}
+				if email == "" {
+					c.note(schedule.Name, "left out %s, who has no email in the export; pass -users with a PagerDuty users export", pagerDutyName(u.User))
+					continue
+				}
+				l.members = append(l.members, email)
+			}
+
+			for _, r := range v.Restrictions {
+				window, ok := r.window()
+				if !ok {
+					c.note(schedule.Name, "%s has a %s restriction, which was left out", v.Name, r.Type)
+					continue
+				}
+				if window != nil {
+					l.windows = append(l.windows, *window)
+				}
+			}
+
+			layers = append(layers, l)
+		}
+
+		if definition := c.definition(schedule.Name, schedule.TimeZone, layers); definition != nil {
+			definitions = append(definitions, definition)
+		}
+	}
+
+	return definitions, nil
+}
+
+func pagerDutyName(user *pagerDutyUser) string {
+	for _, v := range []string{user.Summary, user.Name} {
+		if v != "" {
+			return fmt.Sprintf("%s (%s)", v, user.Id)
+		}
+	}
+	return user.Id
+}
+
+// window turns the restriction into a window. It returns nil if the
+// restriction doesn't restrict shifts at all, and false if it can't be turned
+// into a window.
+func (r *pagerDutyRestriction) window() (*rotadetails.Window, bool) {
+	t, err := time.Parse("15:04:05", r.StartTimeOfDay)
+	if err != nil {
+		return nil, false
+	}
+	start := t.Hour()*60 + t.Minute()
+	minutes := r.DurationSeconds / 60
+
+	switch r.Type {
+	case "daily_restriction":
+		if minutes >= hoursPerDay*60 {
+			return nil, true
+		}
+		return &rotadetails.Window{Start: clockTime(start), End: clockTime(start + minutes)}, true
+	case "weekly_restriction":
+		if minutes >= hoursPerWeek*60 {
+			return nil, true
+		}
+		// PagerDuty numbers days from Monday, as 1.
+		start += (r.StartDayOfWeek % 7) * hoursPerDay * 60
+		end := (start + minutes) % (hoursPerWeek * 60)
+		return &rotadetails.Window{
+			StartDay: dayName(start),
+			Start:    clockTime(start),
+			EndDay:   dayName(end),
+			End:      clockTime(end),
+		}, true
+	default:
+		return nil, false
+	}
+}
+
+// clockTime formats minutes since the start of a day or week as HH:MM.
+func clockTime(minutes int) string {
+	minutes %= hoursPerDay * 60
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+// dayName names the day of a week, starting on Sunday, that minutes falls on.
+func dayName(minutes int) string {
+	return strings.ToLower(time.Weekday(minutes / (hoursPerDay * 60) % 7).String())
+}
+
+// Opsgenie's schedule API, as returned by GET /v2/schedules/{id}?expand=rotation,
+// or just the rotations from GET /v2/schedules/{id}/rotations.
+type opsgenieSchedule struct {
+	Name      string              `json:"name"`
+	Timezone  string              `json:"timezone"`
+	Rotations []*opsgenieRotation `json:"rotations"`
+}
+
+type opsgenieRotation struct {
+	Name         string `json:"name"`
+	StartDate    string `json:"startDate"`
+	EndDate      string `json:"endDate"`
+	Type         string `json:"type"`
+	Length       int    `json:"length"`
+	Participants []struct {
+		Type     string `json:"type"`
+		Name     string `json:"name"`
+		Username string `json:"username"`
+	} `json:"participants"`
+	TimeRestriction *struct {
+		Type         string                 `json:"type"`
+		Restriction  *opsgenieRestriction   `json:"restriction"`
+		Restrictions []*opsgenieRestriction `json:"restrictions"`
+	} `json:"timeRestriction"`
+}
+
+type opsgenieRestriction struct {
+	StartDay  string `json:"startDay"`
+	EndDay    string `json:"endDay"`
+	StartHour int    `json:"startHour"`
+	StartMin  int    `json:"startMin"`
+	EndHour   int    `json:"endHour"`
+	EndMin    int    `json:"endMin"`
+}
+
+func (c *conversion) opsgenie(data []byte, now time.Time) ([]*rotaDefinition, error) {
+	var export struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, err
+	}
+
+	schedule := &opsgenieSchedule{}
+	if strings.HasPrefix(strings.TrimSpace(string(export.Data)), "[") {
+		if err := json.Unmarshal(export.Data, &schedule.Rotations); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(export.Data, schedule); err != nil {
+		return nil, err
+	}
+
+	if len(schedule.Rotations) == 0 {
+		return nil, fmt.Errorf("no rotations found")
+	}
+	if schedule.Name == "" {
+		schedule.Name = schedule.Rotations[0].Name
+	}
+
+	var layers []*layer
+	for _, v := range schedule.Rotations {
+		if v.EndDate != "" {
+			if end, err := time.Parse(time.RFC3339, v.EndDate); err == nil && !end.After(now) {
+				continue
+			}
+			c.note(schedule.Name, "%s stops at %s, but rotas run until they're stopped", v.Name, v.EndDate)
+		}
+
+		length := v.Length
+		if length < 1 {
+			length = 1
+		}
+
+		l := &layer{name: v.Name}
+		switch v.Type {
+		case "hourly":
+			l.turnHours = length
+		case "daily":
+			l.turnHours = length * hoursPerDay
+		case "weekly":
+			l.turnHours = length * hoursPerWeek
+		default:
+			c.note(schedule.Name, "%s has an unknown rotation type %q, so hands over weekly", v.Name, v.Type)
+		}
+
+		anchor, err := time.Parse(time.RFC3339, v.StartDate)
+		if err == nil {
+			l.anchor = anchor
+		}
+
+		for _, p := range v.Participants {
+			if p.Type != "user" || p.Username == "" {
+				c.note(schedule.Name, "left out %s %s, as only users can be rota members", p.Type, p.Name)
+				continue
+			}
+			l.members = append(l.members, p.Username)
+		}
+
+		if v.TimeRestriction != nil {
+			restrictions := v.TimeRestriction.Restrictions
+			if v.TimeRestriction.Restriction != nil {
+				restrictions = append(restrictions, v.TimeRestriction.Restriction)
+			}
+			for _, r := range restrictions {
+				window := r.window()
+				if err := window.Validate(); err != nil {
+					c.note(schedule.Name, "%s has a restriction that was left out: %v", v.Name, err)
+					continue
+				}
+				l.windows = append(l.windows, window)
+			}
+		}
+
+		layers = append(layers, l)
+	}
+
+	var definitions []*rotaDefinition
+	if definition := c.definition(schedule.Name, schedule.Timezone, layers); definition != nil {
+		definitions = append(definitions, definition)
+	}
+
+	return definitions, nil
+}
+
+func (r *opsgenieRestriction) window() rotadetails.Window {
+	return rotadetails.Window{
+		StartDay: r.StartDay,
+		Start:    fmt.Sprintf("%02d:%02d", r.StartHour, r.StartMin),
+		EndDay:   r.EndDay,
+		End:      fmt.Sprintf("%02d:%02d", r.EndHour, r.EndMin),
+	}
+}
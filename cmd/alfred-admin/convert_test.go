@@ -0,0 +1,220 @@
+package main
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/handler"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"alfred-bot/utils/clock"
+	"bytes"
+	"encoding/json"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("Convert", func() {
+	var directory *fakeDirectory
+	var stdout *bytes.Buffer
+	var stderr *bytes.Buffer
+	var a *admin
+	var dir string
+
+	now := time.Date(2022, time.March, 9, 12, 0, 0, 0, time.UTC)
+
+	pagerDuty := `{
+  "schedule": {
+    "id": "PI7DH85",
+    "name": "Payments",
+    "time_zone": "Europe/London",
+    "schedule_layers": [
+      {
+        "name": "Primary",
+        "end": null,
+        "rotation_virtual_start": "2022-01-03T09:00:00+00:00",
+        "rotation_turn_length_seconds": 604800,
+        "users": [
+          {"user": {"id": "PA", "type": "user_reference", "summary": "Evan"}},
+          {"user": {"id": "PB", "type": "user_reference", "summary": "Sia"}},
+          {"user": {"id": "PC", "type": "user_reference", "summary": "Wai"}}
+        ],
+        "restrictions": [
+          {"type": "weekly_restriction", "start_day_of_week": 1, "start_time_of_day": "09:00:00", "duration_seconds": 604800}
+        ]
+      },
+      {
+        "name": "Business hours backup",
+        "end": null,
+        "rotation_virtual_start": "2022-01-03T09:00:00+00:00",
+        "rotation_turn_length_seconds": 86400,
+        "users": [
+          {"user": {"id": "PB", "type": "user_reference", "summary": "Sia"}},
+          {"user": {"id": "PD", "type": "user_reference", "summary": "Suan"}}
+        ],
+        "restrictions": [
+          {"type": "daily_restriction", "start_time_of_day": "09:00:00", "duration_seconds": 32400}
+        ]
+      },
+      {
+        "name": "Old layer",
+        "end": "2021-06-01T00:00:00+00:00",
+        "rotation_virtual_start": "2021-01-04T09:00:00+00:00",
+        "rotation_turn_length_seconds": 604800,
+        "users": [{"user": {"id": "PE", "type": "user_reference", "summary": "Jon"}}]
+      }
+    ],
+    "users": [
+      {"id": "PA", "summary": "Evan", "email": "evan@example.com"},
+      {"id": "PB", "summary": "Sia", "email": "sia@example.com"}
+    ]
+  }
+}`
+
+	pagerDutyUsers := `{"users": [
+  {"id": "PC", "name": "Wai", "email": "wai@example.com"},
+  {"id": "PD", "name": "Suan", "email": "suan@example.com"}
+]}`
+
+	opsgenie := `{
+  "data": {
+    "name": "Search",
+    "timezone": "Europe/London",
+    "rotations": [
+      {
+        "name": "Weekdays",
+        "startDate": "2022-01-04T08:00:00Z",
+        "type": "daily",
+        "length": 3,
+        "participants": [
+          {"type": "user", "username": "evan@example.com"},
+          {"type": "team", "name": "search-team"},
+          {"type": "user", "username": "nobody@example.com"}
+        ],
+        "timeRestriction": {
+          "type": "weekday-and-time-of-day",
+          "restrictions": [
+            {"startDay": "monday", "startHour": 8, "startMin": 0, "endDay": "friday", "endHour": 18, "endMin": 30}
+          ]
+        }
+      }
+    ]
+  }
+}`
+
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+		return path
+	}
+
+	convert := func(args ...string) *rotaFile {
+		stdout.Reset()
+		stderr.Reset()
+		Expect(a.run(append([]string{"convert", "-json", "-channel", "C1"}, args...))).To(Succeed())
+
+		file := &rotaFile{}
+		Expect(json.Unmarshal(stdout.Bytes(), file)).To(Succeed())
+		return file
+	}
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		fakeClock := clock.NewFake(now)
+		directory = &fakeDirectory{users: map[string]string{
+			"evan@example.com": "U1",
+			"sia@example.com":  "U2",
+			"wai@example.com":  "U3",
+			"suan@example.com": "U4",
+		}}
+		stdout = &bytes.Buffer{}
+		stderr = &bytes.Buffer{}
+		a = &admin{handler: handler.NewMemoryHandler(fakeClock), clock: fakeClock, stdout: stdout, stderr: stderr, users: directory}
+	})
+
+	It("Turns a PagerDuty schedule into a rota with tiers", func() {
+		file := convert("-from", "pagerduty", "-users", write("users.json", pagerDutyUsers), write("schedule.json", pagerDuty))
+		Expect(file.Rotas).To(Equal([]*rotaDefinition{{
+			Channel:        "C1",
+			Name:           "Payments",
+			Members:        []string{"U1", "U2", "U3"},
+			DurationWeeks:  1,
+			HandoverAnchor: "2022-01-03T09:00:00Z",
+			Tiers:          []string{"U4"},
+		}}))
+
+		Expect(stderr.String()).To(Equal("Couldn't be represented:\n" +
+			"  Payments: Business hours backup became escalation tiers, but its shifts and windows weren't kept\n"))
+	})
+
+	It("Reports PagerDuty users without an email", func() {
+		file := convert("-from", "pagerduty", write("schedule.json", pagerDuty))
+		Expect(file.Rotas[0].Members).To(Equal([]string{"U1", "U2"}))
+		Expect(stderr.String()).To(ContainSubstring("Payments: left out Wai (PC), who has no email in the export; pass -users with a PagerDuty users export\n"))
+	})
+
+	It("Turns an Opsgenie rotation into a rota, reporting what doesn't fit", func() {
+		file := convert("-from", "opsgenie", write("schedule.json", opsgenie))
+		Expect(file.Rotas).To(Equal([]*rotaDefinition{{
+			Channel:        "C1",
+			Name:           "Search",
+			Members:        []string{"U1"},
+			DurationWeeks:  1,
+			HandoverAnchor: "2022-01-04T08:00:00Z",
+			Windows:        []rotadetails.Window{{StartDay: "monday", Start: "08:00", EndDay: "friday", End: "18:30"}},
+			TimeZone:       "Europe/London",
+		}}))
+
+		Expect(stderr.String()).To(Equal("Couldn't be represented:\n" +
+			"  Search: left out team search-team, as only users can be rota members\n" +
+			"  Search: hands over every 3 days, but rotas hand over in whole weeks, so it will hand over every 1 week(s)\n" +
+			"  Search: left out nobody@example.com, who isn't in Slack (could not look up nobody@example.com: users_not_found)\n"))
+	})
+
+	It("Turns PagerDuty restrictions into windows", func() {
+		file := convert("-from", "pagerduty", write("schedule.json", `{"schedule": {
+  "name": "Nights",
+  "time_zone": "Europe/London",
+  "schedule_layers": [{
+    "name": "Out of hours",
+    "rotation_turn_length_seconds": 604800,
+    "users": [{"user": {"id": "PA", "email": "evan@example.com"}}],
+    "restrictions": [
+      {"type": "weekly_restriction", "start_day_of_week": 5, "start_time_of_day": "18:00:00", "duration_seconds": 226800},
+      {"type": "daily_restriction", "start_time_of_day": "22:00:00", "duration_seconds": 36000},
+      {"type": "monthly_restriction", "start_time_of_day": "09:00:00", "duration_seconds": 3600}
+    ]
+  }]
+}}`))
+		Expect(file.Rotas[0].Windows).To(Equal([]rotadetails.Window{
+			{StartDay: "friday", Start: "18:00", EndDay: "monday", End: "09:00"},
+			{Start: "22:00", End: "08:00"},
+		}))
+		Expect(file.Rotas[0].TimeZone).To(Equal("Europe/London"))
+		Expect(stderr.String()).To(Equal("Couldn't be represented:\n" +
+			"  Nights: Out of hours has a monthly_restriction restriction, which was left out\n"))
+	})
+
+	It("Skips a rota when none of its members are in Slack", func() {
+		directory.users = map[string]string{}
+		file := convert("-from", "opsgenie", write("schedule.json", opsgenie))
+		Expect(file.Rotas).To(BeEmpty())
+		Expect(stderr.String()).To(ContainSubstring("  Search: left out evan@example.com, who isn't in Slack (could not look up evan@example.com: users_not_found)\n" +
+			"  Search: left out nobody@example.com, who isn't in Slack (could not look up nobody@example.com: users_not_found)\n" +
+			"  Search: has no members who are in Slack, so was skipped\n"))
+	})
+
+	It("Keeps emails for apply to look up without a Slack token", func() {
+		a.users = nil
+		stdout.Reset()
+		Expect(a.run([]string{"convert", "-from", "opsgenie", "-channel", "C1", write("schedule.json", opsgenie)})).To(Succeed())
+		Expect(stdout.String()).To(ContainSubstring("  - evan@example.com\n  - nobody@example.com\n"))
+	})
+
+	It("Rejects unknown sources and missing channels", func() {
+		path := write("schedule.json", opsgenie)
+		var usageErr *usageError
+		Expect(a.run([]string{"convert", "-from", "victorops", "-channel", "C1", path})).To(BeAssignableToTypeOf(usageErr))
+		Expect(a.run([]string{"convert", "-from", "opsgenie", path})).To(MatchError("-channel is required."))
+		Expect(a.run([]string{"convert", "-from", "pagerduty", "-channel", "C1", path})).To(MatchError(ContainSubstring("no schedules found")))
+	})
+})
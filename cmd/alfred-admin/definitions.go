@@ -0,0 +1,394 @@
+package main
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/slack-go/slack"
+	"gopkg.in/yaml.v2"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// rotaFile is the declarative format for keeping rotas in a repo. It describes
+// how each rota runs, but not who is on call right now, nor any secrets, which
+// the bot and apply leave alone. JSON files are read as YAML.
+type rotaFile struct {
+	Rotas []*rotaDefinition `yaml:"rotas" json:"rotas"`
+}
+
+type rotaDefinition struct {
+	Channel string `yaml:"channel" json:"channel"`
+	Name    string `yaml:"name" json:"name"`
+	// Members, Owners and Tiers are Slack user IDs or email addresses.
+	Members        []string `yaml:"members" json:"members"`
+	Owners         []string `yaml:"owners,omitempty" json:"owners,omitempty"`
+	DurationWeeks  int      `yaml:"duration_weeks" json:"duration_weeks"`
+	HandoverAnchor string   `yaml:"handover_anchor,omitempty" json:"handover_anchor,omitempty"`
+	Tiers          []string `yaml:"tiers,omitempty" json:"tiers,omitempty"`
+	// Windows are when the rota is on call, in TimeZone, if not all the time.
+	Windows  []rotadetails.Window `yaml:"windows,omitempty" json:"windows,omitempty"`
+	TimeZone string               `yaml:"time_zone,omitempty" json:"time_zone,omitempty"`
+}
+
+// userDirectory finds Slack users by email, for rota files that name people
+// that way.
+type userDirectory interface {
+	GetUserByEmail(email string) (*slack.User, error)
+}
+
+// fieldChange is one difference between a rota file and the store.
+type fieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+func (a *admin) definitions(args []string) error {
+	fs := flag.NewFlagSet("definitions", flag.ContinueOnError)
+	channelId := fs.String("channel", "", "only print this channel's rotas")
+	opts, err := a.parse(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	records, err := a.records(*channelId)
+	if err != nil {
+		return err
+	}
+
+	file := &rotaFile{Rotas: []*rotaDefinition{}}
+	for _, v := range records {
+		file.Rotas = append(file.Rotas, &rotaDefinition{
+			Channel:        v.ChannelId,
+			Name:           v.Name,
+			Members:        v.Members,
+			Owners:         v.Owners,
+			DurationWeeks:  v.DurationWeeks,
+			HandoverAnchor: v.HandoverAnchor,
+			Tiers:          v.Tiers,
+			Windows:        v.Windows,
+			TimeZone:       v.TimeZone,
+		})
+	}
+
+	return a.printRotaFile(opts, file)
+}
+
+// printRotaFile prints a rota file as YAML, or as JSON with -json.
+func (a *admin) printRotaFile(opts *options, file *rotaFile) error {
+	if opts.json {
+		return a.printJSON(file)
+	}
+
+	out, err := yaml.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.stdout.Write(out)
+	return err
+}
+
+func (a *admin) planRotas(args []string) error {
+	fs := flag.NewFlagSet("plan", flag.ContinueOnError)
+	prune := fs.Bool("prune", false, "also show rotas that apply -prune would delete")
+	opts, err := a.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	opts.dryRun = true
+	return a.applyFile(opts, fs.Arg(0), *prune)
+}
+
+func (a *admin) applyRotas(args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	prune := fs.Bool("prune", false, "delete rotas in the file's channels that the file doesn't mention")
+	opts, err := a.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	return a.applyFile(opts, fs.Arg(0), *prune)
+}
+
+// applyFile makes the store match a rota file, or with -dry-run shows what that
+// would change. Applying the same file twice changes nothing the second time.
+func (a *admin) applyFile(opts *options, path string, prune bool) error {
+	file, err := a.readRotaFile(path)
+	if err != nil {
+		return err
+	}
+
+	// Check and resolve everything before writing anything, so a bad file
+	// changes nothing.
+	var errs []error
+	seen := map[[2]string]bool{}
+	channels := map[string]bool{}
+	for _, v := range file.Rotas {
+		errs = append(errs, a.resolve(v))
+
+		key := [2]string{v.Channel, v.Name}
+		if seen[key] {
+			errs = append(errs, fmt.Errorf("%s in %s appears more than once", v.Name, v.Channel))
+		}
+		seen[key] = true
+		channels[v.Channel] = true
+	}
+
+	if err = errors.Join(errs...); err != nil {
+		return err
+	}
+
+	var changes []change
+	for _, v := range file.Rotas {
+		rotaDetails, err := a.handler.GetRotaDetails(v.Channel, v.Name)
+		if err != nil {
+			return err
+		}
+
+		c, err := a.applyDefinition(opts, v, rotaDetails)
+		if err != nil {
+			return err
+		}
+
+		changes = append(changes, c)
+	}
+
+	if prune {
+		records, err := a.records("")
+		if err != nil {
+			return err
+		}
+
+		for _, v := range records {
+			if !channels[v.ChannelId] || seen[[2]string{v.ChannelId, v.Name}] {
+				continue
+			}
+
+			if !opts.dryRun {
+				err = a.handler.DeleteRota(v.ChannelId, v.Name)
+				if err != nil {
+					return err
+				}
+			}
+
+			changes = append(changes, change{Action: actionDelete, ChannelId: v.ChannelId, Name: v.Name, DryRun: opts.dryRun})
+		}
+	}
+
+	return a.report(opts, changes)
+}
+
+// applyDefinition saves one rota from a file, keeping whatever the file doesn't
+// describe, such as who is on call.
+func (a *admin) applyDefinition(opts *options, definition *rotaDefinition, rotaDetails *rotadetails.RotaDetails) (change, error) {
+	record := &rotaRecord{}
+	var existing *rotaRecord
+	if rotaDetails != nil {
+		existing = newRotaRecord(rotaDetails)
+		*record = *existing
+	}
+
+	record.ChannelId = definition.Channel
+	record.Name = definition.Name
+	record.Members = definition.Members
+	record.Owners = definition.Owners
+	record.Tiers = definition.Tiers
+	record.DurationWeeks = definition.DurationWeeks
+	record.HandoverAnchor = definition.HandoverAnchor
+	record.Windows = definition.Windows
+	record.TimeZone = definition.TimeZone
+	normalise(record)
+
+	// Moving the anchor of a running rota moves its current shift's end onto
+	// the new schedule, otherwise handovers would carry on at the old times.
+	if existing != nil && record.OnCallMember != "" && record.HandoverAnchor != "" && record.HandoverAnchor != existing.HandoverAnchor {
+		anchored := &rotadetails.RotaDetails{Duration: record.DurationWeeks, HandoverAnchor: storedTime(record.HandoverAnchor)}
+		record.EndOfShift = anchored.EndOfFirstShift(a.clock.Now()).Format(time.RFC3339)
+	}
+
+	c := change{Action: actionCreate, ChannelId: record.ChannelId, Name: record.Name, DryRun: opts.dryRun, Changes: diff(existing, record)}
+	if existing != nil {
+		c.Action = actionUpdate
+		if len(c.Changes) == 0 {
+			c.Action = actionUnchanged
+		}
+	}
+
+	if !opts.dryRun && c.Action != actionUnchanged {
+		err := record.save(a.handler, existing)
+		if err != nil {
+			return change{}, err
+		}
+	}
+
+	return c, nil
+}
+
+func (a *admin) readRotaFile(path string) (*rotaFile, error) {
+	input := a.stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &rotaFile{}
+	if err = yaml.UnmarshalStrict(data, file); err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+
+	return file, nil
+}
+
+// resolve checks a definition and replaces any email addresses in it with
+// Slack user IDs.
+func (a *admin) resolve(definition *rotaDefinition) error {
+	var errs []error
+
+	if definition.Channel == "" {
+		errs = append(errs, errors.New("channel is required"))
+	}
+
+	if definition.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+
+	if len(definition.Members) == 0 {
+		errs = append(errs, errors.New("members must not be empty"))
+	}
+
+	if definition.DurationWeeks < 1 {
+		errs = append(errs, errors.New("duration_weeks must be at least 1"))
+	}
+
+	if definition.HandoverAnchor != "" {
+		if _, err := time.Parse(time.RFC3339, definition.HandoverAnchor); err != nil {
+			errs = append(errs, fmt.Errorf("handover_anchor must be an RFC 3339 time, got %q", definition.HandoverAnchor))
+		}
+	}
+
+	errs = append(errs, checkWindows(definition.Windows, definition.TimeZone)...)
+
+	for _, v := range []struct {
+		name  string
+		users *[]string
+	}{{"members", &definition.Members}, {"owners", &definition.Owners}, {"tiers", &definition.Tiers}} {
+		seen := map[string]bool{}
+		for i, u := range *v.users {
+			userId, err := a.userId(u)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			if seen[userId] {
+				errs = append(errs, fmt.Errorf("%s appears more than once in %s", u, v.name))
+			}
+			seen[userId] = true
+			(*v.users)[i] = userId
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s in %s: %w", definition.Name, definition.Channel, errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// userId looks up the Slack user ID for an email address. Anything else is
+// taken to be a user ID already.
+func (a *admin) userId(user string) (string, error) {
+	user = strings.TrimSpace(user)
+	if !strings.Contains(user, "@") {
+		return user, nil
+	}
+
+	if userId, ok := a.emails[user]; ok {
+		return userId, nil
+	}
+
+	if a.users == nil {
+		return "", fmt.Errorf("can't look up %s without SLACK_AUTH_TOKEN", user)
+	}
+
+	slackUser, err := a.users.GetUserByEmail(user)
+	if err != nil {
+		return "", fmt.Errorf("could not look up %s: %w", user, err)
+	}
+
+	if a.emails == nil {
+		a.emails = map[string]string{}
+	}
+	a.emails[user] = slackUser.ID
+
+	return slackUser.ID, nil
+}
+
+// diff lists the differences between a stored rota, which is nil for a new one,
+// and what it's about to become.
+func diff(existing *rotaRecord, record *rotaRecord) []fieldChange {
+	if existing == nil {
+		existing = &rotaRecord{}
+	}
+
+	var changes []fieldChange
+	for _, v := range []struct {
+		field    string
+		from, to interface{}
+	}{
+		{"members", existing.Members, record.Members},
+		{"owners", existing.Owners, record.Owners},
+		{"tiers", existing.Tiers, record.Tiers},
+		{"duration_weeks", existing.DurationWeeks, record.DurationWeeks},
+		{"handover_anchor", existing.HandoverAnchor, record.HandoverAnchor},
+		{"windows", existing.Windows, record.Windows},
+		{"time_zone", existing.TimeZone, record.TimeZone},
+		{"end_of_shift", existing.EndOfShift, record.EndOfShift},
+	} {
+		if !reflect.DeepEqual(v.from, v.to) && !(isEmpty(v.from) && isEmpty(v.to)) {
+			changes = append(changes, fieldChange{Field: v.field, From: describe(v.from), To: describe(v.to)})
+		}
+	}
+
+	return changes
+}
+
+func isEmpty(v interface{}) bool {
+	return reflect.ValueOf(v).IsZero() || reflect.ValueOf(v).Kind() == reflect.Slice && reflect.ValueOf(v).Len() == 0
+}
+
+func describe(v interface{}) string {
+	switch v := v.(type) {
+	case []string:
+		return strings.Join(v, ", ")
+	case []rotadetails.Window:
+		var windows []string
+		for _, w := range v {
+			windows = append(windows, w.String())
+		}
+		return strings.Join(windows, ", ")
+	case int:
+		if v == 0 {
+			return ""
+		}
+		return fmt.Sprint(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
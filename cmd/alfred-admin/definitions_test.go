@@ -0,0 +1,206 @@
+package main
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/handler"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"alfred-bot/utils/clock"
+	"alfred-bot/utils/formatter"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/slack-go/slack"
+	"strings"
+	"time"
+)
+
+type fakeDirectory struct {
+	users   map[string]string
+	lookups int
+}
+
+func (d *fakeDirectory) GetUserByEmail(email string) (*slack.User, error) {
+	d.lookups++
+	userId, ok := d.users[email]
+	if !ok {
+		return nil, fmt.Errorf("users_not_found")
+	}
+	return &slack.User{ID: userId}, nil
+}
+
+var _ = Describe("Rota files", func() {
+	var store *handler.MemoryHandler
+	var directory *fakeDirectory
+	var stdin *bytes.Buffer
+	var stdout *bytes.Buffer
+	var a *admin
+
+	now := time.Date(2022, time.March, 9, 12, 0, 0, 0, time.UTC)
+
+	rotaFile := `
+rotas:
+  - channel: C1
+    name: payments
+    members: [U1, sia@example.com, U3]
+    owners: [U1]
+    duration_weeks: 1
+    handover_anchor: "2022-03-07T09:00:00Z"
+    tiers: [sia@example.com, U9]
+  - channel: C1
+    name: search
+    members: [U4]
+    duration_weeks: 2
+`
+
+	run := func(input string, args ...string) error {
+		stdin.Reset()
+		stdin.WriteString(input)
+		stdout.Reset()
+		return a.run(args)
+	}
+
+	BeforeEach(func() {
+		fakeClock := clock.NewFake(now)
+		store = handler.NewMemoryHandler(fakeClock)
+		directory = &fakeDirectory{users: map[string]string{"sia@example.com": "U2"}}
+		stdin = &bytes.Buffer{}
+		stdout = &bytes.Buffer{}
+		a = &admin{handler: store, clock: fakeClock, stdin: stdin, stdout: stdout, stderr: &bytes.Buffer{}, users: directory}
+
+		Expect(store.SaveRotaDetails("C1", "payments", []string{"U1", "U2"}, "1")).To(Succeed())
+		Expect(store.SaveRotaDetails("C1", "legacy", []string{"U5"}, "1")).To(Succeed())
+		Expect(store.SaveRotaDetails("C2", "infra", []string{"U6"}, "1")).To(Succeed())
+	})
+
+	It("Plans the changes without making them", func() {
+		Expect(run(rotaFile, "plan", "-prune", "-")).To(Succeed())
+		Expect(stdout.String()).To(Equal(`Would update payments in C1
+    members: U1, U2 -> U1, U2, U3
+    owners: - -> U1
+    tiers: - -> U2, U9
+    handover_anchor: - -> 2022-03-07T09:00:00Z
+Would create search in C1
+    members: - -> U4
+    duration_weeks: - -> 2
+Would delete legacy in C1
+`))
+
+		rotaDetails, _ := store.GetRotaDetails("C1", "payments")
+		Expect(rotaDetails.Members).To(Equal([]string{"U1", "U2"}))
+		Expect(store.GetRotaDetails("C1", "search")).To(BeNil())
+	})
+
+	It("Applies a file, and changes nothing when it's applied again", func() {
+		Expect(run(rotaFile, "apply", "-")).To(Succeed())
+		Expect(stdout.String()).To(ContainSubstring("Updated payments in C1\n"))
+		Expect(stdout.String()).To(ContainSubstring("Created search in C1\n"))
+		Expect(directory.lookups).To(Equal(1))
+
+		rotaDetails, _ := store.GetRotaDetails("C1", "payments")
+		Expect(rotaDetails.Members).To(Equal([]string{"U1", "U2", "U3"}))
+		Expect(rotaDetails.Tiers).To(Equal([]string{"U2", "U9"}))
+		Expect(rotaDetails.HandoverAnchor).To(Equal("Mon, 07 Mar 2022 09:00:00 +0000"))
+		Expect(store.GetRotaDetails("C1", "legacy")).ToNot(BeNil())
+
+		Expect(run(rotaFile, "apply", "-json", "-")).To(Succeed())
+		var c []change
+		Expect(json.Unmarshal(stdout.Bytes(), &c)).To(Succeed())
+		Expect(c).To(HaveLen(2))
+		Expect(c[0].Action).To(Equal(actionUnchanged))
+		Expect(c[1].Action).To(Equal(actionUnchanged))
+	})
+
+	It("Applies when a rota is on call, and rejects windows it can't make sense of", func() {
+		windowed := `
+rotas:
+  - channel: C1
+    name: payments
+    members: [U1, U2]
+    duration_weeks: 1
+    windows:
+      - start_day: friday
+        start: "18:00"
+        end_day: monday
+        end: "09:00"
+    time_zone: Europe/London
+`
+		Expect(run(windowed, "apply", "-")).To(Succeed())
+		Expect(stdout.String()).To(ContainSubstring("    windows: - -> Friday 18:00 to Monday 09:00\n    time_zone: - -> Europe/London\n"))
+
+		rotaDetails, _ := store.GetRotaDetails("C1", "payments")
+		Expect(rotaDetails.Windows).To(Equal([]rotadetails.Window{{StartDay: "friday", Start: "18:00", EndDay: "monday", End: "09:00"}}))
+		Expect(rotaDetails.TimeZone).To(Equal("Europe/London"))
+
+		Expect(run(windowed, "apply", "-")).To(Succeed())
+		Expect(stdout.String()).To(Equal("Unchanged payments in C1\n"))
+
+		err := run(strings.NewReplacer("friday", "fri", "Europe/London", "Mars/Olympus").Replace(windowed), "apply", "-")
+		Expect(err).To(MatchError(ContainSubstring(`unknown day "fri"`)))
+		Expect(err).To(MatchError(ContainSubstring(`got "Mars/Olympus"`)))
+	})
+
+	It("Moves a running shift onto a new handover anchor", func() {
+		Expect(store.UpdateOnCallMember("C1", "payments", "U2", formatter.FormatTime(now), formatter.FormatTime(now.AddDate(0, 0, 7)))).To(Succeed())
+
+		Expect(run(rotaFile, "apply", "-")).To(Succeed())
+		Expect(stdout.String()).To(ContainSubstring("    end_of_shift: 2022-03-16T12:00:00Z -> 2022-03-14T09:00:00Z\n"))
+
+		rotaDetails, _ := store.GetRotaDetails("C1", "payments")
+		Expect(rotaDetails.CurrOnCallMember).To(Equal("U2"))
+		Expect(rotaDetails.EndOfShift).To(Equal("Mon, 14 Mar 2022 09:00:00 +0000"))
+	})
+
+	It("Prints the store as a file that applies cleanly", func() {
+		Expect(run("", "definitions", "-channel", "C1")).To(Succeed())
+		Expect(stdout.String()).To(Equal(`rotas:
+- channel: C1
+  name: legacy
+  members:
+  - U5
+  duration_weeks: 1
+- channel: C1
+  name: payments
+  members:
+  - U1
+  - U2
+  duration_weeks: 1
+`))
+
+		definitions := stdout.String()
+		Expect(run(definitions, "plan", "-prune", "-")).To(Succeed())
+		Expect(stdout.String()).To(Equal("Unchanged legacy in C1\nUnchanged payments in C1\n"))
+
+		Expect(run("", "definitions", "-json", "-channel", "C2")).To(Succeed())
+		jsonDefinitions := stdout.String()
+		Expect(run(jsonDefinitions, "plan", "-")).To(Succeed())
+		Expect(stdout.String()).To(Equal("Unchanged infra in C2\n"))
+	})
+
+	It("Rejects a bad file without changing anything", func() {
+		err := run(`
+rotas:
+  - channel: C1
+    name: payments
+    members: [U1, nobody@example.com, U1]
+    duration_weeks: 0
+    handover_anchor: monday
+`, "apply", "-")
+		Expect(err).To(HaveOccurred())
+		for _, v := range []string{"duration_weeks must be at least 1", "handover_anchor must be an RFC 3339 time", "could not look up nobody@example.com", "U1 appears more than once in members"} {
+			Expect(err.Error()).To(ContainSubstring(v))
+		}
+
+		err = run("rotas:\n  - channel: C1\n    colour: red\n", "apply", "-")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("field colour not found"))
+
+		a.users = nil
+		err = run(rotaFile, "apply", "-")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("can't look up sia@example.com without SLACK_AUTH_TOKEN"))
+
+		rotaDetails, _ := store.GetRotaDetails("C1", "payments")
+		Expect(rotaDetails.Members).To(Equal([]string{"U1", "U2"}))
+	})
+})
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/handler"
+	"alfred-bot/cmd/bot/installation"
+	"alfred-bot/config"
+	"alfred-bot/utils/clock"
+	"alfred-bot/utils/db"
+	"alfred-bot/utils/slackclient"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/slack-go/slack"
+	"os"
+)
+
+func main() {
+	cfg, args, err := config.LoadStore("alfred-admin", os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprint(os.Stderr, "\n"+usage())
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage())
+		os.Exit(2)
+	}
+
+	database, err := db.New(cfg.DB)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "alfred-admin: could not connect to the store: %v\n", err)
+		os.Exit(1)
+	}
+
+	c := clock.New()
+	rotas, token := handler.New(database, c), cfg.Slack.AuthToken
+	if cfg.Slack.TeamID != "" {
+		rotas, token, err = forTeam(cfg, database, rotas)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "alfred-admin: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	a := &admin{handler: rotas, clock: c, stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}
+	if token != "" {
+		a.users = slackclient.New(slack.New(token))
+	}
+
+	err = a.run(args)
+	var usageErr *usageError
+	if errors.As(err, &usageErr) {
+		fmt.Fprintf(os.Stderr, "%v\n\n%s", err, usage())
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "alfred-admin: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// forTeam points the CLI at one of the workspaces the bot is installed in, and
+// returns the workspace's bot token. As in the bot, SLACK_AUTH_TOKEN's
+// workspace keeps its unprefixed keys.
+func forTeam(cfg *config.Config, database *db.Database, rotas *handler.RotaHandler) (*handler.RotaHandler, string, error) {
+	if cfg.Slack.AuthToken != "" {
+		auth, err := slack.New(cfg.Slack.AuthToken).AuthTest()
+		if err != nil {
+			return nil, "", fmt.Errorf("could not check SLACK_AUTH_TOKEN: %w", err)
+		}
+
+		if auth.TeamID == cfg.Slack.TeamID {
+			return rotas, cfg.Slack.AuthToken, nil
+		}
+	}
+
+	i, err := installation.New(database).GetInstallation(cfg.Slack.TeamID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if i == nil {
+		return nil, "", fmt.Errorf("the bot isn't installed in team %s", cfg.Slack.TeamID)
+	}
+
+	return rotas.ForTeam(i.TeamId()), i.BotToken, nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/handler"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/webhook"
+	"alfred-bot/utils/formatter"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// rotaRecord is a rota as the admin tool prints, exports and imports it. Unlike
+// the REST API, it keeps everything needed to restore the rota, including its
+// routing key and webhook secrets.
+type rotaRecord struct {
+	ChannelId              string               `json:"channel_id"`
+	Name                   string               `json:"name"`
+	Members                []string             `json:"members"`
+	Owners                 []string             `json:"owners,omitempty"`
+	Tiers                  []string             `json:"tiers,omitempty"`
+	DurationWeeks          int                  `json:"duration_weeks"`
+	HandoverAnchor         string               `json:"handover_anchor,omitempty"`
+	OnCallMember           string               `json:"on_call_member,omitempty"`
+	OverriddenOnCallMember string               `json:"overridden_on_call_member,omitempty"`
+	StartOfShift           string               `json:"start_of_shift,omitempty"`
+	EndOfShift             string               `json:"end_of_shift,omitempty"`
+	RoutingKey             string               `json:"routing_key,omitempty"`
+	Webhooks               []webhook.Webhook    `json:"webhooks,omitempty"`
+	Windows                []rotadetails.Window `json:"windows,omitempty"`
+	TimeZone               string               `json:"time_zone,omitempty"`
+}
+
+func newRotaRecord(rotaDetails *rotadetails.RotaDetails) *rotaRecord {
+	record := &rotaRecord{
+		ChannelId:              rotaDetails.Pk,
+		Name:                   rotaDetails.RotaName(),
+		Members:                rotaDetails.Members,
+		Owners:                 rotaDetails.Owners,
+		Tiers:                  rotaDetails.Tiers,
+		DurationWeeks:          rotaDetails.Duration,
+		HandoverAnchor:         rfc3339(rotaDetails.HandoverAnchor),
+		OnCallMember:           rotaDetails.CurrOnCallMember,
+		OverriddenOnCallMember: rotaDetails.OverriddenOnCallMember,
+		StartOfShift:           rfc3339(rotaDetails.StartOfShift),
+		EndOfShift:             rfc3339(rotaDetails.EndOfShift),
+		RoutingKey:             rotaDetails.RoutingKey,
+		Webhooks:               rotaDetails.Webhooks,
+		Windows:                rotaDetails.Windows,
+		TimeZone:               rotaDetails.TimeZone,
+	}
+	normalise(record)
+
+	return record
+}
+
+// normalise gives records the same shape however they were made, so that a
+// rota read back from the store can be compared with one read from a file.
+func normalise(record *rotaRecord) {
+	if record.Members == nil {
+		record.Members = []string{}
+	}
+	if len(record.Owners) == 0 {
+		record.Owners = nil
+	}
+	if len(record.Tiers) == 0 {
+		record.Tiers = nil
+	}
+	if len(record.Webhooks) == 0 {
+		record.Webhooks = nil
+	}
+	if len(record.Windows) == 0 {
+		record.Windows = nil
+	}
+	// The store keeps times to the second.
+	record.StartOfShift = rfc3339(storedTime(record.StartOfShift))
+	record.EndOfShift = rfc3339(storedTime(record.EndOfShift))
+	record.HandoverAnchor = rfc3339(storedTime(record.HandoverAnchor))
+}
+
+// validate checks that the record describes a rota the bot can run.
+func (r *rotaRecord) validate() error {
+	var errs []error
+
+	if r.ChannelId == "" {
+		errs = append(errs, errors.New("channel_id is required"))
+	}
+
+	if r.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+
+	if len(r.Members) == 0 {
+		errs = append(errs, errors.New("members must not be empty"))
+	}
+
+	if r.DurationWeeks < 1 {
+		errs = append(errs, errors.New("duration_weeks must be at least 1"))
+	}
+
+	if r.HandoverAnchor != "" {
+		if _, err := time.Parse(time.RFC3339, r.HandoverAnchor); err != nil {
+			errs = append(errs, fmt.Errorf("handover_anchor must be an RFC 3339 time, got %q", r.HandoverAnchor))
+		}
+	}
+
+	errs = append(errs, checkWindows(r.Windows, r.TimeZone)...)
+
+	if r.OnCallMember != "" {
+		for _, v := range []struct{ name, value string }{{"start_of_shift", r.StartOfShift}, {"end_of_shift", r.EndOfShift}} {
+			if _, err := time.Parse(time.RFC3339, v.value); err != nil {
+				errs = append(errs, fmt.Errorf("%s must be an RFC 3339 time while someone is on call, got %q", v.name, v.value))
+			}
+		}
+	} else if r.OverriddenOnCallMember != "" || r.StartOfShift != "" || r.EndOfShift != "" {
+		errs = append(errs, errors.New("on_call_member is required when the rota has a shift"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s in %s: %w", r.Name, r.ChannelId, errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// save makes the stored rota match the record, writing only the parts that
+// differ from existing, which may be nil for a new rota.
+func (r *rotaRecord) save(h handler.CommandHandler, existing *rotaRecord) error {
+	if existing == nil {
+		existing = &rotaRecord{}
+	}
+
+	if !reflect.DeepEqual(r.Members, existing.Members) || r.DurationWeeks != existing.DurationWeeks {
+		err := h.SaveRotaDetails(r.ChannelId, r.Name, r.Members, strconv.Itoa(r.DurationWeeks))
+		if err != nil {
+			return err
+		}
+	}
+
+	if !reflect.DeepEqual(r.Owners, existing.Owners) {
+		err := h.SaveRotaOwners(r.ChannelId, r.Name, r.Owners)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !reflect.DeepEqual(r.Tiers, existing.Tiers) {
+		err := h.SaveRotaTiers(r.ChannelId, r.Name, r.Tiers)
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.HandoverAnchor != existing.HandoverAnchor {
+		err := h.SaveHandoverAnchor(r.ChannelId, r.Name, storedTime(r.HandoverAnchor))
+		if err != nil {
+			return err
+		}
+	}
+
+	if !reflect.DeepEqual(r.Windows, existing.Windows) || r.TimeZone != existing.TimeZone {
+		err := h.SaveWindows(r.ChannelId, r.Name, r.TimeZone, r.Windows)
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.OnCallMember != existing.OnCallMember || r.StartOfShift != existing.StartOfShift || r.EndOfShift != existing.EndOfShift ||
+		r.OverriddenOnCallMember != existing.OverriddenOnCallMember {
+		err := h.UpdateOnCallMember(r.ChannelId, r.Name, r.OnCallMember, storedTime(r.StartOfShift), storedTime(r.EndOfShift))
+		if err != nil {
+			return err
+		}
+
+		if r.OverriddenOnCallMember != "" {
+			err = h.OverrideOnCallMember(r.ChannelId, r.Name, r.OnCallMember, r.OverriddenOnCallMember)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if r.RoutingKey != existing.RoutingKey {
+		var err error
+		if r.RoutingKey == "" {
+			err = h.DeleteRoutingKey(r.ChannelId, r.Name)
+		} else {
+			err = h.SaveRoutingKey(r.ChannelId, r.Name, r.RoutingKey)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if !reflect.DeepEqual(r.Webhooks, existing.Webhooks) {
+		err := h.SaveWebhooks(r.ChannelId, r.Name, r.Webhooks)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkWindows returns what's wrong with a rota's windows and time zone.
+func checkWindows(windows []rotadetails.Window, timeZone string) []error {
+	var errs []error
+	for _, v := range windows {
+		if err := v.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if _, err := time.LoadLocation(timeZone); err != nil {
+		errs = append(errs, fmt.Errorf("time_zone must be an IANA time zone, such as Europe/London, got %q", timeZone))
+	}
+
+	return errs
+}
+
+// rfc3339 converts a stored time into the format records use, or returns an
+// empty string if there isn't one.
+func rfc3339(formattedTime string) string {
+	t, err := formatter.ParseTime(formattedTime)
+	if err != nil {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+// storedTime converts a record's time into the format the bot stores.
+func storedTime(rfc3339Time string) string {
+	t, err := time.Parse(time.RFC3339, rfc3339Time)
+	if err != nil {
+		return ""
+	}
+
+	return formatter.FormatTime(t)
+}
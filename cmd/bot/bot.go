@@ -3,157 +3,513 @@ package bot
 import (
 	"alfred-bot/cmd/bot/commands/rotacommand"
 	rotaHandler "alfred-bot/cmd/bot/commands/rotacommand/handler"
+	"alfred-bot/cmd/bot/installation"
+	"alfred-bot/cmd/bot/router"
+	"alfred-bot/config"
+	"alfred-bot/utils/clock"
 	"alfred-bot/utils/db"
-	"alfred-bot/utils/slackclient"
+	"alfred-bot/utils/httpserver"
+	"alfred-bot/utils/logger"
+	"alfred-bot/utils/slackverifier"
+	"alfred-bot/utils/workerpool"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slackevents"
 	"github.com/slack-go/slack/socketmode"
-	"log"
-	"os"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const defaultCommandAckTimeout = 2 * time.Second
+
+// responseClient answers slash commands through their response URLs.
+var responseClient = &http.Client{Timeout: 10 * time.Second}
+
+// newClock makes the bot's clock. Tests replace it to move time on.
+var newClock = clock.New
+
 type Bot struct {
 	socketClient *socketmode.Client
-	rotaCommand  *rotacommand.RotaCommand
+	server       *httpserver.Server
+	router       *router.Router
+	// pool handles requests once they've been received, one at a time for
+	// each rota.
+	pool       *workerpool.Pool
+	rotas      *rotaHandler.RotaHandler
+	clock      clock.Clock
+	rotaConfig config.RotaConfig
+	debug      bool
+	// slackAPIURL is where the Slack Web API is reached, or empty for Slack.
+	slackAPIURL string
+	// multiWorkspace bots can be installed in several workspaces, and route
+	// each event to its workspace's team. Otherwise the only team is
+	// SLACK_AUTH_TOKEN's.
+	multiWorkspace bool
+	installations  installation.Store
+	// defaultTeamId is the workspace of SLACK_AUTH_TOKEN, if it's set. Its
+	// rotas keep the unprefixed keys they had before the bot could be
+	// installed elsewhere.
+	defaultTeamId string
+	teamsMu       sync.Mutex
+	teams         map[string]*team
+	// runCtx is set once the bot starts, for the background tasks of
+	// workspaces it's installed in while running.
+	runCtx          context.Context
+	socketConnected int32
+	tasks           sync.WaitGroup
+	// commandAckTimeout is how long a slash command's answer is waited on
+	// before it's acknowledged without one, well inside Slack's three
+	// seconds.
+	commandAckTimeout time.Duration
+	// shutdownTimeout bounds how long Start waits for in-flight event handlers
+	// and handovers to finish once the bot has been asked to stop.
+	shutdownTimeout time.Duration
 }
 
-func New(token string, appToken string) *Bot {
-	client := slack.New(token, slack.OptionDebug(true), slack.OptionAppLevelToken(appToken))
-	dbHandler := db.New()
-	socketClient := socketmode.New(
-		client,
-		socketmode.OptionDebug(true),
-		socketmode.OptionLog(log.New(os.Stdout, "socketmode: ", log.Lshortfile|log.LstdFlags)),
-	)
+// New builds a bot that talks to Slack over Socket Mode, or over HTTP if the
+// config says so. If an HTTP address is configured, the bot also serves health
+// checks and metrics on it, and the pages that install it in other workspaces
+// if it has an OAuth client.
+func New(cfg *config.Config) (*Bot, error) {
+	botClock := newClock()
+	dbHandler, err := db.New(cfg.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bot{
+		rotas:             rotaHandler.New(dbHandler, botClock),
+		clock:             botClock,
+		rotaConfig:        cfg.Rota,
+		debug:             cfg.Debug,
+		slackAPIURL:       cfg.Slack.APIURL,
+		multiWorkspace:    cfg.Slack.MultiWorkspace(),
+		teams:             map[string]*team{},
+		shutdownTimeout:   cfg.ShutdownTimeout,
+		commandAckTimeout: defaultCommandAckTimeout,
+	}
+	// Rotas saved before they were listed under their members are listed now.
+	err = b.rotas.IndexMemberships()
+	if err != nil {
+		return nil, fmt.Errorf("could not index rota members: %w", err)
+	}
+
+	b.router = b.newRouter()
+	b.pool = workerpool.New(cfg.EventWorkers)
+	client := slack.New(cfg.Slack.AuthToken, append(b.slackOptions(), slack.OptionAppLevelToken(cfg.Slack.AppToken))...)
+
+	// One Socket Mode connection carries events from every workspace the app
+	// is installed in. Over HTTP, Slack sends them to the server instead.
+	if cfg.Slack.Transport == config.TransportSocket {
+		b.socketClient = socketmode.New(
+			client,
+			socketmode.OptionDebug(cfg.Debug),
+			socketmode.OptionLog(logger.Std(slog.Default(), "socketmode")),
+		)
+	}
+
+	if b.multiWorkspace {
+		if cfg.Slack.AuthToken != "" {
+			auth, err := client.AuthTest()
+			if err != nil {
+				return nil, fmt.Errorf("could not check SLACK_AUTH_TOKEN: %w", err)
+			}
+			b.defaultTeamId = auth.TeamID
+		}
+
+		b.installations = installation.New(dbHandler)
+		installations, err := b.installations.GetInstallations()
+		if err != nil {
+			return nil, fmt.Errorf("could not load installations: %w", err)
+		}
 
-	return &Bot{
-		socketClient: socketClient,
-		rotaCommand:  rotacommand.New(rotaHandler.New(dbHandler), slackclient.New(client)),
+		for _, v := range installations {
+			b.addTeam(v)
+		}
+	}
+
+	// SLACK_AUTH_TOKEN serves its workspace, unless installing the bot there
+	// again has stored a newer token.
+	if _, ok := b.teams[b.defaultTeamId]; !ok && cfg.Slack.AuthToken != "" {
+		b.teams[b.defaultTeamId] = b.newTeam(b.defaultTeamId, "", cfg.Slack.AuthToken)
+	}
+
+	if cfg.HTTPAddr != "" {
+		b.server = httpserver.New(cfg.HTTPAddr)
+		b.server.AddReadinessCheck("dynamodb", dbHandler.Ping)
+
+		if b.socketClient != nil {
+			b.server.AddReadinessCheck("slack", b.checkSocketConnected)
+		} else {
+			verifier := slackverifier.New(cfg.Slack.SigningSecret, botClock)
+			b.server.Handle(EventsPath, verifier.Handler(b.eventsHandler()))
+			b.server.Handle(CommandsPath, verifier.Handler(b.commandsHandler()))
+			b.server.Handle(InteractivityPath, verifier.Handler(b.interactivityHandler()))
+		}
+
+		if cfg.Rota.AlertWebhooks {
+			// Routing keys made before they were indexed are indexed now.
+			err := b.rotas.IndexRoutingKeys()
+			if err != nil {
+				return nil, fmt.Errorf("could not index routing keys: %w", err)
+			}
+			b.server.Handle(rotacommand.AlertsPath, b.teamHandler(b.routingKeyTeam, (*rotacommand.RotaCommand).AlertsHandler))
+		}
+
+		if cfg.Rota.RestAPI {
+			// API tokens made before they were indexed are indexed now.
+			err := b.rotas.IndexAPITokens()
+			if err != nil {
+				return nil, fmt.Errorf("could not index API tokens: %w", err)
+			}
+			b.server.Handle(rotacommand.APIPath, b.teamHandler(b.apiTokenTeam, (*rotacommand.RotaCommand).APIHandler))
+		}
+
+		if b.multiWorkspace {
+			oauth := installation.NewOAuth(cfg.Slack, b.installations, botClock, func(i *installation.Installation) {
+				b.addTeam(i)
+			})
+			b.server.Handle(installation.InstallPath, oauth.InstallHandler())
+			b.server.Handle(installation.RedirectPath, oauth.RedirectHandler())
+		}
 	}
+
+	return b, nil
 }
 
-func (b *Bot) Start() {
-	ctx, cancel := context.WithCancel(context.Background())
+// Start connects to Slack and handles events until ctx is cancelled or the
+// connection fails for good. Before returning it stops taking new events and
+// waits a while for work already in progress to finish, so that a
+// restart doesn't drop a handover halfway through.
+func (b *Bot) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	b.listenToEvents(ctx)
-	b.startBackgroundTasks()
+	b.startBackgroundTasks(ctx)
+	b.stopPoolOnShutdown(ctx)
+
+	var err error
+	if b.socketClient != nil {
+		b.startServer(ctx)
+		b.listenToEvents(ctx)
+		err = b.socketClient.RunContext(ctx)
+		if errors.Is(err, context.Canceled) {
+			err = nil
+		}
+	} else {
+		// Slack's requests come in through the HTTP server, so the bot is
+		// only up for as long as it is.
+		err = b.server.Run(ctx)
+	}
+
+	cancel()
+	slog.Info("Shutting down")
+
+	if waitErr := b.waitForTasks(b.shutdownTimeout); waitErr != nil {
+		return waitErr
+	}
+
+	return err
+}
+
+// stopPoolOnShutdown stops the worker pool taking requests once ctx is
+// cancelled, and counts the requests already queued as in-flight work.
+func (b *Bot) stopPoolOnShutdown(ctx context.Context) {
+	b.tasks.Add(1)
+	go func() {
+		defer b.tasks.Done()
+		<-ctx.Done()
+		b.pool.Stop()
+	}()
+}
+
+func (b *Bot) waitForTasks(timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		b.tasks.Wait()
+		close(done)
+	}()
 
-	_ = b.socketClient.Run()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("timed out waiting for in-flight work to finish")
+	}
 }
 
 func (b *Bot) listenToEvents(ctx context.Context) {
+	b.tasks.Add(1)
 	go func() {
+		defer b.tasks.Done()
 		for {
 			select {
 			case <-ctx.Done():
-				log.Println("Shutting down...")
 				return
 			case event := <-b.socketClient.Events:
-				switch event.Type {
-				case socketmode.EventTypeEventsAPI:
-					eventsAPIEvent, ok := event.Data.(slackevents.EventsAPIEvent)
-					if !ok {
-						log.Printf("Could not type cast the event to the EventsAPIEvent: %v\n", event)
-						continue
-					}
-					b.socketClient.Ack(*event.Request)
-
-					err := b.handleEventMessage(eventsAPIEvent)
-					if err != nil {
-						log.Println(err)
-					}
-				case socketmode.EventTypeSlashCommand:
-					command, ok := event.Data.(slack.SlashCommand)
-					if !ok {
-						log.Printf("Could not type cast the message to a SlashCommand: %v\n", command)
-						continue
-					}
-
-					payload, err := b.handleSlashCommand(command)
-					if err != nil {
-						log.Println(err)
-						continue
-					}
-
-					b.socketClient.Ack(*event.Request, payload)
-				case socketmode.EventTypeInteractive:
-					interaction, ok := event.Data.(slack.InteractionCallback)
-					if !ok {
-						log.Printf("Could not type cast the message to a Interaction callback: %v\n", interaction)
-						continue
-					}
-
-					err := b.handleInteractionEvent(interaction)
-					if err != nil {
-						log.Println(err)
-						continue
-					}
-
-					b.socketClient.Ack(*event.Request)
-				}
+				b.handleSocketEvent(event)
 			}
 		}
 	}()
 }
 
-func (b *Bot) startBackgroundTasks() {
-	b.rotaCommand.HandleEndOfOnCallShifts()
+// handleSocketEvent acknowledges every event, command and interaction, even
+// ones that fail or panic, so Slack never shows the user a timeout.
+func (b *Bot) handleSocketEvent(event socketmode.Event) {
+	defer recoverPanic("socket_event_type", event.Type)
+
+	switch event.Type {
+	case socketmode.EventTypeConnected:
+		atomic.StoreInt32(&b.socketConnected, 1)
+	case socketmode.EventTypeConnecting, socketmode.EventTypeConnectionError, socketmode.EventTypeDisconnect:
+		atomic.StoreInt32(&b.socketConnected, 0)
+	case socketmode.EventTypeEventsAPI:
+		b.socketClient.Ack(*event.Request)
+
+		eventsAPIEvent, ok := event.Data.(slackevents.EventsAPIEvent)
+		if !ok {
+			slog.Warn("Could not type cast the event to the EventsAPIEvent", "event", event)
+			return
+		}
+
+		if req := router.NewEventRequest(eventsAPIEvent); req != nil {
+			_ = b.submit(req, nil)
+		}
+	case socketmode.EventTypeSlashCommand:
+		command, ok := event.Data.(slack.SlashCommand)
+		if !ok {
+			slog.Warn("Could not type cast the message to a SlashCommand", "event", event)
+			b.socketClient.Ack(*event.Request)
+			return
+		}
+
+		// Failed commands are answered with an error message.
+		err := b.answerCommand(command, func(payload interface{}) {
+			b.socketClient.Ack(*event.Request, payload)
+		})
+		if err != nil {
+			b.socketClient.Ack(*event.Request)
+		}
+	case socketmode.EventTypeInteractive:
+		// Nothing is ever answered in the acknowledgement, and failures are
+		// reported separately, so there's no need to keep Slack waiting.
+		b.socketClient.Ack(*event.Request)
+
+		interaction, ok := event.Data.(slack.InteractionCallback)
+		if !ok {
+			slog.Warn("Could not type cast the message to a Interaction callback", "event", event)
+			return
+		}
+
+		_ = b.submit(router.NewInteractionRequest(interaction), nil)
+	}
 }
 
-func (b *Bot) handleEventMessage(event slackevents.EventsAPIEvent) error {
-	//switch event.Type {
-	//case slackevents.CallbackEvent:
-	//	innerEvent := event.InnerEvent
-	//	switch ev := innerEvent.Data.(type) {
-	//	case *slackevents.MessageEvent:
-	//		if ev.Text == "I need help!" && ev.ThreadTimeStamp == "" {
-	//			client.PostMessage(ev.Channel, slack.MsgOptionText("Hi, I'm here!", false), slack.MsgOptionTS(ev.TimeStamp))
-	//		}
-	//	}
-	//default:
-	//	return errors.New("unsupported event type")
-	//}
-	return nil
+// recoverPanic stops a panic outside the router's handlers from taking the bot
+// down. It must be deferred.
+func recoverPanic(args ...any) {
+	if v := recover(); v != nil {
+		slog.Error("Recovered from panic", append(args, "panic", v, "stack", string(debug.Stack()))...)
+	}
 }
 
-func (b *Bot) handleSlashCommand(command slack.SlashCommand) (interface{}, error) {
-	switch command.Command {
-	case "/rota":
-		return b.rotaCommand.Prompt(command)
-	}
-	return nil, nil
-}
-
-func (b *Bot) handleInteractionEvent(interaction slack.InteractionCallback) error {
-	log.Println(">>> " + interaction.Type)
-	switch interaction.Type {
-	case slack.InteractionTypeBlockActions:
-		for _, action := range interaction.ActionCallback.BlockActions {
-			switch action.ActionID {
-			case rotacommand.SelectRotaAction:
-				return b.rotaCommand.PromptRotaDetails(&interaction, action)
-			case rotacommand.StartRotaAction:
-				return b.rotaCommand.StartRotaPrompt(&interaction, action)
-			case rotacommand.StopRotaAction:
-				return b.rotaCommand.StopRota(&interaction, action)
-			case rotacommand.CreateRotaPromptAction:
-				return b.rotaCommand.CreateRotaPrompt(&interaction)
-			case rotacommand.UpdateRotaPromptAction:
-				return b.rotaCommand.UpdateRotaPrompt(&interaction, action)
-			}
+// submit queues a request from either transport for a worker, behind any
+// earlier requests about the same rota. done, if given, is called on the
+// worker with what to acknowledge the request with, even if handling it
+// panicked. Requests are turned away once the bot is shutting down.
+func (b *Bot) submit(req *router.Request, done func(payload interface{})) error {
+	err := b.pool.Submit(b.orderingKey(req), func() {
+		var payload interface{}
+		if done != nil {
+			defer func() { done(payload) }()
 		}
-	case slack.InteractionTypeViewSubmission:
-		switch interaction.View.CallbackID {
-		case rotacommand.UpdateRotaCallback:
-			return b.rotaCommand.UpdateRota(&interaction)
-		case rotacommand.CreateRotaCallback:
-			return b.rotaCommand.CreateRota(&interaction)
-		case rotacommand.StartRotaCallback:
-			return b.rotaCommand.StartRota(&interaction)
+
+		payload, _ = b.router.Handle(req)
+	})
+	if err != nil {
+		slog.Warn("Could not handle request", "kind", req.Kind, "route", req.Route, "error", err)
+	}
+
+	return err
+}
+
+// answerCommand queues a slash command, and acknowledges it with its answer if
+// that's ready within commandAckTimeout. Otherwise, such as when it's queued
+// behind slow requests about the same rota, it's acknowledged with a holding
+// message before Slack gives up on it, and answered through its response URL
+// once it's been handled.
+func (b *Bot) answerCommand(command slack.SlashCommand, ack func(payload interface{})) error {
+	req := router.NewCommandRequest(command)
+	answered := make(chan interface{}, 1)
+
+	// Counted before it's queued, so shutdown waits for the answer.
+	b.tasks.Add(1)
+	err := b.submit(req, func(payload interface{}) { answered <- payload })
+	if err != nil {
+		b.tasks.Done()
+		return err
+	}
+
+	go func() {
+		defer b.tasks.Done()
+
+		select {
+		case payload := <-answered:
+			ack(payload)
+		case <-time.After(b.commandAckTimeout):
+			ack(&slack.Attachment{Text: "Working on it…"})
+			b.respondLater(req, command.ResponseURL, <-answered)
 		}
+	}()
+
+	return nil
+}
+
+// respondLater answers a slash command through its response URL, in place of
+// the holding message it was acknowledged with.
+func (b *Bot) respondLater(req *router.Request, responseURL string, payload interface{}) {
+	message := map[string]interface{}{}
+	if payload == nil {
+		message["delete_original"] = true
+	} else if body, err := json.Marshal(payload); err != nil {
+		req.Logger.Error("Could not encode answer", "error", err)
+		return
+	} else if err := json.Unmarshal(body, &message); err != nil {
+		req.Logger.Error("Could not encode answer", "error", err)
+		return
+	}
+	message["replace_original"] = true
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		req.Logger.Error("Could not encode answer", "error", err)
+		return
+	}
+
+	response, err := responseClient.Post(responseURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		req.Logger.Warn("Could not answer slash command", "error", err)
+		return
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		req.Logger.Warn("Could not answer slash command", "status", response.StatusCode)
 	}
+}
 
+// orderingKey asks the modules of the workspace a request came from what it's
+// about. Requests about the same rota are handled one at a time, in the order
+// they came in.
+func (b *Bot) orderingKey(req *router.Request) string {
+	if t, err := b.teamFor(req.TeamId, req.EnterpriseId); err == nil && t != nil {
+		req.Routes = t.routes
+	}
+
+	return req.Routes.OrderingKey(req)
+}
+
+// reportError tells the user whose slash command or interaction failed that
+// it did, with the request's correlation ID so it can be found in the logs.
+// Slash commands are answered with the message. Interactions get it as an
+// ephemeral message, or a DM if they didn't happen in a channel.
+func (b *Bot) reportError(req *router.Request, _ error) interface{} {
+	attachment := slack.Attachment{
+		Text:  fmt.Sprintf("Sorry, something went wrong. If it keeps happening, quote `%s` when you report it.", req.Id),
+		Color: "#f0303a",
+	}
+
+	if req.Kind == router.KindCommand {
+		return &attachment
+	}
+
+	if req.Kind == router.KindEvent || req.UserId == "" {
+		return nil
+	}
+
+	t, err := b.teamFor(req.TeamId, req.EnterpriseId)
+	if err == nil && req.ChannelId != "" {
+		_, err = t.client.PostEphemeral(req.ChannelId, req.UserId, attachment)
+	} else if err == nil {
+		_, _, err = t.client.PostMessage(req.UserId, attachment)
+	}
+	if err != nil {
+		req.Logger.Warn("Could not tell the user their request failed", "error", err)
+	}
+
+	return nil
+}
+
+// newRouter sends each request to the modules of the workspace it came from.
+func (b *Bot) newRouter() *router.Router {
+	return router.New(
+		router.Logging(requestAttrs),
+		router.ReportErrors(b.reportError),
+		router.Metrics(),
+		router.Recover(),
+		router.Authorize(b.routesFor),
+	)
+}
+
+func (b *Bot) startBackgroundTasks(ctx context.Context) {
+	b.teamsMu.Lock()
+	defer b.teamsMu.Unlock()
+
+	b.runCtx = ctx
+	for _, t := range b.teams {
+		b.runTeam(t)
+	}
+}
+
+func (b *Bot) startServer(ctx context.Context) {
+	if b.server == nil {
+		return
+	}
+
+	b.tasks.Add(1)
+	go func() {
+		defer b.tasks.Done()
+		if err := b.server.Run(ctx); err != nil {
+			slog.Error("HTTP server stopped", "error", err)
+		}
+	}()
+}
+
+func (b *Bot) checkSocketConnected(_ context.Context) error {
+	if atomic.LoadInt32(&b.socketConnected) == 0 {
+		return errors.New("socket mode is not connected")
+	}
 	return nil
 }
+
+// requestAttrs tags every log line for a request with enough context to find
+// the channel, user and rota it was about.
+func requestAttrs(req *router.Request) []any {
+	switch req.Kind {
+	case router.KindCommand:
+		return []any{
+			"channel_id", req.Command.ChannelID,
+			"user_id", req.Command.UserID,
+			"command", req.Command.Command,
+		}
+	case router.KindEvent:
+		return []any{"event_type", req.Route}
+	}
+
+	return []any{
+		"channel_id", req.ChannelId,
+		"user_id", req.UserId,
+		"ordering_key", req.Routes.OrderingKey(req),
+		"action_id", req.Route,
+	}
+}
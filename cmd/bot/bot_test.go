@@ -0,0 +1,291 @@
+package bot
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand"
+	"alfred-bot/cmd/bot/commands/rotacommand/handler"
+	"alfred-bot/cmd/bot/router"
+	"alfred-bot/config"
+	"alfred-bot/utils/clock"
+	"alfred-bot/utils/fakeslack"
+	"alfred-bot/utils/httpserver"
+	"alfred-bot/utils/slackclient"
+	"alfred-bot/utils/slackverifier"
+	"alfred-bot/utils/workerpool"
+	"context"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/slack-go/slack"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBot(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Bot Suite")
+}
+
+const panickingAction = "panic"
+
+// panickingModule has a button that always panics, as a bug would.
+type panickingModule struct{}
+
+func (panickingModule) Routes(r *router.Routes) {
+	r.BlockAction(panickingAction, func(*slack.InteractionCallback, *slack.BlockAction) error {
+		panic("boom")
+	})
+}
+
+func (panickingModule) OrderingKey(*router.Request) (string, bool) {
+	return "", false
+}
+
+// slowModule has a slash command that holds up its channel's requests until
+// it's released.
+type slowModule struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (m slowModule) Routes(r *router.Routes) {
+	r.Command("/slow", func(slack.SlashCommand) (interface{}, error) {
+		close(m.started)
+		<-m.release
+		return nil, nil
+	})
+}
+
+func (slowModule) OrderingKey(*router.Request) (string, bool) {
+	return "", false
+}
+
+var _ = Describe("HTTP transport", func() {
+	var fakeClock *clock.Fake
+	var fake *fakeslack.Server
+	var b *Bot
+	var slow slowModule
+
+	send := func(handler http.Handler, path string, contentType string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		timestamp := strconv.FormatInt(fakeClock.Now().Unix(), 10)
+		r.Header.Set(slackverifier.TimestampHeader, timestamp)
+		r.Header.Set(slackverifier.SignatureHeader, slackverifier.Sign("shh", timestamp, []byte(body)))
+
+		recorder := httptest.NewRecorder()
+		slackverifier.New("shh", fakeClock).Handler(handler).ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	BeforeEach(func() {
+		fakeClock = clock.NewFake(time.Date(2022, time.March, 7, 9, 0, 0, 0, time.UTC))
+		fake = fakeslack.New()
+		DeferCleanup(fake.Close)
+
+		client := slackclient.New(slack.New("xoxb-test", slack.OptionAPIURL(fake.APIURL())))
+		rotaConfig := config.RotaConfig{
+			PageAckTimeout: 5 * time.Minute,
+			EditRole:       config.RoleAnyone,
+			StartRole:      config.RoleAnyone,
+			SwapRole:       config.RoleMembers,
+		}
+		t := &team{client: client, rotaCommand: rotacommand.New(handler.NewMemoryHandler(fakeClock), client, fakeClock, rotaConfig)}
+		slow = slowModule{started: make(chan struct{}), release: make(chan struct{})}
+		t.routes = router.NewRoutes(append(t.modules(), panickingModule{}, slow)...)
+		b = &Bot{
+			clock:             fakeClock,
+			teams:             map[string]*team{"": t},
+			pool:              workerpool.New(2),
+			commandAckTimeout: 200 * time.Millisecond,
+		}
+		b.router = b.newRouter()
+		DeferCleanup(b.pool.Stop)
+	})
+
+	It("Answers Slack's URL check", func() {
+		res := send(b.eventsHandler(), EventsPath, "application/json", `{"type": "url_verification", "challenge": "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"}`)
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Body.String()).To(Equal("3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"))
+	})
+
+	It("Handles events after acknowledging them", func() {
+		res := send(b.eventsHandler(), EventsPath, "application/json", `{
+			"type": "event_callback",
+			"team_id": "T0123",
+			"event": {"type": "app_home_opened", "user": "U0123", "tab": "home"}
+		}`)
+		Expect(res.Code).To(Equal(http.StatusOK))
+
+		Eventually(fake.Calls).Should(ContainElement("views.publish"))
+	})
+
+	It("Answers slash commands with the prompt", func() {
+		form := url.Values{
+			"command":    {"/rota"},
+			"text":       {""},
+			"channel_id": {"C0123"},
+			"user_id":    {"U0123"},
+			"team_id":    {"T0123"},
+		}
+		res := send(b.commandsHandler(), CommandsPath, "application/x-www-form-urlencoded", form.Encode())
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(res.Body.String()).To(ContainSubstring("Looks like this channel does not have any rotas."))
+	})
+
+	It("Acknowledges slash commands in time, even behind a slow request about the same rota", func() {
+		command := func(name string) url.Values {
+			return url.Values{
+				"command":      {name},
+				"channel_id":   {"C0123"},
+				"user_id":      {"U0123"},
+				"team_id":      {"T0123"},
+				"response_url": {fake.ResponseURL()},
+			}
+		}
+
+		go send(b.commandsHandler(), CommandsPath, "application/x-www-form-urlencoded", command("/slow").Encode())
+		Eventually(slow.started).Should(BeClosed())
+
+		start := time.Now()
+		res := send(b.commandsHandler(), CommandsPath, "application/x-www-form-urlencoded", command("/rota").Encode())
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Body.String()).To(ContainSubstring("Working on it…"))
+
+		// The answer follows once the slow request is done.
+		close(slow.release)
+		responses := func() []string {
+			var texts []string
+			for _, v := range fake.Responses() {
+				texts = append(texts, string(v))
+			}
+			return texts
+		}
+		Eventually(responses).Should(ConsistOf(
+			MatchJSON(`{"delete_original": true, "replace_original": true}`),
+			And(ContainSubstring("Looks like this channel does not have any rotas."), ContainSubstring(`"replace_original":true`)),
+		))
+	})
+
+	It("Handles interactions", func() {
+		payload := `{
+			"type": "block_actions",
+			"trigger_id": "123.456",
+			"team": {"id": "T0123"},
+			"user": {"id": "U0123"},
+			"channel": {"id": "C0123"},
+			"actions": [{"block_id": "prompt", "action_id": "` + rotacommand.CreateRotaPromptAction + `", "type": "button"}]
+		}`
+		form := url.Values{"payload": {payload}}
+		res := send(b.interactivityHandler(), InteractivityPath, "application/x-www-form-urlencoded", form.Encode())
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Eventually(fake.Calls).Should(ContainElement("views.open"))
+	})
+
+	It("Tells users when the rota a button was for has been deleted", func() {
+		payload := `{
+			"type": "block_actions",
+			"team": {"id": "T0123"},
+			"user": {"id": "U0123"},
+			"channel": {"id": "C0123"},
+			"actions": [{"block_id": "rota", "action_id": "` + rotacommand.StopRotaAction + `", "value": "Deleted", "type": "button"}]
+		}`
+		form := url.Values{"payload": {payload}}
+		res := send(b.interactivityHandler(), InteractivityPath, "application/x-www-form-urlencoded", form.Encode())
+		Expect(res.Code).To(Equal(http.StatusOK))
+
+		Eventually(fake.Calls).Should(Equal([]string{"chat.postEphemeral"}))
+		Expect(fake.Ephemerals()[0].Text()).To(Equal("[Deleted] That rota no longer exists."))
+	})
+
+	It("Acknowledges interactions that fail, and tells the user why", func() {
+		payload := `{
+			"type": "block_actions",
+			"team": {"id": "T0123"},
+			"user": {"id": "U0123"},
+			"channel": {"id": "C0123"},
+			"actions": [{"block_id": "broken", "action_id": "` + panickingAction + `", "type": "button"}]
+		}`
+		form := url.Values{"payload": {payload}}
+		res := send(b.interactivityHandler(), InteractivityPath, "application/x-www-form-urlencoded", form.Encode())
+		Expect(res.Code).To(Equal(http.StatusOK))
+
+		Eventually(fake.Calls).Should(Equal([]string{"chat.postEphemeral"}))
+		ephemerals := fake.Ephemerals()
+		Expect(ephemerals[0].Channel).To(Equal("C0123"))
+		Expect(ephemerals[0].User).To(Equal("U0123"))
+		Expect(ephemerals[0].Text()).To(MatchRegexp("Sorry, something went wrong. If it keeps happening, quote `[0-9a-f]{12}`"))
+	})
+
+	It("Turns away requests once the bot is shutting down, for Slack to retry", func() {
+		b.pool.Stop()
+
+		res := send(b.eventsHandler(), EventsPath, "application/json", `{
+			"type": "event_callback",
+			"team_id": "T0123",
+			"event": {"type": "app_home_opened", "user": "U0123", "tab": "home"}
+		}`)
+		Expect(res.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(fake.Calls()).To(BeEmpty())
+	})
+
+	Describe("Shutting down", func() {
+		var cancel context.CancelFunc
+		var stopped chan error
+
+		start := func(shutdownTimeout time.Duration) {
+			b.server = httpserver.New("127.0.0.1:0")
+			b.shutdownTimeout = shutdownTimeout
+
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			stopped = make(chan error, 1)
+			go func() {
+				stopped <- b.Start(ctx)
+			}()
+
+			form := url.Values{
+				"command":      {"/slow"},
+				"channel_id":   {"C0123"},
+				"user_id":      {"U0123"},
+				"team_id":      {"T0123"},
+				"response_url": {fake.ResponseURL()},
+			}
+			go send(b.commandsHandler(), CommandsPath, "application/x-www-form-urlencoded", form.Encode())
+			Eventually(slow.started).Should(BeClosed())
+		}
+
+		It("Waits for requests in progress to finish", func() {
+			start(5 * time.Second)
+
+			cancel()
+			Consistently(stopped, 300*time.Millisecond).ShouldNot(Receive())
+
+			close(slow.release)
+			Eventually(stopped).Should(Receive(BeNil()))
+		})
+
+		It("Gives up waiting after the shutdown timeout", func() {
+			start(200 * time.Millisecond)
+			DeferCleanup(func() {
+				close(slow.release)
+			})
+
+			cancel()
+			Eventually(stopped).Should(Receive(MatchError(ContainSubstring("timed out waiting for in-flight work"))))
+		})
+	})
+
+	It("Turns away requests that weren't signed by Slack", func() {
+		r := httptest.NewRequest(http.MethodPost, CommandsPath, strings.NewReader("command=/rota"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorder := httptest.NewRecorder()
+		slackverifier.New("shh", fakeClock).Handler(b.commandsHandler()).ServeHTTP(recorder, r)
+		Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+	})
+})
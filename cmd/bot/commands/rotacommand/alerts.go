@@ -0,0 +1,232 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/models/alert"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"alfred-bot/utils/formatter"
+	"alfred-bot/utils/metrics"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/slack-go/slack"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// AlertsPath is where the HTTP server accepts alerts, followed by the rota's
+// routing key.
+const AlertsPath = "/alerts/"
+
+const maxAlertBody = 1 << 20
+
+var ErrUnknownRoutingKey = errors.New("unknown routing key")
+
+var (
+	alertsTotal = metrics.NewCounter(
+		"alfred_alerts_total",
+		"Alerts received from monitoring, by what was done with them.",
+		"outcome",
+	)
+	// Alert text comes from outside Slack, so it mustn't be able to mention
+	// people or channels.
+	slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	// linkEscaper also keeps a URL from ending a link's address early.
+	linkEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "|", "%7C")
+)
+
+// AlertsHandler accepts Alertmanager and generic JSON webhooks at
+// /alerts/<routing key>.
+func (c *RotaCommand) AlertsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAlertBody))
+		if err != nil {
+			http.Error(w, "could not read body", http.StatusBadRequest)
+			return
+		}
+
+		alerts, err := alert.Parse(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = c.HandleAlerts(strings.TrimPrefix(r.URL.Path, AlertsPath), alerts)
+		if errors.Is(err, ErrUnknownRoutingKey) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if err != nil {
+			slog.Error("Could not handle alerts", "error", err)
+			http.Error(w, "could not handle alerts", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// HandleAlerts posts new alerts to the rota's channel, mentioning whoever is on
+// duty. Alerts that are already firing are ignored, and resolved alerts update
+// the message they were first posted in.
+func (c *RotaCommand) HandleAlerts(routingKey string, alerts []*alert.Alert) error {
+	if routingKey == "" {
+		return ErrUnknownRoutingKey
+	}
+
+	rotaDetails, err := c.handler.GetRotaByRoutingKey(routingKey)
+	if err != nil {
+		return err
+	}
+
+	if rotaDetails == nil {
+		return ErrUnknownRoutingKey
+	}
+
+	// Alertmanager retries, so the same alert can arrive twice at once.
+	c.alertsMu.Lock()
+	defer c.alertsMu.Unlock()
+
+	var errs []error
+	for _, a := range alerts {
+		errs = append(errs, c.handleAlert(rotaDetails, a))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *RotaCommand) handleAlert(rotaDetails *rotadetails.RotaDetails, a *alert.Alert) error {
+	channelId := rotaDetails.Pk
+	logger := rotaLogger(channelId, rotaDetails.RotaName()).With("fingerprint", a.Fingerprint())
+
+	existing, err := c.handler.GetAlert(channelId, a.Fingerprint())
+	if err != nil {
+		return err
+	}
+
+	alreadyFiring := existing != nil && existing.IsFiring()
+	switch {
+	case a.IsFiring() && alreadyFiring:
+		alertsTotal.Inc("duplicate")
+		return nil
+	case !a.IsFiring() && !alreadyFiring:
+		alertsTotal.Inc("ignored")
+		return nil
+	case a.IsFiring():
+		a.Pk = alert.Key(channelId)
+		a.RotaName = rotaDetails.RotaName()
+		a.OnCall = rotaDetails.CurrOnCallMember
+		if tiers := rotaDetails.EscalationTiers(); !rotaDetails.OnCallAt(c.clock.Now()) && len(tiers) > 0 {
+			a.OnCall = tiers[0]
+		}
+
+		_, a.MessageTs, err = c.client.PostMessage(channelId, alertAttachment(a))
+		if err != nil {
+			return err
+		}
+
+		alertsTotal.Inc("posted")
+		logger.Info("Posted alert", "user_id", a.OnCall)
+	default:
+		existing.Status = alert.StatusResolved
+		a = existing
+
+		_, _, _, err = c.client.UpdateMessage(channelId, a.MessageTs, alertAttachment(a))
+		if err != nil {
+			return err
+		}
+
+		alertsTotal.Inc("resolved")
+		logger.Info("Resolved alert")
+	}
+
+	return c.handler.SaveAlert(a)
+}
+
+// alertsSubcommand shows where to send the rota's alerts, creating its routing
+// key the first time.
+func (c *RotaCommand) alertsSubcommand(rotaDetails *rotadetails.RotaDetails) (*slack.Attachment, error) {
+	if !c.config.AlertWebhooks {
+		return errorAttachment("Incoming alerts are turned off. Set `ALERT_WEBHOOKS=true` to turn them on."), nil
+	}
+
+	routingKey := rotaDetails.RoutingKey
+	if routingKey == "" {
+		var err error
+		routingKey, err = randomToken(16)
+		if err != nil {
+			return nil, err
+		}
+
+		err = c.handler.SaveRoutingKey(rotaDetails.Pk, rotaDetails.RotaName(), routingKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &slack.Attachment{
+		Text: fmt.Sprintf(
+			"[%v] Send Alertmanager or JSON alerts to `%s%s` on the bot's HTTP address. Anyone with this address can post alerts to the channel, so keep it private.",
+			rotaDetails.RotaName(), AlertsPath, routingKey,
+		),
+	}, nil
+}
+
+func alertAttachment(a *alert.Alert) slack.Attachment {
+	var heading string
+	attachment := slack.Attachment{}
+	switch {
+	case !a.IsFiring():
+		heading = fmt.Sprintf("[%v] Resolved: %s", a.RotaName, slackEscaper.Replace(a.Title))
+		attachment.Color = "#4af030"
+	case a.OnCall == "":
+		heading = fmt.Sprintf("[%v] Alert, but no one is on duty: %s", a.RotaName, slackEscaper.Replace(a.Title))
+		attachment.Color = "#f0303a"
+	default:
+		heading = fmt.Sprintf("[%v] Alert for %s: %s", a.RotaName, formatter.AtUserId(a.OnCall), slackEscaper.Replace(a.Title))
+		attachment.Color = "#f0303a"
+	}
+
+	lines := []string{heading}
+	if a.Description != "" {
+		lines = append(lines, slackEscaper.Replace(a.Description))
+	}
+	if link := sourceLink(a.URL); link != "" {
+		lines = append(lines, fmt.Sprintf("<%s|Source>", link))
+	}
+
+	attachment.Text = strings.Join(lines, "\n")
+
+	return attachment
+}
+
+// sourceLink returns an alert's URL ready to put in a link, or nothing if it
+// isn't an http or https URL.
+func sourceLink(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+
+	return linkEscaper.Replace(u.String())
+}
+
+// randomToken returns n random bytes, hex encoded, for use as a secret.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,117 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/handler"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"alfred-bot/utils/clock"
+	"alfred-bot/utils/formatter"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/slack-go/slack"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+var _ = Describe("Alerts", func() {
+	var store *handler.MemoryHandler
+	var mockSlackClient *MockSlackClient
+	var rotaCommand *RotaCommand
+	var routingKey string
+
+	startOfShift := time.Date(2022, time.March, 7, 9, 0, 0, 0, time.UTC)
+	endOfShift := time.Date(2022, time.March, 14, 9, 0, 0, 0, time.UTC)
+
+	post := func(routingKey string, body string) int {
+		request := httptest.NewRequest(http.MethodPost, AlertsPath+routingKey, strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		rotaCommand.AlertsHandler().ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	alertmanager := func(status string) string {
+		return `{"version": "4", "alerts": [{"status": "` + status + `", "labels": {"alertname": "HighLatency"},` +
+			` "annotations": {"summary": "Checkout <is> slow"}, "generatorURL": "http://prometheus/graph", "fingerprint": "abc123"}]}`
+	}
+
+	BeforeEach(func() {
+		fakeClock := clock.NewFake(startOfShift)
+		store = handler.NewMemoryHandler(fakeClock)
+		mockSlackClient = &MockSlackClient{}
+		rotaConfig := testRotaConfig
+		rotaConfig.AlertWebhooks = true
+		rotaCommand = New(store, mockSlackClient, fakeClock, rotaConfig)
+
+		Expect(store.SaveRotaDetails(testChannelId, testRotaName, []string{"Evan", "Sia"}, "1")).To(Succeed())
+		Expect(store.UpdateOnCallMember(testChannelId, testRotaName, "Evan", formatter.FormatTime(startOfShift), formatter.FormatTime(endOfShift))).To(Succeed())
+
+		attachment, err := rotaCommand.Prompt(slack.SlashCommand{ChannelID: testChannelId, UserID: "Evan", Text: "alerts " + testRotaName})
+		Expect(err).To(BeNil())
+		Expect(attachment.(*slack.Attachment).Text).To(ContainSubstring("`" + AlertsPath))
+
+		rotaDetails, err := store.GetRotaDetails(testChannelId, testRotaName)
+		Expect(err).To(BeNil())
+		routingKey = rotaDetails.RoutingKey
+		Expect(routingKey).To(HaveLen(32))
+	})
+
+	It("Posts the alert to the rota's channel, mentioning whoever is on duty", func() {
+		Expect(post(routingKey, alertmanager("firing"))).To(Equal(http.StatusNoContent))
+
+		posts := mockSlackClient.PostsTo(testChannelId)
+		Expect(posts).To(HaveLen(1))
+		Expect(posts[0].Text).To(Equal("[dummy_rota] Alert for <@Evan>: Checkout &lt;is&gt; slow\n<http://prometheus/graph|Source>"))
+	})
+
+	It("Mentions the first tier outside the rota's windows", func() {
+		Expect(store.SaveRotaTiers(testChannelId, testRotaName, []string{"Suan"})).To(Succeed())
+		Expect(store.SaveWindows(testChannelId, testRotaName, "", []rotadetails.Window{{StartDay: "friday", Start: "18:00", EndDay: "monday", End: "09:00"}})).To(Succeed())
+		Expect(post(routingKey, alertmanager("firing"))).To(Equal(http.StatusNoContent))
+
+		Expect(mockSlackClient.PostsTo(testChannelId)[0].Text).To(HavePrefix("[dummy_rota] Alert for <@Suan>:"))
+	})
+
+	It("Ignores an alert that is already firing", func() {
+		Expect(post(routingKey, alertmanager("firing"))).To(Equal(http.StatusNoContent))
+		Expect(post(routingKey, alertmanager("firing"))).To(Equal(http.StatusNoContent))
+
+		Expect(mockSlackClient.PostsTo(testChannelId)).To(HaveLen(1))
+	})
+
+	It("Updates the original message when the alert resolves", func() {
+		Expect(post(routingKey, alertmanager("firing"))).To(Equal(http.StatusNoContent))
+		Expect(post(routingKey, alertmanager("resolved"))).To(Equal(http.StatusNoContent))
+
+		a, err := store.GetAlert(testChannelId, "abc123")
+		Expect(err).To(BeNil())
+		Expect(mockSlackClient.UpdateTo(a.MessageTs)).To(HavePrefix("[dummy_rota] Resolved: Checkout &lt;is&gt; slow"))
+
+		Expect(post(routingKey, alertmanager("firing"))).To(Equal(http.StatusNoContent))
+		Expect(mockSlackClient.PostsTo(testChannelId)).To(HaveLen(2))
+	})
+
+	It("Accepts generic JSON alerts", func() {
+		Expect(post(routingKey, `{"title": "Checkout is down", "message": "500s everywhere"}`)).To(Equal(http.StatusNoContent))
+		Expect(mockSlackClient.PostsTo(testChannelId)[0].Text).To(Equal("[dummy_rota] Alert for <@Evan>: Checkout is down\n500s everywhere"))
+	})
+
+	It("Won't let an alert's URL mention anyone or change its link", func() {
+		Expect(post(routingKey, `{"title": "Down", "url": "x> <!channel"}`)).To(Equal(http.StatusNoContent))
+		Expect(post(routingKey, `{"title": "Down again", "fingerprint": "2", "url": "https://grafana/d?a=1&b=2> <!channel|Click"}`)).To(Equal(http.StatusNoContent))
+		Expect(post(routingKey, `{"title": "Down once more", "fingerprint": "3", "url": "javascript:alert(1)"}`)).To(Equal(http.StatusNoContent))
+
+		posts := mockSlackClient.PostsTo(testChannelId)
+		Expect(posts).To(HaveLen(3))
+		Expect(posts[0].Text).To(Equal("[dummy_rota] Alert for <@Evan>: Down"))
+		Expect(posts[1].Text).To(Equal("[dummy_rota] Alert for <@Evan>: Down again\n<https://grafana/d?a=1&amp;b=2&gt; &lt;!channel%7CClick|Source>"))
+		Expect(posts[2].Text).To(Equal("[dummy_rota] Alert for <@Evan>: Down once more"))
+	})
+
+	It("Rejects unknown routing keys and bad payloads", func() {
+		Expect(post("nope", alertmanager("firing"))).To(Equal(http.StatusNotFound))
+		Expect(post("", alertmanager("firing"))).To(Equal(http.StatusNotFound))
+		Expect(post(routingKey, `{"message": "no title"}`)).To(Equal(http.StatusBadRequest))
+		Expect(mockSlackClient.PostsTo(testChannelId)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,323 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/models/apitoken"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"alfred-bot/utils/metrics"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIPath is where the HTTP server serves the read-only REST API. See
+// docs/api.md for the endpoints and their JSON.
+const APIPath = "/api/v1/"
+
+const (
+	defaultScheduleCount = 5
+	maxScheduleCount     = 52
+	defaultHistoryLimit  = 20
+	maxHistoryLimit      = 100
+)
+
+var apiRequestsTotal = metrics.NewCounter(
+	"alfred_api_requests_total",
+	"REST API requests, by endpoint and status code.",
+	"endpoint", "code",
+)
+
+type apiRota struct {
+	ChannelId     string     `json:"channel_id"`
+	Name          string     `json:"name"`
+	Members       []string   `json:"members"`
+	Owners        []string   `json:"owners"`
+	DurationWeeks int        `json:"duration_weeks"`
+	OnCall        *apiOnCall `json:"on_call"`
+}
+
+type apiOnCall struct {
+	Member       string `json:"member"`
+	CoveringFor  string `json:"covering_for,omitempty"`
+	StartOfShift string `json:"start_of_shift"`
+	EndOfShift   string `json:"end_of_shift"`
+}
+
+type apiShift struct {
+	Member       string `json:"member"`
+	StartOfShift string `json:"start_of_shift"`
+	EndOfShift   string `json:"end_of_shift"`
+}
+
+type apiHistoryEntry struct {
+	Id                   string `json:"id"`
+	Type                 string `json:"type"`
+	OnCallMember         string `json:"on_call_member,omitempty"`
+	PreviousOnCallMember string `json:"previous_on_call_member,omitempty"`
+	Actor                string `json:"actor,omitempty"`
+	Change               string `json:"change,omitempty"`
+	RequestedBy          string `json:"requested_by,omitempty"`
+	At                   string `json:"at"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// apiResponse is what an endpoint answers with: a status code and a value to
+// encode as JSON.
+type apiResponse struct {
+	code int
+	body interface{}
+}
+
+// APIHandler serves the REST API to anyone with a token from /rota token.
+func (c *RotaCommand) APIHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint, response := c.serveAPI(r)
+		apiRequestsTotal.Inc(endpoint, strconv.Itoa(response.code))
+
+		w.Header().Set("Content-Type", "application/json")
+		switch response.code {
+		case http.StatusUnauthorized:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="alfred"`)
+		case http.StatusMethodNotAllowed:
+			w.Header().Set("Allow", http.MethodGet)
+		}
+		w.WriteHeader(response.code)
+
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		err := json.NewEncoder(w).Encode(response.body)
+		if err != nil {
+			slog.Warn("Could not write API response", "error", err)
+		}
+	})
+}
+
+func (c *RotaCommand) serveAPI(r *http.Request) (string, apiResponse) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return "", apiFailure(http.StatusMethodNotAllowed, "method not allowed")
+	}
+
+	response, ok := c.authenticate(r)
+	if !ok {
+		return "", response
+	}
+
+	// Rota names can contain anything, so split the path before decoding it.
+	segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), APIPath), "/")
+	for i, v := range segments {
+		segment, err := url.PathUnescape(v)
+		if err != nil {
+			return "", apiFailure(http.StatusBadRequest, "malformed path")
+		}
+		segments[i] = segment
+	}
+
+	if len(segments) < 3 || segments[0] != "channels" || segments[1] == "" || segments[2] != "rotas" {
+		return "", apiFailure(http.StatusNotFound, "not found")
+	}
+
+	channelId := segments[1]
+	if len(segments) == 3 {
+		return "rotas", c.apiListRotas(channelId)
+	}
+
+	rotaName := segments[3]
+	rotaDetails, err := c.handler.GetRotaDetails(channelId, rotaName)
+	if err != nil {
+		return "rota", apiInternalError(err)
+	}
+
+	if rotaDetails == nil {
+		return "rota", apiFailure(http.StatusNotFound, "no rota called "+rotaName+" in channel "+channelId)
+	}
+
+	endpoint := "rota"
+	if len(segments) > 4 {
+		endpoint = segments[4]
+	}
+
+	switch {
+	case len(segments) == 4:
+		return endpoint, apiResponse{http.StatusOK, newAPIRota(rotaDetails)}
+	case len(segments) > 5:
+		return "", apiFailure(http.StatusNotFound, "not found")
+	case endpoint == "oncall":
+		return endpoint, apiResponse{http.StatusOK, map[string]*apiOnCall{"on_call": newAPIOnCall(rotaDetails)}}
+	case endpoint == "schedule":
+		return endpoint, c.apiSchedule(rotaDetails, r.URL.Query())
+	case endpoint == "history":
+		return endpoint, c.apiHistory(rotaDetails, r.URL.Query())
+	}
+
+	return "", apiFailure(http.StatusNotFound, "not found")
+}
+
+// APITokenHash returns the hash of the request's bearer token, which finds the
+// workspace it was made in for bots that serve several.
+func APITokenHash(r *http.Request) (string, bool) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return "", false
+	}
+
+	return apitoken.Hash(token), true
+}
+
+// authenticate checks the request's bearer token, returning the response to
+// send if it isn't valid.
+func (c *RotaCommand) authenticate(r *http.Request) (apiResponse, bool) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return apiFailure(http.StatusUnauthorized, "missing bearer token"), false
+	}
+
+	t, err := c.handler.GetAPIToken(apitoken.Hash(token))
+	if err != nil {
+		return apiInternalError(err), false
+	}
+
+	if t == nil {
+		return apiFailure(http.StatusUnauthorized, "invalid bearer token"), false
+	}
+
+	return apiResponse{}, true
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	return token, strings.EqualFold(scheme, "Bearer") && token != ""
+}
+
+func (c *RotaCommand) apiListRotas(channelId string) apiResponse {
+	rotaNames, err := c.handler.GetRotaNames(channelId)
+	if err != nil {
+		return apiInternalError(err)
+	}
+
+	rotas := make([]*apiRota, 0, len(rotaNames))
+	for _, v := range rotaNames {
+		rotaDetails, err := c.handler.GetRotaDetails(channelId, v)
+		if err != nil {
+			return apiInternalError(err)
+		}
+
+		// The rota may have been removed since its name was listed.
+		if rotaDetails != nil {
+			rotas = append(rotas, newAPIRota(rotaDetails))
+		}
+	}
+
+	return apiResponse{http.StatusOK, map[string][]*apiRota{"rotas": rotas}}
+}
+
+func (c *RotaCommand) apiSchedule(rotaDetails *rotadetails.RotaDetails, query url.Values) apiResponse {
+	count, err := queryInt(query, "count", defaultScheduleCount, maxScheduleCount)
+	if err != nil {
+		return apiFailure(http.StatusBadRequest, err.Error())
+	}
+
+	shifts := []apiShift{}
+	startOfShift, endOfShift, ok := currentShift(rotaDetails)
+	if ok {
+		for _, v := range rotaDetails.UpcomingShifts(startOfShift, endOfShift, count) {
+			shifts = append(shifts, apiShift{
+				Member:       v.Member,
+				StartOfShift: v.StartOfShift.UTC().Format(time.RFC3339),
+				EndOfShift:   v.EndOfShift.UTC().Format(time.RFC3339),
+			})
+		}
+	}
+
+	return apiResponse{http.StatusOK, map[string][]apiShift{"shifts": shifts}}
+}
+
+func (c *RotaCommand) apiHistory(rotaDetails *rotadetails.RotaDetails, query url.Values) apiResponse {
+	limit, err := queryInt(query, "limit", defaultHistoryLimit, maxHistoryLimit)
+	if err != nil {
+		return apiFailure(http.StatusBadRequest, err.Error())
+	}
+
+	stored, err := c.handler.GetHistory(rotaDetails.Pk, rotaDetails.RotaName(), limit)
+	if err != nil {
+		return apiInternalError(err)
+	}
+
+	entries := make([]apiHistoryEntry, 0, len(stored))
+	for _, v := range stored {
+		entries = append(entries, apiHistoryEntry{
+			Id:                   v.Id(),
+			Type:                 v.Type,
+			OnCallMember:         v.OnCallMember,
+			PreviousOnCallMember: v.PreviousOnCallMember,
+			Actor:                v.Actor,
+			Change:               v.Change,
+			RequestedBy:          v.RequestedBy,
+			At:                   v.At,
+		})
+	}
+
+	return apiResponse{http.StatusOK, map[string][]apiHistoryEntry{"entries": entries}}
+}
+
+func newAPIRota(rotaDetails *rotadetails.RotaDetails) *apiRota {
+	return &apiRota{
+		ChannelId:     rotaDetails.Pk,
+		Name:          rotaDetails.RotaName(),
+		Members:       append([]string{}, rotaDetails.Members...),
+		Owners:        append([]string{}, rotaDetails.Owners...),
+		DurationWeeks: rotaDetails.Duration,
+		OnCall:        newAPIOnCall(rotaDetails),
+	}
+}
+
+// newAPIOnCall describes the current shift, or returns nil if the rota isn't
+// running.
+func newAPIOnCall(rotaDetails *rotadetails.RotaDetails) *apiOnCall {
+	if rotaDetails.CurrOnCallMember == "" {
+		return nil
+	}
+
+	onCall := &apiOnCall{
+		Member:       rotaDetails.CurrOnCallMember,
+		StartOfShift: rfc3339(rotaDetails.StartOfShift),
+		EndOfShift:   rfc3339(rotaDetails.EndOfShift),
+	}
+	if rotaDetails.OverriddenOnCallMember != "" {
+		onCall.CoveringFor = rotaDetails.OverriddenOnCallMember
+	}
+
+	return onCall
+}
+
+func queryInt(query url.Values, name string, defaultValue int, maxValue int) (int, error) {
+	raw := query.Get(name)
+	if raw == "" {
+		return defaultValue, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 1 || value > maxValue {
+		return 0, errors.New(name + " must be a number from 1 to " + strconv.Itoa(maxValue))
+	}
+
+	return value, nil
+}
+
+func apiFailure(code int, message string) apiResponse {
+	return apiResponse{code, apiError{Error: message}}
+}
+
+func apiInternalError(err error) apiResponse {
+	slog.Error("Could not answer API request", "error", err)
+	return apiFailure(http.StatusInternalServerError, "internal error")
+}
@@ -0,0 +1,145 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/handler"
+	"alfred-bot/utils/clock"
+	"encoding/json"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/slack-go/slack"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"time"
+)
+
+var _ = Describe("REST API", func() {
+	var fakeClock *clock.Fake
+	var store *handler.MemoryHandler
+	var mockSlackClient *MockSlackClient
+	var rotaCommand *RotaCommand
+	var token string
+
+	startOfShift := time.Date(2022, time.March, 7, 9, 0, 0, 0, time.UTC)
+	tokenPattern := regexp.MustCompile("`(alfred_[0-9a-f]+)`")
+	rotaPath := APIPath + "channels/" + testChannelId + "/rotas/" + testRotaName
+
+	run := func(userId string, text string) string {
+		payload, err := rotaCommand.Prompt(slack.SlashCommand{ChannelID: testChannelId, UserID: userId, Text: text})
+		Expect(err).To(BeNil())
+		attachment, _ := payload.(*slack.Attachment)
+		if attachment == nil {
+			return ""
+		}
+		return attachment.Text
+	}
+
+	get := func(path string, bearer string, body interface{}) int {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		if bearer != "" {
+			request.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		recorder := httptest.NewRecorder()
+		rotaCommand.APIHandler().ServeHTTP(recorder, request)
+
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+		if body != nil {
+			Expect(json.Unmarshal(recorder.Body.Bytes(), body)).To(Succeed())
+		}
+		return recorder.Code
+	}
+
+	BeforeEach(func() {
+		fakeClock = clock.NewFake(startOfShift)
+		store = handler.NewMemoryHandler(fakeClock)
+		mockSlackClient = &MockSlackClient{Admins: []string{"Admin"}}
+		rotaConfig := testRotaConfig
+		rotaConfig.RestAPI = true
+		rotaCommand = New(store, mockSlackClient, fakeClock, rotaConfig)
+
+		Expect(store.SaveRotaDetails(testChannelId, testRotaName, []string{"Evan", "Sia"}, "1")).To(Succeed())
+
+		match := tokenPattern.FindStringSubmatch(run("Admin", "token status-page"))
+		Expect(match).ToNot(BeNil())
+		token = match[1]
+	})
+
+	It("Only lets workspace admins manage tokens", func() {
+		Expect(run("Evan", "token mine")).To(Equal("Only admins can manage REST API tokens."))
+		Expect(run("Evan", "tokens")).To(Equal("Only admins can manage REST API tokens."))
+		Expect(run("Admin", "tokens")).To(ContainSubstring("• status-page, created by <@Admin>"))
+		Expect(run("Admin", "token status-page")).To(ContainSubstring("already a token called status-page"))
+	})
+
+	It("Rejects requests without a valid token", func() {
+		var body apiError
+		Expect(get(rotaPath, "", &body)).To(Equal(http.StatusUnauthorized))
+		Expect(body.Error).To(Equal("missing bearer token"))
+
+		Expect(get(rotaPath, "alfred_wrong", &body)).To(Equal(http.StatusUnauthorized))
+		Expect(body.Error).To(Equal("invalid bearer token"))
+
+		Expect(run("Admin", "revoke-token status-page")).To(Equal("Revoked the REST API token status-page."))
+		Expect(get(rotaPath, token, nil)).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("Lists the channel's rotas", func() {
+		Expect(store.SaveRotaDetails(testChannelId, "on call", []string{"Wai"}, "2")).To(Succeed())
+
+		var body struct{ Rotas []apiRota }
+		Expect(get(APIPath+"channels/"+testChannelId+"/rotas", token, &body)).To(Equal(http.StatusOK))
+		Expect(body.Rotas).To(HaveLen(2))
+		Expect(body.Rotas[0].Name).To(Equal(testRotaName))
+		Expect(body.Rotas[1].Name).To(Equal("on call"))
+		Expect(body.Rotas[1].DurationWeeks).To(Equal(2))
+
+		var rota apiRota
+		Expect(get(APIPath+"channels/"+testChannelId+"/rotas/on%20call", token, &rota)).To(Equal(http.StatusOK))
+		Expect(rota.Members).To(Equal([]string{"Wai"}))
+		Expect(rota.OnCall).To(BeNil())
+	})
+
+	It("Shows who is on call and the upcoming shifts", func() {
+		run("Evan", "start "+testRotaName+" <@Evan>")
+
+		var onCall struct {
+			OnCall *apiOnCall `json:"on_call"`
+		}
+		Expect(get(rotaPath+"/oncall", token, &onCall)).To(Equal(http.StatusOK))
+		Expect(*onCall.OnCall).To(Equal(apiOnCall{Member: "Evan", StartOfShift: "2022-03-07T09:00:00Z", EndOfShift: "2022-03-14T09:00:00Z"}))
+
+		var schedule struct{ Shifts []apiShift }
+		Expect(get(rotaPath+"/schedule?count=2", token, &schedule)).To(Equal(http.StatusOK))
+		Expect(schedule.Shifts).To(Equal([]apiShift{
+			{Member: "Evan", StartOfShift: "2022-03-07T09:00:00Z", EndOfShift: "2022-03-14T09:00:00Z"},
+			{Member: "Sia", StartOfShift: "2022-03-14T09:00:00Z", EndOfShift: "2022-03-21T09:00:00Z"},
+			{Member: "Evan", StartOfShift: "2022-03-21T09:00:00Z", EndOfShift: "2022-03-28T09:00:00Z"},
+		}))
+
+		var body apiError
+		Expect(get(rotaPath+"/schedule?count=0", token, &body)).To(Equal(http.StatusBadRequest))
+		Expect(body.Error).To(Equal("count must be a number from 1 to 52"))
+	})
+
+	It("Returns the rota's history, newest first", func() {
+		run("Evan", "start "+testRotaName+" <@Evan>")
+		fakeClock.Advance(7 * 24 * time.Hour)
+		rotaCommand.handOverShift(testChannelId, testRotaName)
+
+		var history struct{ Entries []apiHistoryEntry }
+		Expect(get(rotaPath+"/history", token, &history)).To(Equal(http.StatusOK))
+		Expect(history.Entries).To(HaveLen(2))
+		Expect(history.Entries[0].Type).To(Equal("handover"))
+		Expect(history.Entries[0].OnCallMember).To(Equal("Sia"))
+		Expect(history.Entries[0].PreviousOnCallMember).To(Equal("Evan"))
+		Expect(history.Entries[0].At).To(Equal("2022-03-14T09:00:00Z"))
+		Expect(history.Entries[1].Type).To(Equal("start"))
+		Expect(history.Entries[1].Id).ToNot(BeEmpty())
+	})
+
+	It("Answers 404 for rotas and paths it doesn't know", func() {
+		Expect(get(APIPath+"channels/"+testChannelId+"/rotas/missing", token, nil)).To(Equal(http.StatusNotFound))
+		Expect(get(rotaPath+"/members", token, nil)).To(Equal(http.StatusNotFound))
+		Expect(get(APIPath+"rotas", token, nil)).To(Equal(http.StatusNotFound))
+	})
+})
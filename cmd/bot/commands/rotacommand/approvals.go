@@ -0,0 +1,321 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/models/change"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/webhook"
+	"alfred-bot/cmd/bot/commands/rotacommand/subcommand"
+	"alfred-bot/config"
+	"alfred-bot/utils/formatter"
+	"fmt"
+	"github.com/slack-go/slack"
+	"strconv"
+)
+
+const (
+	ApproveChangeAction = "approve_change"
+	RejectChangeAction  = "reject_change"
+)
+
+// requestChange holds a change to a rota that needs approval, and asks the
+// rota's other owners to decide on it, or its admins if no one else owns it.
+// No one approves their own changes, so if there's no one else to ask, the
+// change isn't held.
+func (c *RotaCommand) requestChange(rotaDetails *rotadetails.RotaDetails, ch *change.Change) error {
+	channelId := rotaDetails.Pk
+	rotaName := rotaDetails.RotaName()
+
+	var approvers []string
+	for _, v := range rotaDetails.Owners {
+		if v != ch.RequestedBy {
+			approvers = append(approvers, v)
+		}
+	}
+
+	ownersApprove := len(approvers) > 0
+	if !ownersApprove {
+		admins, err := c.handler.GetAdmins()
+		if err != nil {
+			return err
+		}
+
+		for _, v := range admins {
+			if v.Sk != ch.RequestedBy {
+				approvers = append(approvers, v.Sk)
+			}
+		}
+	}
+
+	// Don't hold a change no one will be asked to decide on.
+	if len(approvers) == 0 {
+		return c.respondToClient(channelId, ch.RequestedBy, errorAttachment(fmt.Sprintf(
+			"[%v] There's no one to approve your request to %s, as no one else owns the rota and there are no admins. Ask a workspace admin to add an owner with `/rota %s` or an admin with `/rota %s`.",
+			rotaName, describeChange(ch), subcommand.AddOwner, subcommand.AddAdmin,
+		)))
+	}
+
+	now := c.clock.Now()
+	ch.Pk = change.Key(channelId, rotaName)
+	ch.Sk = strconv.FormatInt(now.UnixNano(), 10)
+	ch.RequestedAt = formatter.FormatTime(now)
+
+	err := c.handler.SaveChange(ch)
+	if err != nil {
+		return err
+	}
+
+	c.emit(webhook.Event{Type: webhook.EventChangeRequested, ChannelId: channelId, RotaName: rotaName, Actor: ch.RequestedBy, Change: ch.Type, RequestedBy: ch.RequestedBy})
+
+	// The change is saved, so one approver who can't be reached doesn't stop
+	// the others from being asked.
+	for _, v := range approvers {
+		// Posting to a user ID sends a direct message from the bot.
+		_, _, err = c.client.PostMessage(v, *changeAttachment(rotaDetails, ch, true))
+		if err != nil {
+			rotaLogger(channelId, rotaName).Warn("Could not ask for approval", "user_id", v, "error", err)
+		}
+	}
+
+	text := fmt.Sprintf("[%v] Your request to %s is waiting for an owner's approval.", rotaName, describeChange(ch))
+	if !ownersApprove {
+		text = fmt.Sprintf("[%v] Your request to %s is waiting for an admin's approval, as no one else owns the rota.", rotaName, describeChange(ch))
+	}
+
+	return c.respondToClient(channelId, ch.RequestedBy, &slack.Attachment{Text: text})
+}
+
+func (c *RotaCommand) ApproveChange(interaction *slack.InteractionCallback, action *slack.BlockAction) error {
+	return c.decideChange(interaction, action, true)
+}
+
+func (c *RotaCommand) RejectChange(interaction *slack.InteractionCallback, action *slack.BlockAction) error {
+	return c.decideChange(interaction, action, false)
+}
+
+// decideChange approves or rejects a change. Only the rota's owners and admins
+// can decide, and not on their own changes. Approved changes are applied as if
+// whoever asked for them had made them.
+func (c *RotaCommand) decideChange(interaction *slack.InteractionCallback, action *slack.BlockAction, approved bool) error {
+	userId := interaction.User.ID
+
+	channelId, rotaName, changeId, err := change.ParseReference(action.Value)
+	if err != nil {
+		return err
+	}
+
+	c.changesMu.Lock()
+	defer c.changesMu.Unlock()
+
+	ch, err := c.handler.GetChange(channelId, rotaName, changeId)
+	if err != nil {
+		return err
+	}
+
+	rotaDetails, err := c.handler.GetRotaDetails(channelId, rotaName)
+	if err != nil {
+		return err
+	}
+
+	var reply string
+	switch {
+	case ch == nil || rotaDetails == nil:
+		reply = "Sorry, that change has already been approved or rejected."
+	case ch.RequestedBy == userId:
+		reply = fmt.Sprintf("[%v] Someone else needs to decide on your own change.", rotaName)
+	default:
+		isOwner, err := c.hasRole(userId, config.RoleOwners, rotaDetails)
+		if err != nil {
+			return err
+		}
+
+		if !isOwner {
+			reply = fmt.Sprintf("[%v] Only owners of the rota can decide on its changes.", rotaName)
+		}
+	}
+
+	if reply == "" && approved {
+		reply, err = c.applyChange(rotaDetails, ch)
+		if err != nil {
+			return err
+		}
+	}
+
+	if reply != "" {
+		return c.respondToClient(interaction.Channel.ID, userId, errorAttachment(reply))
+	}
+
+	err = c.handler.DeleteChange(channelId, rotaName, changeId)
+	if err != nil {
+		return err
+	}
+
+	event := webhook.EventChangeRejected
+	decision := "rejected"
+	color := "#f0303a"
+	if approved {
+		event = webhook.EventChangeApproved
+		decision = "approved"
+		color = "#4af030"
+	}
+
+	c.emit(webhook.Event{Type: event, ChannelId: channelId, RotaName: rotaName, Actor: userId, Change: ch.Type, RequestedBy: ch.RequestedBy})
+
+	requesterAttachment := slack.Attachment{}
+	requesterAttachment.Text = fmt.Sprintf("[%v] %s %s your request to %s.", rotaName, formatter.AtUserId(userId), decision, describeChange(ch))
+	requesterAttachment.Color = color
+	_, _, err = c.client.PostMessage(ch.RequestedBy, requesterAttachment)
+	if err != nil {
+		return err
+	}
+
+	attachment := slack.Attachment{}
+	attachment.Text = fmt.Sprintf("[%v] You %s %s's request to %s.", rotaName, decision, formatter.AtUserId(ch.RequestedBy), describeChange(ch))
+	attachment.Color = color
+
+	// Take the buttons off the direct message the decision came from.
+	// Ephemeral messages, such as /rota show's, can't be updated.
+	if interaction.Container.MessageTs != "" && !interaction.Container.IsEphemeral {
+		_, _, _, err = c.client.UpdateMessage(interaction.Container.ChannelID, interaction.Container.MessageTs, attachment)
+		return err
+	}
+
+	return c.respondToClient(interaction.Channel.ID, userId, &attachment)
+}
+
+// applyChange makes an approved change. If the rota has moved on so that it no
+// longer makes sense, it says why instead.
+func (c *RotaCommand) applyChange(rotaDetails *rotadetails.RotaDetails, ch *change.Change) (string, error) {
+	rotaName := rotaDetails.RotaName()
+	outdated := fmt.Sprintf("[%v] The rota has changed since this was asked for, so it can't be approved. Reject it instead.", rotaName)
+
+	switch ch.Type {
+	case change.TypeMembers:
+		if rotaDetails.CurrOnCallMember != "" {
+			return fmt.Sprintf("[%v] Can't update rota whilst someone is on duty.", rotaName), nil
+		}
+
+		err := c.handler.SaveRotaDetails(rotaDetails.Pk, rotaName, ch.NewMembers, ch.NewDuration)
+		if err != nil {
+			return "", err
+		}
+
+		c.emit(webhook.Event{Type: webhook.EventMembersChanged, ChannelId: rotaDetails.Pk, RotaName: rotaName, Actor: ch.RequestedBy})
+		return "", nil
+	case change.TypeSkip, change.TypeSwap:
+		if swapRefusal(rotaDetails, ch.RequestedBy, ch.Member) != "" {
+			return outdated, nil
+		}
+
+		var err error
+		if ch.Type == change.TypeSkip {
+			err = c.skipShift(rotaDetails, ch.RequestedBy, ch.Member)
+		} else {
+			err = c.swapShift(rotaDetails, ch.RequestedBy, ch.Member)
+		}
+		if err != nil {
+			return "", err
+		}
+	case change.TypeOverride:
+		if overrideRefusal(rotaDetails, ch.Member) != "" {
+			return outdated, nil
+		}
+
+		err := c.overrideShift(rotaDetails, ch.RequestedBy, ch.Member)
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unknown change type %q", ch.Type)
+	}
+
+	return "", c.PublishHome(ch.RequestedBy)
+}
+
+// setRequireApproval turns approvals on or off for the rota. Changes already
+// waiting for approval still need deciding on.
+func (c *RotaCommand) setRequireApproval(rotaDetails *rotadetails.RotaDetails, requireApproval bool) (*slack.Attachment, error) {
+	rotaName := rotaDetails.RotaName()
+
+	err := c.handler.SaveRequireApproval(rotaDetails.Pk, rotaName, requireApproval)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment := slack.Attachment{}
+	attachment.Text = fmt.Sprintf("[%v] Changes to members and shifts now need an owner's approval.", rotaName)
+	if !requireApproval {
+		attachment.Text = fmt.Sprintf("[%v] Changes to members and shifts no longer need approval.", rotaName)
+	}
+	attachment.Color = "#4af030"
+
+	return &attachment, nil
+}
+
+// changeBlocks lists the rota's changes awaiting approval, with buttons to
+// decide on them for anyone who may.
+func (c *RotaCommand) changeBlocks(userId string, rotaDetails *rotadetails.RotaDetails) ([]slack.Block, error) {
+	changes, err := c.handler.GetChanges(rotaDetails.Pk, rotaDetails.RotaName())
+	if err != nil || len(changes) == 0 {
+		return nil, err
+	}
+
+	isOwner, err := c.hasRole(userId, config.RoleOwners, rotaDetails)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := []slack.Block{markdownSection("*Waiting for approval:*")}
+	for _, v := range changes {
+		attachment := changeAttachment(rotaDetails, v, isOwner && v.RequestedBy != userId)
+		blocks = append(blocks, attachment.Blocks.BlockSet...)
+	}
+
+	return blocks, nil
+}
+
+func changeAttachment(rotaDetails *rotadetails.RotaDetails, ch *change.Change, decidable bool) *slack.Attachment {
+	text := fmt.Sprintf("[%v] %s wants to %s.", rotaDetails.RotaName(), formatter.AtUserId(ch.RequestedBy), describeChange(ch))
+
+	blocks := []slack.Block{markdownSection(text)}
+	if decidable {
+		blocks = append(blocks, slack.NewActionBlock(
+			"change_"+ch.Id(),
+			&slack.ButtonBlockElement{
+				Type:     "button",
+				ActionID: ApproveChangeAction,
+				Text:     &slack.TextBlockObject{Text: "Approve", Type: slack.PlainTextType},
+				Style:    slack.StylePrimary,
+				Value:    ch.Reference(),
+			},
+			&slack.ButtonBlockElement{
+				Type:     "button",
+				ActionID: RejectChangeAction,
+				Text:     &slack.TextBlockObject{Text: "Reject", Type: slack.PlainTextType},
+				Style:    slack.StyleDanger,
+				Value:    ch.Reference(),
+			},
+		))
+	}
+
+	attachment := slack.Attachment{}
+	attachment.Fallback = text
+	attachment.Blocks = slack.Blocks{BlockSet: blocks}
+
+	return &attachment
+}
+
+// describeChange completes "wants to ...".
+func describeChange(ch *change.Change) string {
+	switch ch.Type {
+	case change.TypeMembers:
+		return fmt.Sprintf("change the members to %s, with %s week shifts", atUserIds(ch.NewMembers), ch.NewDuration)
+	case change.TypeSkip:
+		return fmt.Sprintf("skip a shift, so %s goes first", formatter.AtUserId(ch.Member))
+	case change.TypeSwap:
+		return fmt.Sprintf("swap shifts with %s", formatter.AtUserId(ch.Member))
+	case change.TypeOverride:
+		return fmt.Sprintf("have %s cover the current shift", formatter.AtUserId(ch.Member))
+	default:
+		return ch.Type
+	}
+}
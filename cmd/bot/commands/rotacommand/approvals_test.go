@@ -0,0 +1,260 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/handler"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/admin"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/metadata"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/webhook"
+	"alfred-bot/utils/clock"
+	"alfred-bot/utils/formatter"
+	"errors"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/slack-go/slack"
+	"time"
+)
+
+var _ = Describe("Approvals", func() {
+	var fakeClock *clock.Fake
+	var store *handler.MemoryHandler
+	var mockSlackClient *MockSlackClient
+	var rotaCommand *RotaCommand
+
+	startOfShift := time.Date(2022, time.March, 7, 9, 0, 0, 0, time.UTC)
+	endOfShift := time.Date(2022, time.March, 14, 9, 0, 0, 0, time.UTC)
+
+	promptAs := func(userId string, text string) *slack.Attachment {
+		payload, err := rotaCommand.Prompt(slack.SlashCommand{ChannelID: testChannelId, UserID: userId, Text: text})
+		Expect(err).To(BeNil())
+		if payload == nil {
+			return nil
+		}
+		return payload.(*slack.Attachment)
+	}
+
+	buttons := func(attachment *slack.Attachment) []string {
+		var actionIds []string
+		for _, block := range attachment.Blocks.BlockSet {
+			if actions, ok := block.(*slack.ActionBlock); ok {
+				for _, v := range actions.Elements.ElementSet {
+					actionIds = append(actionIds, v.(*slack.ButtonBlockElement).ActionID)
+				}
+			}
+		}
+		return actionIds
+	}
+
+	swap := func(userId string, member string) {
+		value, err := metadata.GenerateCommandMetadata(testChannelId, testRotaName, "", "")
+		Expect(err).To(BeNil())
+
+		interaction := &slack.InteractionCallback{}
+		interaction.User.ID = userId
+		interaction.View.PrivateMetadata = value
+		interaction.View.State = &slack.ViewState{
+			Values: map[string]map[string]slack.BlockAction{
+				rotaMemberBlock: {rotaMemberAction: {SelectedOption: slack.OptionBlockObject{Value: member}}},
+			},
+		}
+		Expect(rotaCommand.SwapShift(interaction)).To(Succeed())
+	}
+
+	// decide clicks a button in the direct message sent to the user.
+	decide := func(userId string, actionId string) {
+		dms := mockSlackClient.PostsTo(userId)
+		Expect(dms).ToNot(BeEmpty())
+		var action *slack.BlockAction
+		for _, block := range dms[len(dms)-1].Blocks.BlockSet {
+			if actions, ok := block.(*slack.ActionBlock); ok {
+				for _, v := range actions.Elements.ElementSet {
+					if button := v.(*slack.ButtonBlockElement); button.ActionID == actionId {
+						action = &slack.BlockAction{ActionID: actionId, Value: button.Value}
+					}
+				}
+			}
+		}
+		Expect(action).ToNot(BeNil())
+
+		interaction := &slack.InteractionCallback{}
+		interaction.User.ID = userId
+		interaction.Channel.ID = "D" + userId
+		interaction.Container = slack.Container{Type: "message", ChannelID: "D" + userId, MessageTs: "42"}
+		if actionId == ApproveChangeAction {
+			Expect(rotaCommand.ApproveChange(interaction, action)).To(Succeed())
+		} else {
+			Expect(rotaCommand.RejectChange(interaction, action)).To(Succeed())
+		}
+	}
+
+	historyTypes := func() []string {
+		entries, err := store.GetHistory(testChannelId, testRotaName, 20)
+		Expect(err).To(BeNil())
+		var types []string
+		for _, v := range entries {
+			types = append(types, v.Type)
+		}
+		return types
+	}
+
+	BeforeEach(func() {
+		fakeClock = clock.NewFake(startOfShift)
+		store = handler.NewMemoryHandler(fakeClock)
+		mockSlackClient = &MockSlackClient{}
+		rotaCommand = New(store, mockSlackClient, fakeClock, testRotaConfig)
+
+		Expect(store.SaveRotaDetails(testChannelId, testRotaName, []string{"Evan", "Sia", "Wai"}, "1")).To(Succeed())
+		Expect(store.SaveRotaOwners(testChannelId, testRotaName, []string{"Suan", "Sia"})).To(Succeed())
+		Expect(store.UpdateOnCallMember(testChannelId, testRotaName, "Evan", formatter.FormatTime(startOfShift), formatter.FormatTime(endOfShift))).To(Succeed())
+	})
+
+	It("Only lets owners turn approvals on", func() {
+		Expect(promptAs("Evan", "approvals dummy_rota on").Text).To(Equal("[dummy_rota] Only owners of the rota can change whether it needs approvals."))
+		Expect(promptAs("Suan", "approvals dummy_rota maybe").Text).To(ContainSubstring("maybe should be on or off."))
+
+		Expect(promptAs("Suan", "approvals dummy_rota on").Color).To(Equal("#4af030"))
+		rotaDetails, _ := store.GetRotaDetails(testChannelId, testRotaName)
+		Expect(rotaDetails.RequireApproval).To(BeTrue())
+	})
+
+	Context("With approvals on", func() {
+		BeforeEach(func() {
+			Expect(store.SaveRequireApproval(testChannelId, testRotaName, true)).To(Succeed())
+		})
+
+		It("Holds a swap until an owner approves it", func() {
+			swap("Evan", "Wai")
+
+			rotaDetails, _ := store.GetRotaDetails(testChannelId, testRotaName)
+			Expect(rotaDetails.Members).To(Equal([]string{"Evan", "Sia", "Wai"}))
+			Expect(mockSlackClient.PostsTo("Suan")[0].Fallback).To(Equal("[dummy_rota] <@Evan> wants to swap shifts with <@Wai>."))
+			Expect(mockSlackClient.PostsTo("Sia")).To(HaveLen(1))
+			Expect(mockSlackClient.PostsTo("Evan")).To(BeEmpty())
+
+			decide("Suan", ApproveChangeAction)
+
+			rotaDetails, _ = store.GetRotaDetails(testChannelId, testRotaName)
+			Expect(rotaDetails.Members).To(Equal([]string{"Wai", "Sia", "Evan"}))
+			Expect(rotaDetails.CurrOnCallMember).To(Equal("Wai"))
+			Expect(mockSlackClient.UpdateTo("42")).To(Equal("[dummy_rota] You approved <@Evan>'s request to swap shifts with <@Wai>."))
+			Expect(mockSlackClient.PostsTo("Evan")[0].Text).To(Equal("[dummy_rota] <@Suan> approved your request to swap shifts with <@Wai>."))
+			Expect(mockSlackClient.Messages()).To(ContainElement("[dummy_rota] <@Evan> and <@Wai> swapped shifts."))
+			Expect(historyTypes()).To(ConsistOf(webhook.EventChangeRequested, webhook.EventSwap, webhook.EventChangeApproved))
+
+			// The other owner's buttons no longer do anything.
+			decide("Sia", RejectChangeAction)
+			Expect(historyTypes()).To(HaveLen(3))
+		})
+
+		It("Drops a rejected change", func() {
+			swap("Evan", "Wai")
+			decide("Sia", RejectChangeAction)
+
+			rotaDetails, _ := store.GetRotaDetails(testChannelId, testRotaName)
+			Expect(rotaDetails.Members).To(Equal([]string{"Evan", "Sia", "Wai"}))
+			Expect(mockSlackClient.PostsTo("Evan")[0].Text).To(Equal("[dummy_rota] <@Sia> rejected your request to swap shifts with <@Wai>."))
+			Expect(historyTypes()).To(ConsistOf(webhook.EventChangeRequested, webhook.EventChangeRejected))
+			Expect(store.GetChanges(testChannelId, testRotaName)).To(BeEmpty())
+		})
+
+		It("Doesn't let owners approve their own changes", func() {
+			swap("Sia", "Wai")
+			Expect(mockSlackClient.PostsTo("Sia")).To(BeEmpty())
+
+			attachment := promptAs("Sia", "show dummy_rota")
+			Expect(attachment.Blocks.BlockSet).To(ContainElement(markdownSection("[dummy_rota] <@Sia> wants to swap shifts with <@Wai>.")))
+			Expect(buttons(attachment)).To(Equal([]string{StopRotaAction, PageOnCallPromptAction}))
+			Expect(buttons(promptAs("Suan", "show dummy_rota"))).To(Equal([]string{ApproveChangeAction, RejectChangeAction, StopRotaAction, PageOnCallPromptAction}))
+		})
+
+		It("Asks the admins when the rota's only owner asks for a change", func() {
+			Expect(store.SaveRotaOwners(testChannelId, testRotaName, []string{"Sia"})).To(Succeed())
+			Expect(store.SaveAdmin(&admin.Admin{Pk: admin.Key, Sk: "Ama"})).To(Succeed())
+			Expect(store.SaveAdmin(&admin.Admin{Pk: admin.Key, Sk: "Sia"})).To(Succeed())
+
+			swap("Sia", "Wai")
+			Expect(mockSlackClient.PostsTo("Ama")[0].Fallback).To(Equal("[dummy_rota] <@Sia> wants to swap shifts with <@Wai>."))
+			Expect(mockSlackClient.PostsTo("Sia")).To(BeEmpty())
+
+			decide("Ama", ApproveChangeAction)
+			Expect(historyTypes()).To(ContainElement(webhook.EventSwap))
+		})
+
+		It("Tells the rota's only owner when there's no one to approve their change", func() {
+			var replies []string
+			mockSlackClient.PostEphemeralStub = func(channelID string, userID string, attachment slack.Attachment) (string, error) {
+				replies = append(replies, attachment.Text)
+				return "", nil
+			}
+			Expect(store.SaveRotaOwners(testChannelId, testRotaName, []string{"Sia"})).To(Succeed())
+			Expect(store.SaveAdmin(&admin.Admin{Pk: admin.Key, Sk: "Sia"})).To(Succeed())
+
+			swap("Sia", "Wai")
+			Expect(replies).To(Equal([]string{"[dummy_rota] There's no one to approve your request to swap shifts with <@Wai>, as no one else owns the rota and there are no admins. Ask a workspace admin to add an owner with `/rota add-owner` or an admin with `/rota add-admin`."}))
+			Expect(store.GetChanges(testChannelId, testRotaName)).To(BeEmpty())
+			Expect(historyTypes()).To(BeEmpty())
+
+			rotaDetails, _ := store.GetRotaDetails(testChannelId, testRotaName)
+			Expect(rotaDetails.Members).To(Equal([]string{"Evan", "Sia", "Wai"}))
+		})
+
+		It("Asks the other owners even if one can't be messaged", func() {
+			mockSlackClient.PostMessageStub = func(channelID string, attachment slack.Attachment) (string, string, error) {
+				if channelID == "Suan" {
+					return "", "", errors.New("channel_not_found")
+				}
+				mockSlackClient.PostMessageStub = nil
+				return mockSlackClient.PostMessage(channelID, attachment)
+			}
+
+			swap("Evan", "Wai")
+			Expect(mockSlackClient.PostsTo("Sia")).To(HaveLen(1))
+			Expect(store.GetChanges(testChannelId, testRotaName)).To(HaveLen(1))
+		})
+
+		It("Holds member changes until the rota is free to change", func() {
+			value, err := metadata.GenerateCommandMetadata(testChannelId, testRotaName, "", "")
+			Expect(err).To(BeNil())
+			interaction := &slack.InteractionCallback{}
+			interaction.User.ID = "Sia"
+			interaction.View.PrivateMetadata = value
+			interaction.View.State = &slack.ViewState{
+				Values: map[string]map[string]slack.BlockAction{
+					rotaMembersBlock:  {rotaMembersAction: {SelectedUsers: []string{"Sia", "Wai"}}},
+					rotaDurationBlock: {rotaDurationAction: {SelectedOption: slack.OptionBlockObject{Value: "2"}}},
+				},
+			}
+			Expect(rotaCommand.UpdateRota(interaction)).To(Succeed())
+			Expect(mockSlackClient.PostsTo("Suan")[0].Fallback).To(Equal("[dummy_rota] <@Sia> wants to change the members to <@Sia>, <@Wai>, with 2 week shifts."))
+
+			decide("Suan", ApproveChangeAction)
+			Expect(store.GetChanges(testChannelId, testRotaName)).To(HaveLen(1))
+
+			Expect(promptAs("Suan", "stop dummy_rota")).To(BeNil())
+			decide("Suan", ApproveChangeAction)
+
+			rotaDetails, _ := store.GetRotaDetails(testChannelId, testRotaName)
+			Expect(rotaDetails.Members).To(Equal([]string{"Sia", "Wai"}))
+			Expect(rotaDetails.Duration).To(Equal(2))
+		})
+
+		It("Won't approve an override the rota has moved past", func() {
+			interaction := &slack.InteractionCallback{}
+			interaction.User.ID = "Evan"
+			value, err := metadata.GenerateCommandMetadata(testChannelId, testRotaName, "", "")
+			Expect(err).To(BeNil())
+			interaction.View.PrivateMetadata = value
+			interaction.View.State = &slack.ViewState{
+				Values: map[string]map[string]slack.BlockAction{
+					rotaMemberBlock: {rotaMemberAction: {SelectedOption: slack.OptionBlockObject{Value: "Wai"}}},
+				},
+			}
+			Expect(rotaCommand.OverrideShift(interaction)).To(Succeed())
+
+			Expect(store.UpdateOnCallMember(testChannelId, testRotaName, "Wai", formatter.FormatTime(startOfShift), formatter.FormatTime(endOfShift))).To(Succeed())
+			decide("Suan", ApproveChangeAction)
+
+			Expect(store.GetChanges(testChannelId, testRotaName)).To(HaveLen(1))
+			Expect(historyTypes()).To(ConsistOf(webhook.EventChangeRequested))
+		})
+	})
+})
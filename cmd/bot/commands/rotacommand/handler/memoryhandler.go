@@ -0,0 +1,639 @@
+package handler
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/models/admin"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/alert"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/apitoken"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/change"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/history"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/outbox"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/page"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/webhook"
+	"alfred-bot/utils/clock"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// MemoryHandler is a CommandHandler that keeps rotas in memory. It mirrors the
+// behaviour of RotaHandler closely enough to exercise the rota logic without a
+// database.
+type MemoryHandler struct {
+	mu     sync.Mutex
+	clock  clock.Clock
+	rotas  map[string]map[string]*rotadetails.RotaDetails
+	pages  map[string]*page.Page
+	alerts map[string]*alert.Alert
+	// deliveries and history are kept oldest first, per rota.
+	deliveries map[string][]*webhook.Delivery
+	history    map[string][]*history.Entry
+	apiTokens  map[string]*apitoken.Token
+	admins     map[string]*admin.Admin
+	// changes are keyed by their partition key, then ID.
+	changes map[string]map[string]*change.Change
+	outbox  map[string]*outbox.Message
+	retries map[string]*webhook.Retry
+}
+
+func NewMemoryHandler(clock clock.Clock) *MemoryHandler {
+	return &MemoryHandler{
+		clock:      clock,
+		rotas:      map[string]map[string]*rotadetails.RotaDetails{},
+		pages:      map[string]*page.Page{},
+		alerts:     map[string]*alert.Alert{},
+		deliveries: map[string][]*webhook.Delivery{},
+		history:    map[string][]*history.Entry{},
+		apiTokens:  map[string]*apitoken.Token{},
+		admins:     map[string]*admin.Admin{},
+		changes:    map[string]map[string]*change.Change{},
+		outbox:     map[string]*outbox.Message{},
+		retries:    map[string]*webhook.Retry{},
+	}
+}
+
+func (h *MemoryHandler) GetRotaNames(channelId string) ([]string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var rotaNames []string
+	for rotaName := range h.rotas[channelId] {
+		rotaNames = append(rotaNames, rotaName)
+	}
+	sort.Strings(rotaNames)
+
+	return rotaNames, nil
+}
+
+func (h *MemoryHandler) GetRotaDetails(channelId string, rotaName string) (*rotadetails.RotaDetails, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rotaDetails, ok := h.rotas[channelId][rotaName]
+	if !ok {
+		return nil, nil
+	}
+
+	return copyRotaDetails(rotaDetails), nil
+}
+
+func (h *MemoryHandler) GetOnCallShifts() ([]*rotadetails.RotaDetails, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var rotas []*rotadetails.RotaDetails
+	for _, channelRotas := range h.rotas {
+		for _, rotaDetails := range channelRotas {
+			if rotaDetails.EndOfShift != "" {
+				rotas = append(rotas, copyRotaDetails(rotaDetails))
+			}
+		}
+	}
+
+	return rotas, nil
+}
+
+func (h *MemoryHandler) GetEndingOnCallShifts() ([]*rotadetails.RotaDetails, error) {
+	rotas, err := h.GetOnCallShifts()
+	if err != nil {
+		return nil, err
+	}
+
+	return endingOnCallShifts(rotas, h.clock.Now())
+}
+
+func (h *MemoryHandler) GetUserRotas(userId string) ([]*rotadetails.RotaDetails, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var rotas []*rotadetails.RotaDetails
+	for _, channelRotas := range h.rotas {
+		for _, rotaDetails := range channelRotas {
+			if rotaDetails.HasMember(userId) || rotaDetails.HasOwner(userId) {
+				rotas = append(rotas, copyRotaDetails(rotaDetails))
+			}
+		}
+	}
+
+	return rotas, nil
+}
+
+func (h *MemoryHandler) GetRotas() ([]*rotadetails.RotaDetails, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var rotas []*rotadetails.RotaDetails
+	for _, channelRotas := range h.rotas {
+		for _, rotaDetails := range channelRotas {
+			rotas = append(rotas, copyRotaDetails(rotaDetails))
+		}
+	}
+
+	return rotas, nil
+}
+
+func (h *MemoryHandler) SaveRotaDetails(channelId string, rotaName string, rotaMembers []string, rotaDuration string) error {
+	duration, err := strconv.Atoi(rotaDuration)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rotaDetails := h.upsert(channelId, rotaName)
+	rotaDetails.Members = append([]string{}, rotaMembers...)
+	rotaDetails.Duration = duration
+
+	return nil
+}
+
+func (h *MemoryHandler) SaveRotaOwners(channelId string, rotaName string, owners []string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.upsert(channelId, rotaName).Owners = append([]string{}, owners...)
+
+	return nil
+}
+
+func (h *MemoryHandler) SaveRotaTiers(channelId string, rotaName string, tiers []string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.upsert(channelId, rotaName).Tiers = append([]string{}, tiers...)
+
+	return nil
+}
+
+func (h *MemoryHandler) SaveHandoverAnchor(channelId string, rotaName string, handoverAnchor string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.upsert(channelId, rotaName).HandoverAnchor = handoverAnchor
+
+	return nil
+}
+
+func (h *MemoryHandler) SaveRequireApproval(channelId string, rotaName string, requireApproval bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.upsert(channelId, rotaName).RequireApproval = requireApproval
+
+	return nil
+}
+
+func (h *MemoryHandler) SaveWindows(channelId string, rotaName string, timeZone string, windows []rotadetails.Window) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rotaDetails := h.upsert(channelId, rotaName)
+	rotaDetails.Windows = append([]rotadetails.Window{}, windows...)
+	rotaDetails.TimeZone = timeZone
+
+	return nil
+}
+
+func (h *MemoryHandler) DeleteRota(channelId string, rotaName string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.rotas[channelId], rotaName)
+
+	return nil
+}
+
+func (h *MemoryHandler) UpdateOnCallMember(channelId string, rotaName string, newOnCallMember string, startOfShift string, endOfShift string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rotaDetails := h.upsert(channelId, rotaName)
+	rotaDetails.CurrOnCallMember = newOnCallMember
+	rotaDetails.OverriddenOnCallMember = ""
+	rotaDetails.StartOfShift = startOfShift
+	rotaDetails.EndOfShift = endOfShift
+
+	return nil
+}
+
+func (h *MemoryHandler) OverrideOnCallMember(channelId string, rotaName string, newOnCallMember string, overriddenOnCallMember string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rotaDetails := h.upsert(channelId, rotaName)
+	rotaDetails.CurrOnCallMember = newOnCallMember
+	rotaDetails.OverriddenOnCallMember = overriddenOnCallMember
+
+	return nil
+}
+
+func (h *MemoryHandler) GetPage(channelId string, pageId string) (*page.Page, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	p, ok := h.pages[page.Key(channelId)+"/"+pageId]
+	if !ok {
+		return nil, nil
+	}
+
+	return copyPage(p), nil
+}
+
+func (h *MemoryHandler) GetOpenPages() ([]*page.Page, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var pages []*page.Page
+	for _, p := range h.pages {
+		if p.IsOpen() {
+			pages = append(pages, copyPage(p))
+		}
+	}
+
+	return pages, nil
+}
+
+func (h *MemoryHandler) SavePage(p *page.Page) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.pages[p.Pk+"/"+p.Sk] = copyPage(p)
+
+	return nil
+}
+
+func (h *MemoryHandler) SaveRoutingKey(channelId string, rotaName string, routingKey string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.upsert(channelId, rotaName).RoutingKey = routingKey
+
+	return nil
+}
+
+func (h *MemoryHandler) DeleteRoutingKey(channelId string, rotaName string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if rotaDetails, ok := h.rotas[channelId][rotaName]; ok {
+		rotaDetails.RoutingKey = ""
+	}
+
+	return nil
+}
+
+func (h *MemoryHandler) GetRotaByRoutingKey(routingKey string) (*rotadetails.RotaDetails, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, rotas := range h.rotas {
+		for _, v := range rotas {
+			if v.RoutingKey == routingKey {
+				return copyRotaDetails(v), nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func (h *MemoryHandler) GetAlert(channelId string, fingerprint string) (*alert.Alert, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	a, ok := h.alerts[alert.Key(channelId)+"/"+fingerprint]
+	if !ok {
+		return nil, nil
+	}
+
+	alertCopy := *a
+	return &alertCopy, nil
+}
+
+func (h *MemoryHandler) SaveAlert(a *alert.Alert) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	alertCopy := *a
+	h.alerts[a.Pk+"/"+a.Sk] = &alertCopy
+
+	return nil
+}
+
+func (h *MemoryHandler) SaveWebhooks(channelId string, rotaName string, webhooks []webhook.Webhook) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.upsert(channelId, rotaName).Webhooks = append([]webhook.Webhook{}, webhooks...)
+
+	return nil
+}
+
+func (h *MemoryHandler) SaveDelivery(d *webhook.Delivery) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	deliveryCopy := *d
+	h.deliveries[d.Pk] = append(h.deliveries[d.Pk], &deliveryCopy)
+	sort.Slice(h.deliveries[d.Pk], func(i, j int) bool {
+		return h.deliveries[d.Pk][i].Sk < h.deliveries[d.Pk][j].Sk
+	})
+
+	return nil
+}
+
+func (h *MemoryHandler) GetDeliveries(channelId string, rotaName string, limit int) ([]*webhook.Delivery, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stored := h.deliveries[webhook.DeliveryKey(channelId, rotaName)]
+
+	var deliveries []*webhook.Delivery
+	for i := len(stored) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		deliveryCopy := *stored[i]
+		deliveries = append(deliveries, &deliveryCopy)
+	}
+
+	return deliveries, nil
+}
+
+func (h *MemoryHandler) SaveHistoryEntry(e *history.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entryCopy := *e
+	h.history[e.Pk] = append(h.history[e.Pk], &entryCopy)
+	sort.Slice(h.history[e.Pk], func(i, j int) bool {
+		return h.history[e.Pk][i].Sk < h.history[e.Pk][j].Sk
+	})
+
+	return nil
+}
+
+func (h *MemoryHandler) GetHistory(channelId string, rotaName string, limit int) ([]*history.Entry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stored := h.history[history.Key(channelId, rotaName)]
+
+	var entries []*history.Entry
+	for i := len(stored) - 1; i >= 0 && len(entries) < limit; i-- {
+		entryCopy := *stored[i]
+		entries = append(entries, &entryCopy)
+	}
+
+	return entries, nil
+}
+
+func (h *MemoryHandler) SaveAPIToken(t *apitoken.Token) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	tokenCopy := *t
+	h.apiTokens[t.Sk] = &tokenCopy
+
+	return nil
+}
+
+func (h *MemoryHandler) GetAPIToken(hash string) (*apitoken.Token, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t, ok := h.apiTokens[hash]
+	if !ok {
+		return nil, nil
+	}
+
+	tokenCopy := *t
+	return &tokenCopy, nil
+}
+
+func (h *MemoryHandler) GetAPITokens() ([]*apitoken.Token, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var tokens []*apitoken.Token
+	for _, t := range h.apiTokens {
+		tokenCopy := *t
+		tokens = append(tokens, &tokenCopy)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Sk < tokens[j].Sk
+	})
+
+	return tokens, nil
+}
+
+func (h *MemoryHandler) DeleteAPIToken(hash string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.apiTokens, hash)
+
+	return nil
+}
+
+func (h *MemoryHandler) SaveAdmin(a *admin.Admin) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	adminCopy := *a
+	h.admins[a.Sk] = &adminCopy
+
+	return nil
+}
+
+func (h *MemoryHandler) GetAdmin(userId string) (*admin.Admin, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	a, ok := h.admins[userId]
+	if !ok {
+		return nil, nil
+	}
+
+	adminCopy := *a
+	return &adminCopy, nil
+}
+
+func (h *MemoryHandler) GetAdmins() ([]*admin.Admin, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var admins []*admin.Admin
+	for _, a := range h.admins {
+		adminCopy := *a
+		admins = append(admins, &adminCopy)
+	}
+	sort.Slice(admins, func(i, j int) bool {
+		return admins[i].Sk < admins[j].Sk
+	})
+
+	return admins, nil
+}
+
+func (h *MemoryHandler) DeleteAdmin(userId string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.admins, userId)
+
+	return nil
+}
+
+func (h *MemoryHandler) SaveChange(c *change.Change) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.changes[c.Pk]; !ok {
+		h.changes[c.Pk] = map[string]*change.Change{}
+	}
+	h.changes[c.Pk][c.Sk] = copyChange(c)
+
+	return nil
+}
+
+func (h *MemoryHandler) GetChange(channelId string, rotaName string, changeId string) (*change.Change, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c, ok := h.changes[change.Key(channelId, rotaName)][changeId]
+	if !ok {
+		return nil, nil
+	}
+
+	return copyChange(c), nil
+}
+
+func (h *MemoryHandler) GetChanges(channelId string, rotaName string) ([]*change.Change, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var changes []*change.Change
+	for _, c := range h.changes[change.Key(channelId, rotaName)] {
+		changes = append(changes, copyChange(c))
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Sk < changes[j].Sk
+	})
+
+	return changes, nil
+}
+
+func (h *MemoryHandler) DeleteChange(channelId string, rotaName string, changeId string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.changes[change.Key(channelId, rotaName)], changeId)
+
+	return nil
+}
+
+func (h *MemoryHandler) SaveOutboxMessage(m *outbox.Message) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	messageCopy := *m
+	h.outbox[m.Sk] = &messageCopy
+
+	return nil
+}
+
+func (h *MemoryHandler) GetOutboxMessages() ([]*outbox.Message, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var messages []*outbox.Message
+	for _, m := range h.outbox {
+		messageCopy := *m
+		messages = append(messages, &messageCopy)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].Sk < messages[j].Sk
+	})
+
+	return messages, nil
+}
+
+func (h *MemoryHandler) DeleteOutboxMessage(messageId string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.outbox, messageId)
+
+	return nil
+}
+
+func (h *MemoryHandler) SaveWebhookRetry(r *webhook.Retry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	retryCopy := *r
+	h.retries[r.Sk] = &retryCopy
+
+	return nil
+}
+
+func (h *MemoryHandler) GetWebhookRetries() ([]*webhook.Retry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var retries []*webhook.Retry
+	for _, r := range h.retries {
+		retryCopy := *r
+		retries = append(retries, &retryCopy)
+	}
+	sort.Slice(retries, func(i, j int) bool {
+		return retries[i].Sk < retries[j].Sk
+	})
+
+	return retries, nil
+}
+
+func (h *MemoryHandler) DeleteWebhookRetry(retryId string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.retries, retryId)
+
+	return nil
+}
+
+// upsert finds the rota, creating it first if need be, as UpdateItem does.
+func (h *MemoryHandler) upsert(channelId string, rotaName string) *rotadetails.RotaDetails {
+	if _, ok := h.rotas[channelId]; !ok {
+		h.rotas[channelId] = map[string]*rotadetails.RotaDetails{}
+	}
+
+	rotaDetails, ok := h.rotas[channelId][rotaName]
+	if !ok {
+		rotaDetails = &rotadetails.RotaDetails{Pk: channelId, Sk: rotaName}
+		h.rotas[channelId][rotaName] = rotaDetails
+	}
+
+	return rotaDetails
+}
+
+func copyRotaDetails(rotaDetails *rotadetails.RotaDetails) *rotadetails.RotaDetails {
+	rotaDetailsCopy := *rotaDetails
+	rotaDetailsCopy.Members = append([]string{}, rotaDetails.Members...)
+	rotaDetailsCopy.Owners = append([]string{}, rotaDetails.Owners...)
+	rotaDetailsCopy.Tiers = append([]string{}, rotaDetails.Tiers...)
+	rotaDetailsCopy.Webhooks = append([]webhook.Webhook{}, rotaDetails.Webhooks...)
+	rotaDetailsCopy.Windows = append([]rotadetails.Window{}, rotaDetails.Windows...)
+	return &rotaDetailsCopy
+}
+
+func copyChange(c *change.Change) *change.Change {
+	changeCopy := *c
+	changeCopy.NewMembers = append([]string{}, c.NewMembers...)
+	return &changeCopy
+}
+
+func copyPage(p *page.Page) *page.Page {
+	pageCopy := *p
+	pageCopy.Responders = append([]string{}, p.Responders...)
+	pageCopy.Timeline = append([]page.Event{}, p.Timeline...)
+	return &pageCopy
+}
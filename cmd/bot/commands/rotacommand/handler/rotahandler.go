@@ -1,33 +1,157 @@
 package handler
 
 import (
+	"alfred-bot/cmd/bot/commands/rotacommand/models/admin"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/alert"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/apitoken"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/change"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/history"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/membership"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/outbox"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/page"
 	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/routingkey"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/webhook"
+	"alfred-bot/utils/clock"
 	"alfred-bot/utils/db"
 	"alfred-bot/utils/formatter"
 	"context"
+	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"slices"
+	"strings"
 	"time"
 )
 
 type CommandHandler interface {
 	GetRotaNames(channelId string) ([]string, error)
 	GetRotaDetails(channelId string, rotaName string) (*rotadetails.RotaDetails, error)
+	GetOnCallShifts() ([]*rotadetails.RotaDetails, error)
 	GetEndingOnCallShifts() ([]*rotadetails.RotaDetails, error)
+	GetUserRotas(userId string) ([]*rotadetails.RotaDetails, error)
+	GetRotas() ([]*rotadetails.RotaDetails, error)
 	SaveRotaDetails(channelId string, rotaName string, rotaMembers []string, rotaDuration string) error
+	SaveRotaOwners(channelId string, rotaName string, owners []string) error
+	SaveRotaTiers(channelId string, rotaName string, tiers []string) error
+	SaveHandoverAnchor(channelId string, rotaName string, handoverAnchor string) error
+	SaveRequireApproval(channelId string, rotaName string, requireApproval bool) error
+	SaveWindows(channelId string, rotaName string, timeZone string, windows []rotadetails.Window) error
+	DeleteRota(channelId string, rotaName string) error
 	UpdateOnCallMember(channelId string, rotaName string, newOnCallMember string, startOfShift string, endOfShift string) error
+	OverrideOnCallMember(channelId string, rotaName string, newOnCallMember string, overriddenOnCallMember string) error
+	GetPage(channelId string, pageId string) (*page.Page, error)
+	GetOpenPages() ([]*page.Page, error)
+	SavePage(p *page.Page) error
+	SaveRoutingKey(channelId string, rotaName string, routingKey string) error
+	DeleteRoutingKey(channelId string, rotaName string) error
+	GetRotaByRoutingKey(routingKey string) (*rotadetails.RotaDetails, error)
+	GetAlert(channelId string, fingerprint string) (*alert.Alert, error)
+	SaveAlert(a *alert.Alert) error
+	SaveWebhooks(channelId string, rotaName string, webhooks []webhook.Webhook) error
+	SaveDelivery(d *webhook.Delivery) error
+	GetDeliveries(channelId string, rotaName string, limit int) ([]*webhook.Delivery, error)
+	SaveHistoryEntry(e *history.Entry) error
+	GetHistory(channelId string, rotaName string, limit int) ([]*history.Entry, error)
+	SaveAPIToken(t *apitoken.Token) error
+	GetAPIToken(hash string) (*apitoken.Token, error)
+	GetAPITokens() ([]*apitoken.Token, error)
+	DeleteAPIToken(hash string) error
+	SaveAdmin(a *admin.Admin) error
+	GetAdmin(userId string) (*admin.Admin, error)
+	GetAdmins() ([]*admin.Admin, error)
+	DeleteAdmin(userId string) error
+	SaveChange(c *change.Change) error
+	GetChange(channelId string, rotaName string, changeId string) (*change.Change, error)
+	GetChanges(channelId string, rotaName string) ([]*change.Change, error)
+	DeleteChange(channelId string, rotaName string, changeId string) error
+	SaveOutboxMessage(m *outbox.Message) error
+	GetOutboxMessages() ([]*outbox.Message, error)
+	DeleteOutboxMessage(messageId string) error
+	SaveWebhookRetry(r *webhook.Retry) error
+	GetWebhookRetries() ([]*webhook.Retry, error)
+	DeleteWebhookRetry(retryId string) error
 }
 
+// teamKeyPrefix starts the partition keys of every workspace but the one the
+// bot served before it could be installed in others.
+const teamKeyPrefix = "team#"
+
 type RotaHandler struct {
-	db *db.Database
+	db     *db.Database
+	clock  clock.Clock
+	teamId string
+}
+
+func New(db *db.Database, clock clock.Clock) *RotaHandler {
+	return &RotaHandler{
+		db:    db,
+		clock: clock,
+	}
 }
 
-func New(db *db.Database) *RotaHandler {
+// ForTeam returns a handler for one workspace's rotas. Each workspace's items
+// are stored under its own partition keys, so channel IDs, admins and API
+// tokens can't collide with another's. The empty team ID is the workspace the
+// bot served before it could be installed in others, whose keys are unchanged.
+func (h *RotaHandler) ForTeam(teamId string) *RotaHandler {
 	return &RotaHandler{
-		db: db,
+		db:     h.db,
+		clock:  h.clock,
+		teamId: teamId,
+	}
+}
+
+func (h *RotaHandler) key(pk string) string {
+	if h.teamId == "" {
+		return pk
+	}
+	return teamKeyPrefix + h.teamId + "#" + pk
+}
+
+// marshal marshals an item to be stored under the handler's workspace.
+func (h *RotaHandler) marshal(in interface{}) (map[string]types.AttributeValue, error) {
+	item, err := attributevalue.MarshalMap(in)
+	if err != nil {
+		return nil, err
+	}
+
+	if pk, ok := item["pk"].(*types.AttributeValueMemberS); ok {
+		item["pk"] = &types.AttributeValueMemberS{Value: h.key(pk.Value)}
+	}
+
+	return item, nil
+}
+
+// unmarshal undoes marshal, so callers only ever see unprefixed keys.
+func (h *RotaHandler) unmarshal(item map[string]types.AttributeValue, out interface{}) error {
+	if pk, ok := item["pk"].(*types.AttributeValueMemberS); ok && h.teamId != "" {
+		item["pk"] = &types.AttributeValueMemberS{Value: strings.TrimPrefix(pk.Value, h.key(""))}
+	}
+
+	return attributevalue.UnmarshalMap(item, out)
+}
+
+// inTeam narrows a scan to the handler's workspace.
+func (h *RotaHandler) inTeam(input *dynamodb.ScanInput) *dynamodb.ScanInput {
+	values := map[string]types.AttributeValue{}
+	for k, v := range input.ExpressionAttributeValues {
+		values[k] = v
+	}
+
+	filter := "NOT begins_with(pk, :team)"
+	values[":team"] = &types.AttributeValueMemberS{Value: teamKeyPrefix}
+	if h.teamId != "" {
+		filter = "begins_with(pk, :team)"
+		values[":team"] = &types.AttributeValueMemberS{Value: h.key("")}
 	}
+
+	input.FilterExpression = aws.String(filter + " AND (" + aws.ToString(input.FilterExpression) + ")")
+	input.ExpressionAttributeValues = values
+
+	return input
 }
 
 func (h *RotaHandler) GetRotaNames(channelId string) ([]string, error) {
@@ -36,7 +160,7 @@ func (h *RotaHandler) GetRotaNames(channelId string) ([]string, error) {
 		TableName:              aws.String(h.db.TableName),
 		KeyConditionExpression: aws.String("pk = :pk"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":pk": &types.AttributeValueMemberS{Value: channelId},
+			":pk": &types.AttributeValueMemberS{Value: h.key(channelId)},
 		},
 		ProjectionExpression: aws.String("sk"),
 	})
@@ -47,7 +171,7 @@ func (h *RotaHandler) GetRotaNames(channelId string) ([]string, error) {
 	var rotaNames []string
 	for _, v := range out.Items {
 		var rotaDetails rotadetails.RotaDetails
-		err = attributevalue.UnmarshalMap(v, &rotaDetails)
+		err = h.unmarshal(v, &rotaDetails)
 		if err != nil {
 			return nil, err
 		}
@@ -62,7 +186,7 @@ func (h *RotaHandler) GetRotaDetails(channelId string, rotaName string) (*rotade
 	out, err := h.db.Client.GetItem(context.TODO(), &dynamodb.GetItemInput{
 		TableName: aws.String(h.db.TableName),
 		Key: map[string]types.AttributeValue{
-			"pk": &types.AttributeValueMemberS{Value: channelId},
+			"pk": &types.AttributeValueMemberS{Value: h.key(channelId)},
 			"sk": &types.AttributeValueMemberS{Value: rotaName},
 		},
 	})
@@ -75,7 +199,7 @@ func (h *RotaHandler) GetRotaDetails(channelId string, rotaName string) (*rotade
 	}
 
 	var rotaDetails rotadetails.RotaDetails
-	err = attributevalue.UnmarshalMap(out.Item, &rotaDetails)
+	err = h.unmarshal(out.Item, &rotaDetails)
 	if err != nil {
 		return nil, err
 	}
@@ -83,52 +207,327 @@ func (h *RotaHandler) GetRotaDetails(channelId string, rotaName string) (*rotade
 	return &rotaDetails, nil
 }
 
-func (h *RotaHandler) GetEndingOnCallShifts() ([]*rotadetails.RotaDetails, error) {
-	out, err := h.db.Client.Scan(context.TODO(), &dynamodb.ScanInput{
+func (h *RotaHandler) GetOnCallShifts() ([]*rotadetails.RotaDetails, error) {
+	return h.scan(&dynamodb.ScanInput{
 		TableName:        aws.String(h.db.TableName),
-		FilterExpression: aws.String("attribute_exists(endOfShift) AND endOfShift <> :empty AND endOfShift <= :now"),
+		FilterExpression: aws.String("attribute_exists(endOfShift) AND endOfShift <> :empty"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":empty": &types.AttributeValueMemberS{Value: ""},
-			":now":   &types.AttributeValueMemberS{Value: formatter.FormatTime(time.Now())},
 		},
 	})
+}
+
+func (h *RotaHandler) scan(input *dynamodb.ScanInput) ([]*rotadetails.RotaDetails, error) {
+	paginator := dynamodb.NewScanPaginator(h.db.Client, h.inTeam(input))
+
+	var rotas []*rotadetails.RotaDetails
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range out.Items {
+			var rotaDetails rotadetails.RotaDetails
+			err = h.unmarshal(v, &rotaDetails)
+			if err != nil {
+				return nil, err
+			}
+
+			rotas = append(rotas, &rotaDetails)
+		}
+	}
+
+	return rotas, nil
+}
+
+func (h *RotaHandler) GetEndingOnCallShifts() ([]*rotadetails.RotaDetails, error) {
+	rotas, err := h.GetOnCallShifts()
 	if err != nil {
 		return nil, err
 	}
 
+	return endingOnCallShifts(rotas, h.clock.Now())
+}
+
+// GetUserRotas returns every rota, across all channels, that the user is a
+// member or owner of.
+func (h *RotaHandler) GetUserRotas(userId string) ([]*rotadetails.RotaDetails, error) {
+	paginator := dynamodb.NewQueryPaginator(h.db.Client, &dynamodb.QueryInput{
+		TableName:              aws.String(h.db.TableName),
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: h.key(membership.Key(userId))},
+		},
+	})
+
 	var rotas []*rotadetails.RotaDetails
-	for _, v := range out.Items {
-		var rotaDetails rotadetails.RotaDetails
-		err = attributevalue.UnmarshalMap(v, &rotaDetails)
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(context.TODO())
 		if err != nil {
 			return nil, err
 		}
 
-		rotas = append(rotas, &rotaDetails)
+		for _, v := range out.Items {
+			var m membership.Membership
+			err = h.unmarshal(v, &m)
+			if err != nil {
+				return nil, err
+			}
+
+			rotaDetails, err := h.GetRotaDetails(m.ChannelId, m.RotaName)
+			if err != nil {
+				return nil, err
+			}
+
+			// Two changes to a rota at once can leave a membership behind.
+			if rotaDetails == nil || !slices.Contains(membership.Users(rotaDetails.Members, rotaDetails.Owners), userId) {
+				continue
+			}
+
+			rotas = append(rotas, rotaDetails)
+		}
 	}
 
 	return rotas, nil
 }
 
+// GetRotas returns every rota in every channel.
+func (h *RotaHandler) GetRotas() ([]*rotadetails.RotaDetails, error) {
+	// Pages, alerts and the like share the table, but only rotas have members.
+	return h.scan(&dynamodb.ScanInput{
+		TableName:        aws.String(h.db.TableName),
+		FilterExpression: aws.String("attribute_exists(members)"),
+	})
+}
+
 func (h *RotaHandler) SaveRotaDetails(channelId string, rotaName string, rotaMembers []string, rotaDuration string) error {
 	var rotaMembersAsAttr []types.AttributeValue
 	for _, v := range rotaMembers {
 		rotaMembersAsAttr = append(rotaMembersAsAttr, &types.AttributeValueMemberS{Value: v})
 	}
 
-	_, err := h.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+	// Update rather than put the item, so that owners and any running shift
+	// survive a change of members.
+	out, err := h.db.Client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(channelId)},
+			"sk": &types.AttributeValueMemberS{Value: rotaName},
+		},
+		UpdateExpression: aws.String("set members = :members, #duration = :duration"),
+		ExpressionAttributeNames: map[string]string{
+			"#duration": "duration",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":members":  &types.AttributeValueMemberL{Value: rotaMembersAsAttr},
+			":duration": &types.AttributeValueMemberN{Value: rotaDuration},
+		},
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return err
+	}
+
+	var old rotadetails.RotaDetails
+	err = attributevalue.UnmarshalMap(out.Attributes, &old)
+	if err != nil {
+		return err
+	}
+
+	return h.indexMembers(channelId, rotaName, membership.Users(old.Members, old.Owners), membership.Users(rotaMembers, old.Owners))
+}
+
+func (h *RotaHandler) SaveRotaOwners(channelId string, rotaName string, owners []string) error {
+	var ownersAsAttr []types.AttributeValue
+	for _, v := range owners {
+		ownersAsAttr = append(ownersAsAttr, &types.AttributeValueMemberS{Value: v})
+	}
+
+	out, err := h.db.Client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(channelId)},
+			"sk": &types.AttributeValueMemberS{Value: rotaName},
+		},
+		UpdateExpression: aws.String("set owners = :owners"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owners": &types.AttributeValueMemberL{Value: ownersAsAttr},
+		},
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return err
+	}
+
+	var old rotadetails.RotaDetails
+	err = attributevalue.UnmarshalMap(out.Attributes, &old)
+	if err != nil {
+		return err
+	}
+
+	return h.indexMembers(channelId, rotaName, membership.Users(old.Members, old.Owners), membership.Users(old.Members, owners))
+}
+
+func (h *RotaHandler) SaveRotaTiers(channelId string, rotaName string, tiers []string) error {
+	var tiersAsAttr []types.AttributeValue
+	for _, v := range tiers {
+		tiersAsAttr = append(tiersAsAttr, &types.AttributeValueMemberS{Value: v})
+	}
+
+	_, err := h.db.Client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(channelId)},
+			"sk": &types.AttributeValueMemberS{Value: rotaName},
+		},
+		UpdateExpression: aws.String("set tiers = :tiers"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":tiers": &types.AttributeValueMemberL{Value: tiersAsAttr},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *RotaHandler) SaveHandoverAnchor(channelId string, rotaName string, handoverAnchor string) error {
+	_, err := h.db.Client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(channelId)},
+			"sk": &types.AttributeValueMemberS{Value: rotaName},
+		},
+		UpdateExpression: aws.String("set handoverAnchor = :handoverAnchor"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":handoverAnchor": &types.AttributeValueMemberS{Value: handoverAnchor},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *RotaHandler) SaveRequireApproval(channelId string, rotaName string, requireApproval bool) error {
+	_, err := h.db.Client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(channelId)},
+			"sk": &types.AttributeValueMemberS{Value: rotaName},
+		},
+		UpdateExpression: aws.String("set requireApproval = :requireApproval"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":requireApproval": &types.AttributeValueMemberBOOL{Value: requireApproval},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *RotaHandler) SaveWindows(channelId string, rotaName string, timeZone string, windows []rotadetails.Window) error {
+	windowsAsAttr, err := attributevalue.Marshal(windows)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(channelId)},
+			"sk": &types.AttributeValueMemberS{Value: rotaName},
+		},
+		UpdateExpression: aws.String("set windows = :windows, timeZone = :timeZone"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":windows":  windowsAsAttr,
+			":timeZone": &types.AttributeValueMemberS{Value: timeZone},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *RotaHandler) DeleteRota(channelId string, rotaName string) error {
+	out, err := h.db.Client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
 		TableName: aws.String(h.db.TableName),
-		Item: map[string]types.AttributeValue{
-			"pk":       &types.AttributeValueMemberS{Value: channelId},
-			"sk":       &types.AttributeValueMemberS{Value: rotaName},
-			"members":  &types.AttributeValueMemberL{Value: rotaMembersAsAttr},
-			"duration": &types.AttributeValueMemberN{Value: rotaDuration},
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(channelId)},
+			"sk": &types.AttributeValueMemberS{Value: rotaName},
 		},
+		ReturnValues: types.ReturnValueAllOld,
 	})
 	if err != nil {
 		return err
 	}
 
+	var old rotadetails.RotaDetails
+	err = attributevalue.UnmarshalMap(out.Attributes, &old)
+	if err != nil {
+		return err
+	}
+
+	err = h.indexMembers(channelId, rotaName, membership.Users(old.Members, old.Owners), nil)
+	if err != nil {
+		return err
+	}
+
+	// Alerts sent with the rota's routing key are turned away from now on.
+	if old.RoutingKey != "" {
+		return h.deleteRoutingKey(old.RoutingKey)
+	}
+
+	return nil
+}
+
+// indexMembers lists the rota under everyone who's now a member or owner of
+// it, and takes it off the lists of anyone who no longer is.
+func (h *RotaHandler) indexMembers(channelId string, rotaName string, before []string, after []string) error {
+	for _, v := range after {
+		item, err := h.marshal(&membership.Membership{
+			Pk:        membership.Key(v),
+			Sk:        membership.SortKey(channelId, rotaName),
+			ChannelId: channelId,
+			RotaName:  rotaName,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = h.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+			TableName: aws.String(h.db.TableName),
+			Item:      item,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, v := range before {
+		if slices.Contains(after, v) {
+			continue
+		}
+
+		_, err := h.db.Client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+			TableName: aws.String(h.db.TableName),
+			Key: map[string]types.AttributeValue{
+				"pk": &types.AttributeValueMemberS{Value: h.key(membership.Key(v))},
+				"sk": &types.AttributeValueMemberS{Value: membership.SortKey(channelId, rotaName)},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -136,14 +535,15 @@ func (h *RotaHandler) UpdateOnCallMember(channelId string, rotaName string, newO
 	_, err := h.db.Client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
 		TableName: aws.String(h.db.TableName),
 		Key: map[string]types.AttributeValue{
-			"pk": &types.AttributeValueMemberS{Value: channelId},
+			"pk": &types.AttributeValueMemberS{Value: h.key(channelId)},
 			"sk": &types.AttributeValueMemberS{Value: rotaName},
 		},
-		UpdateExpression: aws.String("set currOnCallMember = :currOnCallMember, startOfShift = :startOfShift, endOfShift = :endOfShift"),
+		UpdateExpression: aws.String("set currOnCallMember = :currOnCallMember, startOfShift = :startOfShift, endOfShift = :endOfShift, overriddenOnCallMember = :empty"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":currOnCallMember": &types.AttributeValueMemberS{Value: newOnCallMember},
 			":startOfShift":     &types.AttributeValueMemberS{Value: startOfShift},
 			":endOfShift":       &types.AttributeValueMemberS{Value: endOfShift},
+			":empty":            &types.AttributeValueMemberS{Value: ""},
 		},
 	})
 
@@ -153,3 +553,1013 @@ func (h *RotaHandler) UpdateOnCallMember(channelId string, rotaName string, newO
 
 	return nil
 }
+
+// OverrideOnCallMember hands the rest of the current shift to someone else
+// without changing who it belongs to, so the rota carries on in order.
+func (h *RotaHandler) OverrideOnCallMember(channelId string, rotaName string, newOnCallMember string, overriddenOnCallMember string) error {
+	_, err := h.db.Client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(channelId)},
+			"sk": &types.AttributeValueMemberS{Value: rotaName},
+		},
+		UpdateExpression: aws.String("set currOnCallMember = :currOnCallMember, overriddenOnCallMember = :overriddenOnCallMember"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":currOnCallMember":       &types.AttributeValueMemberS{Value: newOnCallMember},
+			":overriddenOnCallMember": &types.AttributeValueMemberS{Value: overriddenOnCallMember},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *RotaHandler) GetPage(channelId string, pageId string) (*page.Page, error) {
+	out, err := h.db.Client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(page.Key(channelId))},
+			"sk": &types.AttributeValueMemberS{Value: pageId},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(out.Item) == 0 {
+		return nil, nil
+	}
+
+	var p page.Page
+	err = h.unmarshal(out.Item, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func (h *RotaHandler) GetOpenPages() ([]*page.Page, error) {
+	paginator := dynamodb.NewScanPaginator(h.db.Client, &dynamodb.ScanInput{
+		TableName:        aws.String(h.db.TableName),
+		FilterExpression: aws.String("begins_with(pk, :prefix) AND #status = :open"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":prefix": &types.AttributeValueMemberS{Value: h.key(page.Key(""))},
+			":open":   &types.AttributeValueMemberS{Value: page.StatusOpen},
+		},
+	})
+
+	var pages []*page.Page
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range out.Items {
+			var p page.Page
+			err = h.unmarshal(v, &p)
+			if err != nil {
+				return nil, err
+			}
+
+			pages = append(pages, &p)
+		}
+	}
+
+	return pages, nil
+}
+
+func (h *RotaHandler) SavePage(p *page.Page) error {
+	item, err := h.marshal(p)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(h.db.TableName),
+		Item:      item,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SaveRoutingKey gives the rota a new routing key, and indexes it under
+// routingkey.Key so alerts can find their workspace without a scan. The
+// rota's old key stops working.
+func (h *RotaHandler) SaveRoutingKey(channelId string, rotaName string, routingKey string) error {
+	if routingKey == "" {
+		return h.DeleteRoutingKey(channelId, rotaName)
+	}
+
+	out, err := h.db.Client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(channelId)},
+			"sk": &types.AttributeValueMemberS{Value: rotaName},
+		},
+		UpdateExpression: aws.String("set routingKey = :routingKey"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":routingKey": &types.AttributeValueMemberS{Value: routingKey},
+		},
+		ReturnValues: types.ReturnValueUpdatedOld,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = h.putRoutingKey(&routingkey.RoutingKey{
+		Pk:        routingkey.Key,
+		Sk:        routingKey,
+		TeamId:    h.teamId,
+		ChannelId: channelId,
+		RotaName:  rotaName,
+	})
+	if err != nil {
+		return err
+	}
+
+	if old, ok := out.Attributes["routingKey"].(*types.AttributeValueMemberS); ok && old.Value != "" && old.Value != routingKey {
+		return h.deleteRoutingKey(old.Value)
+	}
+
+	return nil
+}
+
+// DeleteRoutingKey takes the rota's routing key away, so alerts sent with it
+// are turned away.
+func (h *RotaHandler) DeleteRoutingKey(channelId string, rotaName string) error {
+	out, err := h.db.Client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(channelId)},
+			"sk": &types.AttributeValueMemberS{Value: rotaName},
+		},
+		UpdateExpression: aws.String("remove routingKey"),
+		ReturnValues:     types.ReturnValueUpdatedOld,
+	})
+	if err != nil {
+		return err
+	}
+
+	if old, ok := out.Attributes["routingKey"].(*types.AttributeValueMemberS); ok && old.Value != "" {
+		return h.deleteRoutingKey(old.Value)
+	}
+
+	return nil
+}
+
+// putRoutingKey stores a routing key under its own partition, which is shared
+// by every workspace.
+func (h *RotaHandler) putRoutingKey(k *routingkey.RoutingKey) error {
+	item, err := attributevalue.MarshalMap(k)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(h.db.TableName),
+		Item:      item,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *RotaHandler) deleteRoutingKey(routingKey string) error {
+	_, err := h.db.Client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: routingkey.Key},
+			"sk": &types.AttributeValueMemberS{Value: routingKey},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetRoutingKey finds which workspace, channel and rota a routing key belongs
+// to, in any workspace, or returns nil if it doesn't exist.
+func (h *RotaHandler) GetRoutingKey(routingKey string) (*routingkey.RoutingKey, error) {
+	out, err := h.db.Client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: routingkey.Key},
+			"sk": &types.AttributeValueMemberS{Value: routingKey},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(out.Item) == 0 {
+		return nil, nil
+	}
+
+	var k routingkey.RoutingKey
+	err = attributevalue.UnmarshalMap(out.Item, &k)
+	if err != nil {
+		return nil, err
+	}
+
+	return &k, nil
+}
+
+// GetRotaByRoutingKey finds the rota that incoming alerts with the given key
+// belong to, or returns nil if there isn't one in this workspace.
+func (h *RotaHandler) GetRotaByRoutingKey(routingKey string) (*rotadetails.RotaDetails, error) {
+	k, err := h.GetRoutingKey(routingKey)
+	if err != nil || k == nil || k.TeamId != h.teamId {
+		return nil, err
+	}
+
+	rotaDetails, err := h.GetRotaDetails(k.ChannelId, k.RotaName)
+	if err != nil || rotaDetails == nil || rotaDetails.RoutingKey != routingKey {
+		return nil, err
+	}
+
+	return rotaDetails, nil
+}
+
+// IndexRoutingKeys indexes the routing keys of every workspace's rotas, for
+// rotas whose keys were made before they were stored under routingkey.Key.
+// It's safe to run more than once.
+func (h *RotaHandler) IndexRoutingKeys() error {
+	paginator := dynamodb.NewScanPaginator(h.db.Client, &dynamodb.ScanInput{
+		TableName:        aws.String(h.db.TableName),
+		FilterExpression: aws.String("attribute_exists(routingKey) AND routingKey <> :empty"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":empty": &types.AttributeValueMemberS{Value: ""},
+		},
+	})
+
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+
+		for _, v := range out.Items {
+			var rotaDetails rotadetails.RotaDetails
+			err = attributevalue.UnmarshalMap(v, &rotaDetails)
+			if err != nil {
+				return err
+			}
+
+			teamId, channelId, err := splitKey(rotaDetails.Pk)
+			if err != nil {
+				return err
+			}
+
+			err = h.putRoutingKey(&routingkey.RoutingKey{
+				Pk:        routingkey.Key,
+				Sk:        rotaDetails.RoutingKey,
+				TeamId:    teamId,
+				ChannelId: channelId,
+				RotaName:  rotaDetails.RotaName(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// IndexMemberships lists every workspace's rotas under their members and
+// owners, for rotas saved before they were. It's safe to run more than once.
+func (h *RotaHandler) IndexMemberships() error {
+	paginator := dynamodb.NewScanPaginator(h.db.Client, &dynamodb.ScanInput{
+		TableName:        aws.String(h.db.TableName),
+		FilterExpression: aws.String("attribute_exists(members)"),
+	})
+
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+
+		for _, v := range out.Items {
+			var rotaDetails rotadetails.RotaDetails
+			err = attributevalue.UnmarshalMap(v, &rotaDetails)
+			if err != nil {
+				return err
+			}
+
+			teamId, channelId, err := splitKey(rotaDetails.Pk)
+			if err != nil {
+				return err
+			}
+
+			err = h.ForTeam(teamId).indexMembers(channelId, rotaDetails.RotaName(), nil, membership.Users(rotaDetails.Members, rotaDetails.Owners))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// splitKey finds the workspace and the unprefixed partition key of an item
+// read without a handler's workspace.
+func splitKey(pk string) (string, string, error) {
+	if !strings.HasPrefix(pk, teamKeyPrefix) {
+		return "", pk, nil
+	}
+
+	teamId, key, ok := strings.Cut(strings.TrimPrefix(pk, teamKeyPrefix), "#")
+	if !ok {
+		return "", "", fmt.Errorf("item has a malformed key: %s", pk)
+	}
+
+	return teamId, key, nil
+}
+
+func (h *RotaHandler) GetAlert(channelId string, fingerprint string) (*alert.Alert, error) {
+	out, err := h.db.Client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(alert.Key(channelId))},
+			"sk": &types.AttributeValueMemberS{Value: fingerprint},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(out.Item) == 0 {
+		return nil, nil
+	}
+
+	var a alert.Alert
+	err = h.unmarshal(out.Item, &a)
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+func (h *RotaHandler) SaveAlert(a *alert.Alert) error {
+	item, err := h.marshal(a)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(h.db.TableName),
+		Item:      item,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *RotaHandler) SaveWebhooks(channelId string, rotaName string, webhooks []webhook.Webhook) error {
+	webhooksAsAttr, err := attributevalue.Marshal(webhooks)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(channelId)},
+			"sk": &types.AttributeValueMemberS{Value: rotaName},
+		},
+		UpdateExpression: aws.String("set webhooks = :webhooks"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":webhooks": webhooksAsAttr,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *RotaHandler) SaveDelivery(d *webhook.Delivery) error {
+	item, err := h.marshal(d)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(h.db.TableName),
+		Item:      item,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetDeliveries returns a rota's most recent webhook deliveries, newest first.
+func (h *RotaHandler) GetDeliveries(channelId string, rotaName string, limit int) ([]*webhook.Delivery, error) {
+	out, err := h.db.Client.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String(h.db.TableName),
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: h.key(webhook.DeliveryKey(channelId, rotaName))},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(int32(limit)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []*webhook.Delivery
+	for _, v := range out.Items {
+		var d webhook.Delivery
+		err = h.unmarshal(v, &d)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &d)
+	}
+
+	return deliveries, nil
+}
+
+func (h *RotaHandler) SaveHistoryEntry(e *history.Entry) error {
+	item, err := h.marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(h.db.TableName),
+		Item:      item,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetHistory returns what most recently happened to a rota, newest first.
+func (h *RotaHandler) GetHistory(channelId string, rotaName string, limit int) ([]*history.Entry, error) {
+	out, err := h.db.Client.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String(h.db.TableName),
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: h.key(history.Key(channelId, rotaName))},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(int32(limit)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*history.Entry
+	for _, v := range out.Items {
+		var e history.Entry
+		err = h.unmarshal(v, &e)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &e)
+	}
+
+	return entries, nil
+}
+
+// SaveAPIToken stores a token, and indexes its hash under apitoken.TeamKey so
+// API requests can find their workspace without trying each one.
+func (h *RotaHandler) SaveAPIToken(t *apitoken.Token) error {
+	item, err := h.marshal(t)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(h.db.TableName),
+		Item:      item,
+	})
+	if err != nil {
+		return err
+	}
+
+	return h.putAPITokenTeam(&apitoken.Team{
+		Pk:     apitoken.TeamKey,
+		Sk:     t.Sk,
+		TeamId: h.teamId,
+	})
+}
+
+// putAPITokenTeam stores a token's workspace under its own partition, which
+// is shared by every workspace.
+func (h *RotaHandler) putAPITokenTeam(t *apitoken.Team) error {
+	item, err := attributevalue.MarshalMap(t)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(h.db.TableName),
+		Item:      item,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetAPITokenTeam finds which workspace a token's hash belongs to, in any
+// workspace, or returns nil if it doesn't exist.
+func (h *RotaHandler) GetAPITokenTeam(hash string) (*apitoken.Team, error) {
+	out, err := h.db.Client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: apitoken.TeamKey},
+			"sk": &types.AttributeValueMemberS{Value: hash},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(out.Item) == 0 {
+		return nil, nil
+	}
+
+	var t apitoken.Team
+	err = attributevalue.UnmarshalMap(out.Item, &t)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// IndexAPITokens indexes the hashes of every workspace's API tokens, for
+// tokens made before they were stored under apitoken.TeamKey. It's safe to
+// run more than once.
+func (h *RotaHandler) IndexAPITokens() error {
+	paginator := dynamodb.NewScanPaginator(h.db.Client, &dynamodb.ScanInput{
+		TableName:        aws.String(h.db.TableName),
+		FilterExpression: aws.String("attribute_exists(label) AND attribute_exists(createdBy)"),
+	})
+
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+
+		for _, v := range out.Items {
+			var t apitoken.Token
+			err = attributevalue.UnmarshalMap(v, &t)
+			if err != nil {
+				return err
+			}
+
+			teamId, key, err := splitKey(t.Pk)
+			if err != nil {
+				return err
+			}
+
+			if key != apitoken.Key {
+				continue
+			}
+
+			err = h.putAPITokenTeam(&apitoken.Team{
+				Pk:     apitoken.TeamKey,
+				Sk:     t.Sk,
+				TeamId: teamId,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (h *RotaHandler) GetAPIToken(hash string) (*apitoken.Token, error) {
+	out, err := h.db.Client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(apitoken.Key)},
+			"sk": &types.AttributeValueMemberS{Value: hash},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(out.Item) == 0 {
+		return nil, nil
+	}
+
+	var t apitoken.Token
+	err = h.unmarshal(out.Item, &t)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func (h *RotaHandler) GetAPITokens() ([]*apitoken.Token, error) {
+	paginator := dynamodb.NewQueryPaginator(h.db.Client, &dynamodb.QueryInput{
+		TableName:              aws.String(h.db.TableName),
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: h.key(apitoken.Key)},
+		},
+	})
+
+	var tokens []*apitoken.Token
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range out.Items {
+			var t apitoken.Token
+			err = h.unmarshal(v, &t)
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, &t)
+		}
+	}
+
+	return tokens, nil
+}
+
+func (h *RotaHandler) DeleteAPIToken(hash string) error {
+	_, err := h.db.Client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(apitoken.Key)},
+			"sk": &types.AttributeValueMemberS{Value: hash},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: apitoken.TeamKey},
+			"sk": &types.AttributeValueMemberS{Value: hash},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *RotaHandler) SaveAdmin(a *admin.Admin) error {
+	item, err := h.marshal(a)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(h.db.TableName),
+		Item:      item,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *RotaHandler) GetAdmin(userId string) (*admin.Admin, error) {
+	out, err := h.db.Client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(admin.Key)},
+			"sk": &types.AttributeValueMemberS{Value: userId},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(out.Item) == 0 {
+		return nil, nil
+	}
+
+	var a admin.Admin
+	err = h.unmarshal(out.Item, &a)
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+func (h *RotaHandler) GetAdmins() ([]*admin.Admin, error) {
+	paginator := dynamodb.NewQueryPaginator(h.db.Client, &dynamodb.QueryInput{
+		TableName:              aws.String(h.db.TableName),
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: h.key(admin.Key)},
+		},
+	})
+
+	var admins []*admin.Admin
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range out.Items {
+			var a admin.Admin
+			err = h.unmarshal(v, &a)
+			if err != nil {
+				return nil, err
+			}
+
+			admins = append(admins, &a)
+		}
+	}
+
+	return admins, nil
+}
+
+func (h *RotaHandler) DeleteAdmin(userId string) error {
+	_, err := h.db.Client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(admin.Key)},
+			"sk": &types.AttributeValueMemberS{Value: userId},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *RotaHandler) SaveChange(c *change.Change) error {
+	item, err := h.marshal(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(h.db.TableName),
+		Item:      item,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *RotaHandler) GetChange(channelId string, rotaName string, changeId string) (*change.Change, error) {
+	out, err := h.db.Client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(change.Key(channelId, rotaName))},
+			"sk": &types.AttributeValueMemberS{Value: changeId},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(out.Item) == 0 {
+		return nil, nil
+	}
+
+	var c change.Change
+	err = h.unmarshal(out.Item, &c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// GetChanges returns the rota's changes awaiting approval, oldest first.
+func (h *RotaHandler) GetChanges(channelId string, rotaName string) ([]*change.Change, error) {
+	paginator := dynamodb.NewQueryPaginator(h.db.Client, &dynamodb.QueryInput{
+		TableName:              aws.String(h.db.TableName),
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: h.key(change.Key(channelId, rotaName))},
+		},
+	})
+
+	var changes []*change.Change
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range out.Items {
+			var c change.Change
+			err = h.unmarshal(v, &c)
+			if err != nil {
+				return nil, err
+			}
+
+			changes = append(changes, &c)
+		}
+	}
+
+	return changes, nil
+}
+
+func (h *RotaHandler) DeleteChange(channelId string, rotaName string, changeId string) error {
+	_, err := h.db.Client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(change.Key(channelId, rotaName))},
+			"sk": &types.AttributeValueMemberS{Value: changeId},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func endingOnCallShifts(rotas []*rotadetails.RotaDetails, now time.Time) ([]*rotadetails.RotaDetails, error) {
+	// Shift times are stored as formatted strings, which don't sort
+	// chronologically, so the comparison has to happen here rather than in
+	// the scan's filter expression.
+	var endingRotas []*rotadetails.RotaDetails
+	for _, v := range rotas {
+		endOfShift, err := formatter.ParseTime(v.EndOfShift)
+		if err != nil {
+			return nil, err
+		}
+
+		if !endOfShift.After(now) {
+			endingRotas = append(endingRotas, v)
+		}
+	}
+
+	return endingRotas, nil
+}
+
+func (h *RotaHandler) SaveOutboxMessage(m *outbox.Message) error {
+	item, err := h.marshal(m)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(h.db.TableName),
+		Item:      item,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetOutboxMessages returns the messages waiting to be sent, oldest first.
+func (h *RotaHandler) GetOutboxMessages() ([]*outbox.Message, error) {
+	paginator := dynamodb.NewQueryPaginator(h.db.Client, &dynamodb.QueryInput{
+		TableName:              aws.String(h.db.TableName),
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: h.key(outbox.Key)},
+		},
+	})
+
+	var messages []*outbox.Message
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range out.Items {
+			var m outbox.Message
+			err = h.unmarshal(v, &m)
+			if err != nil {
+				return nil, err
+			}
+
+			messages = append(messages, &m)
+		}
+	}
+
+	return messages, nil
+}
+
+func (h *RotaHandler) DeleteOutboxMessage(messageId string) error {
+	_, err := h.db.Client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(outbox.Key)},
+			"sk": &types.AttributeValueMemberS{Value: messageId},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *RotaHandler) SaveWebhookRetry(r *webhook.Retry) error {
+	item, err := h.marshal(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(h.db.TableName),
+		Item:      item,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetWebhookRetries returns the deliveries waiting to be retried.
+func (h *RotaHandler) GetWebhookRetries() ([]*webhook.Retry, error) {
+	paginator := dynamodb.NewQueryPaginator(h.db.Client, &dynamodb.QueryInput{
+		TableName:              aws.String(h.db.TableName),
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: h.key(webhook.RetryKey)},
+		},
+	})
+
+	var retries []*webhook.Retry
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range out.Items {
+			var r webhook.Retry
+			err = h.unmarshal(v, &r)
+			if err != nil {
+				return nil, err
+			}
+
+			retries = append(retries, &r)
+		}
+	}
+
+	return retries, nil
+}
+
+func (h *RotaHandler) DeleteWebhookRetry(retryId string) error {
+	_, err := h.db.Client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: h.key(webhook.RetryKey)},
+			"sk": &types.AttributeValueMemberS{Value: retryId},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
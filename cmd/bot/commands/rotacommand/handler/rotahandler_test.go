@@ -1,8 +1,17 @@
 package handler
 
 import (
+	"alfred-bot/cmd/bot/commands/rotacommand/models/apitoken"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/routingkey"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/webhook"
 	"alfred-bot/config"
+	"alfred-bot/utils/clock"
 	"alfred-bot/utils/db"
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"testing"
@@ -13,8 +22,12 @@ func TestRotaDetails(t *testing.T) {
 	RunSpecs(t, "RotaDetails Suite")
 }
 
+var cfg *config.Config
+
 var _ = BeforeSuite(func() {
-	config.BootstrapEnv(true)
+	var err error
+	cfg, err = config.LoadForTesting()
+	Expect(err).To(BeNil())
 })
 
 var _ = Describe("RotaHandler", func() {
@@ -22,8 +35,10 @@ var _ = Describe("RotaHandler", func() {
 	var rotaHandler *RotaHandler
 
 	BeforeEach(func() {
-		dbHandler = db.New()
-		rotaHandler = New(dbHandler)
+		var err error
+		dbHandler, err = db.New(cfg.DB)
+		Expect(err).To(BeNil())
+		rotaHandler = New(dbHandler, clock.New())
 	})
 
 	AfterEach(func() {
@@ -73,4 +88,314 @@ var _ = Describe("RotaHandler", func() {
 			})
 		})
 	})
+
+	Describe("GetUserRotas", func() {
+		BeforeEach(func() {
+			_ = rotaHandler.SaveRotaDetails("dummyId", "dummyRota", []string{"Evan", "Sia"}, "1")
+			_ = rotaHandler.SaveRotaDetails("otherId", "otherRota", []string{"Wai"}, "1")
+			_ = rotaHandler.SaveRotaOwners("otherId", "otherRota", []string{"Evan"})
+		})
+
+		It("Returns the rotas the user is a member or owner of", func() {
+			res, err := rotaHandler.GetUserRotas("Evan")
+			Expect(err).To(BeNil())
+			Expect(len(res)).To(Equal(2))
+		})
+
+		It("Returns nothing for someone outside every rota", func() {
+			res, err := rotaHandler.GetUserRotas("Suan")
+			Expect(err).To(BeNil())
+			Expect(len(res)).To(Equal(0))
+		})
+
+		It("Drops rotas the user has left or that were deleted", func() {
+			err := rotaHandler.SaveRotaDetails("dummyId", "dummyRota", []string{"Sia"}, "1")
+			Expect(err).To(BeNil())
+
+			res, err := rotaHandler.GetUserRotas("Evan")
+			Expect(err).To(BeNil())
+			Expect(res).To(HaveLen(1))
+			Expect(res[0].RotaName()).To(Equal("otherRota"))
+
+			err = rotaHandler.SaveRotaOwners("otherId", "otherRota", []string{"Sia"})
+			Expect(err).To(BeNil())
+			res, err = rotaHandler.GetUserRotas("Evan")
+			Expect(err).To(BeNil())
+			Expect(res).To(BeEmpty())
+
+			err = rotaHandler.DeleteRota("dummyId", "dummyRota")
+			Expect(err).To(BeNil())
+			res, err = rotaHandler.GetUserRotas("Sia")
+			Expect(err).To(BeNil())
+			Expect(res).To(HaveLen(1))
+			Expect(res[0].RotaName()).To(Equal("otherRota"))
+		})
+	})
+
+	Describe("IndexMemberships", func() {
+		It("Lists rotas saved before they were listed under their members", func() {
+			for _, h := range []*RotaHandler{rotaHandler, rotaHandler.ForTeam("T0456")} {
+				_, err := dbHandler.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+					TableName: aws.String(dbHandler.TableName),
+					Item: map[string]types.AttributeValue{
+						"pk":      &types.AttributeValueMemberS{Value: h.key("dummyId")},
+						"sk":      &types.AttributeValueMemberS{Value: "dummyRota"},
+						"members": &types.AttributeValueMemberL{Value: []types.AttributeValue{&types.AttributeValueMemberS{Value: "Evan"}}},
+						"owners":  &types.AttributeValueMemberL{Value: []types.AttributeValue{&types.AttributeValueMemberS{Value: "Sia"}}},
+					},
+				})
+				Expect(err).To(BeNil())
+			}
+
+			err := rotaHandler.IndexMemberships()
+			Expect(err).To(BeNil())
+
+			for _, h := range []*RotaHandler{rotaHandler, rotaHandler.ForTeam("T0456")} {
+				for _, userId := range []string{"Evan", "Sia"} {
+					res, err := h.GetUserRotas(userId)
+					Expect(err).To(BeNil())
+					Expect(res).To(HaveLen(1))
+					Expect(res[0].Pk).To(Equal("dummyId"))
+				}
+			}
+		})
+	})
+
+	Describe("SaveRotaDetails", func() {
+		It("Keeps the owners and current shift when members change", func() {
+			_ = rotaHandler.SaveRotaDetails("dummyId", "dummyRota", []string{"Evan"}, "1")
+			_ = rotaHandler.SaveRotaOwners("dummyId", "dummyRota", []string{"Evan"})
+			_ = rotaHandler.UpdateOnCallMember("dummyId", "dummyRota", "Evan", "start", "end")
+
+			err := rotaHandler.SaveRotaDetails("dummyId", "dummyRota", []string{"Evan", "Sia"}, "2")
+			Expect(err).To(BeNil())
+
+			res, err := rotaHandler.GetRotaDetails("dummyId", "dummyRota")
+			Expect(err).To(BeNil())
+			Expect(res.Members).To(Equal([]string{"Evan", "Sia"}))
+			Expect(res.Duration).To(Equal(2))
+			Expect(res.Owners).To(Equal([]string{"Evan"}))
+			Expect(res.CurrOnCallMember).To(Equal("Evan"))
+		})
+	})
+
+	Describe("SaveWindows", func() {
+		It("Stores when the rota is on call", func() {
+			_ = rotaHandler.SaveRotaDetails("dummyId", "dummyRota", []string{"Evan"}, "1")
+			windows := []rotadetails.Window{{Start: "09:00", End: "17:00"}, {StartDay: "friday", Start: "18:00", EndDay: "monday", End: "09:00"}}
+
+			err := rotaHandler.SaveWindows("dummyId", "dummyRota", "Europe/London", windows)
+			Expect(err).To(BeNil())
+
+			res, err := rotaHandler.GetRotaDetails("dummyId", "dummyRota")
+			Expect(err).To(BeNil())
+			Expect(res.Windows).To(Equal(windows))
+			Expect(res.TimeZone).To(Equal("Europe/London"))
+			Expect(res.Members).To(Equal([]string{"Evan"}))
+		})
+	})
+
+	Describe("OverrideOnCallMember", func() {
+		It("Is cleared by the next handover", func() {
+			_ = rotaHandler.SaveRotaDetails("dummyId", "dummyRota", []string{"Evan", "Sia"}, "1")
+			_ = rotaHandler.UpdateOnCallMember("dummyId", "dummyRota", "Evan", "start", "end")
+
+			err := rotaHandler.OverrideOnCallMember("dummyId", "dummyRota", "Sia", "Evan")
+			Expect(err).To(BeNil())
+			res, _ := rotaHandler.GetRotaDetails("dummyId", "dummyRota")
+			Expect(res.CurrOnCallMember).To(Equal("Sia"))
+			Expect(res.OverriddenOnCallMember).To(Equal("Evan"))
+
+			_ = rotaHandler.UpdateOnCallMember("dummyId", "dummyRota", "Sia", "end", "later")
+			res, _ = rotaHandler.GetRotaDetails("dummyId", "dummyRota")
+			Expect(res.OverriddenOnCallMember).To(Equal(""))
+		})
+	})
+
+	Describe("GetRotaByRoutingKey", func() {
+		It("Finds the rota the key was saved against", func() {
+			_ = rotaHandler.SaveRotaDetails("dummyId", "dummyRota", []string{"Evan"}, "1")
+			_ = rotaHandler.SaveRotaDetails("dummyId", "otherRota", []string{"Sia"}, "1")
+
+			err := rotaHandler.SaveRoutingKey("dummyId", "dummyRota", "secret")
+			Expect(err).To(BeNil())
+
+			res, err := rotaHandler.GetRotaByRoutingKey("secret")
+			Expect(err).To(BeNil())
+			Expect(res.RotaName()).To(Equal("dummyRota"))
+			Expect(res.Members).To(Equal([]string{"Evan"}))
+
+			res, err = rotaHandler.GetRotaByRoutingKey("wrong")
+			Expect(err).To(BeNil())
+			Expect(res).To(BeNil())
+		})
+
+		It("Forgets a rota's old key, and the keys of deleted rotas", func() {
+			_ = rotaHandler.SaveRotaDetails("dummyId", "dummyRota", []string{"Evan"}, "1")
+			_ = rotaHandler.SaveRoutingKey("dummyId", "dummyRota", "old")
+			_ = rotaHandler.SaveRoutingKey("dummyId", "dummyRota", "new")
+
+			k, err := rotaHandler.GetRoutingKey("old")
+			Expect(err).To(BeNil())
+			Expect(k).To(BeNil())
+
+			err = rotaHandler.DeleteRota("dummyId", "dummyRota")
+			Expect(err).To(BeNil())
+
+			k, err = rotaHandler.GetRoutingKey("new")
+			Expect(err).To(BeNil())
+			Expect(k).To(BeNil())
+		})
+
+		It("Clears a rota's key", func() {
+			_ = rotaHandler.SaveRotaDetails("dummyId", "dummyRota", []string{"Evan"}, "1")
+			_ = rotaHandler.SaveRoutingKey("dummyId", "dummyRota", "secret")
+
+			err := rotaHandler.DeleteRoutingKey("dummyId", "dummyRota")
+			Expect(err).To(BeNil())
+			err = rotaHandler.SaveRoutingKey("dummyId", "dummyRota", "")
+			Expect(err).To(BeNil())
+
+			k, err := rotaHandler.GetRoutingKey("secret")
+			Expect(err).To(BeNil())
+			Expect(k).To(BeNil())
+
+			res, err := rotaHandler.GetRotaDetails("dummyId", "dummyRota")
+			Expect(err).To(BeNil())
+			Expect(res.RoutingKey).To(BeEmpty())
+		})
+
+		It("Points the key at the rota's workspace", func() {
+			otherTeam := rotaHandler.ForTeam("T0456")
+			_ = otherTeam.SaveRotaDetails("dummyId", "dummyRota", []string{"Evan"}, "1")
+			_ = otherTeam.SaveRoutingKey("dummyId", "dummyRota", "secret")
+
+			k, err := rotaHandler.GetRoutingKey("secret")
+			Expect(err).To(BeNil())
+			Expect(*k).To(Equal(routingkey.RoutingKey{Pk: routingkey.Key, Sk: "secret", TeamId: "T0456", ChannelId: "dummyId", RotaName: "dummyRota"}))
+
+			res, err := rotaHandler.GetRotaByRoutingKey("secret")
+			Expect(err).To(BeNil())
+			Expect(res).To(BeNil())
+		})
+	})
+
+	Describe("IndexRoutingKeys", func() {
+		It("Indexes keys saved before they were indexed", func() {
+			for _, h := range []*RotaHandler{rotaHandler, rotaHandler.ForTeam("T0456")} {
+				_ = h.SaveRotaDetails("dummyId", "dummyRota", []string{"Evan"}, "1")
+				_, err := dbHandler.Client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+					TableName: aws.String(dbHandler.TableName),
+					Key: map[string]types.AttributeValue{
+						"pk": &types.AttributeValueMemberS{Value: h.key("dummyId")},
+						"sk": &types.AttributeValueMemberS{Value: "dummyRota"},
+					},
+					UpdateExpression: aws.String("set routingKey = :routingKey"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":routingKey": &types.AttributeValueMemberS{Value: "key-" + h.teamId},
+					},
+				})
+				Expect(err).To(BeNil())
+			}
+
+			err := rotaHandler.IndexRoutingKeys()
+			Expect(err).To(BeNil())
+
+			res, err := rotaHandler.GetRotaByRoutingKey("key-")
+			Expect(err).To(BeNil())
+			Expect(res.RotaName()).To(Equal("dummyRota"))
+
+			res, err = rotaHandler.ForTeam("T0456").GetRotaByRoutingKey("key-T0456")
+			Expect(err).To(BeNil())
+			Expect(res.RotaName()).To(Equal("dummyRota"))
+		})
+	})
+
+	Describe("API tokens", func() {
+		It("Points the token at its workspace, until it's revoked", func() {
+			otherTeam := rotaHandler.ForTeam("T0456")
+			err := otherTeam.SaveAPIToken(&apitoken.Token{Pk: apitoken.Key, Sk: "hash", Label: "ci", CreatedBy: "Evan"})
+			Expect(err).To(BeNil())
+
+			t, err := rotaHandler.GetAPITokenTeam("hash")
+			Expect(err).To(BeNil())
+			Expect(*t).To(Equal(apitoken.Team{Pk: apitoken.TeamKey, Sk: "hash", TeamId: "T0456"}))
+
+			err = otherTeam.DeleteAPIToken("hash")
+			Expect(err).To(BeNil())
+
+			t, err = rotaHandler.GetAPITokenTeam("hash")
+			Expect(err).To(BeNil())
+			Expect(t).To(BeNil())
+		})
+	})
+
+	Describe("IndexAPITokens", func() {
+		It("Indexes tokens made before they were indexed", func() {
+			for _, h := range []*RotaHandler{rotaHandler, rotaHandler.ForTeam("T0456")} {
+				item, err := h.marshal(&apitoken.Token{Pk: apitoken.Key, Sk: "hash-" + h.teamId, Label: "ci", CreatedBy: "Evan"})
+				Expect(err).To(BeNil())
+				_, err = dbHandler.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+					TableName: aws.String(dbHandler.TableName),
+					Item:      item,
+				})
+				Expect(err).To(BeNil())
+			}
+
+			err := rotaHandler.IndexAPITokens()
+			Expect(err).To(BeNil())
+
+			t, err := rotaHandler.GetAPITokenTeam("hash-")
+			Expect(err).To(BeNil())
+			Expect(t.TeamId).To(BeEmpty())
+
+			t, err = rotaHandler.GetAPITokenTeam("hash-T0456")
+			Expect(err).To(BeNil())
+			Expect(t.TeamId).To(Equal("T0456"))
+		})
+	})
+
+	Describe("GetDeliveries", func() {
+		It("Returns the latest deliveries first", func() {
+			for i, outcome := range []string{webhook.OutcomeRetrying, webhook.OutcomeRetrying, webhook.OutcomeDelivered} {
+				err := rotaHandler.SaveDelivery(&webhook.Delivery{
+					Pk:      webhook.DeliveryKey("dummyId", "dummyRota"),
+					Sk:      webhook.DeliverySortKey(int64(i), "event", i+1),
+					Attempt: i + 1,
+					Outcome: outcome,
+				})
+				Expect(err).To(BeNil())
+			}
+
+			res, err := rotaHandler.GetDeliveries("dummyId", "dummyRota", 2)
+			Expect(err).To(BeNil())
+			Expect(res).To(HaveLen(2))
+			Expect(res[0].Outcome).To(Equal(webhook.OutcomeDelivered))
+			Expect(res[1].Attempt).To(Equal(2))
+		})
+	})
+
+	Describe("ForTeam", func() {
+		It("Keeps each workspace's rotas apart", func() {
+			otherTeam := rotaHandler.ForTeam("T0456")
+			_ = rotaHandler.SaveRotaDetails("dummyId", "dummyRota", []string{"Evan"}, "1")
+			_ = otherTeam.SaveRotaDetails("dummyId", "dummyRota", []string{"Sia"}, "1")
+			_ = otherTeam.SaveRotaDetails("dummyId", "otherRota", []string{"Sia"}, "1")
+
+			res, err := otherTeam.GetRotaDetails("dummyId", "dummyRota")
+			Expect(err).To(BeNil())
+			Expect(res.Pk).To(Equal("dummyId"))
+			Expect(res.Members).To(Equal([]string{"Sia"}))
+
+			rotas, err := rotaHandler.GetRotas()
+			Expect(err).To(BeNil())
+			Expect(rotas).To(HaveLen(1))
+			Expect(rotas[0].Members).To(Equal([]string{"Evan"}))
+
+			rotas, err = otherTeam.GetUserRotas("Sia")
+			Expect(err).To(BeNil())
+			Expect(rotas).To(HaveLen(2))
+			Expect(rotas[0].Pk).To(Equal("dummyId"))
+		})
+	})
 })
@@ -0,0 +1,462 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/models/change"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/metadata"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/webhook"
+	"alfred-bot/utils/formatter"
+	"fmt"
+	"github.com/slack-go/slack"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	SwapShiftPromptAction     = "swap_shift_prompt"
+	OverrideShiftPromptAction = "override_shift_prompt"
+	SkipShiftAction           = "skip_shift"
+	SwapShiftCallback         = "swap_shift"
+	OverrideShiftCallback     = "override_shift"
+	rotaMemberAction          = "select_rota_member"
+	rotaMemberBlock           = "rota_member"
+)
+
+// PublishHome renders the user's App Home tab: every rota they're in with their
+// current or next shift, and the rotas they own.
+func (c *RotaCommand) PublishHome(userId string) error {
+	rotas, err := c.handler.GetUserRotas(userId)
+	if err != nil {
+		return err
+	}
+
+	sortRotas(rotas)
+
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(&slack.TextBlockObject{Type: slack.PlainTextType, Text: "Your rotas"}),
+	}
+
+	var memberRotas, ownedRotas []*rotadetails.RotaDetails
+	for _, v := range rotas {
+		if v.HasMember(userId) {
+			memberRotas = append(memberRotas, v)
+		}
+		if v.HasOwner(userId) {
+			ownedRotas = append(ownedRotas, v)
+		}
+	}
+
+	if len(memberRotas) == 0 {
+		blocks = append(blocks, markdownSection("You aren't in any rotas yet. Run `/rota` in a channel to create one."))
+	}
+
+	for _, v := range memberRotas {
+		memberBlocks, err := c.homeRotaBlocks(userId, v)
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, memberBlocks...)
+	}
+
+	blocks = append(blocks,
+		slack.NewDividerBlock(),
+		slack.NewHeaderBlock(&slack.TextBlockObject{Type: slack.PlainTextType, Text: "Rotas you administer"}),
+	)
+
+	if len(ownedRotas) == 0 {
+		blocks = append(blocks, markdownSection("You don't administer any rotas."))
+	}
+
+	for _, v := range ownedRotas {
+		onDuty := "not running"
+		if v.CurrOnCallMember != "" {
+			onDuty = fmt.Sprintf("%s on duty", formatter.AtUserId(v.CurrOnCallMember))
+		}
+		blocks = append(blocks, markdownSection(fmt.Sprintf("*%s* in <#%s>: %d member(s), %s", v.RotaName(), v.Pk, len(v.Members), onDuty)))
+	}
+
+	_, err = c.client.PublishView(userId, slack.HomeTabViewRequest{
+		Type:   slack.VTHomeTab,
+		Blocks: slack.Blocks{BlockSet: blocks},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *RotaCommand) homeRotaBlocks(userId string, rotaDetails *rotadetails.RotaDetails) ([]slack.Block, error) {
+	rotaName := rotaDetails.RotaName()
+	title := fmt.Sprintf("*%s* in <#%s>", rotaName, rotaDetails.Pk)
+
+	if rotaDetails.CurrOnCallMember == "" {
+		return []slack.Block{markdownSection(fmt.Sprintf("%s\nThe rota hasn't started.", title))}, nil
+	}
+
+	var status string
+	switch {
+	case rotaDetails.CurrOnCallMember == userId && rotaDetails.OverriddenOnCallMember != "":
+		status = fmt.Sprintf("You're covering %s's shift until %v.", formatter.AtUserId(rotaDetails.OverriddenOnCallMember), rotaDetails.EndOfShift)
+	case rotaDetails.CurrOnCallMember == userId:
+		status = fmt.Sprintf("You're on duty until %v.", rotaDetails.EndOfShift)
+	default:
+		status = fmt.Sprintf("%s is on duty until %v.", formatter.AtUserId(rotaDetails.CurrOnCallMember), rotaDetails.EndOfShift)
+		if shift, ok := nextShiftOf(rotaDetails, userId); ok {
+			status += fmt.Sprintf(" Your next shift starts on %v.", formatter.FormatTime(shift.StartOfShift))
+		}
+	}
+
+	section := markdownSection(fmt.Sprintf("%s\n%s", title, status))
+
+	canSwap, err := c.hasRole(userId, c.config.SwapRole, rotaDetails)
+	if err != nil {
+		return nil, err
+	}
+
+	if !canSwap {
+		return []slack.Block{section}, nil
+	}
+
+	value, err := metadata.GenerateCommandMetadata(rotaDetails.Pk, rotaName, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	actions := slack.NewActionBlock("")
+	if len(rotaDetails.Members) > 1 {
+		actions.Elements.ElementSet = append(actions.Elements.ElementSet,
+			&slack.ButtonBlockElement{
+				Type:     "button",
+				ActionID: SwapShiftPromptAction,
+				Text:     &slack.TextBlockObject{Text: "Swap", Type: slack.PlainTextType},
+				Value:    value,
+			},
+			&slack.ButtonBlockElement{
+				Type:     "button",
+				ActionID: SkipShiftAction,
+				Text:     &slack.TextBlockObject{Text: "Skip my shift", Type: slack.PlainTextType},
+				Value:    value,
+			},
+		)
+	}
+	actions.Elements.ElementSet = append(actions.Elements.ElementSet,
+		&slack.ButtonBlockElement{
+			Type:     "button",
+			ActionID: OverrideShiftPromptAction,
+			Text:     &slack.TextBlockObject{Text: "Override", Type: slack.PlainTextType},
+			Value:    value,
+		},
+	)
+
+	return []slack.Block{section, actions}, nil
+}
+
+// sortRotas orders rotas by channel, then name.
+func sortRotas(rotas []*rotadetails.RotaDetails) {
+	sort.Slice(rotas, func(i, j int) bool {
+		if rotas[i].Pk != rotas[j].Pk {
+			return rotas[i].Pk < rotas[j].Pk
+		}
+		return rotas[i].Sk < rotas[j].Sk
+	})
+}
+
+func nextShiftOf(rotaDetails *rotadetails.RotaDetails, member string) (rotadetails.Shift, bool) {
+	startOfShift, endOfShift, ok := currentShift(rotaDetails)
+	if !ok {
+		return rotadetails.Shift{}, false
+	}
+
+	return rotaDetails.NextShiftOf(member, startOfShift, endOfShift)
+}
+
+// currentShift reads the times of the rota's running shift, if there is one.
+func currentShift(rotaDetails *rotadetails.RotaDetails) (time.Time, time.Time, bool) {
+	startOfShift, err := formatter.ParseTime(rotaDetails.StartOfShift)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	endOfShift, err := formatter.ParseTime(rotaDetails.EndOfShift)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	return startOfShift, endOfShift, true
+}
+
+func (c *RotaCommand) SwapShiftPrompt(interaction *slack.InteractionCallback, action *slack.BlockAction) error {
+	return c.selectMemberPrompt(interaction, action, SwapShiftCallback, "Swap shifts", "Who do you want to swap with?")
+}
+
+func (c *RotaCommand) OverrideShiftPrompt(interaction *slack.InteractionCallback, action *slack.BlockAction) error {
+	return c.selectMemberPrompt(interaction, action, OverrideShiftCallback, "Override a shift", "Who should cover the current shift?")
+}
+
+func (c *RotaCommand) selectMemberPrompt(interaction *slack.InteractionCallback, action *slack.BlockAction, callbackId string, title string, label string) error {
+	userId := interaction.User.ID
+
+	rotaDetails, err := c.homeActionRota(userId, action.Value)
+	if err != nil || rotaDetails == nil {
+		return err
+	}
+
+	memberOptionBlockObjects := make([]*slack.OptionBlockObject, 0, len(rotaDetails.Members))
+	for _, v := range rotaDetails.Members {
+		if callbackId == SwapShiftCallback && v == userId {
+			continue
+		}
+		if callbackId == OverrideShiftCallback && v == rotaDetails.CurrOnCallMember {
+			continue
+		}
+		optionText := slack.NewTextBlockObject(slack.PlainTextType, formatter.AtUserId(v), false, false)
+		memberOptionBlockObjects = append(memberOptionBlockObjects, slack.NewOptionBlockObject(v, optionText, nil))
+	}
+
+	memberText := slack.NewTextBlockObject(slack.PlainTextType, label, false, false)
+	memberElement := slack.NewOptionsSelectBlockElement(slack.OptTypeStatic, nil, rotaMemberAction, memberOptionBlockObjects...)
+
+	var modalRequest slack.ModalViewRequest
+	modalRequest.Type = "modal"
+	modalRequest.Title = slack.NewTextBlockObject(slack.PlainTextType, title, false, false)
+	modalRequest.Close = slack.NewTextBlockObject(slack.PlainTextType, "Close", false, false)
+	modalRequest.Submit = slack.NewTextBlockObject(slack.PlainTextType, "Save", false, false)
+	modalRequest.Blocks = slack.Blocks{
+		BlockSet: []slack.Block{
+			slack.NewInputBlock(rotaMemberBlock, memberText, memberElement),
+		},
+	}
+	modalRequest.CallbackID = callbackId
+	modalRequest.PrivateMetadata = action.Value
+
+	_, err = c.client.OpenView(interaction.TriggerID, modalRequest)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *RotaCommand) SkipShift(interaction *slack.InteractionCallback, action *slack.BlockAction) error {
+	userId := interaction.User.ID
+
+	rotaDetails, err := c.homeActionRota(userId, action.Value)
+	if err != nil || rotaDetails == nil {
+		return err
+	}
+
+	nextMember := rotaDetails.MemberAfter(userId)
+	if nextMember == userId {
+		return c.respondToClient(rotaDetails.Pk, userId, errorAttachment(fmt.Sprintf("[%v] There's no one else in the rota to take your shift.", rotaDetails.RotaName())))
+	}
+
+	if rotaDetails.RequireApproval {
+		return c.requestChange(rotaDetails, &change.Change{Type: change.TypeSkip, RequestedBy: userId, Member: nextMember})
+	}
+
+	err = c.skipShift(rotaDetails, userId, nextMember)
+	if err != nil {
+		return err
+	}
+
+	return c.PublishHome(userId)
+}
+
+func (c *RotaCommand) SwapShift(interaction *slack.InteractionCallback) error {
+	userId := interaction.User.ID
+	member := interaction.View.State.Values[rotaMemberBlock][rotaMemberAction].SelectedOption.Value
+
+	rotaDetails, err := c.homeActionRota(userId, interaction.View.PrivateMetadata)
+	if err != nil || rotaDetails == nil {
+		return err
+	}
+
+	if refusal := swapRefusal(rotaDetails, userId, member); refusal != "" {
+		return c.respondToClient(rotaDetails.Pk, userId, errorAttachment(refusal))
+	}
+
+	if rotaDetails.RequireApproval {
+		return c.requestChange(rotaDetails, &change.Change{Type: change.TypeSwap, RequestedBy: userId, Member: member})
+	}
+
+	err = c.swapShift(rotaDetails, userId, member)
+	if err != nil {
+		return err
+	}
+
+	return c.PublishHome(userId)
+}
+
+func (c *RotaCommand) OverrideShift(interaction *slack.InteractionCallback) error {
+	userId := interaction.User.ID
+	member := interaction.View.State.Values[rotaMemberBlock][rotaMemberAction].SelectedOption.Value
+
+	rotaDetails, err := c.homeActionRota(userId, interaction.View.PrivateMetadata)
+	if err != nil || rotaDetails == nil {
+		return err
+	}
+
+	if refusal := overrideRefusal(rotaDetails, member); refusal != "" {
+		return c.respondToClient(rotaDetails.Pk, userId, errorAttachment(refusal))
+	}
+
+	if rotaDetails.RequireApproval {
+		return c.requestChange(rotaDetails, &change.Change{Type: change.TypeOverride, RequestedBy: userId, Member: member})
+	}
+
+	err = c.overrideShift(rotaDetails, userId, member)
+	if err != nil {
+		return err
+	}
+
+	return c.PublishHome(userId)
+}
+
+// swapRefusal says why the user can't swap shifts with the member, or is empty
+// if they can.
+func swapRefusal(rotaDetails *rotadetails.RotaDetails, userId string, member string) string {
+	if !rotaDetails.HasMember(userId) || !rotaDetails.HasMember(member) || member == userId {
+		return fmt.Sprintf("[%v] %s isn't someone you can swap with.", rotaDetails.RotaName(), formatter.AtUserId(member))
+	}
+
+	return ""
+}
+
+// overrideRefusal says why the member can't cover the current shift, or is
+// empty if they can.
+func overrideRefusal(rotaDetails *rotadetails.RotaDetails, member string) string {
+	rotaName := rotaDetails.RotaName()
+
+	if rotaDetails.CurrOnCallMember == "" {
+		return fmt.Sprintf("[%v] Can't override a shift that has yet to start.", rotaName)
+	}
+
+	if !rotaDetails.HasMember(member) || member == rotaDetails.CurrOnCallMember {
+		return fmt.Sprintf("[%v] %s can't cover this shift.", rotaName, formatter.AtUserId(member))
+	}
+
+	return ""
+}
+
+func (c *RotaCommand) skipShift(rotaDetails *rotadetails.RotaDetails, userId string, nextMember string) error {
+	err := c.swapShifts(rotaDetails, userId, nextMember)
+	if err != nil {
+		return err
+	}
+
+	c.emit(webhook.Event{Type: webhook.EventSwap, ChannelId: rotaDetails.Pk, RotaName: rotaDetails.RotaName(), PreviousOnCallMember: rotaDetails.CurrOnCallMember, Actor: userId})
+
+	return c.announce(rotaDetails.Pk, fmt.Sprintf("[%v] %s skipped a shift, so %s goes first.", rotaDetails.RotaName(), formatter.AtUserId(userId), formatter.AtUserId(nextMember)))
+}
+
+func (c *RotaCommand) swapShift(rotaDetails *rotadetails.RotaDetails, userId string, member string) error {
+	err := c.swapShifts(rotaDetails, userId, member)
+	if err != nil {
+		return err
+	}
+
+	c.emit(webhook.Event{Type: webhook.EventSwap, ChannelId: rotaDetails.Pk, RotaName: rotaDetails.RotaName(), PreviousOnCallMember: rotaDetails.CurrOnCallMember, Actor: userId})
+
+	return c.announce(rotaDetails.Pk, fmt.Sprintf("[%v] %s and %s swapped shifts.", rotaDetails.RotaName(), formatter.AtUserId(userId), formatter.AtUserId(member)))
+}
+
+// overrideShift has the member cover the current shift, or hands it back to
+// whoever it belongs to.
+func (c *RotaCommand) overrideShift(rotaDetails *rotadetails.RotaDetails, userId string, member string) error {
+	channelId := rotaDetails.Pk
+	rotaName := rotaDetails.RotaName()
+
+	scheduledOnCallMember := rotaDetails.ScheduledOnCallMember()
+	var err error
+	var text string
+	if member == scheduledOnCallMember {
+		err = c.handler.UpdateOnCallMember(channelId, rotaName, member, rotaDetails.StartOfShift, rotaDetails.EndOfShift)
+		text = fmt.Sprintf("[%v] %s is back on duty!", rotaName, formatter.AtUserId(member))
+	} else {
+		err = c.handler.OverrideOnCallMember(channelId, rotaName, member, scheduledOnCallMember)
+		text = fmt.Sprintf("[%v] %s is covering %s's shift until %v.", rotaName, formatter.AtUserId(member), formatter.AtUserId(scheduledOnCallMember), rotaDetails.EndOfShift)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.emit(webhook.Event{Type: webhook.EventOverride, ChannelId: channelId, RotaName: rotaName, PreviousOnCallMember: rotaDetails.CurrOnCallMember, Actor: userId})
+
+	return c.announce(channelId, text)
+}
+
+// swapShifts swaps two members' places in the rota. If either of them holds
+// the current shift, it moves to the other along with their place.
+func (c *RotaCommand) swapShifts(rotaDetails *rotadetails.RotaDetails, a string, b string) error {
+	channelId := rotaDetails.Pk
+	rotaName := rotaDetails.RotaName()
+
+	err := c.handler.SaveRotaDetails(channelId, rotaName, rotaDetails.SwapMembers(a, b), strconv.Itoa(rotaDetails.Duration))
+	if err != nil {
+		return err
+	}
+
+	scheduledOnCallMember := rotaDetails.ScheduledOnCallMember()
+	var swappedOnCallMember string
+	switch scheduledOnCallMember {
+	case a:
+		swappedOnCallMember = b
+	case b:
+		swappedOnCallMember = a
+	default:
+		return nil
+	}
+
+	if rotaDetails.CurrOnCallMember == scheduledOnCallMember || rotaDetails.CurrOnCallMember == swappedOnCallMember {
+		return c.handler.UpdateOnCallMember(channelId, rotaName, swappedOnCallMember, rotaDetails.StartOfShift, rotaDetails.EndOfShift)
+	}
+
+	return c.handler.OverrideOnCallMember(channelId, rotaName, rotaDetails.CurrOnCallMember, swappedOnCallMember)
+}
+
+// homeActionRota loads the rota a Home tab action refers to. Home tab
+// interactions don't come from a channel, so the button carries it. Users who
+// can't act on the rota are told why and get nil back.
+func (c *RotaCommand) homeActionRota(userId string, value string) (*rotadetails.RotaDetails, error) {
+	commandMetadata, err := metadata.UnpackCommandMetadata(value)
+	if err != nil {
+		return nil, err
+	}
+
+	rotaDetails, err := c.handler.GetRotaDetails(commandMetadata.ChannelId, commandMetadata.RotaName)
+	if err != nil {
+		return nil, err
+	}
+
+	if rotaDetails == nil {
+		return nil, c.PublishHome(userId)
+	}
+
+	if !rotaDetails.HasMember(userId) {
+		return nil, c.respondToClient(rotaDetails.Pk, userId, errorAttachment(fmt.Sprintf("[%v] Only members of the rota can change its shifts.", rotaDetails.RotaName())))
+	}
+
+	if ok, err := c.allowed(userId, c.config.SwapRole, rotaDetails, "change its shifts"); !ok || err != nil {
+		return nil, err
+	}
+
+	return rotaDetails, nil
+}
+
+func (c *RotaCommand) announce(channelId string, text string) error {
+	attachment := slack.Attachment{}
+	attachment.Text = text
+	attachment.Color = "#4af030"
+	_, _, err := c.client.PostMessage(channelId, attachment)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func markdownSection(text string) *slack.SectionBlock {
+	return slack.NewSectionBlock(&slack.TextBlockObject{Type: slack.MarkdownType, Text: text}, nil, nil)
+}
@@ -0,0 +1,149 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/handler"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/metadata"
+	"alfred-bot/utils/clock"
+	"alfred-bot/utils/formatter"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/slack-go/slack"
+	"time"
+)
+
+var _ = Describe("Home", func() {
+	var fakeClock *clock.Fake
+	var store *handler.MemoryHandler
+	var mockSlackClient *MockSlackClient
+	var rotaCommand *RotaCommand
+
+	startOfShift := time.Date(2022, time.March, 7, 9, 0, 0, 0, time.UTC)
+	endOfShift := time.Date(2022, time.March, 14, 9, 0, 0, 0, time.UTC)
+
+	homeTexts := func(userId string) []string {
+		var texts []string
+		for _, block := range mockSlackClient.HomeViews[userId].Blocks.BlockSet {
+			if section, ok := block.(*slack.SectionBlock); ok {
+				texts = append(texts, section.Text.Text)
+			}
+		}
+		return texts
+	}
+
+	buttonValue := func() string {
+		value, err := metadata.GenerateCommandMetadata(testChannelId, testRotaName, "", "")
+		Expect(err).To(BeNil())
+		return value
+	}
+
+	interactionFrom := func(userId string) *slack.InteractionCallback {
+		interaction := &slack.InteractionCallback{}
+		interaction.User.ID = userId
+		return interaction
+	}
+
+	submission := func(userId string, member string) *slack.InteractionCallback {
+		interaction := interactionFrom(userId)
+		interaction.View.PrivateMetadata = buttonValue()
+		interaction.View.State = &slack.ViewState{
+			Values: map[string]map[string]slack.BlockAction{
+				rotaMemberBlock: {rotaMemberAction: {SelectedOption: slack.OptionBlockObject{Value: member}}},
+			},
+		}
+		return interaction
+	}
+
+	BeforeEach(func() {
+		fakeClock = clock.NewFake(startOfShift)
+		store = handler.NewMemoryHandler(fakeClock)
+		mockSlackClient = &MockSlackClient{}
+		rotaCommand = New(store, mockSlackClient, fakeClock, testRotaConfig)
+
+		Expect(store.SaveRotaDetails(testChannelId, testRotaName, []string{"Evan", "Sia", "Wai"}, "1")).To(Succeed())
+		Expect(store.SaveRotaOwners(testChannelId, testRotaName, []string{"Suan"})).To(Succeed())
+		Expect(store.SaveRotaDetails("other_channel", "other_rota", []string{"Sia"}, "1")).To(Succeed())
+		Expect(store.UpdateOnCallMember(testChannelId, testRotaName, "Evan", formatter.FormatTime(startOfShift), formatter.FormatTime(endOfShift))).To(Succeed())
+	})
+
+	Describe("PublishHome", func() {
+		It("Shows each rota the user is in with their next shift", func() {
+			Expect(rotaCommand.PublishHome("Wai")).To(Succeed())
+			Expect(homeTexts("Wai")).To(Equal([]string{
+				"*dummy_rota* in <#dummy_channel>\n<@Evan> is on duty until " + formatter.FormatTime(endOfShift) +
+					". Your next shift starts on " + formatter.FormatTime(time.Date(2022, time.March, 21, 9, 0, 0, 0, time.UTC)) + ".",
+				"You don't administer any rotas.",
+			}))
+		})
+
+		It("Shows rotas that haven't started", func() {
+			Expect(rotaCommand.PublishHome("Sia")).To(Succeed())
+			Expect(homeTexts("Sia")).To(ContainElement("*other_rota* in <#other_channel>\nThe rota hasn't started."))
+		})
+
+		It("Shows the rotas the user administers", func() {
+			Expect(rotaCommand.PublishHome("Suan")).To(Succeed())
+			Expect(homeTexts("Suan")).To(Equal([]string{
+				"You aren't in any rotas yet. Run `/rota` in a channel to create one.",
+				"*dummy_rota* in <#dummy_channel>: 3 member(s), <@Evan> on duty",
+			}))
+		})
+	})
+
+	Describe("SkipShift", func() {
+		It("Hands the current shift to the next member", func() {
+			Expect(rotaCommand.SkipShift(interactionFrom("Evan"), &slack.BlockAction{Value: buttonValue()})).To(Succeed())
+
+			rotaDetails, _ := store.GetRotaDetails(testChannelId, testRotaName)
+			Expect(rotaDetails.Members).To(Equal([]string{"Sia", "Evan", "Wai"}))
+			Expect(rotaDetails.CurrOnCallMember).To(Equal("Sia"))
+			Expect(rotaDetails.EndOfShift).To(Equal(formatter.FormatTime(endOfShift)))
+			Expect(mockSlackClient.Messages()).To(Equal([]string{"[dummy_rota] <@Evan> skipped a shift, so <@Sia> goes first."}))
+		})
+
+		It("Refuses users outside the rota", func() {
+			Expect(rotaCommand.SkipShift(interactionFrom("Suan"), &slack.BlockAction{Value: buttonValue()})).To(Succeed())
+
+			rotaDetails, _ := store.GetRotaDetails(testChannelId, testRotaName)
+			Expect(rotaDetails.Members).To(Equal([]string{"Evan", "Sia", "Wai"}))
+			Expect(mockSlackClient.Messages()).To(BeEmpty())
+		})
+	})
+
+	Describe("SwapShift", func() {
+		It("Swaps places without touching the current shift", func() {
+			Expect(rotaCommand.SwapShift(submission("Sia", "Wai"))).To(Succeed())
+
+			rotaDetails, _ := store.GetRotaDetails(testChannelId, testRotaName)
+			Expect(rotaDetails.Members).To(Equal([]string{"Evan", "Wai", "Sia"}))
+			Expect(rotaDetails.CurrOnCallMember).To(Equal("Evan"))
+			Expect(rotaDetails.NextOnCallMember()).To(Equal("Wai"))
+		})
+	})
+
+	Describe("OverrideShift", func() {
+		It("Covers the current shift and then carries on in order", func() {
+			Expect(rotaCommand.OverrideShift(submission("Evan", "Wai"))).To(Succeed())
+
+			rotaDetails, _ := store.GetRotaDetails(testChannelId, testRotaName)
+			Expect(rotaDetails.CurrOnCallMember).To(Equal("Wai"))
+			Expect(rotaDetails.OverriddenOnCallMember).To(Equal("Evan"))
+
+			fakeClock.Set(endOfShift)
+			rotaCommand.handOverShift(testChannelId, testRotaName)
+
+			rotaDetails, _ = store.GetRotaDetails(testChannelId, testRotaName)
+			Expect(rotaDetails.CurrOnCallMember).To(Equal("Sia"))
+			Expect(rotaDetails.OverriddenOnCallMember).To(Equal(""))
+		})
+
+		It("Hands the shift back to whoever it belongs to", func() {
+			Expect(rotaCommand.OverrideShift(submission("Evan", "Wai"))).To(Succeed())
+			Expect(rotaCommand.OverrideShift(submission("Evan", "Evan"))).To(Succeed())
+
+			rotaDetails, _ := store.GetRotaDetails(testChannelId, testRotaName)
+			Expect(rotaDetails.CurrOnCallMember).To(Equal("Evan"))
+			Expect(rotaDetails.OverriddenOnCallMember).To(Equal(""))
+			Expect(mockSlackClient.Messages()[1]).To(Equal("[dummy_rota] <@Evan> is back on duty!"))
+		})
+	})
+})
@@ -0,0 +1,42 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/subcommand"
+	"errors"
+	"fmt"
+	"github.com/slack-go/slack"
+)
+
+// HandleMention answers a message that mentions the bot, replying in the
+// message's thread. It understands the same subcommands as /rota.
+func (c *RotaCommand) HandleMention(channelId string, userId string, text string, threadTimestamp string) error {
+	cmd, err := subcommand.ParseMention(text)
+
+	var usageErr *subcommand.UsageError
+	var reply *slack.Attachment
+	switch {
+	case errors.As(err, &usageErr):
+		reply = errorAttachment(usageErr.Error())
+	case err != nil || cmd == nil:
+		reply = &slack.Attachment{Text: "Sorry, I didn't catch that. Try asking \"who's on call?\".\n" + subcommand.Usage()}
+	case subcommand.IsPrivate(cmd.Name):
+		reply = errorAttachment(fmt.Sprintf("The answer to that is a secret. Run `/rota %s` instead, so only you can see it.", cmd.Name))
+	default:
+		reply, err = c.runSubcommand(channelId, userId, cmd)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Commands like start and stop announce themselves in the channel.
+	if reply == nil {
+		return nil
+	}
+
+	_, _, err = c.client.PostReply(channelId, threadTimestamp, *reply)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
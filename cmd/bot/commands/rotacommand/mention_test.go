@@ -0,0 +1,66 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/handler"
+	"alfred-bot/utils/clock"
+	"alfred-bot/utils/formatter"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("HandleMention", func() {
+	const threadTimestamp = "1650000000.000100"
+
+	var store *handler.MemoryHandler
+	var mockSlackClient *MockSlackClient
+	var rotaCommand *RotaCommand
+
+	startOfShift := time.Date(2022, time.March, 7, 9, 0, 0, 0, time.UTC)
+	endOfShift := time.Date(2022, time.March, 14, 9, 0, 0, 0, time.UTC)
+
+	mention := func(userId string, text string) []string {
+		Expect(rotaCommand.HandleMention(testChannelId, userId, text, threadTimestamp)).To(Succeed())
+		return mockSlackClient.Replies[threadTimestamp]
+	}
+
+	BeforeEach(func() {
+		fakeClock := clock.NewFake(startOfShift)
+		store = handler.NewMemoryHandler(fakeClock)
+		mockSlackClient = &MockSlackClient{}
+		rotaCommand = New(store, mockSlackClient, fakeClock, testRotaConfig)
+
+		Expect(store.SaveRotaDetails(testChannelId, "Payments", []string{"Evan", "Sia"}, "1")).To(Succeed())
+		Expect(store.UpdateOnCallMember(testChannelId, "Payments", "Evan", formatter.FormatTime(startOfShift), formatter.FormatTime(endOfShift))).To(Succeed())
+	})
+
+	It("Answers who is on call in the thread", func() {
+		Expect(mention("Sia", "<@UBOT> who's on call for Payments?")).To(Equal([]string{
+			"[Payments] <@Evan> is on duty until " + formatter.FormatTime(endOfShift),
+		}))
+	})
+
+	It("Answers when the user is next on duty", func() {
+		Expect(mention("Sia", "<@UBOT> when am I next?")).To(Equal([]string{
+			"[Payments] in <#dummy_channel>: your next shift starts on " + formatter.FormatTime(endOfShift),
+		}))
+	})
+
+	It("Runs subcommands that change the rota", func() {
+		Expect(mention("Sia", "<@UBOT> stop Payments")).To(BeEmpty())
+		Expect(mockSlackClient.Messages()).To(Equal([]string{"[Payments] <@Evan> is now off duty!"}))
+	})
+
+	It("Pages the channel's only rota when none is named", func() {
+		Expect(mention("Sia", "<@UBOT> page on-call: checkout is down")).To(BeEmpty())
+		Expect(mockSlackClient.PostsTo("Evan")).To(HaveLen(1))
+		Expect(mockSlackClient.Messages()[0]).To(HavePrefix("[Payments] <@Sia> paged <@Evan>: checkout is down"))
+	})
+
+	It("Explains what it understands when it doesn't follow", func() {
+		replies := mention("Sia", "<@UBOT> make me a sandwich")
+		Expect(replies).To(HaveLen(1))
+		Expect(replies[0]).To(ContainSubstring("who's on call?"))
+		Expect(replies[0]).To(ContainSubstring("/rota who [name]"))
+	})
+})
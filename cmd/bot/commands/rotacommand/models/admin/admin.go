@@ -0,0 +1,13 @@
+package admin
+
+// Key is the partition that every workspace admin is stored under.
+const Key = "admin"
+
+// Admin may do anything to any rota, and choose who else is an admin. Slack's
+// own workspace admins and owners count as admins without being stored.
+type Admin struct {
+	Pk      string `dynamodbav:"pk"`
+	Sk      string `dynamodbav:"sk"` // Slack user ID
+	AddedBy string `dynamodbav:"addedBy"`
+	AddedAt string `dynamodbav:"addedAt"`
+}
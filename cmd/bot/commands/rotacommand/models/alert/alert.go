@@ -0,0 +1,179 @@
+package alert
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+
+	keyPrefix = "alert#"
+)
+
+// Alert is a notification from a monitoring system, kept so that repeats can be
+// told apart from new alerts and a resolution can update the original message.
+// Alerts share the rotas table, under their own partition key per channel.
+type Alert struct {
+	Pk          string `dynamodbav:"pk"`
+	Sk          string `dynamodbav:"sk"` // Fingerprint
+	RotaName    string `dynamodbav:"rotaName"`
+	Status      string `dynamodbav:"status"`
+	Title       string `dynamodbav:"title"`
+	Description string `dynamodbav:"description"`
+	URL         string `dynamodbav:"url"`
+	OnCall      string `dynamodbav:"onCall"`    // Who was on duty when it fired
+	MessageTs   string `dynamodbav:"messageTs"` // Timestamp of the channel message
+}
+
+func Key(channelId string) string {
+	return keyPrefix + channelId
+}
+
+func (a *Alert) ChannelId() string {
+	return strings.TrimPrefix(a.Pk, keyPrefix)
+}
+
+func (a *Alert) Fingerprint() string {
+	return a.Sk
+}
+
+func (a *Alert) IsFiring() bool {
+	return a.Status == StatusFiring
+}
+
+// alertmanagerPayload is the body of an Alertmanager webhook, which Grafana
+// also sends.
+type alertmanagerPayload struct {
+	Alerts []struct {
+		Status       string            `json:"status"`
+		Labels       map[string]string `json:"labels"`
+		Annotations  map[string]string `json:"annotations"`
+		GeneratorURL string            `json:"generatorURL"`
+		Fingerprint  string            `json:"fingerprint"`
+	} `json:"alerts"`
+}
+
+// genericPayload is a single alert from anything else that can send JSON.
+type genericPayload struct {
+	Title       string `json:"title"`
+	Message     string `json:"message"`
+	Description string `json:"description"`
+	Status      string `json:"status"`
+	Fingerprint string `json:"fingerprint"`
+	Id          string `json:"id"`
+	URL         string `json:"url"`
+}
+
+// Parse reads an Alertmanager webhook, or a generic JSON alert such as
+// {"title": "...", "message": "...", "status": "firing", "fingerprint": "..."}.
+// The alerts it returns have no channel or rota yet.
+func Parse(body []byte) ([]*Alert, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, errors.New("payload must be a JSON object")
+	}
+
+	if _, ok := fields["alerts"]; ok {
+		return parseAlertmanager(body)
+	}
+
+	return parseGeneric(body)
+}
+
+func parseAlertmanager(body []byte) ([]*Alert, error) {
+	var payload alertmanagerPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	alerts := make([]*Alert, 0, len(payload.Alerts))
+	for _, v := range payload.Alerts {
+		title := v.Annotations["summary"]
+		if title == "" {
+			title = v.Labels["alertname"]
+		}
+
+		fingerprint := v.Fingerprint
+		if fingerprint == "" {
+			// Alertmanager versions before 0.19 don't send one.
+			fingerprint = labelsFingerprint(v.Labels)
+		}
+
+		alerts = append(alerts, &Alert{
+			Sk:          fingerprint,
+			Status:      status(v.Status),
+			Title:       title,
+			Description: v.Annotations["description"],
+			URL:         v.GeneratorURL,
+		})
+	}
+
+	return alerts, nil
+}
+
+func parseGeneric(body []byte) ([]*Alert, error) {
+	var payload genericPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	if payload.Title == "" {
+		return nil, errors.New("alert must have a title")
+	}
+
+	description := payload.Message
+	if description == "" {
+		description = payload.Description
+	}
+
+	fingerprint := payload.Fingerprint
+	if fingerprint == "" {
+		fingerprint = payload.Id
+	}
+	if fingerprint == "" {
+		fingerprint = hash(payload.Title)
+	}
+
+	return []*Alert{{
+		Sk:          fingerprint,
+		Status:      status(payload.Status),
+		Title:       payload.Title,
+		Description: description,
+		URL:         payload.URL,
+	}}, nil
+}
+
+func status(raw string) string {
+	switch strings.ToLower(raw) {
+	case StatusResolved, "ok", "resolve":
+		return StatusResolved
+	default:
+		return StatusFiring
+	}
+}
+
+func labelsFingerprint(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + "=" + labels[name] + "\n")
+	}
+
+	return hash(b.String())
+}
+
+func hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,80 @@
+package alert
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestAlert(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Alert Suite")
+}
+
+var _ = Describe("Parse", func() {
+	It("Reads each alert from an Alertmanager webhook", func() {
+		alerts, err := Parse([]byte(`{
+			"version": "4",
+			"status": "firing",
+			"alerts": [
+				{
+					"status": "firing",
+					"labels": {"alertname": "HighLatency", "service": "checkout"},
+					"annotations": {"summary": "Checkout is slow", "description": "p99 over 2s"},
+					"generatorURL": "http://prometheus/graph",
+					"fingerprint": "abc123"
+				},
+				{
+					"status": "resolved",
+					"labels": {"alertname": "DiskFull"},
+					"annotations": {},
+					"fingerprint": "def456"
+				}
+			]
+		}`))
+		Expect(err).To(BeNil())
+		Expect(alerts).To(Equal([]*Alert{
+			{Sk: "abc123", Status: StatusFiring, Title: "Checkout is slow", Description: "p99 over 2s", URL: "http://prometheus/graph"},
+			{Sk: "def456", Status: StatusResolved, Title: "DiskFull"},
+		}))
+	})
+
+	It("Fingerprints Alertmanager alerts by their labels if need be", func() {
+		first, err := Parse([]byte(`{"alerts": [{"labels": {"alertname": "DiskFull", "host": "a"}}]}`))
+		Expect(err).To(BeNil())
+		second, err := Parse([]byte(`{"alerts": [{"labels": {"host": "a", "alertname": "DiskFull"}}]}`))
+		Expect(err).To(BeNil())
+		other, err := Parse([]byte(`{"alerts": [{"labels": {"alertname": "DiskFull", "host": "b"}}]}`))
+		Expect(err).To(BeNil())
+
+		Expect(first[0].Fingerprint()).ToNot(BeEmpty())
+		Expect(first[0].Fingerprint()).To(Equal(second[0].Fingerprint()))
+		Expect(first[0].Fingerprint()).ToNot(Equal(other[0].Fingerprint()))
+	})
+
+	It("Reads a generic alert", func() {
+		alerts, err := Parse([]byte(`{"title": "Checkout is down", "message": "500s everywhere", "status": "ok", "id": "42"}`))
+		Expect(err).To(BeNil())
+		Expect(alerts).To(Equal([]*Alert{
+			{Sk: "42", Status: StatusResolved, Title: "Checkout is down", Description: "500s everywhere"},
+		}))
+	})
+
+	It("Fingerprints a generic alert by its title if need be", func() {
+		first, err := Parse([]byte(`{"title": "Checkout is down"}`))
+		Expect(err).To(BeNil())
+		second, err := Parse([]byte(`{"title": "Checkout is down", "message": "Still down"}`))
+		Expect(err).To(BeNil())
+
+		Expect(first[0].Status).To(Equal(StatusFiring))
+		Expect(first[0].Fingerprint()).To(Equal(second[0].Fingerprint()))
+	})
+
+	It("Rejects payloads it can't read", func() {
+		_, err := Parse([]byte(`not json`))
+		Expect(err).ToNot(BeNil())
+
+		_, err = Parse([]byte(`{"message": "no title"}`))
+		Expect(err).ToNot(BeNil())
+	})
+})
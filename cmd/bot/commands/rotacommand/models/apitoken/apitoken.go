@@ -0,0 +1,38 @@
+package apitoken
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Key is the partition that every API token is stored under.
+const Key = "apitoken"
+
+// TeamKey is the partition that every workspace's token hashes are indexed
+// under, so a request can be matched to its workspace with a single read.
+const TeamKey = "apitokenteam"
+
+// Token grants read access to the REST API. Only a hash of the token is kept,
+// so a leaked table doesn't leak working tokens.
+type Token struct {
+	Pk        string `dynamodbav:"pk"`
+	Sk        string `dynamodbav:"sk"` // Hash of the token
+	Label     string `dynamodbav:"label"`
+	CreatedBy string `dynamodbav:"createdBy"`
+	CreatedAt string `dynamodbav:"createdAt"`
+}
+
+// Hash returns the form a token is stored and looked up in.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Team points a token's hash at the workspace it was made in.
+type Team struct {
+	Pk string `dynamodbav:"pk"`
+	Sk string `dynamodbav:"sk"` // Hash of the token
+	// TeamId is empty for the workspace the bot served before it could be
+	// installed in others.
+	TeamId string `dynamodbav:"teamId"`
+}
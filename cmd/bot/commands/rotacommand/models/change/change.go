@@ -0,0 +1,58 @@
+package change
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	TypeMembers  = "members"
+	TypeOverride = "override"
+	TypeSwap     = "swap"
+	TypeSkip     = "skip"
+
+	keyPrefix = "change#"
+)
+
+// Change is a change to a rota that is waiting for an owner to approve or
+// reject it. Changes share the rotas table, under their own partition key per
+// rota, and are deleted once decided; the rota's history keeps the decision.
+type Change struct {
+	Pk          string `dynamodbav:"pk"`
+	Sk          string `dynamodbav:"sk"` // Change ID
+	Type        string `dynamodbav:"type"`
+	RequestedBy string `dynamodbav:"requestedBy"`
+	RequestedAt string `dynamodbav:"requestedAt"`
+	// Member is who the requester swaps with, who goes first when they skip,
+	// or who covers the current shift.
+	Member string `dynamodbav:"member"`
+	// NewMembers and NewDuration are what a members change sets. They aren't
+	// called members and duration, as only rotas have those.
+	NewMembers  []string `dynamodbav:"newMembers"`
+	NewDuration string   `dynamodbav:"newDuration"`
+}
+
+func Key(channelId string, rotaName string) string {
+	return keyPrefix + channelId + "/" + rotaName
+}
+
+func (c *Change) Id() string {
+	return c.Sk
+}
+
+// Reference identifies a change from a button value.
+func (c *Change) Reference() string {
+	return strings.TrimPrefix(c.Pk, keyPrefix) + "/" + c.Sk
+}
+
+// ParseReference returns the channel, rota name and ID of a change. Channel IDs
+// and change IDs never contain a slash, but rota names might.
+func ParseReference(reference string) (string, string, string, error) {
+	channelId, rest, ok := strings.Cut(reference, "/")
+	i := strings.LastIndex(rest, "/")
+	if !ok || channelId == "" || i <= 0 || i == len(rest)-1 {
+		return "", "", "", errors.New("invalid change reference")
+	}
+
+	return channelId, rest[:i], rest[i+1:], nil
+}
@@ -0,0 +1,30 @@
+package change
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestChange(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Change Suite")
+}
+
+var _ = Describe("Change", func() {
+	It("Round-trips its reference, even with slashes in the rota name", func() {
+		c := &Change{Pk: Key("C123", "web/api"), Sk: "42"}
+		channelId, rotaName, id, err := ParseReference(c.Reference())
+		Expect(err).To(BeNil())
+		Expect(channelId).To(Equal("C123"))
+		Expect(rotaName).To(Equal("web/api"))
+		Expect(id).To(Equal("42"))
+	})
+
+	It("Rejects references that aren't whole", func() {
+		for _, v := range []string{"", "C123", "C123/42", "/payments/42", "C123/payments/"} {
+			_, _, _, err := ParseReference(v)
+			Expect(err).To(HaveOccurred(), v)
+		}
+	})
+})
@@ -0,0 +1,45 @@
+package history
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const keyPrefix = "history#"
+
+// Retention is how long entries are kept before DynamoDB deletes them.
+const Retention = 365 * 24 * time.Hour
+
+// Entry records something that happened to a rota, such as a handover or a
+// change of members. Entries share the rotas table, under their own partition
+// key per rota, oldest first.
+type Entry struct {
+	Pk                   string `dynamodbav:"pk"`
+	Sk                   string `dynamodbav:"sk"`
+	Type                 string `dynamodbav:"type"`
+	OnCallMember         string `dynamodbav:"onCallMember"`
+	PreviousOnCallMember string `dynamodbav:"previousOnCallMember"`
+	Actor                string `dynamodbav:"actor"`
+	// Change and RequestedBy describe a change that needed approval.
+	Change      string `dynamodbav:"change"`
+	RequestedBy string `dynamodbav:"requestedBy"`
+	At          string `dynamodbav:"at"` // RFC 3339
+	// ExpiresAt is when DynamoDB deletes the entry, in Unix seconds.
+	ExpiresAt int64 `dynamodbav:"expiresAt,omitempty"`
+}
+
+func Key(channelId string, rotaName string) string {
+	return keyPrefix + channelId + "/" + rotaName
+}
+
+// SortKey orders entries by when they happened.
+func SortKey(unixNano int64, id string) string {
+	return fmt.Sprintf("%020d#%s", unixNano, id)
+}
+
+// Id is the unique part of the entry's sort key.
+func (e *Entry) Id() string {
+	_, id, _ := strings.Cut(e.Sk, "#")
+	return id
+}
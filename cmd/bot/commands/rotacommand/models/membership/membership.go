@@ -0,0 +1,34 @@
+package membership
+
+// Membership lists a rota under one of its members or owners, so that a
+// user's rotas can be found without a scan.
+type Membership struct {
+	Pk        string `dynamodbav:"pk"`
+	Sk        string `dynamodbav:"sk"` // Channel ID and rota name
+	ChannelId string `dynamodbav:"channelId"`
+	RotaName  string `dynamodbav:"rotaName"`
+}
+
+// Key is the partition that a user's memberships are stored under.
+func Key(userId string) string {
+	return "member#" + userId
+}
+
+func SortKey(channelId string, rotaName string) string {
+	return channelId + "#" + rotaName
+}
+
+// Users returns everyone a rota is listed under: its members and its owners,
+// each once.
+func Users(members []string, owners []string) []string {
+	var users []string
+	seen := map[string]bool{}
+	for _, v := range append(append([]string{}, members...), owners...) {
+		if !seen[v] {
+			seen[v] = true
+			users = append(users, v)
+		}
+	}
+
+	return users
+}
@@ -0,0 +1,28 @@
+package outbox
+
+import "fmt"
+
+// Key is the partition that every queued message is stored under.
+const Key = "outbox"
+
+// Message is a message the bot posts of its own accord, such as a handover
+// announcement, on its way to Slack. It's stored before it's first sent and
+// deleted once Slack has it, so a message that couldn't be sent is tried
+// again after a restart.
+type Message struct {
+	Pk        string `dynamodbav:"pk"`
+	Sk        string `dynamodbav:"sk"` // Message ID
+	ChannelId string `dynamodbav:"channelId"`
+	// Attachment is the message's JSON-encoded attachment.
+	Attachment string `dynamodbav:"attachment"`
+	Attempt    int    `dynamodbav:"attempt"`
+	// NextAttemptAt is empty until the message has failed to send once.
+	NextAttemptAt string `dynamodbav:"nextAttemptAt"`
+	QueuedAt      string `dynamodbav:"queuedAt"`
+	Error         string `dynamodbav:"error"`
+}
+
+// Id orders messages by when they were queued.
+func Id(unixNano int64, token string) string {
+	return fmt.Sprintf("%020d#%s", unixNano, token)
+}
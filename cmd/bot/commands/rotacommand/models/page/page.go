@@ -0,0 +1,108 @@
+package page
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	StatusOpen         = "open"
+	StatusAcknowledged = "acknowledged"
+	// StatusUnanswered means everyone in the escalation chain was paged and no
+	// one acknowledged.
+	StatusUnanswered = "unanswered"
+
+	keyPrefix = "page#"
+)
+
+// Page is an alert sent to a rota's on-call member, escalating along Responders
+// until someone acknowledges it. Pages share the rotas table, under their own
+// partition key per channel.
+type Page struct {
+	Pk         string   `dynamodbav:"pk"`
+	Sk         string   `dynamodbav:"sk"` // Page ID
+	RotaName   string   `dynamodbav:"rotaName"`
+	Message    string   `dynamodbav:"message"`
+	PagedBy    string   `dynamodbav:"pagedBy"`
+	Responders []string `dynamodbav:"responders"`
+	// Level is the index in Responders of whoever is currently being paged.
+	Level          int     `dynamodbav:"level"`
+	Status         string  `dynamodbav:"status"`
+	AcknowledgedBy string  `dynamodbav:"acknowledgedBy"`
+	EscalateAt     string  `dynamodbav:"escalateAt"`
+	MessageTs      string  `dynamodbav:"messageTs"` // Timestamp of the channel message
+	Timeline       []Event `dynamodbav:"timeline"`
+}
+
+type Event struct {
+	At   string `dynamodbav:"at"`
+	Text string `dynamodbav:"text"`
+}
+
+func Key(channelId string) string {
+	return keyPrefix + channelId
+}
+
+func (p *Page) ChannelId() string {
+	return strings.TrimPrefix(p.Pk, keyPrefix)
+}
+
+func (p *Page) Id() string {
+	return p.Sk
+}
+
+func (p *Page) IsOpen() bool {
+	return p.Status == StatusOpen
+}
+
+func (p *Page) CurrentResponder() string {
+	if p.Level < 0 || p.Level >= len(p.Responders) {
+		return ""
+	}
+
+	return p.Responders[p.Level]
+}
+
+// HasBeenPaged reports whether the user has been asked to respond yet.
+func (p *Page) HasBeenPaged(userId string) bool {
+	for i := 0; i <= p.Level && i < len(p.Responders); i++ {
+		if p.Responders[i] == userId {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *Page) Record(at string, text string) {
+	p.Timeline = append(p.Timeline, Event{At: at, Text: text})
+}
+
+// Reference identifies a page from a button value.
+func (p *Page) Reference() string {
+	return p.ChannelId() + "/" + p.Id()
+}
+
+func ParseReference(reference string) (string, string, error) {
+	i := strings.LastIndex(reference, "/")
+	if i <= 0 || i == len(reference)-1 {
+		return "", "", errors.New("invalid page reference")
+	}
+
+	return reference[:i], reference[i+1:], nil
+}
+
+// Responders lists who to page, in order: the on-call member, whoever is on
+// next, then the rota's escalation tiers.
+func Responders(onCallMember string, nextOnCallMember string, tiers []string) []string {
+	var responders []string
+	seen := map[string]bool{"": true}
+	for _, v := range append([]string{onCallMember, nextOnCallMember}, tiers...) {
+		if !seen[v] {
+			seen[v] = true
+			responders = append(responders, v)
+		}
+	}
+
+	return responders
+}
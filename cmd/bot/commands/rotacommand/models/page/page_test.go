@@ -0,0 +1,48 @@
+package page
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestPage(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Page Suite")
+}
+
+var _ = Describe("Responders", func() {
+	It("Pages the on-call member, then whoever is next, then the owners", func() {
+		Expect(Responders("Evan", "Sia", []string{"Wai", "Evan"})).To(Equal([]string{"Evan", "Sia", "Wai"}))
+	})
+
+	It("Skips whoever is next when they're the same person", func() {
+		Expect(Responders("Evan", "Evan", nil)).To(Equal([]string{"Evan"}))
+	})
+})
+
+var _ = Describe("Page", func() {
+	p := &Page{Pk: Key("C123"), Sk: "42", Responders: []string{"Evan", "Sia", "Wai"}, Level: 1}
+
+	It("Knows its channel", func() {
+		Expect(p.ChannelId()).To(Equal("C123"))
+	})
+
+	It("Knows who is being paged", func() {
+		Expect(p.CurrentResponder()).To(Equal("Sia"))
+		Expect(p.HasBeenPaged("Evan")).To(BeTrue())
+		Expect(p.HasBeenPaged("Wai")).To(BeFalse())
+	})
+
+	It("Round-trips its reference", func() {
+		channelId, pageId, err := ParseReference(p.Reference())
+		Expect(err).To(BeNil())
+		Expect(channelId).To(Equal("C123"))
+		Expect(pageId).To(Equal("42"))
+	})
+
+	It("Rejects a malformed reference", func() {
+		_, _, err := ParseReference("C123")
+		Expect(err).ToNot(BeNil())
+	})
+})
@@ -1,26 +1,180 @@
 package rotadetails
 
 import (
+	"alfred-bot/cmd/bot/commands/rotacommand/models/webhook"
 	"alfred-bot/utils/formatter"
 	"time"
 )
 
 type RotaDetails struct {
-	Pk               string // ChannelID
-	Sk               string // RotaName
-	Members          []string
+	Pk      string // ChannelID
+	Sk      string // RotaName
+	Members []string
+	Owners  []string
+	// Tiers are who pages escalate to, in order, after the on-call and next
+	// members. Without them, pages escalate to the owners.
+	Tiers            []string
 	CurrOnCallMember string
-	Duration         int
-	StartOfShift     string
-	EndOfShift       string
+	// OverriddenOnCallMember is who the current shift belongs to when someone
+	// else is covering it, so that the rota carries on in order afterwards.
+	OverriddenOnCallMember string
+	Duration               int
+	// HandoverAnchor is a time shifts hand over at, so that a rota started
+	// mid-week still hands over on the agreed day and hour.
+	HandoverAnchor string
+	StartOfShift   string
+	EndOfShift     string
+	// RoutingKey identifies the rota to monitoring systems that send it alerts.
+	RoutingKey string
+	Webhooks   []webhook.Webhook
+	// RequireApproval holds changes to members and shifts until an owner
+	// approves them.
+	RequireApproval bool
+	// Windows are when the rota is on call, in TimeZone. Without any, it's on
+	// call all the time.
+	Windows  []Window
+	TimeZone string
+}
+
+type Shift struct {
+	Member       string
+	StartOfShift time.Time
+	EndOfShift   time.Time
 }
 
 func (rd *RotaDetails) RotaName() string {
 	return rd.Sk
 }
 
-func GenerateEndOfShift(startOfShift time.Time, duration int) string {
-	// TODO: Hard-coded quick shift duration for testing purposes
-	return formatter.FormatTime(startOfShift.Add(time.Minute))
-	// return formatTime(startOfShift.Add(time.Hour * 168 * time.Duration(rd.Duration)))
+// GenerateEndOfShift adds whole calendar weeks rather than a fixed number of
+// hours so that shifts keep handing over at the same local time across DST
+// changes.
+func GenerateEndOfShift(startOfShift time.Time, duration int) time.Time {
+	return startOfShift.AddDate(0, 0, 7*duration)
+}
+
+// EndOfFirstShift is when a shift starting at startOfShift should end: a whole
+// shift later, or with a handover anchor, at the first handover on the anchor's
+// schedule that leaves at least a day on call.
+func (rd *RotaDetails) EndOfFirstShift(startOfShift time.Time) time.Time {
+	anchor, err := formatter.ParseTime(rd.HandoverAnchor)
+	if err != nil || rd.Duration < 1 {
+		return GenerateEndOfShift(startOfShift, rd.Duration)
+	}
+
+	// Stored times only carry an offset, so move the anchor into the start's
+	// location to keep the local handover time across DST changes.
+	endOfShift := anchor.In(startOfShift.Location())
+	for endOfShift.After(startOfShift) {
+		endOfShift = GenerateEndOfShift(endOfShift, -rd.Duration)
+	}
+	for endOfShift.Before(startOfShift.Add(24 * time.Hour)) {
+		endOfShift = GenerateEndOfShift(endOfShift, rd.Duration)
+	}
+
+	return endOfShift
+}
+
+// EscalationTiers are who a page goes to after the on-call and next members.
+func (rd *RotaDetails) EscalationTiers() []string {
+	if len(rd.Tiers) > 0 {
+		return rd.Tiers
+	}
+
+	return rd.Owners
+}
+
+func (rd *RotaDetails) NextOnCallMember() string {
+	return rd.MemberAfter(rd.ScheduledOnCallMember())
+}
+
+// ScheduledOnCallMember is who the current shift belongs to, even if someone
+// else is covering it.
+func (rd *RotaDetails) ScheduledOnCallMember() string {
+	if rd.OverriddenOnCallMember != "" {
+		return rd.OverriddenOnCallMember
+	}
+
+	return rd.CurrOnCallMember
+}
+
+func (rd *RotaDetails) MemberAfter(member string) string {
+	if len(rd.Members) == 0 {
+		return ""
+	}
+
+	for i, m := range rd.Members {
+		if m == member {
+			return rd.Members[(i+1)%len(rd.Members)]
+		}
+	}
+
+	// The member has left the rota, so start from the top.
+	return rd.Members[0]
+}
+
+func (rd *RotaDetails) HasMember(member string) bool {
+	for _, m := range rd.Members {
+		if m == member {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (rd *RotaDetails) HasOwner(owner string) bool {
+	for _, o := range rd.Owners {
+		if o == owner {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UpcomingShifts lists the current shift, which runs from startOfShift to
+// endOfShift, followed by the next count shifts in rota order.
+func (rd *RotaDetails) UpcomingShifts(startOfShift time.Time, endOfShift time.Time, count int) []Shift {
+	if rd.CurrOnCallMember == "" {
+		return nil
+	}
+
+	shifts := []Shift{{Member: rd.CurrOnCallMember, StartOfShift: startOfShift, EndOfShift: endOfShift}}
+	member := rd.NextOnCallMember()
+	for i := 0; i < count && member != ""; i++ {
+		startOfShift = endOfShift
+		endOfShift = GenerateEndOfShift(startOfShift, rd.Duration)
+		shifts = append(shifts, Shift{Member: member, StartOfShift: startOfShift, EndOfShift: endOfShift})
+		member = rd.MemberAfter(member)
+	}
+
+	return shifts
+}
+
+// NextShiftOf finds the member's current or next shift, if the rota is running
+// and they're in it.
+func (rd *RotaDetails) NextShiftOf(member string, startOfShift time.Time, endOfShift time.Time) (Shift, bool) {
+	for _, shift := range rd.UpcomingShifts(startOfShift, endOfShift, len(rd.Members)) {
+		if shift.Member == member {
+			return shift, true
+		}
+	}
+
+	return Shift{}, false
+}
+
+// SwapMembers swaps the places of two members in the rota order.
+func (rd *RotaDetails) SwapMembers(a string, b string) []string {
+	members := append([]string{}, rd.Members...)
+	for i, m := range members {
+		switch m {
+		case a:
+			members[i] = b
+		case b:
+			members[i] = a
+		}
+	}
+
+	return members
 }
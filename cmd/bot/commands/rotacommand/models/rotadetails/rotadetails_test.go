@@ -5,6 +5,7 @@ import (
 	. "github.com/onsi/gomega"
 	"testing"
 	"time"
+	_ "time/tzdata"
 )
 
 func TestRotaDetails(t *testing.T) {
@@ -16,4 +17,198 @@ var _ = Describe("GenerateEndOfShift", func() {
 	It("Runs", func() {
 		Expect(GenerateEndOfShift(time.Now(), 1)).ToNot(BeNil())
 	})
+
+	It("Ends the shift after the given number of weeks", func() {
+		startOfShift := time.Date(2022, time.May, 2, 9, 0, 0, 0, time.UTC)
+		Expect(GenerateEndOfShift(startOfShift, 2)).To(Equal(time.Date(2022, time.May, 16, 9, 0, 0, 0, time.UTC)))
+	})
+
+	It("Keeps the local handover time across a DST change", func() {
+		london, err := time.LoadLocation("Europe/London")
+		Expect(err).To(BeNil())
+
+		startOfShift := time.Date(2022, time.March, 21, 9, 0, 0, 0, london)
+		endOfShift := GenerateEndOfShift(startOfShift, 1)
+		Expect(endOfShift.Hour()).To(Equal(9))
+		Expect(endOfShift.Sub(startOfShift)).To(Equal(167 * time.Hour))
+	})
+})
+
+var _ = Describe("EndOfFirstShift", func() {
+	// A Monday at 09:00.
+	anchor := "Mon, 07 Mar 2022 09:00:00 +0000"
+
+	It("Ends a whole shift later without an anchor", func() {
+		startOfShift := time.Date(2022, time.May, 4, 15, 0, 0, 0, time.UTC)
+		Expect((&RotaDetails{Duration: 1}).EndOfFirstShift(startOfShift)).To(Equal(time.Date(2022, time.May, 11, 15, 0, 0, 0, time.UTC)))
+	})
+
+	It("Hands over at the next anchored time", func() {
+		rotaDetails := &RotaDetails{Duration: 1, HandoverAnchor: anchor}
+		startOfShift := time.Date(2022, time.May, 4, 15, 0, 0, 0, time.UTC)
+		Expect(rotaDetails.EndOfFirstShift(startOfShift)).To(Equal(time.Date(2022, time.May, 9, 9, 0, 0, 0, time.UTC)))
+
+		startOfShift = time.Date(2021, time.December, 31, 15, 0, 0, 0, time.UTC)
+		Expect(rotaDetails.EndOfFirstShift(startOfShift)).To(Equal(time.Date(2022, time.January, 3, 9, 0, 0, 0, time.UTC)))
+	})
+
+	It("Keeps to the anchor's fortnight for two-week shifts", func() {
+		rotaDetails := &RotaDetails{Duration: 2, HandoverAnchor: anchor}
+		startOfShift := time.Date(2022, time.May, 4, 15, 0, 0, 0, time.UTC)
+		Expect(rotaDetails.EndOfFirstShift(startOfShift)).To(Equal(time.Date(2022, time.May, 16, 9, 0, 0, 0, time.UTC)))
+	})
+
+	It("Leaves at least a day on call", func() {
+		rotaDetails := &RotaDetails{Duration: 1, HandoverAnchor: anchor}
+		startOfShift := time.Date(2022, time.May, 9, 8, 0, 0, 0, time.UTC)
+		Expect(rotaDetails.EndOfFirstShift(startOfShift)).To(Equal(time.Date(2022, time.May, 16, 9, 0, 0, 0, time.UTC)))
+	})
+
+	It("Keeps the local handover time across a DST change", func() {
+		london, err := time.LoadLocation("Europe/London")
+		Expect(err).To(BeNil())
+
+		rotaDetails := &RotaDetails{Duration: 1, HandoverAnchor: anchor}
+		startOfShift := time.Date(2022, time.May, 4, 15, 0, 0, 0, london)
+		Expect(rotaDetails.EndOfFirstShift(startOfShift)).To(Equal(time.Date(2022, time.May, 9, 9, 0, 0, 0, london)))
+	})
+})
+
+var _ = Describe("EscalationTiers", func() {
+	It("Falls back to the owners", func() {
+		Expect((&RotaDetails{Owners: []string{"Evan"}}).EscalationTiers()).To(Equal([]string{"Evan"}))
+		Expect((&RotaDetails{Owners: []string{"Evan"}, Tiers: []string{"Sia", "Wai"}}).EscalationTiers()).To(Equal([]string{"Sia", "Wai"}))
+	})
+})
+
+var _ = Describe("MemberAfter", func() {
+	rotaDetails := &RotaDetails{Members: []string{"Evan", "Sia", "Wai"}}
+
+	It("Returns the next member in the rota", func() {
+		Expect(rotaDetails.MemberAfter("Evan")).To(Equal("Sia"))
+	})
+
+	It("Wraps around to the first member", func() {
+		Expect(rotaDetails.MemberAfter("Wai")).To(Equal("Evan"))
+	})
+
+	It("Starts from the top when the member has left the rota", func() {
+		Expect(rotaDetails.MemberAfter("Suan")).To(Equal("Evan"))
+	})
+
+	It("Returns nobody for an empty rota", func() {
+		Expect((&RotaDetails{}).MemberAfter("Evan")).To(Equal(""))
+	})
+})
+
+var _ = Describe("HasMember", func() {
+	rotaDetails := &RotaDetails{Members: []string{"Evan", "Sia", "Wai"}}
+
+	It("Finds a member of the rota", func() {
+		Expect(rotaDetails.HasMember("Sia")).To(BeTrue())
+	})
+
+	It("Does not find someone outside the rota", func() {
+		Expect(rotaDetails.HasMember("Suan")).To(BeFalse())
+	})
+})
+
+var _ = Describe("NextOnCallMember", func() {
+	It("Carries on from whoever's shift is being covered", func() {
+		rotaDetails := &RotaDetails{Members: []string{"Evan", "Sia", "Wai"}, CurrOnCallMember: "Wai", OverriddenOnCallMember: "Evan"}
+		Expect(rotaDetails.NextOnCallMember()).To(Equal("Sia"))
+	})
+})
+
+var _ = Describe("UpcomingShifts", func() {
+	startOfShift := time.Date(2022, time.May, 2, 9, 0, 0, 0, time.UTC)
+	endOfShift := time.Date(2022, time.May, 9, 9, 0, 0, 0, time.UTC)
+
+	It("Lists nothing for a rota that hasn't started", func() {
+		rotaDetails := &RotaDetails{Members: []string{"Evan", "Sia"}, Duration: 1}
+		Expect(rotaDetails.UpcomingShifts(startOfShift, endOfShift, 2)).To(BeEmpty())
+	})
+
+	It("Lists the current shift followed by the next ones in order", func() {
+		rotaDetails := &RotaDetails{Members: []string{"Evan", "Sia", "Wai"}, CurrOnCallMember: "Sia", Duration: 2}
+		Expect(rotaDetails.UpcomingShifts(startOfShift, endOfShift, 2)).To(Equal([]Shift{
+			{Member: "Sia", StartOfShift: startOfShift, EndOfShift: endOfShift},
+			{Member: "Wai", StartOfShift: endOfShift, EndOfShift: time.Date(2022, time.May, 23, 9, 0, 0, 0, time.UTC)},
+			{Member: "Evan", StartOfShift: time.Date(2022, time.May, 23, 9, 0, 0, 0, time.UTC), EndOfShift: time.Date(2022, time.June, 6, 9, 0, 0, 0, time.UTC)},
+		}))
+	})
+})
+
+var _ = Describe("NextShiftOf", func() {
+	startOfShift := time.Date(2022, time.May, 2, 9, 0, 0, 0, time.UTC)
+	endOfShift := time.Date(2022, time.May, 9, 9, 0, 0, 0, time.UTC)
+	rotaDetails := &RotaDetails{Members: []string{"Evan", "Sia", "Wai"}, CurrOnCallMember: "Evan", Duration: 1}
+
+	It("Finds the current shift", func() {
+		shift, ok := rotaDetails.NextShiftOf("Evan", startOfShift, endOfShift)
+		Expect(ok).To(BeTrue())
+		Expect(shift.EndOfShift).To(Equal(endOfShift))
+	})
+
+	It("Finds a later shift", func() {
+		shift, ok := rotaDetails.NextShiftOf("Wai", startOfShift, endOfShift)
+		Expect(ok).To(BeTrue())
+		Expect(shift.StartOfShift).To(Equal(time.Date(2022, time.May, 16, 9, 0, 0, 0, time.UTC)))
+	})
+
+	It("Finds nothing for someone outside the rota", func() {
+		_, ok := rotaDetails.NextShiftOf("Suan", startOfShift, endOfShift)
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("SwapMembers", func() {
+	It("Swaps two members without changing the rota", func() {
+		rotaDetails := &RotaDetails{Members: []string{"Evan", "Sia", "Wai"}}
+		Expect(rotaDetails.SwapMembers("Evan", "Wai")).To(Equal([]string{"Wai", "Sia", "Evan"}))
+		Expect(rotaDetails.Members).To(Equal([]string{"Evan", "Sia", "Wai"}))
+	})
+})
+
+var _ = Describe("OnCallAt", func() {
+	// A Monday.
+	monday := time.Date(2022, time.March, 7, 0, 0, 0, 0, time.UTC)
+
+	It("Is always on call without windows", func() {
+		rd := &RotaDetails{}
+		Expect(rd.OnCallAt(monday)).To(BeTrue())
+	})
+
+	It("Is on call during daily windows, including overnight ones", func() {
+		rd := &RotaDetails{Windows: []Window{{Start: "09:00", End: "17:00"}, {Start: "22:00", End: "02:00"}}}
+		Expect(rd.OnCallAt(monday.Add(9 * time.Hour))).To(BeTrue())
+		Expect(rd.OnCallAt(monday.Add(17 * time.Hour))).To(BeFalse())
+		Expect(rd.OnCallAt(monday.Add(8*time.Hour + 59*time.Minute))).To(BeFalse())
+		Expect(rd.OnCallAt(monday.Add(23 * time.Hour))).To(BeTrue())
+		Expect(rd.OnCallAt(monday.Add(time.Hour))).To(BeTrue())
+		Expect(rd.OnCallAt(monday.Add(3 * time.Hour))).To(BeFalse())
+	})
+
+	It("Is on call during weekly windows, in the rota's time zone", func() {
+		rd := &RotaDetails{
+			Windows:  []Window{{StartDay: "friday", Start: "18:00", EndDay: "monday", End: "09:00"}},
+			TimeZone: "Europe/London",
+		}
+		Expect(rd.OnCallAt(monday.Add(8 * time.Hour))).To(BeTrue())
+		Expect(rd.OnCallAt(monday.Add(9 * time.Hour))).To(BeFalse())
+		Expect(rd.OnCallAt(monday.AddDate(0, 0, 3).Add(12 * time.Hour))).To(BeFalse())
+		Expect(rd.OnCallAt(monday.AddDate(0, 0, 5).Add(12 * time.Hour))).To(BeTrue())
+
+		// Once the clocks go forward, 08:30 UTC is 09:30 in London.
+		summerMonday := time.Date(2022, time.April, 4, 8, 30, 0, 0, time.UTC)
+		Expect(rd.OnCallAt(summerMonday)).To(BeFalse())
+		Expect(rd.DescribeWindows()).To(Equal("Friday 18:00 to Monday 09:00 (Europe/London)"))
+	})
+
+	It("Rejects windows it can't make sense of", func() {
+		Expect(Window{Start: "09:00", End: "17:00"}.Validate()).To(Succeed())
+		Expect(Window{StartDay: "monday", Start: "09:00", End: "17:00"}.Validate()).ToNot(Succeed())
+		Expect(Window{StartDay: "mon", Start: "09:00", EndDay: "friday", End: "17:00"}.Validate()).ToNot(Succeed())
+		Expect(Window{Start: "9am", End: "17:00"}.Validate()).ToNot(Succeed())
+	})
 })
@@ -0,0 +1,138 @@
+package rotadetails
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	// Windows are in IANA time zones, which the host may not have.
+	_ "time/tzdata"
+)
+
+const (
+	minutesPerDay  = 24 * 60
+	minutesPerWeek = 7 * minutesPerDay
+)
+
+// Window is a stretch of each day, or each week, that a rota is on call.
+// Times are HH:MM in the rota's time zone, and a window that ends at or before
+// its start runs on into the next day or week.
+type Window struct {
+	// StartDay and EndDay are weekdays, such as "monday", or both empty for a
+	// window every day.
+	StartDay string `dynamodbav:"startDay,omitempty" json:"start_day,omitempty" yaml:"start_day,omitempty"`
+	Start    string `dynamodbav:"start" json:"start" yaml:"start"`
+	EndDay   string `dynamodbav:"endDay,omitempty" json:"end_day,omitempty" yaml:"end_day,omitempty"`
+	End      string `dynamodbav:"end" json:"end" yaml:"end"`
+}
+
+// Validate returns why the window doesn't make sense, if it doesn't.
+func (w Window) Validate() error {
+	if (w.StartDay == "") != (w.EndDay == "") {
+		return fmt.Errorf("window %s needs both start_day and end_day, or neither", w)
+	}
+
+	for _, v := range []string{w.StartDay, w.EndDay} {
+		if _, ok := weekday(v); v != "" && !ok {
+			return fmt.Errorf("window %s has an unknown day %q", w, v)
+		}
+	}
+
+	for _, v := range []string{w.Start, w.End} {
+		if _, ok := minuteOfDay(v); !ok {
+			return fmt.Errorf("window %s has a time %q that isn't HH:MM", w, v)
+		}
+	}
+
+	return nil
+}
+
+// contains reports whether the window is open at t, which is in the rota's
+// time zone.
+func (w Window) contains(t time.Time) bool {
+	start, _ := minuteOfDay(w.Start)
+	end, _ := minuteOfDay(w.End)
+	now := t.Hour()*60 + t.Minute()
+	period := minutesPerDay
+
+	if w.StartDay != "" {
+		startDay, _ := weekday(w.StartDay)
+		endDay, _ := weekday(w.EndDay)
+		start += int(startDay) * minutesPerDay
+		end += int(endDay) * minutesPerDay
+		now += int(t.Weekday()) * minutesPerDay
+		period = minutesPerWeek
+	}
+
+	return (now-start+period)%period < (end-start+period-1)%period+1
+}
+
+func (w Window) String() string {
+	if w.StartDay == "" {
+		return fmt.Sprintf("%s to %s every day", w.Start, w.End)
+	}
+
+	return fmt.Sprintf("%s %s to %s %s", capitalise(w.StartDay), w.Start, capitalise(w.EndDay), w.End)
+}
+
+// OnCallAt reports whether the rota is on call at t: always, unless it has
+// windows.
+func (rd *RotaDetails) OnCallAt(t time.Time) bool {
+	if len(rd.Windows) == 0 {
+		return true
+	}
+
+	t = t.In(rd.Location())
+	for _, v := range rd.Windows {
+		if v.contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Location is the time zone the rota's windows are in, which is UTC unless it
+// says otherwise.
+func (rd *RotaDetails) Location() *time.Location {
+	if location, err := time.LoadLocation(rd.TimeZone); err == nil {
+		return location
+	}
+
+	return time.UTC
+}
+
+// DescribeWindows says when the rota is on call, if not all the time.
+func (rd *RotaDetails) DescribeWindows() string {
+	var windows []string
+	for _, v := range rd.Windows {
+		windows = append(windows, v.String())
+	}
+
+	return fmt.Sprintf("%s (%s)", strings.Join(windows, ", "), rd.Location())
+}
+
+func weekday(day string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), day) {
+			return d, true
+		}
+	}
+
+	return 0, false
+}
+
+func minuteOfDay(hhmm string) (int, bool) {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return 0, false
+	}
+
+	return t.Hour()*60 + t.Minute(), true
+}
+
+func capitalise(day string) string {
+	if day == "" {
+		return day
+	}
+	return strings.ToUpper(day[:1]) + strings.ToLower(day[1:])
+}
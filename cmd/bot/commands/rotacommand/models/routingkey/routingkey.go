@@ -0,0 +1,16 @@
+package routingkey
+
+// Key is the partition that every workspace's routing keys are stored under,
+// so an alert can be matched to its workspace with a single read.
+const Key = "routingkey"
+
+// RoutingKey points an alert routing key at the rota it belongs to.
+type RoutingKey struct {
+	Pk string `dynamodbav:"pk"`
+	Sk string `dynamodbav:"sk"` // Routing key
+	// TeamId is the partition of the rota's workspace, which is empty for the
+	// workspace the bot served before it could be installed in others.
+	TeamId    string `dynamodbav:"teamId"`
+	ChannelId string `dynamodbav:"channelId"`
+	RotaName  string `dynamodbav:"rotaName"`
+}
@@ -0,0 +1,137 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	EventHandover        = "handover"
+	EventStart           = "start"
+	EventStop            = "stop"
+	EventOverride        = "override"
+	EventSwap            = "swap"
+	EventMembersChanged  = "members_changed"
+	EventChangeRequested = "change_requested"
+	EventChangeApproved  = "change_approved"
+	EventChangeRejected  = "change_rejected"
+
+	SignatureHeader = "X-Alfred-Signature"
+	TimestampHeader = "X-Alfred-Timestamp"
+	EventHeader     = "X-Alfred-Event"
+	DeliveryHeader  = "X-Alfred-Delivery"
+
+	OutcomeDelivered = "delivered"
+	OutcomeRetrying  = "retrying"
+	OutcomeFailed    = "failed"
+
+	deliveryKeyPrefix = "delivery#"
+)
+
+// Webhook is an endpoint subscribed to a rota's events.
+type Webhook struct {
+	URL    string `dynamodbav:"url" json:"url"`
+	Secret string `dynamodbav:"secret" json:"secret"`
+}
+
+// Event is the JSON body posted to each of a rota's webhooks when something
+// about it changes.
+type Event struct {
+	Id                   string   `json:"id"`
+	Type                 string   `json:"type"`
+	ChannelId            string   `json:"channel_id"`
+	RotaName             string   `json:"rota_name"`
+	OnCallMember         string   `json:"on_call_member,omitempty"`
+	PreviousOnCallMember string   `json:"previous_on_call_member,omitempty"`
+	Members              []string `json:"members,omitempty"`
+	Actor                string   `json:"actor,omitempty"`
+	Change               string   `json:"change,omitempty"`
+	RequestedBy          string   `json:"requested_by,omitempty"`
+	StartOfShift         string   `json:"start_of_shift,omitempty"`
+	EndOfShift           string   `json:"end_of_shift,omitempty"`
+	OccurredAt           string   `json:"occurred_at"`
+}
+
+// DeliveryRetention is how long deliveries are kept before DynamoDB deletes
+// them.
+const DeliveryRetention = 30 * 24 * time.Hour
+
+// Delivery records one attempt to post an event, so that subscribers can find
+// out why they didn't hear about something. Deliveries share the rotas table,
+// under their own partition key per rota, newest last.
+type Delivery struct {
+	Pk         string `dynamodbav:"pk"`
+	Sk         string `dynamodbav:"sk"`
+	EventId    string `dynamodbav:"eventId"`
+	EventType  string `dynamodbav:"eventType"`
+	URL        string `dynamodbav:"url"`
+	Attempt    int    `dynamodbav:"attempt"`
+	StatusCode int    `dynamodbav:"statusCode"`
+	Error      string `dynamodbav:"error"`
+	Outcome    string `dynamodbav:"outcome"`
+	At         string `dynamodbav:"at"`
+	// ExpiresAt is when DynamoDB deletes the delivery, in Unix seconds.
+	ExpiresAt int64 `dynamodbav:"expiresAt,omitempty"`
+}
+
+// RetryKey is the partition that every delivery waiting to be retried is
+// stored under.
+const RetryKey = "webhookretry"
+
+// Retry is a delivery that failed and will be tried again. It's stored until
+// the event is delivered or given up on, so a restart doesn't drop it.
+type Retry struct {
+	Pk string `dynamodbav:"pk"`
+	Sk string `dynamodbav:"sk"` // Retry ID
+	// Body is the event, as it's posted.
+	Body string `dynamodbav:"body"`
+	URL  string `dynamodbav:"url"`
+	// Attempt is the attempt that's made next.
+	Attempt       int    `dynamodbav:"attempt"`
+	NextAttemptAt string `dynamodbav:"nextAttemptAt"`
+}
+
+// RetryId is the same for every retry of an event to a URL.
+func RetryId(eventId string, url string) string {
+	return eventId + "#" + url
+}
+
+func DeliveryKey(channelId string, rotaName string) string {
+	return deliveryKeyPrefix + channelId + "/" + rotaName
+}
+
+// DeliverySortKey orders deliveries by when they happened.
+func DeliverySortKey(unixNano int64, eventId string, attempt int) string {
+	return fmt.Sprintf("%020d#%s#%d", unixNano, eventId, attempt)
+}
+
+// Sign returns the signature header value for a body sent at the given Unix
+// time: the hex HMAC-SHA256 of "<timestamp>.<body>", keyed with the webhook's
+// secret. Including the timestamp lets receivers reject replayed requests.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature made by Sign.
+func Verify(secret string, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(strings.TrimSpace(signature)))
+}
+
+// Without returns the webhooks that don't post to url.
+func Without(webhooks []Webhook, url string) []Webhook {
+	var remaining []Webhook
+	for _, v := range webhooks {
+		if v.URL != url {
+			remaining = append(remaining, v)
+		}
+	}
+
+	return remaining
+}
@@ -0,0 +1,53 @@
+package webhook
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"sort"
+	"testing"
+)
+
+func TestWebhook(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Webhook Suite")
+}
+
+var _ = Describe("Sign", func() {
+	body := []byte(`{"type":"handover"}`)
+
+	It("Signs the timestamp and body with the secret", func() {
+		// echo -n '1646643600.{"type":"handover"}' | openssl dgst -sha256 -hmac secret
+		Expect(Sign("secret", "1646643600", body)).To(Equal("sha256=867cee74cde7952813c94fe2dafbe06810dbc1f292b854d24e9459989412a393"))
+	})
+
+	It("Verifies its own signatures", func() {
+		signature := Sign("secret", "1646643600", body)
+		Expect(Verify("secret", "1646643600", body, signature)).To(BeTrue())
+		Expect(Verify("other", "1646643600", body, signature)).To(BeFalse())
+		Expect(Verify("secret", "1646643601", body, signature)).To(BeFalse())
+	})
+})
+
+var _ = Describe("DeliverySortKey", func() {
+	It("Sorts deliveries by time", func() {
+		keys := []string{
+			DeliverySortKey(1646643600000000000, "b", 1),
+			DeliverySortKey(999, "a", 2),
+			DeliverySortKey(1646643600000000001, "a", 1),
+		}
+		sort.Strings(keys)
+		Expect(keys).To(Equal([]string{
+			DeliverySortKey(999, "a", 2),
+			DeliverySortKey(1646643600000000000, "b", 1),
+			DeliverySortKey(1646643600000000001, "a", 1),
+		}))
+	})
+})
+
+var _ = Describe("Without", func() {
+	It("Removes the webhook with the URL", func() {
+		webhooks := []Webhook{{URL: "https://a"}, {URL: "https://b"}}
+		Expect(Without(webhooks, "https://a")).To(Equal([]Webhook{{URL: "https://b"}}))
+		Expect(Without(webhooks, "https://c")).To(Equal(webhooks))
+	})
+})
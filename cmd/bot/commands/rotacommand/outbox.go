@@ -0,0 +1,126 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/models/outbox"
+	"alfred-bot/utils/formatter"
+	"alfred-bot/utils/metrics"
+	"alfred-bot/utils/slackclient"
+	"encoding/json"
+	"github.com/slack-go/slack"
+	"log/slog"
+	"time"
+)
+
+const maxOutboxAttempt = 6
+
+// outboxBackoff is how long to wait before each retry of a message that
+// couldn't be sent. The Slack client only retries for a couple of seconds, so
+// these cover the rate limits and outages it won't wait out.
+var outboxBackoff = []time.Duration{30 * time.Second, 2 * time.Minute, 10 * time.Minute, 30 * time.Minute, time.Hour}
+
+var outboxMessagesTotal = metrics.NewCounter(
+	"alfred_outbox_messages_total",
+	"Attempts to send messages the bot posts of its own accord, by outcome.",
+	"outcome",
+)
+
+// enqueue posts a message that no one is waiting on, such as a handover
+// announcement, in the background. It's stored first, and kept until Slack
+// has it, so that it isn't lost to a rate limit, an outage or a restart.
+func (c *RotaCommand) enqueue(channelId string, attachment slack.Attachment) {
+	logger := slog.With("channel_id", channelId)
+
+	body, err := json.Marshal(attachment)
+	if err != nil {
+		logger.Error("Could not encode message", "error", err)
+		return
+	}
+
+	token, err := randomToken(8)
+	if err != nil {
+		logger.Error("Could not queue message", "error", err)
+		return
+	}
+
+	now := c.clock.Now()
+	m := &outbox.Message{
+		Pk:         outbox.Key,
+		Sk:         outbox.Id(now.UnixNano(), token),
+		ChannelId:  channelId,
+		Attachment: string(body),
+		QueuedAt:   formatter.FormatTime(now),
+	}
+
+	// Send it even if it can't be stored; it just won't survive a restart.
+	err = c.handler.SaveOutboxMessage(m)
+	if err != nil {
+		logger.Error("Could not store message", "error", err)
+	}
+
+	c.background(func() { c.send(m) })
+}
+
+// resumeOutbox schedules the messages that hadn't been sent when the bot last
+// stopped.
+func (c *RotaCommand) resumeOutbox() {
+	messages, err := c.handler.GetOutboxMessages()
+	if err != nil {
+		slog.Error("Could not load queued messages", "error", err)
+	}
+
+	for _, m := range messages {
+		at := c.clock.Now()
+		if nextAttemptAt, err := formatter.ParseTime(m.NextAttemptAt); err == nil && nextAttemptAt.After(at) {
+			at = nextAttemptAt
+		}
+
+		c.scheduleSend(m, at)
+	}
+}
+
+func (c *RotaCommand) scheduleSend(m *outbox.Message, at time.Time) {
+	c.scheduler.Schedule("outbox:"+m.Sk, at, func() {
+		// The scheduler runs jobs one at a time, so don't make it wait on
+		// Slack.
+		c.background(func() { c.send(m) })
+	})
+}
+
+// send makes one attempt to post a queued message and, if it failed for a
+// reason that might pass, schedules the next attempt.
+func (c *RotaCommand) send(m *outbox.Message) {
+	m.Attempt++
+	logger := slog.With("channel_id", m.ChannelId, "message_id", m.Sk, "attempt", m.Attempt)
+
+	var attachment slack.Attachment
+	err := json.Unmarshal([]byte(m.Attachment), &attachment)
+	if err == nil {
+		_, _, err = c.client.PostMessage(m.ChannelId, attachment)
+	}
+
+	if err != nil && slackclient.Temporary(err) && m.Attempt < maxOutboxAttempt {
+		outboxMessagesTotal.Inc("retrying")
+		logger.Warn("Could not send message, will retry", "error", err)
+
+		next := c.clock.Now().Add(outboxBackoff[m.Attempt-1])
+		m.NextAttemptAt = formatter.FormatTime(next)
+		m.Error = err.Error()
+		if err := c.handler.SaveOutboxMessage(m); err != nil {
+			logger.Error("Could not store message", "error", err)
+		}
+
+		c.scheduleSend(m, next)
+		return
+	}
+
+	if err != nil {
+		outboxMessagesTotal.Inc("failed")
+		logger.Error("Could not send message, giving up", "error", err)
+	} else {
+		outboxMessagesTotal.Inc("sent")
+	}
+
+	if err := c.handler.DeleteOutboxMessage(m.Sk); err != nil {
+		logger.Error("Could not remove sent message", "error", err)
+	}
+}
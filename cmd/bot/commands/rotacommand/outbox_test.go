@@ -0,0 +1,141 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/handler"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/outbox"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"alfred-bot/utils/clock"
+	"alfred-bot/utils/formatter"
+	"fmt"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/slack-go/slack"
+	"sync"
+	"time"
+)
+
+var _ = Describe("Outbox", func() {
+	var fakeClock *clock.Fake
+	var store *handler.MemoryHandler
+	var mockSlackClient *MockSlackClient
+	var stop func()
+
+	// slackDown makes posts fail with err, until it's set to nil.
+	var mu sync.Mutex
+	var slackDown error
+	var attempts int
+	var recorder *MockSlackClient
+
+	announcements := func() []string {
+		return recorder.Messages()
+	}
+
+	setSlackDown := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		slackDown = err
+	}
+
+	postAttempts := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return attempts
+	}
+
+	run := func() {
+		stop = runInBackground(New(store, mockSlackClient, fakeClock, testRotaConfig))
+	}
+
+	queued := func() []*outbox.Message {
+		messages, err := store.GetOutboxMessages()
+		Expect(err).To(BeNil())
+		return messages
+	}
+
+	// queuedAttempts is how many times the first queued message has been tried.
+	queuedAttempts := func() int {
+		messages := queued()
+		if len(messages) == 0 {
+			return 0
+		}
+		return messages[0].Attempt
+	}
+
+	BeforeEach(func() {
+		fakeClock = clock.NewFake(time.Date(2022, time.March, 7, 9, 0, 0, 0, time.UTC))
+		store = handler.NewMemoryHandler(fakeClock)
+		mockSlackClient = &MockSlackClient{}
+		slackDown = nil
+		attempts = 0
+
+		// Posts that get through are recorded by a second mock.
+		recorder = &MockSlackClient{}
+		mockSlackClient.PostMessageStub = func(channelID string, attachment slack.Attachment) (string, string, error) {
+			mu.Lock()
+			attempts++
+			err := slackDown
+			mu.Unlock()
+
+			if err != nil {
+				return "", "", err
+			}
+			return recorder.PostMessage(channelID, attachment)
+		}
+
+		err := store.SaveRotaDetails(testChannelId, testRotaName, []string{"Evan", "Sia"}, "1")
+		Expect(err).To(BeNil())
+		startOfShift := fakeClock.Now()
+		endOfShift := rotadetails.GenerateEndOfShift(startOfShift, 1)
+		err = store.UpdateOnCallMember(testChannelId, testRotaName, "Evan", formatter.FormatTime(startOfShift), formatter.FormatTime(endOfShift))
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		stop()
+	})
+
+	It("Retries handover announcements until Slack takes them", func() {
+		setSlackDown(slack.SlackErrorResponse{Err: "service_unavailable"})
+		run()
+
+		fakeClock.Advance(7 * 24 * time.Hour)
+		Eventually(queuedAttempts).Should(Equal(1))
+		Expect(queued()[0].Error).To(Equal("service_unavailable"))
+		Expect(announcements()).To(BeEmpty())
+
+		setSlackDown(nil)
+		fakeClock.Advance(outboxBackoff[0])
+		Eventually(announcements).Should(Equal([]string{fmt.Sprintf("[%s] <@Sia> now on duty!", testRotaName)}))
+		Eventually(queued).Should(BeEmpty())
+	})
+
+	It("Sends messages left over from before a restart", func() {
+		setSlackDown(&slack.RateLimitedError{RetryAfter: 5 * time.Minute})
+		run()
+
+		fakeClock.Advance(7 * 24 * time.Hour)
+		Eventually(queuedAttempts).Should(Equal(1))
+		stop()
+
+		// The next attempt is still due after the restart.
+		setSlackDown(nil)
+		run()
+		Consistently(announcements, 50*time.Millisecond).Should(BeEmpty())
+
+		fakeClock.Advance(outboxBackoff[0])
+		Eventually(announcements).Should(Equal([]string{fmt.Sprintf("[%s] <@Sia> now on duty!", testRotaName)}))
+		Eventually(queued).Should(BeEmpty())
+	})
+
+	It("Gives up on messages Slack will never take", func() {
+		setSlackDown(slack.SlackErrorResponse{Err: "channel_not_found"})
+		run()
+
+		fakeClock.Advance(7 * 24 * time.Hour)
+		Eventually(postAttempts).Should(Equal(1))
+		Eventually(queued).Should(BeEmpty())
+
+		fakeClock.Advance(outboxBackoff[0])
+		Consistently(postAttempts, 50*time.Millisecond).Should(Equal(1))
+	})
+})
@@ -0,0 +1,363 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/models/metadata"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/page"
+	"alfred-bot/utils/formatter"
+	"alfred-bot/utils/metrics"
+	"fmt"
+	"github.com/slack-go/slack"
+	"strconv"
+	"strings"
+)
+
+const (
+	PageOnCallPromptAction = "page_on_call_prompt"
+	AcknowledgePageAction  = "acknowledge_page"
+	PageOnCallCallback     = "page_on_call"
+	pageMessageAction      = "set_page_message"
+	pageMessageBlock       = "page_message"
+)
+
+var pagesTotal = metrics.NewCounter(
+	"alfred_pages_total",
+	"Pages sent to on-call members, by how they ended.",
+	"outcome",
+)
+
+// PageOnCall pages whoever is on duty for the rota, escalating to the next
+// member and then the rota's tiers, or its owners, if no one acknowledges in
+// time. Outside the rota's windows, the tiers are paged instead. It returns an attachment for the user who asked if the page couldn't be
+// sent.
+func (c *RotaCommand) PageOnCall(channelId string, userId string, rotaName string, message string) (*slack.Attachment, error) {
+	rotaDetails, err := c.handler.GetRotaDetails(channelId, rotaName)
+	if err != nil {
+		return nil, err
+	}
+
+	if rotaDetails == nil {
+		return errorAttachment(fmt.Sprintf("I couldn't find a rota called %s in this channel. Try `/rota list`.", rotaName)), nil
+	}
+
+	if rotaDetails.CurrOnCallMember == "" {
+		return errorAttachment(fmt.Sprintf("[%v] No one is on duty to page.", rotaName)), nil
+	}
+
+	now := c.clock.Now()
+	responders := page.Responders(rotaDetails.CurrOnCallMember, rotaDetails.NextOnCallMember(), rotaDetails.EscalationTiers())
+	if !rotaDetails.OnCallAt(now) {
+		// Outside the rota's windows, pages go straight to its tiers.
+		responders = page.Responders("", "", rotaDetails.EscalationTiers())
+		if len(responders) == 0 {
+			return errorAttachment(fmt.Sprintf("[%v] The rota is only on call %s, and has no tiers or owners to page outside those times.", rotaName, rotaDetails.DescribeWindows())), nil
+		}
+	}
+
+	p := &page.Page{
+		Pk:         page.Key(channelId),
+		Sk:         strconv.FormatInt(now.UnixNano(), 10),
+		RotaName:   rotaName,
+		Message:    message,
+		PagedBy:    userId,
+		Responders: responders,
+		Status:     page.StatusOpen,
+		EscalateAt: formatter.FormatTime(now.Add(c.config.PageAckTimeout)),
+	}
+	p.Record(formatter.FormatTime(now), fmt.Sprintf("%s paged %s", formatter.AtUserId(userId), formatter.AtUserId(p.CurrentResponder())))
+
+	_, p.MessageTs, err = c.client.PostMessage(channelId, pageAttachment(p))
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.handler.SavePage(p)
+	if err != nil {
+		return nil, err
+	}
+
+	c.scheduleEscalation(p)
+
+	return nil, c.notifyResponder(p)
+}
+
+// pageSubcommand pages the on-call member of the named rota, or of the
+// channel's only rota if no name was given.
+func (c *RotaCommand) pageSubcommand(channelId string, userId string, rotaName string, message string) (*slack.Attachment, error) {
+	if strings.TrimSpace(message) == "" {
+		return errorAttachment("What should I tell them? Try `/rota page <name> <message>`."), nil
+	}
+
+	if rotaName == "" {
+		rotaNames, err := c.handler.GetRotaNames(channelId)
+		if err != nil {
+			return nil, err
+		}
+
+		switch len(rotaNames) {
+		case 0:
+			return &slack.Attachment{Text: "Looks like this channel does not have any rotas. Run `/rota` to create one."}, nil
+		case 1:
+			rotaName = rotaNames[0]
+		default:
+			return errorAttachment(fmt.Sprintf("This channel has more than one rota, so I don't know who to page. Say which one, e.g. `/rota page %s <message>`.", rotaNames[0])), nil
+		}
+	}
+
+	return c.PageOnCall(channelId, userId, rotaName, message)
+}
+
+func (c *RotaCommand) PageOnCallPrompt(interaction *slack.InteractionCallback, action *slack.BlockAction) error {
+	channelId := interaction.Channel.ID
+	rotaName := action.Value
+
+	messageText := slack.NewTextBlockObject(slack.PlainTextType, "What's happening?", false, false)
+	messageElement := slack.NewPlainTextInputBlockElement(nil, pageMessageAction)
+	messageElement.Multiline = true
+	messageElement.MaxLength = 1000
+
+	var modalRequest slack.ModalViewRequest
+	modalRequest.Type = "modal"
+	modalRequest.Title = slack.NewTextBlockObject(slack.PlainTextType, "Page on-call", false, false)
+	modalRequest.Close = slack.NewTextBlockObject(slack.PlainTextType, "Close", false, false)
+	modalRequest.Submit = slack.NewTextBlockObject(slack.PlainTextType, "Page", false, false)
+	modalRequest.Blocks = slack.Blocks{
+		BlockSet: []slack.Block{
+			slack.NewInputBlock(pageMessageBlock, messageText, messageElement),
+		},
+	}
+	modalRequest.CallbackID = PageOnCallCallback
+
+	var err error
+	modalRequest.PrivateMetadata, err = metadata.GenerateCommandMetadata(channelId, rotaName, "", "")
+	if err != nil {
+		return err
+	}
+
+	_, err = c.client.OpenView(interaction.TriggerID, modalRequest)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *RotaCommand) PageOnCallSubmission(interaction *slack.InteractionCallback) error {
+	commandMetadata, err := metadata.UnpackCommandMetadata(interaction.View.PrivateMetadata)
+	if err != nil {
+		return err
+	}
+
+	userId := interaction.User.ID
+	message := interaction.View.State.Values[pageMessageBlock][pageMessageAction].Value
+
+	reply, err := c.PageOnCall(commandMetadata.ChannelId, userId, commandMetadata.RotaName, message)
+	if err != nil || reply == nil {
+		return err
+	}
+
+	return c.respondToClient(commandMetadata.ChannelId, userId, reply)
+}
+
+// AcknowledgePage stops a page escalating. Anyone who has been paged so far can
+// acknowledge it.
+func (c *RotaCommand) AcknowledgePage(interaction *slack.InteractionCallback, action *slack.BlockAction) error {
+	userId := interaction.User.ID
+
+	channelId, pageId, err := page.ParseReference(action.Value)
+	if err != nil {
+		return err
+	}
+
+	c.pagesMu.Lock()
+	defer c.pagesMu.Unlock()
+
+	p, err := c.handler.GetPage(channelId, pageId)
+	if err != nil {
+		return err
+	}
+
+	var reply string
+	switch {
+	case p == nil:
+		reply = "Sorry, I can't find that page any more."
+	case p.Status == page.StatusAcknowledged:
+		reply = fmt.Sprintf("[%v] %s has already acknowledged this page.", p.RotaName, formatter.AtUserId(p.AcknowledgedBy))
+	case !p.HasBeenPaged(userId):
+		reply = fmt.Sprintf("[%v] You haven't been paged for this yet.", p.RotaName)
+	}
+
+	if reply != "" {
+		return c.respondToClient(interaction.Channel.ID, userId, errorAttachment(reply))
+	}
+
+	p.Status = page.StatusAcknowledged
+	p.AcknowledgedBy = userId
+	p.EscalateAt = ""
+	p.Record(formatter.FormatTime(c.clock.Now()), fmt.Sprintf("%s acknowledged", formatter.AtUserId(userId)))
+
+	err = c.handler.SavePage(p)
+	if err != nil {
+		return err
+	}
+
+	pagesTotal.Inc(page.StatusAcknowledged)
+	c.scheduler.Cancel(pageJobId(p))
+
+	_, _, _, err = c.client.UpdateMessage(p.ChannelId(), p.MessageTs, pageAttachment(p))
+	if err != nil {
+		return err
+	}
+
+	attachment := slack.Attachment{}
+	attachment.Text = fmt.Sprintf("[%v] Thanks, you've acknowledged the page.", p.RotaName)
+	attachment.Color = "#4af030"
+
+	return c.respondToClient(interaction.Channel.ID, userId, &attachment)
+}
+
+// escalatePage pages the next responder once the current one has had long
+// enough to acknowledge.
+func (c *RotaCommand) escalatePage(channelId string, pageId string) {
+	logger := rotaLogger(channelId, "").With("page_id", pageId)
+
+	c.pagesMu.Lock()
+	defer c.pagesMu.Unlock()
+
+	p, err := c.handler.GetPage(channelId, pageId)
+	if err != nil {
+		logger.Error("Could not load page", "error", err)
+		return
+	}
+
+	if p == nil || !p.IsOpen() {
+		return
+	}
+
+	now := c.clock.Now()
+	escalateAt, err := formatter.ParseTime(p.EscalateAt)
+	if err == nil && escalateAt.After(now) {
+		c.scheduleEscalation(p)
+		return
+	}
+
+	p.Level++
+	if p.Level >= len(p.Responders) {
+		p.Status = page.StatusUnanswered
+		p.EscalateAt = ""
+		p.Record(formatter.FormatTime(now), "No one acknowledged")
+		pagesTotal.Inc(page.StatusUnanswered)
+	} else {
+		p.EscalateAt = formatter.FormatTime(now.Add(c.config.PageAckTimeout))
+		p.Record(formatter.FormatTime(now), fmt.Sprintf("Escalated to %s", formatter.AtUserId(p.CurrentResponder())))
+	}
+
+	err = c.handler.SavePage(p)
+	if err != nil {
+		logger.Error("Could not save page", "error", err)
+		return
+	}
+
+	logger.Info("Escalated page", "status", p.Status, "user_id", p.CurrentResponder())
+
+	_, _, _, err = c.client.UpdateMessage(channelId, p.MessageTs, pageAttachment(p))
+	if err != nil {
+		logger.Error("Could not update page message", "error", err)
+	}
+
+	if !p.IsOpen() {
+		return
+	}
+
+	c.scheduleEscalation(p)
+
+	// No one is waiting on an escalation, so it's queued to survive outages.
+	c.enqueue(p.CurrentResponder(), responderAttachment(p))
+}
+
+func (c *RotaCommand) scheduleEscalation(p *page.Page) {
+	escalateAt, err := formatter.ParseTime(p.EscalateAt)
+	if err != nil {
+		rotaLogger(p.ChannelId(), p.RotaName).Error("Could not schedule page escalation", "page_id", p.Id(), "error", err)
+		return
+	}
+
+	channelId := p.ChannelId()
+	pageId := p.Id()
+	c.scheduler.Schedule(pageJobId(p), escalateAt, func() {
+		c.escalatePage(channelId, pageId)
+	})
+}
+
+// notifyResponder sends the current responder a direct message they can
+// acknowledge the page from.
+func (c *RotaCommand) notifyResponder(p *page.Page) error {
+	// Posting to a user ID sends a direct message from the bot.
+	_, _, err := c.client.PostMessage(p.CurrentResponder(), responderAttachment(p))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func responderAttachment(p *page.Page) slack.Attachment {
+	text := fmt.Sprintf("*[%v] %s paged you:* %s", p.RotaName, formatter.AtUserId(p.PagedBy), p.Message)
+	if p.Level > 0 {
+		text = fmt.Sprintf("*[%v] Escalated to you, as no one else acknowledged in time. %s paged:* %s", p.RotaName, formatter.AtUserId(p.PagedBy), p.Message)
+	}
+
+	attachment := slack.Attachment{}
+	attachment.Fallback = text
+	attachment.Color = "#f0303a"
+	attachment.Blocks = slack.Blocks{
+		BlockSet: []slack.Block{
+			markdownSection(text),
+			slack.NewActionBlock(
+				"",
+				&slack.ButtonBlockElement{
+					Type:     "button",
+					ActionID: AcknowledgePageAction,
+					Text:     &slack.TextBlockObject{Text: "Acknowledge", Type: slack.PlainTextType},
+					Style:    slack.StylePrimary,
+					Value:    p.Reference(),
+				},
+			),
+		},
+	}
+
+	return attachment
+}
+
+// pageAttachment renders the channel message for a page, including its
+// escalation timeline.
+func pageAttachment(p *page.Page) slack.Attachment {
+	var status string
+	attachment := slack.Attachment{}
+	switch p.Status {
+	case page.StatusAcknowledged:
+		status = fmt.Sprintf("Acknowledged by %s", formatter.AtUserId(p.AcknowledgedBy))
+		attachment.Color = "#4af030"
+	case page.StatusUnanswered:
+		status = "No one acknowledged"
+		attachment.Color = "#f0303a"
+	default:
+		status = fmt.Sprintf("Waiting for %s to acknowledge", formatter.AtUserId(p.CurrentResponder()))
+		attachment.Color = "#f0303a"
+	}
+
+	lines := []string{
+		fmt.Sprintf("[%v] %s paged %s: %s", p.RotaName, formatter.AtUserId(p.PagedBy), formatter.AtUserId(p.Responders[0]), p.Message),
+		fmt.Sprintf("Status: %s", status),
+	}
+	for _, event := range p.Timeline {
+		lines = append(lines, fmt.Sprintf("• %s: %s", event.At, event.Text))
+	}
+
+	attachment.Text = strings.Join(lines, "\n")
+
+	return attachment
+}
+
+func pageJobId(p *page.Page) string {
+	return fmt.Sprintf("page:%s:%s", p.ChannelId(), p.Id())
+}
@@ -0,0 +1,179 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/handler"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/page"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"alfred-bot/utils/clock"
+	"alfred-bot/utils/formatter"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/slack-go/slack"
+	"time"
+)
+
+var _ = Describe("Pages", func() {
+	var fakeClock *clock.Fake
+	var store *handler.MemoryHandler
+	var mockSlackClient *MockSlackClient
+	var rotaCommand *RotaCommand
+	var stop func()
+
+	startOfShift := time.Date(2022, time.March, 7, 9, 0, 0, 0, time.UTC)
+	endOfShift := time.Date(2022, time.March, 14, 9, 0, 0, 0, time.UTC)
+
+	run := func() {
+		rotaCommand = New(store, mockSlackClient, fakeClock, testRotaConfig)
+		stop = runInBackground(rotaCommand)
+	}
+
+	openPage := func() *page.Page {
+		pages, err := store.GetOpenPages()
+		Expect(err).To(BeNil())
+		Expect(pages).To(HaveLen(1))
+		return pages[0]
+	}
+
+	pageStatus := func(p *page.Page) func() string {
+		return func() string {
+			p, err := store.GetPage(p.ChannelId(), p.Id())
+			Expect(err).To(BeNil())
+			return p.Status
+		}
+	}
+
+	dmsTo := func(userId string) func() int {
+		return func() int {
+			return len(mockSlackClient.PostsTo(userId))
+		}
+	}
+
+	acknowledge := func(userId string, p *page.Page) {
+		interaction := &slack.InteractionCallback{}
+		interaction.User.ID = userId
+		Expect(rotaCommand.AcknowledgePage(interaction, &slack.BlockAction{Value: p.Reference()})).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		fakeClock = clock.NewFake(startOfShift)
+		store = handler.NewMemoryHandler(fakeClock)
+		mockSlackClient = &MockSlackClient{}
+
+		Expect(store.SaveRotaDetails(testChannelId, testRotaName, []string{"Evan", "Sia", "Wai"}, "1")).To(Succeed())
+		Expect(store.SaveRotaOwners(testChannelId, testRotaName, []string{"Suan"})).To(Succeed())
+		Expect(store.UpdateOnCallMember(testChannelId, testRotaName, "Evan", formatter.FormatTime(startOfShift), formatter.FormatTime(endOfShift))).To(Succeed())
+		run()
+	})
+
+	AfterEach(func() {
+		stop()
+	})
+
+	It("Messages the channel and the on-call member", func() {
+		reply, err := rotaCommand.PageOnCall(testChannelId, "Wai", testRotaName, "Checkout is down")
+		Expect(err).To(BeNil())
+		Expect(reply).To(BeNil())
+
+		p := openPage()
+		Expect(p.Responders).To(Equal([]string{"Evan", "Sia", "Suan"}))
+		Expect(mockSlackClient.PostsTo(testChannelId)[0].Text).To(HavePrefix("[dummy_rota] <@Wai> paged <@Evan>: Checkout is down\nStatus: Waiting for <@Evan> to acknowledge"))
+
+		dm := mockSlackClient.PostsTo("Evan")
+		Expect(dm).To(HaveLen(1))
+		buttons := dm[0].Blocks.BlockSet[1].(*slack.ActionBlock).Elements.ElementSet
+		Expect(buttons[0].(*slack.ButtonBlockElement).Value).To(Equal(p.Reference()))
+	})
+
+	It("Escalates to the rota's tiers rather than its owners", func() {
+		Expect(store.SaveRotaTiers(testChannelId, testRotaName, []string{"Wai", "Jon"})).To(Succeed())
+
+		_, err := rotaCommand.PageOnCall(testChannelId, "Suan", testRotaName, "Checkout is down")
+		Expect(err).To(BeNil())
+		Expect(openPage().Responders).To(Equal([]string{"Evan", "Sia", "Wai", "Jon"}))
+	})
+
+	It("Pages the tiers outside the rota's windows", func() {
+		Expect(store.SaveWindows(testChannelId, testRotaName, "", []rotadetails.Window{{Start: "18:00", End: "09:00"}})).To(Succeed())
+
+		_, err := rotaCommand.PageOnCall(testChannelId, "Wai", testRotaName, "Checkout is down")
+		Expect(err).To(BeNil())
+		Expect(openPage().Responders).To(Equal([]string{"Suan"}))
+		Expect(dmsTo("Evan")()).To(Equal(0))
+	})
+
+	It("Refuses to page outside the rota's windows if there's no one else to page", func() {
+		Expect(store.SaveRotaOwners(testChannelId, testRotaName, nil)).To(Succeed())
+		Expect(store.SaveWindows(testChannelId, testRotaName, "", []rotadetails.Window{{Start: "18:00", End: "09:00"}})).To(Succeed())
+
+		reply, err := rotaCommand.PageOnCall(testChannelId, "Wai", testRotaName, "Checkout is down")
+		Expect(err).To(BeNil())
+		Expect(reply.Text).To(Equal("[dummy_rota] The rota is only on call 18:00 to 09:00 every day (UTC), and has no tiers or owners to page outside those times."))
+		Expect(mockSlackClient.PostsTo(testChannelId)).To(BeEmpty())
+	})
+
+	It("Refuses to page a rota that hasn't started", func() {
+		Expect(store.SaveRotaDetails(testChannelId, "idle", []string{"Evan"}, "1")).To(Succeed())
+
+		reply, err := rotaCommand.PageOnCall(testChannelId, "Wai", "idle", "Checkout is down")
+		Expect(err).To(BeNil())
+		Expect(reply.Text).To(Equal("[idle] No one is on duty to page."))
+		Expect(mockSlackClient.PostsTo(testChannelId)).To(BeEmpty())
+	})
+
+	It("Escalates to the next member and then the owners", func() {
+		_, err := rotaCommand.PageOnCall(testChannelId, "Wai", testRotaName, "Checkout is down")
+		Expect(err).To(BeNil())
+		p := openPage()
+
+		fakeClock.Advance(testRotaConfig.PageAckTimeout - time.Second)
+		Consistently(dmsTo("Sia"), 50*time.Millisecond).Should(Equal(0))
+
+		fakeClock.Advance(time.Second)
+		Eventually(dmsTo("Sia")).Should(Equal(1))
+
+		fakeClock.Advance(testRotaConfig.PageAckTimeout)
+		Eventually(dmsTo("Suan")).Should(Equal(1))
+
+		fakeClock.Advance(testRotaConfig.PageAckTimeout)
+		Eventually(pageStatus(p)).Should(Equal(page.StatusUnanswered))
+		Expect(mockSlackClient.UpdateTo(p.MessageTs)).To(ContainSubstring("Status: No one acknowledged"))
+	})
+
+	It("Stops escalating once acknowledged", func() {
+		_, err := rotaCommand.PageOnCall(testChannelId, "Wai", testRotaName, "Checkout is down")
+		Expect(err).To(BeNil())
+		p := openPage()
+
+		acknowledge("Evan", p)
+		Expect(pageStatus(p)()).To(Equal(page.StatusAcknowledged))
+		Expect(mockSlackClient.UpdateTo(p.MessageTs)).To(ContainSubstring("Status: Acknowledged by <@Evan>"))
+
+		fakeClock.Advance(testRotaConfig.PageAckTimeout)
+		Consistently(dmsTo("Sia"), 50*time.Millisecond).Should(Equal(0))
+	})
+
+	It("Only lets someone who has been paged acknowledge", func() {
+		_, err := rotaCommand.PageOnCall(testChannelId, "Wai", testRotaName, "Checkout is down")
+		Expect(err).To(BeNil())
+		p := openPage()
+
+		acknowledge("Sia", p)
+		Expect(pageStatus(p)()).To(Equal(page.StatusOpen))
+	})
+
+	It("Picks up escalations again after a restart", func() {
+		_, err := rotaCommand.PageOnCall(testChannelId, "Wai", testRotaName, "Checkout is down")
+		Expect(err).To(BeNil())
+		stop()
+
+		fakeClock.Advance(testRotaConfig.PageAckTimeout)
+		run()
+
+		Eventually(dmsTo("Sia")).Should(Equal(1))
+	})
+
+	It("Pages from /rota page", func() {
+		Expect(rotaCommand.Prompt(slack.SlashCommand{ChannelID: testChannelId, UserID: "Wai", Text: "page dummy_rota Checkout is down"})).To(BeNil())
+		Expect(openPage().Message).To(Equal("Checkout is down"))
+	})
+})
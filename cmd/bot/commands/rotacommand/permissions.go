@@ -0,0 +1,228 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/models/admin"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"alfred-bot/cmd/bot/commands/rotacommand/subcommand"
+	"alfred-bot/config"
+	"alfred-bot/utils/formatter"
+	"fmt"
+	"github.com/slack-go/slack"
+	"strings"
+)
+
+// isAdmin reports whether the user may do anything to any rota: either they
+// were made an admin with /rota add-admin, or Slack counts them as a workspace
+// admin or owner.
+func (c *RotaCommand) isAdmin(userId string) (bool, error) {
+	stored, err := c.handler.GetAdmin(userId)
+	if err != nil {
+		return false, err
+	}
+
+	if stored != nil {
+		return true, nil
+	}
+
+	return c.isWorkspaceAdmin(userId)
+}
+
+// hasRole reports whether the user has the role on the rota. Rotas made before
+// they had owners are owned by their members.
+func (c *RotaCommand) hasRole(userId string, role config.Role, rotaDetails *rotadetails.RotaDetails) (bool, error) {
+	switch role {
+	case config.RoleAnyone:
+		return true, nil
+	case config.RoleMembers:
+		if rotaDetails.HasMember(userId) || rotaDetails.HasOwner(userId) {
+			return true, nil
+		}
+	case config.RoleOwners:
+		if rotaDetails.HasOwner(userId) || len(rotaDetails.Owners) == 0 && rotaDetails.HasMember(userId) {
+			return true, nil
+		}
+	}
+
+	return c.isAdmin(userId)
+}
+
+// checkRole returns why the user can't do something to the rota that needs the
+// role, or nil if they can.
+func (c *RotaCommand) checkRole(userId string, role config.Role, rotaDetails *rotadetails.RotaDetails, action string) (*slack.Attachment, error) {
+	allowed, err := c.hasRole(userId, role, rotaDetails)
+	if err != nil {
+		return nil, err
+	}
+
+	if allowed {
+		return nil, nil
+	}
+
+	var who string
+	switch role {
+	case config.RoleMembers:
+		who = "members of the rota"
+	case config.RoleOwners:
+		who = "owners of the rota"
+	default:
+		who = "admins"
+	}
+
+	return errorAttachment(fmt.Sprintf("[%v] Only %s can %s.", rotaDetails.RotaName(), who, action)), nil
+}
+
+// allowed checks the user has the role on the rota for an interaction, and
+// tells them why not if they don't.
+func (c *RotaCommand) allowed(userId string, role config.Role, rotaDetails *rotadetails.RotaDetails, action string) (bool, error) {
+	denied, err := c.checkRole(userId, role, rotaDetails, action)
+	if err != nil {
+		return false, err
+	}
+
+	if denied != nil {
+		return false, c.respondToClient(rotaDetails.Pk, userId, denied)
+	}
+
+	return true, nil
+}
+
+// subcommandRole is the role a /rota subcommand needs, if it changes the rota.
+func (c *RotaCommand) subcommandRole(name string) (config.Role, string, bool) {
+	switch name {
+	case subcommand.Start, subcommand.Stop:
+		return c.config.StartRole, "start or stop its shifts", true
+	case subcommand.Alerts, subcommand.Subscribe, subcommand.Unsubscribe:
+		return c.config.EditRole, "manage its alerts and webhooks", true
+	case subcommand.AddOwner, subcommand.RemoveOwner:
+		return config.RoleOwners, "change its owners", true
+	case subcommand.Approvals:
+		return config.RoleOwners, "change whether it needs approvals", true
+	}
+
+	return "", "", false
+}
+
+func (c *RotaCommand) addOwner(rotaDetails *rotadetails.RotaDetails, owner string) (*slack.Attachment, error) {
+	rotaName := rotaDetails.RotaName()
+
+	if rotaDetails.HasOwner(owner) {
+		return errorAttachment(fmt.Sprintf("[%v] %s is already an owner.", rotaName, formatter.AtUserId(owner))), nil
+	}
+
+	err := c.handler.SaveRotaOwners(rotaDetails.Pk, rotaName, append(append([]string{}, rotaDetails.Owners...), owner))
+	if err != nil {
+		return nil, err
+	}
+
+	attachment := slack.Attachment{}
+	attachment.Text = fmt.Sprintf("[%v] %s is now an owner.", rotaName, formatter.AtUserId(owner))
+	attachment.Color = "#4af030"
+
+	return &attachment, nil
+}
+
+func (c *RotaCommand) removeOwner(rotaDetails *rotadetails.RotaDetails, owner string) (*slack.Attachment, error) {
+	rotaName := rotaDetails.RotaName()
+
+	if !rotaDetails.HasOwner(owner) {
+		return errorAttachment(fmt.Sprintf("[%v] %s isn't an owner.", rotaName, formatter.AtUserId(owner))), nil
+	}
+
+	if len(rotaDetails.Owners) == 1 {
+		return errorAttachment(fmt.Sprintf("[%v] A rota needs at least one owner. Add another before removing %s.", rotaName, formatter.AtUserId(owner))), nil
+	}
+
+	owners := make([]string, 0, len(rotaDetails.Owners)-1)
+	for _, v := range rotaDetails.Owners {
+		if v != owner {
+			owners = append(owners, v)
+		}
+	}
+
+	err := c.handler.SaveRotaOwners(rotaDetails.Pk, rotaName, owners)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment := slack.Attachment{}
+	attachment.Text = fmt.Sprintf("[%v] %s is no longer an owner.", rotaName, formatter.AtUserId(owner))
+	attachment.Color = "#4af030"
+
+	return &attachment, nil
+}
+
+func (c *RotaCommand) listAdmins() (*slack.Attachment, error) {
+	admins, err := c.handler.GetAdmins()
+	if err != nil {
+		return nil, err
+	}
+
+	text := "Slack's workspace admins and owners are admins."
+	if len(admins) > 0 {
+		lines := make([]string, 0, len(admins))
+		for _, v := range admins {
+			lines = append(lines, fmt.Sprintf("• %s, added by %s on %v", formatter.AtUserId(v.Sk), formatter.AtUserId(v.AddedBy), v.AddedAt))
+		}
+		text = fmt.Sprintf("Admins:\n%s\n%s", strings.Join(lines, "\n"), text)
+	}
+
+	return &slack.Attachment{Text: text}, nil
+}
+
+func (c *RotaCommand) addAdmin(userId string, newAdmin string) (*slack.Attachment, error) {
+	isAdmin, err := c.isAdmin(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isAdmin {
+		return errorAttachment("Only admins can add admins."), nil
+	}
+
+	err = c.handler.SaveAdmin(&admin.Admin{
+		Pk:      admin.Key,
+		Sk:      newAdmin,
+		AddedBy: userId,
+		AddedAt: formatter.FormatTime(c.clock.Now()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	attachment := slack.Attachment{}
+	attachment.Text = fmt.Sprintf("%s is now an admin, and can manage every rota.", formatter.AtUserId(newAdmin))
+	attachment.Color = "#4af030"
+
+	return &attachment, nil
+}
+
+func (c *RotaCommand) removeAdmin(userId string, formerAdmin string) (*slack.Attachment, error) {
+	isAdmin, err := c.isAdmin(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isAdmin {
+		return errorAttachment("Only admins can remove admins."), nil
+	}
+
+	stored, err := c.handler.GetAdmin(formerAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	if stored == nil {
+		return errorAttachment(fmt.Sprintf("%s wasn't added as an admin. Slack's workspace admins can only be changed in Slack.", formatter.AtUserId(formerAdmin))), nil
+	}
+
+	err = c.handler.DeleteAdmin(formerAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment := slack.Attachment{}
+	attachment.Text = fmt.Sprintf("%s is no longer an admin.", formatter.AtUserId(formerAdmin))
+	attachment.Color = "#4af030"
+
+	return &attachment, nil
+}
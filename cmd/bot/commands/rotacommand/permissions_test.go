@@ -0,0 +1,125 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/handler"
+	"alfred-bot/config"
+	"alfred-bot/utils/clock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/slack-go/slack"
+	"time"
+)
+
+var _ = Describe("Permissions", func() {
+	var fakeClock *clock.Fake
+	var store *handler.MemoryHandler
+	var mockSlackClient *MockSlackClient
+	var rotaConfig config.RotaConfig
+	var rotaCommand *RotaCommand
+
+	promptAs := func(userId string, text string) *slack.Attachment {
+		rotaCommand = New(store, mockSlackClient, fakeClock, rotaConfig)
+		payload, err := rotaCommand.Prompt(slack.SlashCommand{ChannelID: testChannelId, UserID: userId, Text: text})
+		Expect(err).To(BeNil())
+		if payload == nil {
+			return nil
+		}
+		return payload.(*slack.Attachment)
+	}
+
+	buttons := func(attachment *slack.Attachment) []string {
+		var actionIds []string
+		for _, block := range attachment.Blocks.BlockSet {
+			if actions, ok := block.(*slack.ActionBlock); ok {
+				for _, v := range actions.Elements.ElementSet {
+					actionIds = append(actionIds, v.(*slack.ButtonBlockElement).ActionID)
+				}
+			}
+		}
+		return actionIds
+	}
+
+	BeforeEach(func() {
+		fakeClock = clock.NewFake(time.Date(2022, time.March, 7, 9, 0, 0, 0, time.UTC))
+		store = handler.NewMemoryHandler(fakeClock)
+		mockSlackClient = &MockSlackClient{Admins: []string{"Boss"}}
+		rotaConfig = testRotaConfig
+
+		Expect(store.SaveRotaDetails(testChannelId, testRotaName, []string{"Evan", "Sia"}, "1")).To(Succeed())
+		Expect(store.SaveRotaOwners(testChannelId, testRotaName, []string{"Suan"})).To(Succeed())
+	})
+
+	It("Shows each user only the buttons they may use", func() {
+		Expect(buttons(promptAs("Suan", "show dummy_rota"))).To(Equal([]string{UpdateRotaPromptAction, StartRotaAction}))
+		Expect(buttons(promptAs("Evan", "show dummy_rota"))).To(Equal([]string{StartRotaAction}))
+		Expect(buttons(promptAs("Wai", "show dummy_rota"))).To(BeEmpty())
+		Expect(buttons(promptAs("Boss", "show dummy_rota"))).To(Equal([]string{UpdateRotaPromptAction, StartRotaAction}))
+
+		Expect(promptAs("Evan", "start dummy_rota <@Sia>")).To(BeNil())
+		Expect(buttons(promptAs("Wai", "show dummy_rota"))).To(Equal([]string{PageOnCallPromptAction}))
+	})
+
+	It("Only lets owners update a rota", func() {
+		mockSlackClient.Inbox = []string{}
+		rotaCommand = New(store, mockSlackClient, fakeClock, rotaConfig)
+		interaction := &slack.InteractionCallback{User: slack.User{ID: "Evan"}, Channel: slack.Channel{}}
+		interaction.Channel.ID = testChannelId
+
+		Expect(rotaCommand.UpdateRotaPrompt(interaction, &slack.BlockAction{Value: testRotaName})).To(Succeed())
+		Expect(mockSlackClient.Inbox).To(BeEmpty())
+	})
+
+	It("Refuses to start or stop a shift for someone outside the rota", func() {
+		attachment := promptAs("Wai", "start dummy_rota <@Sia>")
+		Expect(attachment.Text).To(Equal("[dummy_rota] Only members of the rota can start or stop its shifts."))
+		Expect(mockSlackClient.Messages()).To(BeEmpty())
+
+		rotaConfig.StartRole = config.RoleAnyone
+		Expect(promptAs("Wai", "start dummy_rota <@Sia>")).To(BeNil())
+
+		rotaConfig.StartRole = config.RoleOwners
+		Expect(promptAs("Evan", "stop dummy_rota").Text).To(Equal("[dummy_rota] Only owners of the rota can start or stop its shifts."))
+		Expect(promptAs("Suan", "stop dummy_rota")).To(BeNil())
+	})
+
+	It("Lets members manage rotas that predate owners", func() {
+		Expect(store.SaveRotaDetails(testChannelId, "legacy", []string{"Evan"}, "1")).To(Succeed())
+		Expect(buttons(promptAs("Evan", "show legacy"))).To(Equal([]string{UpdateRotaPromptAction, StartRotaAction}))
+		Expect(promptAs("Evan", "subscribe legacy https://203.0.113.10/hook").Color).To(Equal("#4af030"))
+	})
+
+	It("Lets owners share the rota, but keeps at least one owner", func() {
+		Expect(promptAs("Evan", "add-owner dummy_rota <@Evan>").Text).To(Equal("[dummy_rota] Only owners of the rota can change its owners."))
+
+		Expect(promptAs("Suan", "add-owner dummy_rota <@Evan>").Text).To(Equal("[dummy_rota] <@Evan> is now an owner."))
+		Expect(promptAs("Evan", "remove-owner dummy_rota <@Suan>").Text).To(Equal("[dummy_rota] <@Suan> is no longer an owner."))
+		Expect(promptAs("Evan", "remove-owner dummy_rota <@Evan>").Text).To(ContainSubstring("A rota needs at least one owner."))
+
+		rotaDetails, _ := store.GetRotaDetails(testChannelId, testRotaName)
+		Expect(rotaDetails.Owners).To(Equal([]string{"Evan"}))
+	})
+
+	It("Lets admins manage every rota, and choose other admins", func() {
+		Expect(promptAs("Wai", "add-admin <@Wai>").Text).To(Equal("Only admins can add admins."))
+		Expect(promptAs("Wai", "alerts dummy_rota").Text).To(Equal("[dummy_rota] Only owners of the rota can manage its alerts and webhooks."))
+
+		Expect(promptAs("Boss", "add-admin <@Wai>").Color).To(Equal("#4af030"))
+		Expect(promptAs("Wai", "admins").Text).To(Equal("Admins:\n• <@Wai>, added by <@Boss> on Mon, 07 Mar 2022 09:00:00 +0000\nSlack's workspace admins and owners are admins."))
+		Expect(promptAs("Wai", "add-owner dummy_rota <@Sia>").Color).To(Equal("#4af030"))
+
+		Expect(promptAs("Wai", "remove-admin <@Boss>").Text).To(ContainSubstring("can only be changed in Slack"))
+		Expect(promptAs("Wai", "remove-admin <@Wai>").Text).To(Equal("<@Wai> is no longer an admin."))
+		Expect(promptAs("Wai", "add-owner dummy_rota <@Wai>").Color).To(Equal("#f0303a"))
+	})
+
+	It("Hides the Home tab's shift buttons from members who may not use them", func() {
+		Expect(promptAs("Evan", "start dummy_rota <@Sia>")).To(BeNil())
+
+		rotaConfig.SwapRole = config.RoleOwners
+		rotaCommand = New(store, mockSlackClient, fakeClock, rotaConfig)
+		Expect(rotaCommand.PublishHome("Evan")).To(Succeed())
+		for _, block := range mockSlackClient.HomeViews["Evan"].Blocks.BlockSet {
+			Expect(block).ToNot(BeAssignableToTypeOf(&slack.ActionBlock{}))
+		}
+	})
+})
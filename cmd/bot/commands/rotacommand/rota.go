@@ -2,14 +2,26 @@ package rotacommand
 
 import (
 	"alfred-bot/cmd/bot/commands/rotacommand/handler"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/change"
 	"alfred-bot/cmd/bot/commands/rotacommand/models/metadata"
 	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/webhook"
+	"alfred-bot/cmd/bot/commands/rotacommand/subcommand"
+	"alfred-bot/config"
+	"alfred-bot/utils/clock"
 	"alfred-bot/utils/formatter"
+	"alfred-bot/utils/metrics"
+	"alfred-bot/utils/scheduler"
 	"alfred-bot/utils/slackclient"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/slack-go/slack"
-	"log"
-	"strconv"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -32,93 +44,229 @@ const (
 	rotaMembersBlock       = "rota_members"
 	rotaDurationBlock      = "rota_duration"
 	rotaOnCallMemberBlock  = "on_call_member"
+	reconciliationJobId    = "reconcile_shifts"
+	reconciliationInterval = 10 * time.Minute
+)
+
+var (
+	handoversTotal = metrics.NewCounter(
+		"alfred_handovers_total",
+		"Scheduled shift handovers, by outcome.",
+		"outcome",
+	)
+	schedulerLag = metrics.NewHistogram(
+		"alfred_scheduler_lag_seconds",
+		"How long after the end of a shift its handover ran.",
+		[]float64{.01, .1, 1, 10, 60, 300, 900, 3600},
+	)
 )
 
 type RotaCommand struct {
-	handler handler.CommandHandler
-	client  slackclient.SlackClient
+	handler    handler.CommandHandler
+	client     slackclient.SlackClient
+	clock      clock.Clock
+	scheduler  *scheduler.Scheduler
+	config     config.RotaConfig
+	httpClient *http.Client
+	// webhookAddressAllowed says whether webhooks may be posted to an
+	// address. Only public addresses are, so that rota owners can't reach
+	// the bot's own network through it.
+	webhookAddressAllowed func(ip net.IP) bool
+	// pagesMu stops an acknowledgement and an escalation of the same page
+	// from overwriting each other.
+	pagesMu  sync.Mutex
+	alertsMu sync.Mutex
+	// changesMu stops two owners deciding on the same change at once.
+	changesMu sync.Mutex
+	// tasks tracks the messages and webhooks being sent in the background, so
+	// that HandleEndOfOnCallShifts doesn't return while any are in flight.
+	tasks sync.WaitGroup
 }
 
-func New(handler handler.CommandHandler, client slackclient.SlackClient) *RotaCommand {
-	return &RotaCommand{
-		handler: handler,
-		client:  client,
+func New(handler handler.CommandHandler, client slackclient.SlackClient, clock clock.Clock, config config.RotaConfig) *RotaCommand {
+	c := &RotaCommand{
+		handler:               handler,
+		client:                client,
+		clock:                 clock,
+		scheduler:             scheduler.New(clock),
+		config:                config,
+		webhookAddressAllowed: publicAddress,
 	}
+	c.httpClient = c.newWebhookClient()
+
+	return c
 }
 
-func (c *RotaCommand) HandleEndOfOnCallShifts() {
+// HandleEndOfOnCallShifts hands each running rota over to its next on-call
+// member as soon as the current shift ends. The schedule is rebuilt from the
+// store on startup and kept up to date as rotas are started and stopped, with a
+// periodic sweep for any shift that slipped through. Open pages pick up their
+// escalations again too, and messages that hadn't been sent are retried. It
+// blocks until ctx is cancelled.
+func (c *RotaCommand) HandleEndOfOnCallShifts(ctx context.Context) {
+	rotas, err := c.handler.GetOnCallShifts()
+	if err != nil {
+		slog.Error("Could not load running rotas", "error", err)
+	}
+
+	for _, v := range rotas {
+		c.scheduleEndOfShift(v.Pk, v.Sk, v.EndOfShift)
+	}
+
+	pages, err := c.handler.GetOpenPages()
+	if err != nil {
+		slog.Error("Could not load open pages", "error", err)
+	}
+
+	for _, p := range pages {
+		c.scheduleEscalation(p)
+	}
+
+	c.resumeOutbox()
+	c.resumeWebhookRetries()
+
+	c.scheduleReconciliation()
+	c.scheduler.Run(ctx)
+	c.tasks.Wait()
+}
+
+// background runs f in its own goroutine, tracked so that shutdown waits for
+// it.
+func (c *RotaCommand) background(f func()) {
+	c.tasks.Add(1)
 	go func() {
-		for {
-			rotas, err := c.handler.GetEndingOnCallShifts()
-			if err != nil {
-				log.Println(err)
-			}
+		defer c.tasks.Done()
+		f()
+	}()
+}
 
-			for _, v := range rotas {
-				log.Println(v)
-
-				var nextOnCallMember string
-				for i, m := range v.Members {
-					if m == v.CurrOnCallMember {
-						nextOnCallMember = v.Members[(i+1)%len(v.Members)]
-					}
-				}
-				startOfShift := time.Now()
-				endOfShift := rotadetails.GenerateEndOfShift(startOfShift, v.Duration)
-				err := c.handler.UpdateOnCallMember(v.Pk, v.Sk, nextOnCallMember, formatter.FormatTime(startOfShift), endOfShift)
-				if err != nil {
-					log.Println(fmt.Sprintf("Could not update rota shift for %v (%v): %v", v.Sk, v.Pk, err))
-				} else {
-					attachment := slack.Attachment{}
-					attachment.Text = fmt.Sprintf("[%v] %s now on duty!", v.Sk, formatter.AtUserId(nextOnCallMember))
-					attachment.Color = "#4af030"
-					_, _, err = c.client.PostMessage(v.Pk, attachment)
-					if err != nil {
-						log.Println(err)
-					}
-				}
-			}
+func (c *RotaCommand) scheduleReconciliation() {
+	c.scheduler.Schedule(reconciliationJobId, c.clock.Now().Add(reconciliationInterval), func() {
+		rotas, err := c.handler.GetEndingOnCallShifts()
+		if err != nil {
+			slog.Error("Could not load overdue shifts", "error", err)
+		}
 
-			time.Sleep(time.Minute)
+		for _, v := range rotas {
+			rotaLogger(v.Pk, v.Sk).Warn("Found overdue shift, handing over", "end_of_shift", v.EndOfShift)
+			c.scheduleEndOfShift(v.Pk, v.Sk, v.EndOfShift)
 		}
-	}()
+
+		c.scheduleReconciliation()
+	})
 }
 
-func (c *RotaCommand) StartRotaPrompt(interaction *slack.InteractionCallback, action *slack.BlockAction) error {
-	channelId := interaction.Channel.ID
-	rotaName := action.Value
+func (c *RotaCommand) scheduleEndOfShift(channelId string, rotaName string, endOfShift string) {
+	endOfShiftTime, err := formatter.ParseTime(endOfShift)
+	if err != nil {
+		rotaLogger(channelId, rotaName).Error("Could not schedule end of shift", "end_of_shift", endOfShift, "error", err)
+		return
+	}
+
+	c.scheduler.Schedule(shiftJobId(channelId, rotaName), endOfShiftTime, func() {
+		c.handOverShift(channelId, rotaName)
+	})
+}
+
+func (c *RotaCommand) unscheduleEndOfShift(channelId string, rotaName string) {
+	c.scheduler.Cancel(shiftJobId(channelId, rotaName))
+}
+
+func (c *RotaCommand) handOverShift(channelId string, rotaName string) {
+	logger := rotaLogger(channelId, rotaName)
 
 	rotaDetails, err := c.handler.GetRotaDetails(channelId, rotaName)
 	if err != nil {
-		return err
+		handoversTotal.Inc("failed")
+		logger.Error("Could not load rota", "error", err)
+		return
 	}
 
-	var unableToStartRotaErr string
+	// The rota may have been stopped or removed since the shift was scheduled.
+	if rotaDetails == nil || rotaDetails.CurrOnCallMember == "" || rotaDetails.EndOfShift == "" {
+		return
+	}
 
-	if rotaDetails == nil {
-		unableToStartRotaErr = "Sorry, I can't start an invalid rota!"
-	} else {
-		if len(rotaDetails.Members) == 0 {
-			unableToStartRotaErr = "Sorry, I can't start an empty rota!"
-		}
+	endOfShift, err := formatter.ParseTime(rotaDetails.EndOfShift)
+	if err != nil {
+		handoversTotal.Inc("failed")
+		logger.Error("Could not read end of shift", "error", err)
+		return
+	}
 
-		if rotaDetails.Duration == 0 {
-			unableToStartRotaErr = "Sorry, I can't start a rota without a shift duration!"
-		}
+	now := c.clock.Now()
+	if endOfShift.After(now) {
+		c.scheduleEndOfShift(channelId, rotaName, rotaDetails.EndOfShift)
+		return
+	}
 
-		if rotaDetails.CurrOnCallMember != "" {
-			unableToStartRotaErr = fmt.Sprintf("[%s] %s is already currently on duty!", rotaName, formatter.AtUserId(rotaDetails.CurrOnCallMember))
-		}
+	if rotaDetails.Duration <= 0 {
+		handoversTotal.Inc("failed")
+		logger.Error("Could not hand over: rota has no shift duration")
+		return
 	}
 
-	if unableToStartRotaErr != "" {
-		userId := interaction.User.ID
+	// Pick up from where the last shift ended rather than from now, so a late
+	// handover doesn't push the rest of the schedule back. If the bot was down
+	// for longer than a shift, skip ahead to whoever should be on duty now.
+	// Stored times only carry an offset, so move them back into the clock's
+	// location before doing calendar arithmetic across DST changes.
+	startOfShift := endOfShift.In(now.Location())
+	nextOnCallMember := rotaDetails.NextOnCallMember()
+	endOfNextShift := rotadetails.GenerateEndOfShift(startOfShift, rotaDetails.Duration)
+	for !endOfNextShift.After(now) {
+		startOfShift = endOfNextShift
+		nextOnCallMember = rotaDetails.MemberAfter(nextOnCallMember)
+		endOfNextShift = rotadetails.GenerateEndOfShift(startOfShift, rotaDetails.Duration)
+	}
 
-		attachment := slack.Attachment{}
-		attachment.Text = unableToStartRotaErr
-		attachment.Color = "#f0303a"
+	err = c.handler.UpdateOnCallMember(channelId, rotaName, nextOnCallMember, formatter.FormatTime(startOfShift), formatter.FormatTime(endOfNextShift))
+	if err != nil {
+		handoversTotal.Inc("failed")
+		logger.Error("Could not update rota shift", "error", err)
+		return
+	}
 
-		err = c.respondToClient(channelId, userId, &attachment)
+	handoversTotal.Inc("performed")
+	schedulerLag.Observe(now.Sub(endOfShift).Seconds())
+	logger.Info("Handed over shift", "user_id", nextOnCallMember, "end_of_shift", formatter.FormatTime(endOfNextShift))
+
+	c.scheduleEndOfShift(channelId, rotaName, formatter.FormatTime(endOfNextShift))
+	c.emit(webhook.Event{Type: webhook.EventHandover, ChannelId: channelId, RotaName: rotaName, PreviousOnCallMember: rotaDetails.CurrOnCallMember})
+
+	attachment := slack.Attachment{}
+	attachment.Text = fmt.Sprintf("[%v] %s now on duty!", rotaName, formatter.AtUserId(nextOnCallMember))
+	attachment.Color = "#4af030"
+	c.enqueue(channelId, attachment)
+}
+
+func rotaLogger(channelId string, rotaName string) *slog.Logger {
+	return slog.With("channel_id", channelId, "rota_name", rotaName)
+}
+
+func shiftJobId(channelId string, rotaName string) string {
+	return fmt.Sprintf("shift:%s:%s", channelId, rotaName)
+}
+
+func (c *RotaCommand) StartRotaPrompt(interaction *slack.InteractionCallback, action *slack.BlockAction) error {
+	channelId := interaction.Channel.ID
+	rotaName := action.Value
+
+	userId := interaction.User.ID
+
+	rotaDetails, err := c.handler.GetRotaDetails(channelId, rotaName)
+	if err != nil {
+		return err
+	}
+
+	if rotaDetails != nil {
+		if ok, err := c.allowed(userId, c.config.StartRole, rotaDetails, "start or stop its shifts"); !ok || err != nil {
+			return err
+		}
+	}
+
+	if unableToStartRotaErr := unableToStartRota(rotaDetails, rotaName); unableToStartRotaErr != "" {
+		err = c.respondToClient(channelId, userId, errorAttachment(unableToStartRotaErr))
 		if err != nil {
 			return err
 		}
@@ -141,8 +289,8 @@ func (c *RotaCommand) StartRotaPrompt(interaction *slack.InteractionCallback, ac
 	onCallMemberElement := slack.NewOptionsSelectBlockElement(slack.OptTypeStatic, nil, rotaOnCallMemberAction, onCallOptionBlockObjects...)
 	onCallMemberInputBlock := slack.NewInputBlock(rotaOnCallMemberBlock, onCallMemberText, onCallMemberElement)
 
-	startOfShiftTime := time.Now()
-	endOfShiftTime := rotadetails.GenerateEndOfShift(startOfShiftTime, rotaDetails.Duration)
+	startOfShiftTime := c.clock.Now()
+	endOfShiftTime := formatter.FormatTime(rotaDetails.EndOfFirstShift(startOfShiftTime))
 	shiftDetailsBlock := slack.NewSectionBlock(
 		&slack.TextBlockObject{
 			Type: slack.MarkdownType,
@@ -185,6 +333,28 @@ func (c *RotaCommand) StartRotaPrompt(interaction *slack.InteractionCallback, ac
 	return nil
 }
 
+func unableToStartRota(rotaDetails *rotadetails.RotaDetails, rotaName string) string {
+	var unableToStartRotaErr string
+
+	if rotaDetails == nil {
+		unableToStartRotaErr = "Sorry, I can't start an invalid rota!"
+	} else {
+		if len(rotaDetails.Members) == 0 {
+			unableToStartRotaErr = "Sorry, I can't start an empty rota!"
+		}
+
+		if rotaDetails.Duration == 0 {
+			unableToStartRotaErr = "Sorry, I can't start a rota without a shift duration!"
+		}
+
+		if rotaDetails.CurrOnCallMember != "" {
+			unableToStartRotaErr = fmt.Sprintf("[%s] %s is already currently on duty!", rotaName, formatter.AtUserId(rotaDetails.CurrOnCallMember))
+		}
+	}
+
+	return unableToStartRotaErr
+}
+
 func (c *RotaCommand) StopRota(interaction *slack.InteractionCallback, action *slack.BlockAction) error {
 	channelId := interaction.Channel.ID
 	userId := interaction.User.ID
@@ -195,11 +365,16 @@ func (c *RotaCommand) StopRota(interaction *slack.InteractionCallback, action *s
 		return err
 	}
 
+	if rotaDetails == nil {
+		return c.rotaNoLongerExists(channelId, userId, rotaName)
+	}
+
+	if ok, err := c.allowed(userId, c.config.StartRole, rotaDetails, "start or stop its shifts"); !ok || err != nil {
+		return err
+	}
+
 	if rotaDetails.CurrOnCallMember == "" {
-		attachment := slack.Attachment{}
-		attachment.Text = fmt.Sprintf("[%v] Can't stop a shift that has yet to start.", rotaName)
-		attachment.Color = "#f0303a"
-		err := c.respondToClient(channelId, userId, &attachment)
+		err := c.respondToClient(channelId, userId, errorAttachment(fmt.Sprintf("[%v] Can't stop a shift that has yet to start.", rotaName)))
 		if err != nil {
 			return err
 		}
@@ -207,13 +382,20 @@ func (c *RotaCommand) StopRota(interaction *slack.InteractionCallback, action *s
 		return nil
 	}
 
-	err = c.handler.UpdateOnCallMember(channelId, rotaName, "", "", "")
+	return c.stopShift(channelId, rotaName, rotaDetails.CurrOnCallMember)
+}
+
+func (c *RotaCommand) stopShift(channelId string, rotaName string, currOnCallMember string) error {
+	err := c.handler.UpdateOnCallMember(channelId, rotaName, "", "", "")
 	if err != nil {
 		return err
 	}
 
+	c.unscheduleEndOfShift(channelId, rotaName)
+	c.emit(webhook.Event{Type: webhook.EventStop, ChannelId: channelId, RotaName: rotaName, PreviousOnCallMember: currOnCallMember})
+
 	attachment := slack.Attachment{}
-	attachment.Text = fmt.Sprintf("[%v] %s is now off duty!", rotaName, formatter.AtUserId(rotaDetails.CurrOnCallMember))
+	attachment.Text = fmt.Sprintf("[%v] %s is now off duty!", rotaName, formatter.AtUserId(currOnCallMember))
 	attachment.Color = "#4af030"
 	_, _, err = c.client.PostMessage(channelId, attachment)
 	if err != nil {
@@ -223,8 +405,25 @@ func (c *RotaCommand) StopRota(interaction *slack.InteractionCallback, action *s
 	return nil
 }
 
+// Prompt handles /rota. Text such as "who" or "show <name>" runs a subcommand;
+// anything it doesn't recognise falls back to the interactive rota selector.
 func (c *RotaCommand) Prompt(command slack.SlashCommand) (interface{}, error) {
-	rotaNames, err := c.handler.GetRotaNames(command.ChannelID)
+	cmd, err := subcommand.Parse(command.Text)
+
+	var usageErr *subcommand.UsageError
+	if errors.As(err, &usageErr) {
+		return errorAttachment(usageErr.Error()), nil
+	}
+
+	if err == nil && cmd != nil {
+		return c.runSubcommand(command.ChannelID, command.UserID, cmd)
+	}
+
+	return c.selectRotaPrompt(command.ChannelID)
+}
+
+func (c *RotaCommand) selectRotaPrompt(channelId string) (*slack.Attachment, error) {
+	rotaNames, err := c.handler.GetRotaNames(channelId)
 	if err != nil {
 		return nil, err
 	}
@@ -291,12 +490,15 @@ func (c *RotaCommand) PromptRotaDetails(interaction *slack.InteractionCallback,
 		return err
 	}
 
-	rotaMembers := rotaDetails.Members
-	currOnCallMember := rotaDetails.CurrOnCallMember
-	rotaDuration := rotaDetails.Duration
-	endOfShift := rotaDetails.EndOfShift
+	if rotaDetails == nil {
+		return c.rotaNoLongerExists(channelId, interaction.User.ID, rotaName)
+	}
+
+	prompt, err := c.rotaDetailsPrompt(interaction.User.ID, rotaDetails)
+	if err != nil {
+		return err
+	}
 
-	prompt := c.rotaDetailsPrompt(rotaMembers, currOnCallMember, rotaName, rotaDuration, endOfShift)
 	err = c.respondToClient(channelId, interaction.User.ID, prompt)
 	if err != nil {
 		return err
@@ -323,6 +525,14 @@ func (c *RotaCommand) UpdateRotaPrompt(interaction *slack.InteractionCallback, a
 		return err
 	}
 
+	if rotaDetails == nil {
+		return c.rotaNoLongerExists(channelId, userId, rotaName)
+	}
+
+	if ok, err := c.allowed(userId, c.config.EditRole, rotaDetails, "update it"); !ok || err != nil {
+		return err
+	}
+
 	if rotaDetails.CurrOnCallMember != "" {
 		attachment := slack.Attachment{}
 		attachment.Text = fmt.Sprintf("[%v] Can't update rota whilst someone is on duty.", rotaName)
@@ -370,6 +580,11 @@ func (c *RotaCommand) CreateRota(interaction *slack.InteractionCallback) error {
 		return nil
 	}
 
+	err = c.handler.SaveRotaOwners(channelId, rotaName, []string{userId})
+	if err != nil {
+		return err
+	}
+
 	return c.upsertRotaCallback(channelId, userId, rotaName, rotaMembers, rotaDuration)
 }
 
@@ -387,6 +602,20 @@ func (c *RotaCommand) UpdateRota(interaction *slack.InteractionCallback) error {
 	rotaMembers := inputs[rotaMembersBlock][rotaMembersAction].SelectedUsers
 	rotaDuration := inputs[rotaDurationBlock][rotaDurationAction].SelectedOption.Value
 
+	// The user's role may have changed since they opened the form.
+	rotaDetails, err := c.handler.GetRotaDetails(channelId, rotaName)
+	if err != nil || rotaDetails == nil {
+		return err
+	}
+
+	if ok, err := c.allowed(userId, c.config.EditRole, rotaDetails, "update it"); !ok || err != nil {
+		return err
+	}
+
+	if rotaDetails.RequireApproval {
+		return c.requestChange(rotaDetails, &change.Change{Type: change.TypeMembers, RequestedBy: userId, NewMembers: rotaMembers, NewDuration: rotaDuration})
+	}
+
 	return c.upsertRotaCallback(channelId, userId, rotaName, rotaMembers, rotaDuration)
 }
 
@@ -400,15 +629,31 @@ func (c *RotaCommand) StartRota(interaction *slack.InteractionCallback) error {
 		return err
 	}
 
-	err = c.handler.UpdateOnCallMember(metadata.ChannelId, metadata.RotaName, onCallMember, metadata.StartOfShift, metadata.EndOfShift)
+	rotaDetails, err := c.handler.GetRotaDetails(metadata.ChannelId, metadata.RotaName)
+	if err != nil || rotaDetails == nil {
+		return err
+	}
+
+	if ok, err := c.allowed(interaction.User.ID, c.config.StartRole, rotaDetails, "start or stop its shifts"); !ok || err != nil {
+		return err
+	}
+
+	return c.startShift(metadata.ChannelId, metadata.RotaName, onCallMember, metadata.StartOfShift, metadata.EndOfShift)
+}
+
+func (c *RotaCommand) startShift(channelId string, rotaName string, onCallMember string, startOfShift string, endOfShift string) error {
+	err := c.handler.UpdateOnCallMember(channelId, rotaName, onCallMember, startOfShift, endOfShift)
 	if err != nil {
 		return err
 	}
 
+	c.scheduleEndOfShift(channelId, rotaName, endOfShift)
+	c.emit(webhook.Event{Type: webhook.EventStart, ChannelId: channelId, RotaName: rotaName})
+
 	attachment := slack.Attachment{}
-	attachment.Text = fmt.Sprintf("[%v] %s is now on duty!", metadata.RotaName, formatter.AtUserId(onCallMember))
+	attachment.Text = fmt.Sprintf("[%v] %s is now on duty!", rotaName, formatter.AtUserId(onCallMember))
 	attachment.Color = "#4af030"
-	_, _, err = c.client.PostMessage(metadata.ChannelId, attachment)
+	_, _, err = c.client.PostMessage(channelId, attachment)
 	if err != nil {
 		return err
 	}
@@ -416,14 +661,30 @@ func (c *RotaCommand) StartRota(interaction *slack.InteractionCallback) error {
 	return nil
 }
 
-func (c *RotaCommand) rotaDetailsPrompt(rotaMembers []string, currOnCallMember string, rotaName string, rotaDuration int, endOfShift string) *slack.Attachment {
+// rotaDetailsPrompt shows a rota, with buttons for whatever the user may do to
+// it.
+func (c *RotaCommand) rotaDetailsPrompt(userId string, rotaDetails *rotadetails.RotaDetails) (*slack.Attachment, error) {
+	rotaName := rotaDetails.RotaName()
+	rotaMembers := rotaDetails.Members
+	currOnCallMember := rotaDetails.CurrOnCallMember
+
+	canEdit, err := c.hasRole(userId, c.config.EditRole, rotaDetails)
+	if err != nil {
+		return nil, err
+	}
+
+	canStart, err := c.hasRole(userId, c.config.StartRole, rotaDetails)
+	if err != nil {
+		return nil, err
+	}
+
 	var currRotaMembersText string
 	var currOnCallMemberText string
 	if len(rotaMembers) > 0 {
 		currRotaMembersText = fmt.Sprintf("Current rota members:\n%s", formatter.RotaMembersAsString(rotaMembers))
 
 		if currOnCallMember != "" {
-			currOnCallMemberText = fmt.Sprintf("*%s is currently on duty (shift ends at %v)*", formatter.AtUserId(currOnCallMember), endOfShift)
+			currOnCallMemberText = fmt.Sprintf("*%s is currently on duty (shift ends at %v)*", formatter.AtUserId(currOnCallMember), rotaDetails.EndOfShift)
 		} else {
 			currOnCallMemberText = "*No one is currently on duty.*"
 		}
@@ -431,6 +692,11 @@ func (c *RotaCommand) rotaDetailsPrompt(rotaMembers []string, currOnCallMember s
 		currRotaMembersText = "The rota is empty. Shall we pull in some members?"
 	}
 
+	durationText := fmt.Sprintf("Duration of a rota shift: %d week(s)", rotaDetails.Duration)
+	if len(rotaDetails.Windows) > 0 {
+		durationText += fmt.Sprintf("\nOn call only %s", rotaDetails.DescribeWindows())
+	}
+
 	blocks := []slack.Block{
 		slack.NewHeaderBlock(
 			&slack.TextBlockObject{
@@ -441,7 +707,7 @@ func (c *RotaCommand) rotaDetailsPrompt(rotaMembers []string, currOnCallMember s
 		slack.NewSectionBlock(
 			&slack.TextBlockObject{
 				Type: slack.MarkdownType,
-				Text: fmt.Sprintf("Duration of a rota shift: %d week(s)", rotaDuration),
+				Text: durationText,
 			},
 			nil,
 			nil,
@@ -472,47 +738,100 @@ func (c *RotaCommand) rotaDetailsPrompt(rotaMembers []string, currOnCallMember s
 		),
 	)
 
+	if len(rotaDetails.Owners) > 0 {
+		ownersText := fmt.Sprintf("Owned by %s", atUserIds(rotaDetails.Owners))
+		if rotaDetails.RequireApproval {
+			ownersText += ", who approve changes to its members and shifts"
+		}
+
+		blocks = append(blocks,
+			slack.NewContextBlock("",
+				&slack.TextBlockObject{
+					Type: slack.MarkdownType,
+					Text: ownersText,
+				},
+			),
+		)
+	}
+
+	changeBlocks, err := c.changeBlocks(userId, rotaDetails)
+	if err != nil {
+		return nil, err
+	}
+	blocks = append(blocks, changeBlocks...)
+
 	rotaActionsBlock := slack.NewActionBlock(rotaActions)
 
 	if len(rotaMembers) > 0 {
 		if currOnCallMember == "" {
-			rotaActionsBlock.Elements.ElementSet = append(
-				rotaActionsBlock.Elements.ElementSet,
-				&slack.ButtonBlockElement{
-					Type:     "button",
-					ActionID: UpdateRotaPromptAction,
-					Text:     &slack.TextBlockObject{Text: "Update the rota", Type: slack.PlainTextType},
-					Style:    slack.StyleDefault,
-					Value:    rotaName,
-				},
-				&slack.ButtonBlockElement{
-					Type:     "button",
-					ActionID: StartRotaAction,
-					Text:     &slack.TextBlockObject{Text: "Start shift", Type: slack.PlainTextType},
-					Style:    slack.StylePrimary,
-					Value:    rotaName,
-				},
-			)
+			if canEdit {
+				rotaActionsBlock.Elements.ElementSet = append(
+					rotaActionsBlock.Elements.ElementSet,
+					&slack.ButtonBlockElement{
+						Type:     "button",
+						ActionID: UpdateRotaPromptAction,
+						Text:     &slack.TextBlockObject{Text: "Update the rota", Type: slack.PlainTextType},
+						Style:    slack.StyleDefault,
+						Value:    rotaName,
+					},
+				)
+			}
+			if canStart {
+				rotaActionsBlock.Elements.ElementSet = append(
+					rotaActionsBlock.Elements.ElementSet,
+					&slack.ButtonBlockElement{
+						Type:     "button",
+						ActionID: StartRotaAction,
+						Text:     &slack.TextBlockObject{Text: "Start shift", Type: slack.PlainTextType},
+						Style:    slack.StylePrimary,
+						Value:    rotaName,
+					},
+				)
+			}
 		} else {
+			if canStart {
+				rotaActionsBlock.Elements.ElementSet = append(
+					rotaActionsBlock.Elements.ElementSet,
+					&slack.ButtonBlockElement{
+						Type:     "button",
+						ActionID: StopRotaAction,
+						Text:     &slack.TextBlockObject{Text: "Stop shift", Type: slack.PlainTextType},
+						Style:    slack.StyleDanger,
+						Value:    rotaName,
+					},
+				)
+			}
 			rotaActionsBlock.Elements.ElementSet = append(
 				rotaActionsBlock.Elements.ElementSet,
 				&slack.ButtonBlockElement{
 					Type:     "button",
-					ActionID: StopRotaAction,
-					Text:     &slack.TextBlockObject{Text: "Stop shift", Type: slack.PlainTextType},
-					Style:    slack.StyleDanger,
+					ActionID: PageOnCallPromptAction,
+					Text:     &slack.TextBlockObject{Text: "Page on-call", Type: slack.PlainTextType},
+					Style:    slack.StyleDefault,
 					Value:    rotaName,
 				},
 			)
 		}
 	}
 
-	blocks = append(blocks, rotaActionsBlock)
+	// Slack rejects an actions block without any elements.
+	if len(rotaActionsBlock.Elements.ElementSet) > 0 {
+		blocks = append(blocks, rotaActionsBlock)
+	}
 
 	attachment := slack.Attachment{}
 	attachment.Blocks = slack.Blocks{BlockSet: blocks}
 
-	return &attachment
+	return &attachment, nil
+}
+
+func atUserIds(userIds []string) string {
+	atUserIds := make([]string, 0, len(userIds))
+	for _, v := range userIds {
+		atUserIds = append(atUserIds, formatter.AtUserId(v))
+	}
+
+	return strings.Join(atUserIds, ", ")
 }
 
 func (c *RotaCommand) upsertRotaCallback(channelId string, userId string, rotaName string, rotaMembers []string, rotaDuration string) error {
@@ -521,9 +840,17 @@ func (c *RotaCommand) upsertRotaCallback(channelId string, userId string, rotaNa
 		return err
 	}
 
-	rotaDurationAsInt, _ := strconv.Atoi(rotaDuration)
+	c.emit(webhook.Event{Type: webhook.EventMembersChanged, ChannelId: channelId, RotaName: rotaName, Actor: userId})
+
+	rotaDetails, err := c.handler.GetRotaDetails(channelId, rotaName)
+	if err != nil {
+		return err
+	}
 
-	prompt := c.rotaDetailsPrompt(rotaMembers, "", rotaName, rotaDurationAsInt, "")
+	prompt, err := c.rotaDetailsPrompt(userId, rotaDetails)
+	if err != nil {
+		return err
+	}
 	prompt.Color = "#4af030"
 
 	err = c.respondToClient(channelId, userId, prompt)
@@ -623,6 +950,20 @@ func (c *RotaCommand) upsertRotaPrompt(channelId string, triggerId string, rotaN
 	return nil
 }
 
+// rotaNoLongerExists tells a user that the rota a button or menu they used was
+// for has since been deleted.
+func (c *RotaCommand) rotaNoLongerExists(channelId string, userId string, rotaName string) error {
+	return c.respondToClient(channelId, userId, errorAttachment(fmt.Sprintf("[%v] That rota no longer exists.", rotaName)))
+}
+
+func errorAttachment(text string) *slack.Attachment {
+	attachment := slack.Attachment{}
+	attachment.Text = text
+	attachment.Color = "#f0303a"
+
+	return &attachment
+}
+
 func (c *RotaCommand) respondToClient(channelId string, userId string, payload *slack.Attachment) error {
 	_, err := c.client.PostEphemeral(channelId, userId, *payload)
 	if err != nil {
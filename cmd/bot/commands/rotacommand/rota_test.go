@@ -1,13 +1,29 @@
 package rotacommand
 
 import (
+	"alfred-bot/cmd/bot/commands/rotacommand/handler"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/admin"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/alert"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/apitoken"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/change"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/history"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/outbox"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/page"
 	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/webhook"
+	"alfred-bot/cmd/bot/router"
 	"alfred-bot/config"
+	"alfred-bot/utils/clock"
+	"alfred-bot/utils/formatter"
+	"context"
 	"fmt"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/slack-go/slack"
+	"sync"
 	"testing"
+	"time"
+	_ "time/tzdata"
 )
 
 const (
@@ -16,32 +32,116 @@ const (
 )
 
 type MockSlackClient struct {
-	PostMessageStub   func(channelID string, attachment slack.Attachment) (string, string, error)
-	PostEphemeralStub func(channelID string, userID string, attachment slack.Attachment) (string, error)
-	OpenViewStub      func(triggerID string, view slack.ModalViewRequest) (*slack.ViewResponse, error)
-	Inbox             []string
+	PostMessageStub    func(channelID string, attachment slack.Attachment) (string, string, error)
+	PostEphemeralStub  func(channelID string, userID string, attachment slack.Attachment) (string, error)
+	PostReplyStub      func(channelID string, threadTimestamp string, attachment slack.Attachment) (string, string, error)
+	UpdateMessageStub  func(channelID string, timestamp string, attachment slack.Attachment) (string, string, string, error)
+	OpenViewStub       func(triggerID string, view slack.ModalViewRequest) (*slack.ViewResponse, error)
+	PublishViewStub    func(userID string, view slack.HomeTabViewRequest) (*slack.ViewResponse, error)
+	GetUserInfoStub    func(userID string) (*slack.User, error)
+	GetUserByEmailStub func(email string) (*slack.User, error)
+	Admins             []string
+	Inbox              []string
+	Views              []slack.ModalViewRequest
+	HomeViews          map[string]slack.HomeTabViewRequest
+	Replies            map[string][]string
+	Posts              map[string][]slack.Attachment
+	Updates            map[string]slack.Attachment
+	mu                 sync.Mutex
+	messages           []string
 }
 
 func (m *MockSlackClient) PostMessage(channelID string, attachment slack.Attachment) (string, string, error) {
-	return "", "", nil
+	if m.PostMessageStub != nil {
+		return m.PostMessageStub(channelID, attachment)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, attachment.Text)
+	if m.Posts == nil {
+		m.Posts = map[string][]slack.Attachment{}
+	}
+	m.Posts[channelID] = append(m.Posts[channelID], attachment)
+	return channelID, fmt.Sprintf("%d", len(m.messages)), nil
+}
+
+func (m *MockSlackClient) UpdateMessage(channelID string, timestamp string, attachment slack.Attachment) (string, string, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Updates == nil {
+		m.Updates = map[string]slack.Attachment{}
+	}
+	m.Updates[timestamp] = attachment
+	return channelID, timestamp, attachment.Text, nil
+}
+
+func (m *MockSlackClient) Messages() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string{}, m.messages...)
+}
+
+func (m *MockSlackClient) PostsTo(channelID string) []slack.Attachment {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]slack.Attachment{}, m.Posts[channelID]...)
+}
+
+func (m *MockSlackClient) UpdateTo(timestamp string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Updates[timestamp].Text
 }
 
 func (m *MockSlackClient) PostEphemeral(channelID string, userID string, attachment slack.Attachment) (string, error) {
+	if m.PostEphemeralStub != nil {
+		return m.PostEphemeralStub(channelID, userID, attachment)
+	}
+
 	return "", nil
 }
 
+func (m *MockSlackClient) PostReply(channelID string, threadTimestamp string, attachment slack.Attachment) (string, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Replies == nil {
+		m.Replies = map[string][]string{}
+	}
+	m.Replies[threadTimestamp] = append(m.Replies[threadTimestamp], attachment.Text)
+	return "", "", nil
+}
+
+func (m *MockSlackClient) PublishView(userID string, view slack.HomeTabViewRequest) (*slack.ViewResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.HomeViews == nil {
+		m.HomeViews = map[string]slack.HomeTabViewRequest{}
+	}
+	m.HomeViews[userID] = view
+	return nil, nil
+}
+
+func (m *MockSlackClient) GetUserInfo(userID string) (*slack.User, error) {
+	user := &slack.User{ID: userID}
+	for _, v := range m.Admins {
+		if v == userID {
+			user.IsAdmin = true
+		}
+	}
+	return user, nil
+}
+
+func (m *MockSlackClient) GetUserByEmail(email string) (*slack.User, error) {
+	return nil, fmt.Errorf("users_not_found")
+}
+
 func (m *MockSlackClient) OpenView(triggerID string, view slack.ModalViewRequest) (*slack.ViewResponse, error) {
-	element := view.Blocks.BlockSet[0].(*slack.InputBlock)
-	fmt.Println(element.BlockID)
-	fmt.Println(element.Label.Text)
-
-	subElement := element.Element.(*slack.SelectBlockElement)
-	fmt.Println(subElement.Type)
-	fmt.Println(subElement.ActionID)
-	for _, v := range subElement.Options {
-		fmt.Println(fmt.Sprintf("%v, %v", v.Text.Text, v.Value))
+	if m.OpenViewStub != nil {
+		return m.OpenViewStub(triggerID, view)
 	}
 
+	m.Views = append(m.Views, view)
 	m.Inbox = append(
 		m.Inbox,
 		string(view.Type),
@@ -52,12 +152,64 @@ func (m *MockSlackClient) OpenView(triggerID string, view slack.ModalViewRequest
 	return nil, nil
 }
 
+// runInBackground handles end of shift events with rotaCommand, as the bot
+// does, until the returned func is called, which waits for it to stop.
+func runInBackground(rotaCommand *RotaCommand) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		rotaCommand.HandleEndOfOnCallShifts(ctx)
+		close(stopped)
+	}()
+
+	return func() {
+		cancel()
+		Eventually(stopped).Should(BeClosed())
+	}
+}
+
 type MockRotaHandler struct {
 	_ func(channelId string) ([]string, error)
 	_ func(channelId string, rotaName string) (*rotadetails.RotaDetails, error)
 	_ func() ([]*rotadetails.RotaDetails, error)
+	_ func() ([]*rotadetails.RotaDetails, error)
+	_ func(userId string) ([]*rotadetails.RotaDetails, error)
+	_ func() ([]*rotadetails.RotaDetails, error)
 	_ func(channelId string, rotaName string, rotaMembers []string, rotaDuration string) error
+	_ func(channelId string, rotaName string, owners []string) error
+	_ func(channelId string, rotaName string, tiers []string) error
+	_ func(channelId string, rotaName string, handoverAnchor string) error
+	_ func(channelId string, rotaName string, requireApproval bool) error
+	_ func(channelId string, rotaName string) error
 	_ func(channelId string, rotaName string, newOnCallMember string, startOfShift string, endOfShift string) error
+	_ func(channelId string, rotaName string, newOnCallMember string, overriddenOnCallMember string) error
+	_ func(channelId string, pageId string) (*page.Page, error)
+	_ func() ([]*page.Page, error)
+	_ func(p *page.Page) error
+	_ func(channelId string, rotaName string, routingKey string) error
+	_ func(routingKey string) (*rotadetails.RotaDetails, error)
+	_ func(channelId string, fingerprint string) (*alert.Alert, error)
+	_ func(a *alert.Alert) error
+	_ func(channelId string, rotaName string, webhooks []webhook.Webhook) error
+	_ func(d *webhook.Delivery) error
+	_ func(channelId string, rotaName string, limit int) ([]*webhook.Delivery, error)
+	_ func(e *history.Entry) error
+	_ func(channelId string, rotaName string, limit int) ([]*history.Entry, error)
+	_ func(t *apitoken.Token) error
+	_ func(hash string) (*apitoken.Token, error)
+	_ func() ([]*apitoken.Token, error)
+	_ func(hash string) error
+	_ func(a *admin.Admin) error
+	_ func(userId string) (*admin.Admin, error)
+	_ func() ([]*admin.Admin, error)
+	_ func(userId string) error
+	_ func(c *change.Change) error
+	_ func(channelId string, rotaName string, changeId string) (*change.Change, error)
+	_ func(channelId string, rotaName string) ([]*change.Change, error)
+	_ func(channelId string, rotaName string, changeId string) error
+	_ func(m *outbox.Message) error
+	_ func() ([]*outbox.Message, error)
+	_ func(messageId string) error
 }
 
 func (r *MockRotaHandler) GetRotaNames(channelId string) ([]string, error) {
@@ -79,10 +231,50 @@ func (r *MockRotaHandler) GetRotaDetails(channelId string, rotaName string) (*ro
 	return nil, nil
 }
 
+func (r *MockRotaHandler) GetOnCallShifts() ([]*rotadetails.RotaDetails, error) {
+	return nil, nil
+}
+
 func (r *MockRotaHandler) GetEndingOnCallShifts() ([]*rotadetails.RotaDetails, error) {
 	return nil, nil
 }
 
+func (r *MockRotaHandler) GetUserRotas(userId string) ([]*rotadetails.RotaDetails, error) {
+	return nil, nil
+}
+
+func (r *MockRotaHandler) GetRotas() ([]*rotadetails.RotaDetails, error) {
+	return nil, nil
+}
+
+func (r *MockRotaHandler) SaveWindows(channelId string, rotaName string, timeZone string, windows []rotadetails.Window) error {
+	return nil
+}
+
+func (r *MockRotaHandler) DeleteRota(channelId string, rotaName string) error {
+	return nil
+}
+
+func (r *MockRotaHandler) SaveRotaOwners(channelId string, rotaName string, owners []string) error {
+	return nil
+}
+
+func (r *MockRotaHandler) SaveRotaTiers(channelId string, rotaName string, tiers []string) error {
+	return nil
+}
+
+func (r *MockRotaHandler) SaveHandoverAnchor(channelId string, rotaName string, handoverAnchor string) error {
+	return nil
+}
+
+func (r *MockRotaHandler) SaveRequireApproval(channelId string, rotaName string, requireApproval bool) error {
+	return nil
+}
+
+func (r *MockRotaHandler) OverrideOnCallMember(channelId string, rotaName string, newOnCallMember string, overriddenOnCallMember string) error {
+	return nil
+}
+
 func (r *MockRotaHandler) SaveRotaDetails(channelId string, rotaName string, rotaMembers []string, rotaDuration string) error {
 	return nil
 }
@@ -91,13 +283,141 @@ func (r *MockRotaHandler) UpdateOnCallMember(channelId string, rotaName string,
 	return nil
 }
 
+func (r *MockRotaHandler) GetPage(channelId string, pageId string) (*page.Page, error) {
+	return nil, nil
+}
+
+func (r *MockRotaHandler) GetOpenPages() ([]*page.Page, error) {
+	return nil, nil
+}
+
+func (r *MockRotaHandler) SavePage(p *page.Page) error {
+	return nil
+}
+
+func (r *MockRotaHandler) SaveRoutingKey(channelId string, rotaName string, routingKey string) error {
+	return nil
+}
+
+func (r *MockRotaHandler) DeleteRoutingKey(channelId string, rotaName string) error {
+	return nil
+}
+
+func (r *MockRotaHandler) GetRotaByRoutingKey(routingKey string) (*rotadetails.RotaDetails, error) {
+	return nil, nil
+}
+
+func (r *MockRotaHandler) GetAlert(channelId string, fingerprint string) (*alert.Alert, error) {
+	return nil, nil
+}
+
+func (r *MockRotaHandler) SaveAlert(a *alert.Alert) error {
+	return nil
+}
+
+func (r *MockRotaHandler) SaveWebhooks(channelId string, rotaName string, webhooks []webhook.Webhook) error {
+	return nil
+}
+
+func (r *MockRotaHandler) SaveDelivery(d *webhook.Delivery) error {
+	return nil
+}
+
+func (r *MockRotaHandler) GetDeliveries(channelId string, rotaName string, limit int) ([]*webhook.Delivery, error) {
+	return nil, nil
+}
+
+func (r *MockRotaHandler) SaveHistoryEntry(e *history.Entry) error {
+	return nil
+}
+
+func (r *MockRotaHandler) GetHistory(channelId string, rotaName string, limit int) ([]*history.Entry, error) {
+	return nil, nil
+}
+
+func (r *MockRotaHandler) SaveAPIToken(t *apitoken.Token) error {
+	return nil
+}
+
+func (r *MockRotaHandler) GetAPIToken(hash string) (*apitoken.Token, error) {
+	return nil, nil
+}
+
+func (r *MockRotaHandler) GetAPITokens() ([]*apitoken.Token, error) {
+	return nil, nil
+}
+
+func (r *MockRotaHandler) DeleteAPIToken(hash string) error {
+	return nil
+}
+
+func (r *MockRotaHandler) SaveAdmin(a *admin.Admin) error {
+	return nil
+}
+
+func (r *MockRotaHandler) GetAdmin(userId string) (*admin.Admin, error) {
+	return nil, nil
+}
+
+func (r *MockRotaHandler) GetAdmins() ([]*admin.Admin, error) {
+	return nil, nil
+}
+
+func (r *MockRotaHandler) DeleteAdmin(userId string) error {
+	return nil
+}
+
+func (r *MockRotaHandler) SaveChange(c *change.Change) error {
+	return nil
+}
+
+func (r *MockRotaHandler) GetChange(channelId string, rotaName string, changeId string) (*change.Change, error) {
+	return nil, nil
+}
+
+func (r *MockRotaHandler) GetChanges(channelId string, rotaName string) ([]*change.Change, error) {
+	return nil, nil
+}
+
+func (r *MockRotaHandler) DeleteChange(channelId string, rotaName string, changeId string) error {
+	return nil
+}
+
+func (r *MockRotaHandler) SaveOutboxMessage(m *outbox.Message) error {
+	return nil
+}
+
+func (r *MockRotaHandler) GetOutboxMessages() ([]*outbox.Message, error) {
+	return nil, nil
+}
+
+func (r *MockRotaHandler) DeleteOutboxMessage(messageId string) error {
+	return nil
+}
+
+func (r *MockRotaHandler) SaveWebhookRetry(retry *webhook.Retry) error {
+	return nil
+}
+
+func (r *MockRotaHandler) GetWebhookRetries() ([]*webhook.Retry, error) {
+	return nil, nil
+}
+
+func (r *MockRotaHandler) DeleteWebhookRetry(retryId string) error {
+	return nil
+}
+
 func TestRota(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "RotaCommand Suite")
 }
 
+var testRotaConfig config.RotaConfig
+
 var _ = BeforeSuite(func() {
-	config.BootstrapEnv(true)
+	cfg, err := config.LoadForTesting()
+	Expect(err).To(BeNil())
+	testRotaConfig = cfg.Rota
 })
 
 var _ = Describe("RotaCommand", func() {
@@ -108,13 +428,13 @@ var _ = Describe("RotaCommand", func() {
 				Inbox: []string{},
 			}
 
-			rotaCommand := New(handler, mockSlackClient)
+			rotaCommand := New(handler, mockSlackClient, clock.New(), testRotaConfig)
 
 			channel := slack.Channel{}
 			channel.ID = testChannelId
 
 			interaction := &slack.InteractionCallback{
-				User:    slack.User{},
+				User:    slack.User{ID: "Evan"},
 				Channel: channel,
 			}
 
@@ -127,6 +447,307 @@ var _ = Describe("RotaCommand", func() {
 			Expect(mockSlackClient.Inbox[1]).To(Equal(StartRotaCallback))
 			Expect(mockSlackClient.Inbox[2]).ToNot(Equal(""))
 			Expect(mockSlackClient.Inbox[3]).To(Equal("Start a shift"))
+
+			input := mockSlackClient.Views[0].Blocks.BlockSet[0].(*slack.InputBlock)
+			Expect(input.BlockID).To(Equal(rotaOnCallMemberBlock))
+			Expect(input.Label.Text).To(Equal("Who should be on duty for this shift?"))
+			selectElement := input.Element.(*slack.SelectBlockElement)
+			Expect(selectElement.ActionID).To(Equal(rotaOnCallMemberAction))
+			var options []string
+			for _, v := range selectElement.Options {
+				options = append(options, v.Text.Text+", "+v.Value)
+			}
+			Expect(options).To(Equal([]string{"<@Evan>, Evan", "<@Sia>, Sia", "<@Wai>, Wai", "<@Suan>, Suan"}))
+		})
+	})
+
+	Describe("Buttons for deleted rotas", func() {
+		var rotaCommand *RotaCommand
+		var ephemerals []string
+
+		interaction := &slack.InteractionCallback{User: slack.User{ID: "Evan"}}
+		interaction.Channel.ID = testChannelId
+
+		BeforeEach(func() {
+			ephemerals = nil
+			mockSlackClient := &MockSlackClient{
+				PostEphemeralStub: func(channelID string, userID string, attachment slack.Attachment) (string, error) {
+					ephemerals = append(ephemerals, attachment.Text)
+					return "", nil
+				},
+			}
+			rotaCommand = New(handler.NewMemoryHandler(clock.New()), mockSlackClient, clock.New(), testRotaConfig)
+		})
+
+		It("Tell the user the rota no longer exists", func() {
+			Expect(rotaCommand.StopRota(interaction, &slack.BlockAction{Value: "Deleted"})).To(Succeed())
+			Expect(rotaCommand.UpdateRotaPrompt(interaction, &slack.BlockAction{Value: "Deleted"})).To(Succeed())
+			Expect(rotaCommand.PromptRotaDetails(interaction, &slack.BlockAction{SelectedOption: slack.OptionBlockObject{Value: "Deleted"}})).To(Succeed())
+
+			Expect(ephemerals).To(Equal([]string{
+				"[Deleted] That rota no longer exists.",
+				"[Deleted] That rota no longer exists.",
+				"[Deleted] That rota no longer exists.",
+			}))
 		})
 	})
+
+	Describe("OrderingKey", func() {
+		It("Keys requests by the rota they're about", func() {
+			rotaCommand := New(new(MockRotaHandler), &MockSlackClient{}, clock.New(), testRotaConfig)
+			orderingKey := func(req *router.Request) string {
+				key, ok := rotaCommand.OrderingKey(req)
+				Expect(ok).To(BeTrue())
+				return key
+			}
+			command := func(text string) *router.Request {
+				return router.NewCommandRequest(slack.SlashCommand{Command: "/rota", Text: text, ChannelID: "C0123", UserID: "U0123"})
+			}
+			button := func(actionId string, value string) *router.Request {
+				interaction := slack.InteractionCallback{Type: slack.InteractionTypeBlockActions}
+				interaction.Channel.ID = "C0456"
+				interaction.User.ID = "U0123"
+				interaction.ActionCallback.BlockActions = []*slack.BlockAction{{ActionID: actionId, Value: value}}
+				return router.NewInteractionRequest(interaction)
+			}
+
+			Expect(orderingKey(command(`show "on call"`))).To(Equal("C0123/on call"))
+			Expect(orderingKey(command("list"))).To(Equal("C0123"))
+			Expect(orderingKey(button(StopRotaAction, "Support"))).To(Equal("C0456/Support"))
+			// Approvals are posted to the owner, away from the rota's channel.
+			Expect(orderingKey(button(ApproveChangeAction, "C0123/on call/01H"))).To(Equal("C0123/on call"))
+			Expect(orderingKey(button(AcknowledgePageAction, "C0123/01H"))).To(Equal("C0123"))
+
+			// Home tab buttons say which channel's rota they're for.
+			Expect(orderingKey(button(SkipShiftAction, `{"ChannelId":"C0789","RotaName":"Support"}`))).To(Equal("C0789/Support"))
+		})
+	})
+})
+
+var _ = Describe("HandleEndOfOnCallShifts", func() {
+	var london *time.Location
+	var fakeClock *clock.Fake
+	var store *handler.MemoryHandler
+	var mockSlackClient *MockSlackClient
+	var stop func()
+
+	run := func() {
+		stop = runInBackground(New(store, mockSlackClient, fakeClock, testRotaConfig))
+	}
+
+	startShift := func(onCallMember string) {
+		startOfShift := fakeClock.Now()
+		endOfShift := rotadetails.GenerateEndOfShift(startOfShift, 1)
+		err := store.UpdateOnCallMember(testChannelId, testRotaName, onCallMember, formatter.FormatTime(startOfShift), formatter.FormatTime(endOfShift))
+		Expect(err).To(BeNil())
+	}
+
+	onCallMember := func() string {
+		rotaDetails, _ := store.GetRotaDetails(testChannelId, testRotaName)
+		return rotaDetails.CurrOnCallMember
+	}
+
+	BeforeEach(func() {
+		var err error
+		london, err = time.LoadLocation("Europe/London")
+		Expect(err).To(BeNil())
+
+		fakeClock = clock.NewFake(time.Date(2022, time.March, 7, 9, 0, 0, 0, london))
+		store = handler.NewMemoryHandler(fakeClock)
+		mockSlackClient = &MockSlackClient{}
+
+		err = store.SaveRotaDetails(testChannelId, testRotaName, []string{"Evan", "Sia", "Wai"}, "1")
+		Expect(err).To(BeNil())
+		startShift("Evan")
+		run()
+	})
+
+	AfterEach(func() {
+		stop()
+	})
+
+	It("Does not hand over before the shift ends", func() {
+		fakeClock.Advance(7*24*time.Hour - time.Minute)
+		Consistently(mockSlackClient.Messages, 50*time.Millisecond).Should(BeEmpty())
+		Expect(onCallMember()).To(Equal("Evan"))
+	})
+
+	It("Hands over to each member in turn as the weeks pass", func() {
+		for i, member := range []string{"Sia", "Wai", "Evan"} {
+			fakeClock.Advance(7 * 24 * time.Hour)
+			Eventually(mockSlackClient.Messages).Should(HaveLen(i + 1))
+			Expect(onCallMember()).To(Equal(member))
+		}
+
+		Expect(mockSlackClient.Messages()).To(Equal([]string{
+			fmt.Sprintf("[%s] <@Sia> now on duty!", testRotaName),
+			fmt.Sprintf("[%s] <@Wai> now on duty!", testRotaName),
+			fmt.Sprintf("[%s] <@Evan> now on duty!", testRotaName),
+		}))
+	})
+
+	It("Keeps handing over at the same local time across DST changes", func() {
+		// Clocks go forward on 27 March 2022 and back on 30 October 2022.
+		for fakeClock.Now().Before(time.Date(2022, time.November, 7, 0, 0, 0, 0, london)) {
+			fakeClock.Advance(7 * 24 * time.Hour)
+			expectedMessages := len(mockSlackClient.Messages()) + 1
+			Eventually(mockSlackClient.Messages).Should(HaveLen(expectedMessages))
+
+			rotaDetails, _ := store.GetRotaDetails(testChannelId, testRotaName)
+			endOfShift, err := formatter.ParseTime(rotaDetails.EndOfShift)
+			Expect(err).To(BeNil())
+			Expect(endOfShift.In(london).Hour()).To(Equal(9))
+			Expect(endOfShift.In(london).Weekday()).To(Equal(time.Monday))
+		}
+	})
+
+	It("Does not hand over an hour early when the clocks go forward", func() {
+		stop()
+		fakeClock.Set(time.Date(2022, time.March, 21, 9, 0, 0, 0, london))
+		startShift("Evan")
+		run()
+
+		fakeClock.Set(time.Date(2022, time.March, 28, 8, 30, 0, 0, london))
+		Consistently(mockSlackClient.Messages, 50*time.Millisecond).Should(BeEmpty())
+
+		fakeClock.Set(time.Date(2022, time.March, 28, 9, 0, 0, 0, london))
+		Eventually(mockSlackClient.Messages).Should(HaveLen(1))
+		Expect(onCallMember()).To(Equal("Sia"))
+	})
+
+	It("Resumes the schedule after a restart", func() {
+		stop()
+		run()
+
+		fakeClock.Advance(7 * 24 * time.Hour)
+		Eventually(mockSlackClient.Messages).Should(HaveLen(1))
+		Expect(onCallMember()).To(Equal("Sia"))
+	})
+
+	It("Catches up on shifts that ended whilst it was down", func() {
+		stop()
+
+		fakeClock.Advance(15 * 24 * time.Hour)
+		run()
+
+		Eventually(mockSlackClient.Messages).Should(HaveLen(1))
+		Expect(onCallMember()).To(Equal("Wai"))
+
+		rotaDetails, _ := store.GetRotaDetails(testChannelId, testRotaName)
+		Expect(rotaDetails.StartOfShift).To(Equal(formatter.FormatTime(time.Date(2022, time.March, 21, 9, 0, 0, 0, london))))
+		Expect(rotaDetails.EndOfShift).To(Equal(formatter.FormatTime(time.Date(2022, time.March, 28, 9, 0, 0, 0, london))))
+	})
+
+	It("Stops handing over once the rota is stopped", func() {
+		err := store.UpdateOnCallMember(testChannelId, testRotaName, "", "", "")
+		Expect(err).To(BeNil())
+
+		fakeClock.Advance(7 * 24 * time.Hour)
+		Consistently(mockSlackClient.Messages, 50*time.Millisecond).Should(BeEmpty())
+	})
+})
+
+var _ = Describe("Prompt", func() {
+	var fakeClock *clock.Fake
+	var store *handler.MemoryHandler
+	var mockSlackClient *MockSlackClient
+	var rotaCommand *RotaCommand
+
+	promptAs := func(userId string, text string) *slack.Attachment {
+		payload, err := rotaCommand.Prompt(slack.SlashCommand{ChannelID: testChannelId, UserID: userId, Text: text})
+		Expect(err).To(BeNil())
+		if payload == nil {
+			return nil
+		}
+		return payload.(*slack.Attachment)
+	}
+
+	prompt := func(text string) *slack.Attachment {
+		return promptAs("Evan", text)
+	}
+
+	BeforeEach(func() {
+		fakeClock = clock.NewFake(time.Date(2022, time.March, 7, 9, 0, 0, 0, time.UTC))
+		store = handler.NewMemoryHandler(fakeClock)
+		mockSlackClient = &MockSlackClient{}
+		rotaCommand = New(store, mockSlackClient, fakeClock, testRotaConfig)
+
+		err := store.SaveRotaDetails(testChannelId, "on call", []string{"Evan", "Sia"}, "1")
+		Expect(err).To(BeNil())
+		err = store.SaveRotaDetails(testChannelId, testRotaName, []string{"Wai"}, "2")
+		Expect(err).To(BeNil())
+	})
+
+	It("Falls back to the rota selector for unknown input", func() {
+		attachment := prompt("what is this")
+		Expect(attachment.Blocks.BlockSet).To(HaveLen(2))
+		Expect(attachment.Blocks.BlockSet[1].(*slack.ActionBlock).BlockID).To(Equal(promptActions))
+	})
+
+	It("Falls back to the rota selector for empty input", func() {
+		Expect(prompt("").Blocks.BlockSet).To(HaveLen(2))
+	})
+
+	It("Lists the rotas in the channel", func() {
+		Expect(prompt("list").Text).To(Equal("Rotas in this channel:\n• dummy_rota\n• on call"))
+	})
+
+	It("Shows a rota by its quoted name", func() {
+		attachment := prompt(`show "on call"`)
+		Expect(attachment.Blocks.BlockSet[0].(*slack.HeaderBlock).Text.Text).To(Equal("on call"))
+	})
+
+	It("Explains when a rota doesn't exist", func() {
+		attachment := prompt("show missing")
+		Expect(attachment.Color).To(Equal("#f0303a"))
+		Expect(attachment.Text).To(ContainSubstring("couldn't find a rota called missing"))
+	})
+
+	It("Shows the usage when arguments are missing", func() {
+		attachment := prompt("start")
+		Expect(attachment.Color).To(Equal("#f0303a"))
+		Expect(attachment.Text).To(ContainSubstring("/rota start <name> @user"))
+	})
+
+	It("Starts and stops a shift", func() {
+		Expect(prompt(`start "on call" <@Sia|sia>`)).To(BeNil())
+
+		rotaDetails, _ := store.GetRotaDetails(testChannelId, "on call")
+		Expect(rotaDetails.CurrOnCallMember).To(Equal("Sia"))
+		Expect(rotaDetails.EndOfShift).To(Equal(formatter.FormatTime(time.Date(2022, time.March, 14, 9, 0, 0, 0, time.UTC))))
+
+		Expect(prompt("who").Text).To(Equal(fmt.Sprintf(
+			"[dummy_rota] No one is currently on duty.\n[on call] <@Sia> is on duty until %s",
+			rotaDetails.EndOfShift,
+		)))
+		Expect(prompt(`next "on call"`).Text).To(Equal(fmt.Sprintf("[on call] <@Evan> is on duty next, from %s", rotaDetails.EndOfShift)))
+
+		Expect(prompt(`stop "on call"`)).To(BeNil())
+		rotaDetails, _ = store.GetRotaDetails(testChannelId, "on call")
+		Expect(rotaDetails.CurrOnCallMember).To(Equal(""))
+
+		Expect(mockSlackClient.Messages()).To(Equal([]string{
+			"[on call] <@Sia> is now on duty!",
+			"[on call] <@Sia> is now off duty!",
+		}))
+	})
+
+	It("Ends the first shift at the rota's handover anchor", func() {
+		Expect(store.SaveHandoverAnchor(testChannelId, "on call", "Wed, 02 Mar 2022 17:00:00 +0000")).To(Succeed())
+		Expect(prompt(`start "on call" <@Sia>`)).To(BeNil())
+
+		rotaDetails, _ := store.GetRotaDetails(testChannelId, "on call")
+		Expect(rotaDetails.EndOfShift).To(Equal(formatter.FormatTime(time.Date(2022, time.March, 9, 17, 0, 0, 0, time.UTC))))
+	})
+
+	It("Refuses to start a shift for someone outside the rota", func() {
+		attachment := promptAs("Wai", "start dummy_rota <@Sia>")
+		Expect(attachment.Color).To(Equal("#f0303a"))
+		Expect(attachment.Text).To(Equal("[dummy_rota] <@Sia> isn't a member of this rota."))
+		Expect(mockSlackClient.Messages()).To(BeEmpty())
+	})
+
+	It("Refuses to stop a rota that hasn't started", func() {
+		Expect(promptAs("Wai", "stop dummy_rota").Text).To(Equal("[dummy_rota] Can't stop a shift that has yet to start."))
+	})
 })
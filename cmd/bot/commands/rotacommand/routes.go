@@ -0,0 +1,129 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/models/change"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/metadata"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/page"
+	"alfred-bot/cmd/bot/commands/rotacommand/subcommand"
+	"alfred-bot/cmd/bot/router"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Routes registers /rota, its buttons and modals, and the Home tab and
+// mentions.
+func (c *RotaCommand) Routes(r *router.Routes) {
+	r.Command("/rota", c.Prompt)
+
+	r.BlockAction(SelectRotaAction, c.PromptRotaDetails)
+	r.BlockAction(StartRotaAction, c.StartRotaPrompt)
+	r.BlockAction(StopRotaAction, c.StopRota)
+	r.BlockAction(CreateRotaPromptAction, func(interaction *slack.InteractionCallback, _ *slack.BlockAction) error {
+		return c.CreateRotaPrompt(interaction)
+	})
+	r.BlockAction(UpdateRotaPromptAction, c.UpdateRotaPrompt)
+	r.BlockAction(SwapShiftPromptAction, c.SwapShiftPrompt)
+	r.BlockAction(OverrideShiftPromptAction, c.OverrideShiftPrompt)
+	r.BlockAction(SkipShiftAction, c.SkipShift)
+	r.BlockAction(PageOnCallPromptAction, c.PageOnCallPrompt)
+	r.BlockAction(AcknowledgePageAction, c.AcknowledgePage)
+	r.BlockAction(ApproveChangeAction, c.ApproveChange)
+	r.BlockAction(RejectChangeAction, c.RejectChange)
+
+	r.ViewSubmission(UpdateRotaCallback, c.UpdateRota)
+	r.ViewSubmission(CreateRotaCallback, c.CreateRota)
+	r.ViewSubmission(StartRotaCallback, c.StartRota)
+	r.ViewSubmission(SwapShiftCallback, c.SwapShift)
+	r.ViewSubmission(OverrideShiftCallback, c.OverrideShift)
+	r.ViewSubmission(PageOnCallCallback, c.PageOnCallSubmission)
+
+	r.Event(slackevents.AppHomeOpened, c.appHomeOpened)
+	r.Event(slackevents.AppMention, c.appMention)
+}
+
+func (c *RotaCommand) appHomeOpened(event slackevents.EventsAPIEvent) error {
+	ev, ok := event.InnerEvent.Data.(*slackevents.AppHomeOpenedEvent)
+	if !ok || ev.Tab != "home" {
+		return nil
+	}
+
+	return c.PublishHome(ev.User)
+}
+
+func (c *RotaCommand) appMention(event slackevents.EventsAPIEvent) error {
+	ev, ok := event.InnerEvent.Data.(*slackevents.AppMentionEvent)
+	// Don't get drawn into conversations with other bots.
+	if !ok || ev.BotID != "" {
+		return nil
+	}
+
+	threadTimestamp := ev.ThreadTimeStamp
+	if threadTimestamp == "" {
+		threadTimestamp = ev.TimeStamp
+	}
+	return c.HandleMention(ev.Channel, ev.User, ev.Text, threadTimestamp)
+}
+
+// OrderingKey keys requests by the rota they're about. Requests about no rota
+// in particular, or about a page, are keyed by their channel.
+func (c *RotaCommand) OrderingKey(req *router.Request) (string, bool) {
+	channelId, rotaName := requestRota(req)
+	if channelId == "" {
+		return "", false
+	}
+
+	if rotaName == "" {
+		return channelId, true
+	}
+	return channelId + "/" + rotaName, true
+}
+
+// requestRota returns the channel and name of the rota a request is about, as
+// far as can be told without looking anything up. The rota name is empty for
+// requests about no rota in particular, or about a page.
+func requestRota(req *router.Request) (string, string) {
+	switch req.Kind {
+	case router.KindCommand:
+		if cmd, err := subcommand.Parse(req.Command.Text); err == nil && cmd != nil {
+			return req.ChannelId, cmd.RotaName
+		}
+		return req.ChannelId, ""
+	case router.KindEvent:
+		if ev, ok := req.Event.InnerEvent.Data.(*slackevents.AppMentionEvent); ok {
+			if cmd, err := subcommand.ParseMention(ev.Text); err == nil && cmd != nil {
+				return req.ChannelId, cmd.RotaName
+			}
+		}
+		return req.ChannelId, ""
+	case router.KindViewSubmission:
+		if commandMetadata, err := metadata.UnpackCommandMetadata(req.Interaction.View.PrivateMetadata); err == nil {
+			return commandMetadata.ChannelId, commandMetadata.RotaName
+		}
+		return req.ChannelId, ""
+	}
+
+	if req.Action == nil {
+		return req.ChannelId, ""
+	}
+
+	switch req.Action.ActionID {
+	case AcknowledgePageAction:
+		if channelId, _, err := page.ParseReference(req.Action.Value); err == nil {
+			return channelId, ""
+		}
+	case ApproveChangeAction, RejectChangeAction:
+		if channelId, rotaName, _, err := change.ParseReference(req.Action.Value); err == nil {
+			return channelId, rotaName
+		}
+	}
+
+	// Home tab buttons carry the channel and rota as metadata.
+	if commandMetadata, err := metadata.UnpackCommandMetadata(req.Action.Value); err == nil {
+		return commandMetadata.ChannelId, commandMetadata.RotaName
+	}
+
+	if req.Action.Value != "" {
+		return req.ChannelId, req.Action.Value
+	}
+	return req.ChannelId, req.Action.SelectedOption.Value
+}
@@ -0,0 +1,66 @@
+package subcommand
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	leadingMention = regexp.MustCompile(`^.*?<@[^>]+>`)
+	// Slack clients often send curly apostrophes.
+	apostrophes = strings.NewReplacer("’", "'", "‘", "'")
+)
+
+// rotaGroup matches a rota name, quoted if it contains spaces.
+const rotaGroup = `(?:"(?P<quoted>[^"]+)"|(?P<rota>[^\s:,]+))`
+
+// intents map plain-English requests onto subcommands.
+var intents = []struct {
+	pattern *regexp.Regexp
+	name    string
+}{
+	{regexp.MustCompile(`(?i)^who(?:'s| is)? (?:on[- ]?call|on duty)(?: (?:for|in|on) ` + rotaGroup + `)?$`), Who},
+	{regexp.MustCompile(`(?i)^who(?:'s| is)? next (?:for|in|on) ` + rotaGroup + `$`), Next},
+	{regexp.MustCompile(`(?i)^when (?:am i|is my) (?:next|on[- ]?call|on duty|shift|next shift)(?: next)?$`), Mine},
+	{regexp.MustCompile(`(?i)^(?:what|which) rotas(?: are there)?$`), List},
+	{regexp.MustCompile(`(?i)^page (?:the )?(?:on[- ]?call|on duty)(?: (?:for|in|on) ` + rotaGroup + `)?(?:[:,-]? (?P<message>.+))?$`), Page},
+}
+
+// ParseMention reads a message that mentions the bot, such as
+// "@alfred who's on call for payments?". It accepts a few plain-English
+// requests as well as anything /rota does. A page request may leave out the
+// rota, for the caller to fill in.
+func ParseMention(text string) (*Subcommand, error) {
+	text = strings.TrimSpace(leadingMention.ReplaceAllString(text, ""))
+
+	request := strings.Join(strings.Fields(apostrophes.Replace(text)), " ")
+	question := strings.TrimRight(request, "?!. ")
+
+	for _, intent := range intents {
+		input := question
+		if intent.name == Page {
+			// Keep the message as it was written.
+			input = request
+		}
+
+		match := intent.pattern.FindStringSubmatch(input)
+		if match == nil {
+			continue
+		}
+
+		subcommand := &Subcommand{Name: intent.name}
+		for i, group := range intent.pattern.SubexpNames() {
+			switch group {
+			case "quoted", "rota":
+				if match[i] != "" {
+					subcommand.RotaName = match[i]
+				}
+			case "message":
+				subcommand.Message = match[i]
+			}
+		}
+		return subcommand, nil
+	}
+
+	return Parse(text)
+}
@@ -0,0 +1,282 @@
+package subcommand
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	List        = "list"
+	Show        = "show"
+	Start       = "start"
+	Stop        = "stop"
+	Next        = "next"
+	Who         = "who"
+	Mine        = "mine"
+	Page        = "page"
+	Alerts      = "alerts"
+	Webhooks    = "webhooks"
+	Subscribe   = "subscribe"
+	Unsubscribe = "unsubscribe"
+	Tokens      = "tokens"
+	Token       = "token"
+	RevokeToken = "revoke-token"
+	AddOwner    = "add-owner"
+	RemoveOwner = "remove-owner"
+	Approvals   = "approvals"
+	Admins      = "admins"
+	AddAdmin    = "add-admin"
+	RemoveAdmin = "remove-admin"
+	Help        = "help"
+)
+
+// ErrUnknown is returned for text that doesn't start with a known subcommand,
+// in which case callers fall back to the interactive prompt.
+var ErrUnknown = errors.New("unknown subcommand")
+
+var (
+	userMention = regexp.MustCompile(`^<@([^|>]+)(\|[^>]*)?>$`)
+	// Slack sends links as <url> or <url|label>.
+	link = regexp.MustCompile(`^<?(https?://[^|>\s]+)(\|[^>]*)?>?$`)
+)
+
+type Subcommand struct {
+	Name     string
+	RotaName string
+	UserId   string
+	Message  string
+	URL      string
+	Label    string
+	Enabled  bool
+}
+
+// UsageError explains why a known subcommand couldn't be parsed.
+type UsageError struct {
+	Name   string
+	Reason string
+}
+
+func (e *UsageError) Error() string {
+	return fmt.Sprintf("%s\nUsage: `%s`", e.Reason, specs[e.Name].usage)
+}
+
+type spec struct {
+	usage   string
+	summary string
+	// args lists the positional arguments: "rota", "user", "url", "label",
+	// "on|off", "rota?" for an optional rota name, or "message..." for the
+	// rest of the text.
+	args []string
+	// private subcommands reply with secrets, so they mustn't be answered
+	// where the whole channel can see.
+	private bool
+}
+
+var specs = map[string]spec{
+	List:        {usage: "/rota list", summary: "List the rotas in this channel"},
+	Show:        {usage: "/rota show <name>", summary: "Show a rota's members and current shift", args: []string{"rota"}},
+	Start:       {usage: "/rota start <name> @user", summary: "Start a rota with someone on duty", args: []string{"rota", "user"}},
+	Stop:        {usage: "/rota stop <name>", summary: "Stop a running rota", args: []string{"rota"}},
+	Next:        {usage: "/rota next <name>", summary: "Show who is on duty next, and when", args: []string{"rota"}},
+	Who:         {usage: "/rota who [name]", summary: "Show who is on duty for each rota, or just one", args: []string{"rota?"}},
+	Page:        {usage: "/rota page <name> <message>", summary: "Page whoever is on duty until they acknowledge", args: []string{"rota", "message..."}},
+	Alerts:      {usage: "/rota alerts <name>", summary: "Show where monitoring should send the rota's alerts", args: []string{"rota"}, private: true},
+	Webhooks:    {usage: "/rota webhooks <name>", summary: "List the rota's webhooks and their recent deliveries", args: []string{"rota"}},
+	Subscribe:   {usage: "/rota subscribe <name> <url>", summary: "Post the rota's changes to a webhook", args: []string{"rota", "url"}, private: true},
+	Unsubscribe: {usage: "/rota unsubscribe <name> <url>", summary: "Stop posting the rota's changes to a webhook", args: []string{"rota", "url"}},
+	Tokens:      {usage: "/rota tokens", summary: "List the REST API's tokens (admins only)"},
+	Token:       {usage: "/rota token <label>", summary: "Create a REST API token (admins only)", args: []string{"label"}, private: true},
+	RevokeToken: {usage: "/rota revoke-token <label>", summary: "Revoke a REST API token (admins only)", args: []string{"label"}},
+	AddOwner:    {usage: "/rota add-owner <name> @user", summary: "Let someone manage the rota (owners only)", args: []string{"rota", "user"}},
+	RemoveOwner: {usage: "/rota remove-owner <name> @user", summary: "Stop someone managing the rota (owners only)", args: []string{"rota", "user"}},
+	Approvals:   {usage: "/rota approvals <name> on|off", summary: "Choose whether changes to members and shifts need an owner's approval (owners only)", args: []string{"rota", "on|off"}},
+	Admins:      {usage: "/rota admins", summary: "List the admins, who can manage every rota"},
+	AddAdmin:    {usage: "/rota add-admin @user", summary: "Let someone manage every rota (admins only)", args: []string{"user"}},
+	RemoveAdmin: {usage: "/rota remove-admin @user", summary: "Stop someone managing every rota (admins only)", args: []string{"user"}},
+	Mine:        {usage: "/rota mine", summary: "Show your current or next shift in each of your rotas"},
+	Help:        {usage: "/rota help", summary: "Show this help"},
+}
+
+var order = []string{List, Show, Start, Stop, Next, Who, Page, Alerts, Webhooks, Subscribe, Unsubscribe, AddOwner, RemoveOwner, Approvals, Mine, Admins, AddAdmin, RemoveAdmin, Tokens, Token, RevokeToken, Help}
+
+// Parse reads the text of a /rota command. Empty text parses to nil. Rota names
+// containing spaces must be quoted, e.g. /rota show "on call".
+func Parse(text string) (*Subcommand, error) {
+	name, rest, ok, err := nextToken(text)
+	if err != nil {
+		return nil, ErrUnknown
+	}
+
+	if !ok {
+		return nil, nil
+	}
+
+	name = strings.ToLower(name)
+	s, ok := specs[name]
+	if !ok {
+		return nil, ErrUnknown
+	}
+
+	subcommand := &Subcommand{Name: name}
+	for _, a := range s.args {
+		optional := strings.HasSuffix(a, "?")
+		a = strings.TrimSuffix(a, "?")
+
+		var arg string
+		if a == "message..." {
+			// The message is everything left over, as typed.
+			arg = strings.TrimSpace(rest)
+			rest = ""
+			ok = arg != ""
+		} else {
+			arg, rest, ok, err = nextToken(rest)
+			if err != nil {
+				return nil, &UsageError{Name: name, Reason: err.Error()}
+			}
+		}
+
+		if !ok {
+			if optional {
+				continue
+			}
+			return nil, &UsageError{Name: name, Reason: "Not enough arguments."}
+		}
+
+		switch a {
+		case "rota":
+			subcommand.RotaName = arg
+		case "user":
+			match := userMention.FindStringSubmatch(arg)
+			if match == nil {
+				return nil, &UsageError{Name: name, Reason: fmt.Sprintf("I couldn't tell who %s is. Mention them with @.", arg)}
+			}
+			subcommand.UserId = match[1]
+		case "url":
+			match := link.FindStringSubmatch(arg)
+			if match == nil {
+				return nil, &UsageError{Name: name, Reason: fmt.Sprintf("%s isn't an http or https URL.", arg)}
+			}
+			subcommand.URL = match[1]
+		case "label":
+			subcommand.Label = arg
+		case "on|off":
+			switch strings.ToLower(arg) {
+			case "on":
+				subcommand.Enabled = true
+			case "off":
+			default:
+				return nil, &UsageError{Name: name, Reason: fmt.Sprintf("%s should be on or off.", arg)}
+			}
+		case "message...":
+			subcommand.Message = arg
+		}
+	}
+
+	if strings.TrimSpace(rest) != "" {
+		reason := "Too many arguments."
+		if len(s.args) > 0 && strings.HasPrefix(s.args[0], "rota") {
+			reason += " Rota names with spaces need quotes, e.g. \"on call\"."
+		}
+		return nil, &UsageError{Name: name, Reason: reason}
+	}
+
+	return subcommand, nil
+}
+
+// IsPrivate reports whether the subcommand's reply should only be shown to
+// whoever ran it.
+func IsPrivate(name string) bool {
+	return specs[name].private
+}
+
+// Tokenize splits text on whitespace, keeping quoted sections together.
+// Straight and curly double or single quotes are accepted, since Slack clients
+// often turn one into the other.
+func Tokenize(text string) ([]string, error) {
+	var tokens []string
+	for {
+		token, rest, ok, err := nextToken(text)
+		if err != nil {
+			return tokens, err
+		}
+
+		if !ok {
+			return tokens, nil
+		}
+
+		tokens = append(tokens, token)
+		text = rest
+	}
+}
+
+// nextToken reads one token from the start of text and returns the text after
+// it, or false if there are no tokens left. Quotes only open at the start of a
+// token and close at its end, so apostrophes, as in "Bob's" or 'Bob's', are
+// kept as they are.
+func nextToken(text string) (string, string, bool, error) {
+	var current strings.Builder
+	inToken := false
+	var closingQuote rune
+
+	for i, r := range text {
+		switch {
+		case closingQuote != 0:
+			if r == closingQuote && endsToken(text[i+utf8.RuneLen(r):]) {
+				closingQuote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case !inToken && (r == '"' || r == '\'' || r == '“' || r == '‘'):
+			closingQuote = matchingQuote(r)
+			inToken = true
+		case isSpace(r):
+			if inToken {
+				return current.String(), text[i:], true, nil
+			}
+		default:
+			current.WriteRune(r)
+			inToken = true
+		}
+	}
+
+	if closingQuote != 0 {
+		return current.String(), "", true, errors.New("A quote is missing its closing partner.")
+	}
+
+	return current.String(), "", inToken, nil
+}
+
+// endsToken reports whether rest, the text after a rune, starts a new token or
+// is empty.
+func endsToken(rest string) bool {
+	r, _ := utf8.DecodeRuneInString(rest)
+	return rest == "" || isSpace(r)
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n'
+}
+
+func matchingQuote(r rune) rune {
+	switch r {
+	case '“':
+		return '”'
+	case '‘':
+		return '’'
+	default:
+		return r
+	}
+}
+
+// Usage lists every subcommand, for /rota help.
+func Usage() string {
+	lines := []string{"*Usage*"}
+	for _, name := range order {
+		lines = append(lines, fmt.Sprintf("• `%s`: %s", specs[name].usage, specs[name].summary))
+	}
+	lines = append(lines, "• `/rota`: Pick a rota from a menu")
+	return strings.Join(lines, "\n")
+}
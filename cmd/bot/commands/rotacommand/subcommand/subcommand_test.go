@@ -0,0 +1,179 @@
+package subcommand
+
+import (
+	"errors"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestSubcommand(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Subcommand Suite")
+}
+
+var _ = Describe("Tokenize", func() {
+	It("Splits on whitespace", func() {
+		Expect(Tokenize("  show   payments ")).To(Equal([]string{"show", "payments"}))
+	})
+
+	It("Keeps quoted sections together", func() {
+		Expect(Tokenize(`show "on call" 'team a'`)).To(Equal([]string{"show", "on call", "team a"}))
+	})
+
+	It("Accepts curly quotes", func() {
+		Expect(Tokenize("show “on call”")).To(Equal([]string{"show", "on call"}))
+	})
+
+	It("Keeps an empty quoted argument", func() {
+		Expect(Tokenize(`show ""`)).To(Equal([]string{"show", ""}))
+	})
+
+	It("Complains about an unterminated quote", func() {
+		_, err := Tokenize(`show "on call`)
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("Only opens quotes at the start of a token", func() {
+		Expect(Tokenize(`show Bob's`)).To(Equal([]string{"show", "Bob's"}))
+		Expect(Tokenize("who is on Wai‘s rota")).To(Equal([]string{"who", "is", "on", "Wai‘s", "rota"}))
+		Expect(Tokenize(`show 'Bob's' 5"`)).To(Equal([]string{"show", "Bob's", `5"`}))
+	})
+
+	It("Only closes quotes at the end of a token", func() {
+		Expect(Tokenize("show ‘Wai’s rota’")).To(Equal([]string{"show", "Wai’s rota"}))
+		_, err := Tokenize(`show 'Bob's`)
+		Expect(err).ToNot(BeNil())
+	})
+})
+
+var _ = Describe("Parse", func() {
+	It("Returns nothing for empty text", func() {
+		cmd, err := Parse("   ")
+		Expect(err).To(BeNil())
+		Expect(cmd).To(BeNil())
+	})
+
+	It("Parses subcommands without arguments", func() {
+		Expect(Parse("list")).To(Equal(&Subcommand{Name: List}))
+		Expect(Parse("HELP")).To(Equal(&Subcommand{Name: Help}))
+		Expect(Parse("who")).To(Equal(&Subcommand{Name: Who}))
+	})
+
+	It("Parses a quoted rota name", func() {
+		Expect(Parse(`show "on call"`)).To(Equal(&Subcommand{Name: Show, RotaName: "on call"}))
+		Expect(Parse(`who "on call"`)).To(Equal(&Subcommand{Name: Who, RotaName: "on call"}))
+	})
+
+	It("Parses a URL, as Slack sends it or as typed", func() {
+		Expect(Parse("subscribe payments <https://example.com/hook>")).To(Equal(&Subcommand{Name: Subscribe, RotaName: "payments", URL: "https://example.com/hook"}))
+		Expect(Parse("unsubscribe payments <https://example.com/hook|example.com/hook>")).To(Equal(&Subcommand{Name: Unsubscribe, RotaName: "payments", URL: "https://example.com/hook"}))
+		Expect(Parse("subscribe payments http://localhost:9000")).To(Equal(&Subcommand{Name: Subscribe, RotaName: "payments", URL: "http://localhost:9000"}))
+
+		_, err := Parse("subscribe payments ftp://example.com")
+		Expect(err).To(MatchError(ContainSubstring("isn't an http or https URL")))
+	})
+
+	It("Parses a user mention", func() {
+		Expect(Parse("start payments <@U123ABC|evan>")).To(Equal(&Subcommand{Name: Start, RotaName: "payments", UserId: "U123ABC"}))
+		Expect(Parse("start payments <@W456>")).To(Equal(&Subcommand{Name: Start, RotaName: "payments", UserId: "W456"}))
+	})
+
+	It("Reports unknown input so the caller can fall back", func() {
+		_, err := Parse("payments")
+		Expect(errors.Is(err, ErrUnknown)).To(BeTrue())
+	})
+
+	It("Reports missing arguments with the usage", func() {
+		_, err := Parse("stop")
+		var usageErr *UsageError
+		Expect(errors.As(err, &usageErr)).To(BeTrue())
+		Expect(usageErr.Name).To(Equal(Stop))
+		Expect(err.Error()).To(ContainSubstring("/rota stop <name>"))
+	})
+
+	It("Accepts apostrophes in rota names", func() {
+		Expect(Parse("show Bob's")).To(Equal(&Subcommand{Name: Show, RotaName: "Bob's"}))
+	})
+
+	It("Suggests quotes when a rota name has spaces", func() {
+		_, err := Parse("show on call")
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("need quotes"))
+	})
+
+	It("Takes the rest of the text as the message", func() {
+		Expect(Parse(`page "on call" the DB's   down`)).To(Equal(&Subcommand{Name: Page, RotaName: "on call", Message: "the DB's   down"}))
+	})
+
+	It("Requires a message to page with", func() {
+		_, err := Parse("page payments")
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("/rota page <name> <message>"))
+	})
+
+	It("Rejects a user that isn't a mention", func() {
+		_, err := Parse("start payments @evan")
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("Mention them with @"))
+	})
+
+	It("Reports an unterminated quote as a usage error", func() {
+		_, err := Parse(`show "on call`)
+		var usageErr *UsageError
+		Expect(errors.As(err, &usageErr)).To(BeTrue())
+	})
+})
+
+var _ = Describe("Usage", func() {
+	It("Lists every subcommand", func() {
+		for _, name := range order {
+			Expect(Usage()).To(ContainSubstring(specs[name].usage))
+		}
+	})
+})
+
+var _ = Describe("ParseMention", func() {
+	It("Accepts anything /rota does", func() {
+		Expect(ParseMention(`<@UBOT> show "on call"`)).To(Equal(&Subcommand{Name: Show, RotaName: "on call"}))
+	})
+
+	It("Ignores text before the mention", func() {
+		Expect(ParseMention("hey <@UBOT> list")).To(Equal(&Subcommand{Name: List}))
+	})
+
+	It("Understands who is on call", func() {
+		Expect(ParseMention("<@UBOT> who's on call?")).To(Equal(&Subcommand{Name: Who}))
+		Expect(ParseMention("<@UBOT> Who’s on-call for Payments?")).To(Equal(&Subcommand{Name: Who, RotaName: "Payments"}))
+		Expect(ParseMention(`<@UBOT> who is on duty for "Team A"`)).To(Equal(&Subcommand{Name: Who, RotaName: "Team A"}))
+	})
+
+	It("Understands who is next", func() {
+		Expect(ParseMention("<@UBOT> who's next for payments")).To(Equal(&Subcommand{Name: Next, RotaName: "payments"}))
+	})
+
+	It("Understands when the user is next", func() {
+		Expect(ParseMention("<@UBOT> when am I next?")).To(Equal(&Subcommand{Name: Mine}))
+		Expect(ParseMention("<@UBOT>   When am I on call next")).To(Equal(&Subcommand{Name: Mine}))
+	})
+
+	It("Understands a request to page", func() {
+		Expect(ParseMention("<@UBOT> page on-call")).To(Equal(&Subcommand{Name: Page}))
+		Expect(ParseMention("<@UBOT> Page the on call for Payments: the DB's down!")).To(Equal(&Subcommand{Name: Page, RotaName: "Payments", Message: "the DB's down!"}))
+	})
+
+	It("Reads apostrophes as apostrophes", func() {
+		Expect(ParseMention("<@UBOT> show Wai’s")).To(Equal(&Subcommand{Name: Show, RotaName: "Wai’s"}))
+	})
+
+	It("Reports anything else as unknown", func() {
+		_, err := ParseMention("<@UBOT> make me a sandwich")
+		Expect(errors.Is(err, ErrUnknown)).To(BeTrue())
+	})
+
+	It("Passes on usage errors", func() {
+		_, err := ParseMention("<@UBOT> show")
+		var usageErr *UsageError
+		Expect(errors.As(err, &usageErr)).To(BeTrue())
+	})
+})
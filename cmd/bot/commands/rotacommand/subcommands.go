@@ -0,0 +1,201 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"alfred-bot/cmd/bot/commands/rotacommand/subcommand"
+	"alfred-bot/utils/formatter"
+	"fmt"
+	"github.com/slack-go/slack"
+	"strings"
+)
+
+// runSubcommand answers a parsed /rota subcommand. The returned attachment is
+// only shown to the user who ran the command; anything the whole channel needs
+// to know about, like a shift starting, is posted separately.
+func (c *RotaCommand) runSubcommand(channelId string, userId string, cmd *subcommand.Subcommand) (*slack.Attachment, error) {
+	switch cmd.Name {
+	case subcommand.List:
+		return c.listRotas(channelId)
+	case subcommand.Who:
+		return c.whoIsOnDuty(channelId, cmd.RotaName)
+	case subcommand.Mine:
+		return c.userShifts(userId)
+	case subcommand.Help:
+		return &slack.Attachment{Text: subcommand.Usage()}, nil
+	case subcommand.Page:
+		return c.pageSubcommand(channelId, userId, cmd.RotaName, cmd.Message)
+	case subcommand.Tokens:
+		return c.listAPITokens(userId)
+	case subcommand.Token:
+		return c.createAPIToken(userId, cmd.Label)
+	case subcommand.RevokeToken:
+		return c.revokeAPIToken(userId, cmd.Label)
+	case subcommand.Admins:
+		return c.listAdmins()
+	case subcommand.AddAdmin:
+		return c.addAdmin(userId, cmd.UserId)
+	case subcommand.RemoveAdmin:
+		return c.removeAdmin(userId, cmd.UserId)
+	}
+
+	rotaDetails, err := c.handler.GetRotaDetails(channelId, cmd.RotaName)
+	if err != nil {
+		return nil, err
+	}
+
+	if rotaDetails == nil {
+		return errorAttachment(fmt.Sprintf("I couldn't find a rota called %s in this channel. Try `/rota list`.", cmd.RotaName)), nil
+	}
+
+	if role, action, ok := c.subcommandRole(cmd.Name); ok {
+		if denied, err := c.checkRole(userId, role, rotaDetails, action); denied != nil || err != nil {
+			return denied, err
+		}
+	}
+
+	switch cmd.Name {
+	case subcommand.Show:
+		return c.rotaDetailsPrompt(userId, rotaDetails)
+	case subcommand.Start:
+		return c.startRotaSubcommand(rotaDetails, cmd.UserId)
+	case subcommand.Stop:
+		if rotaDetails.CurrOnCallMember == "" {
+			return errorAttachment(fmt.Sprintf("[%v] Can't stop a shift that has yet to start.", cmd.RotaName)), nil
+		}
+
+		return nil, c.stopShift(channelId, cmd.RotaName, rotaDetails.CurrOnCallMember)
+	case subcommand.Next:
+		return nextOnDuty(rotaDetails), nil
+	case subcommand.Alerts:
+		return c.alertsSubcommand(rotaDetails)
+	case subcommand.Webhooks:
+		return c.listWebhooks(rotaDetails)
+	case subcommand.Subscribe:
+		return c.subscribe(rotaDetails, cmd.URL)
+	case subcommand.Unsubscribe:
+		return c.unsubscribe(rotaDetails, cmd.URL)
+	case subcommand.AddOwner:
+		return c.addOwner(rotaDetails, cmd.UserId)
+	case subcommand.RemoveOwner:
+		return c.removeOwner(rotaDetails, cmd.UserId)
+	case subcommand.Approvals:
+		return c.setRequireApproval(rotaDetails, cmd.Enabled)
+	}
+
+	return nil, fmt.Errorf("unhandled subcommand %q", cmd.Name)
+}
+
+func (c *RotaCommand) listRotas(channelId string) (*slack.Attachment, error) {
+	rotaNames, err := c.handler.GetRotaNames(channelId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rotaNames) == 0 {
+		return &slack.Attachment{Text: "Looks like this channel does not have any rotas. Run `/rota` to create one."}, nil
+	}
+
+	lines := make([]string, 0, len(rotaNames))
+	for _, v := range rotaNames {
+		lines = append(lines, fmt.Sprintf("• %s", v))
+	}
+
+	return &slack.Attachment{Text: fmt.Sprintf("Rotas in this channel:\n%s", strings.Join(lines, "\n"))}, nil
+}
+
+func (c *RotaCommand) whoIsOnDuty(channelId string, rotaName string) (*slack.Attachment, error) {
+	rotaNames := []string{rotaName}
+	if rotaName == "" {
+		var err error
+		rotaNames, err = c.handler.GetRotaNames(channelId)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(rotaNames) == 0 {
+			return &slack.Attachment{Text: "Looks like this channel does not have any rotas. Run `/rota` to create one."}, nil
+		}
+	}
+
+	lines := make([]string, 0, len(rotaNames))
+	for _, v := range rotaNames {
+		rotaDetails, err := c.handler.GetRotaDetails(channelId, v)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case rotaDetails == nil:
+			return errorAttachment(fmt.Sprintf("I couldn't find a rota called %s in this channel. Try `/rota list`.", v)), nil
+		case rotaDetails.CurrOnCallMember == "":
+			lines = append(lines, fmt.Sprintf("[%v] No one is currently on duty.", v))
+		default:
+			lines = append(lines, fmt.Sprintf("[%v] %s is on duty until %v", v, formatter.AtUserId(rotaDetails.CurrOnCallMember), rotaDetails.EndOfShift))
+		}
+	}
+
+	return &slack.Attachment{Text: strings.Join(lines, "\n")}, nil
+}
+
+func (c *RotaCommand) userShifts(userId string) (*slack.Attachment, error) {
+	rotas, err := c.handler.GetUserRotas(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	sortRotas(rotas)
+
+	var lines []string
+	for _, v := range rotas {
+		if !v.HasMember(userId) {
+			continue
+		}
+
+		prefix := fmt.Sprintf("[%v] in <#%s>:", v.RotaName(), v.Pk)
+		shift, ok := nextShiftOf(v, userId)
+		switch {
+		case !ok:
+			lines = append(lines, fmt.Sprintf("%s the rota hasn't started.", prefix))
+		case v.CurrOnCallMember == userId:
+			lines = append(lines, fmt.Sprintf("%s you're on duty until %v", prefix, formatter.FormatTime(shift.EndOfShift)))
+		default:
+			lines = append(lines, fmt.Sprintf("%s your next shift starts on %v", prefix, formatter.FormatTime(shift.StartOfShift)))
+		}
+	}
+
+	if len(lines) == 0 {
+		return &slack.Attachment{Text: "You aren't in any rotas yet."}, nil
+	}
+
+	return &slack.Attachment{Text: strings.Join(lines, "\n")}, nil
+}
+
+func (c *RotaCommand) startRotaSubcommand(rotaDetails *rotadetails.RotaDetails, onCallMember string) (*slack.Attachment, error) {
+	channelId := rotaDetails.Pk
+	rotaName := rotaDetails.RotaName()
+
+	if unableToStartRotaErr := unableToStartRota(rotaDetails, rotaName); unableToStartRotaErr != "" {
+		return errorAttachment(unableToStartRotaErr), nil
+	}
+
+	if !rotaDetails.HasMember(onCallMember) {
+		return errorAttachment(fmt.Sprintf("[%v] %s isn't a member of this rota.", rotaName, formatter.AtUserId(onCallMember))), nil
+	}
+
+	startOfShift := c.clock.Now()
+	endOfShift := rotaDetails.EndOfFirstShift(startOfShift)
+
+	return nil, c.startShift(channelId, rotaName, onCallMember, formatter.FormatTime(startOfShift), formatter.FormatTime(endOfShift))
+}
+
+func nextOnDuty(rotaDetails *rotadetails.RotaDetails) *slack.Attachment {
+	rotaName := rotaDetails.RotaName()
+
+	if rotaDetails.CurrOnCallMember == "" {
+		return &slack.Attachment{Text: fmt.Sprintf("[%v] The rota hasn't started, so no one is up next.", rotaName)}
+	}
+
+	return &slack.Attachment{
+		Text: fmt.Sprintf("[%v] %s is on duty next, from %v", rotaName, formatter.AtUserId(rotaDetails.NextOnCallMember()), rotaDetails.EndOfShift),
+	}
+}
@@ -0,0 +1,145 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/models/apitoken"
+	"alfred-bot/utils/formatter"
+	"fmt"
+	"github.com/slack-go/slack"
+	"strings"
+)
+
+const apiTokenPrefix = "alfred_"
+
+// isWorkspaceAdmin reports whether Slack counts the user as an admin or owner
+// of the workspace.
+func (c *RotaCommand) isWorkspaceAdmin(userId string) (bool, error) {
+	user, err := c.client.GetUserInfo(userId)
+	if err != nil {
+		return false, err
+	}
+
+	return user.IsAdmin || user.IsOwner || user.IsPrimaryOwner, nil
+}
+
+// checkAPITokenAccess returns why the user can't manage API tokens, or nil if
+// they can.
+func (c *RotaCommand) checkAPITokenAccess(userId string) (*slack.Attachment, error) {
+	if !c.config.RestAPI {
+		return errorAttachment("The REST API is turned off. Set `REST_API=true` to turn it on."), nil
+	}
+
+	isAdmin, err := c.isAdmin(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isAdmin {
+		return errorAttachment("Only admins can manage REST API tokens."), nil
+	}
+
+	return nil, nil
+}
+
+func (c *RotaCommand) createAPIToken(userId string, label string) (*slack.Attachment, error) {
+	if denied, err := c.checkAPITokenAccess(userId); denied != nil || err != nil {
+		return denied, err
+	}
+
+	existing, err := c.findAPIToken(label)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing != nil {
+		return errorAttachment(fmt.Sprintf("There's already a token called %s. Revoke it first with `/rota revoke-token %s`.", label, label)), nil
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	token := apiTokenPrefix + secret
+	err = c.handler.SaveAPIToken(&apitoken.Token{
+		Pk:        apitoken.Key,
+		Sk:        apitoken.Hash(token),
+		Label:     label,
+		CreatedBy: userId,
+		CreatedAt: formatter.FormatTime(c.clock.Now()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	attachment := slack.Attachment{}
+	attachment.Text = fmt.Sprintf(
+		"Created the REST API token %s: `%s`\nSend it as `Authorization: Bearer <token>`. It won't be shown again, so keep it somewhere safe.",
+		label, token,
+	)
+	attachment.Color = "#4af030"
+
+	return &attachment, nil
+}
+
+func (c *RotaCommand) revokeAPIToken(userId string, label string) (*slack.Attachment, error) {
+	if denied, err := c.checkAPITokenAccess(userId); denied != nil || err != nil {
+		return denied, err
+	}
+
+	existing, err := c.findAPIToken(label)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing == nil {
+		return errorAttachment(fmt.Sprintf("There's no token called %s. Try `/rota tokens`.", label)), nil
+	}
+
+	err = c.handler.DeleteAPIToken(existing.Sk)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment := slack.Attachment{}
+	attachment.Text = fmt.Sprintf("Revoked the REST API token %s.", label)
+	attachment.Color = "#4af030"
+
+	return &attachment, nil
+}
+
+func (c *RotaCommand) listAPITokens(userId string) (*slack.Attachment, error) {
+	if denied, err := c.checkAPITokenAccess(userId); denied != nil || err != nil {
+		return denied, err
+	}
+
+	tokens, err := c.handler.GetAPITokens()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return &slack.Attachment{Text: "No REST API tokens yet. Create one with `/rota token <label>`."}, nil
+	}
+
+	lines := []string{"REST API tokens:"}
+	for _, v := range tokens {
+		lines = append(lines, fmt.Sprintf("• %s, created by %s on %v", v.Label, formatter.AtUserId(v.CreatedBy), v.CreatedAt))
+	}
+
+	return &slack.Attachment{Text: strings.Join(lines, "\n")}, nil
+}
+
+func (c *RotaCommand) findAPIToken(label string) (*apitoken.Token, error) {
+	tokens, err := c.handler.GetAPITokens()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range tokens {
+		if v.Label == label {
+			return v, nil
+		}
+	}
+
+	return nil, nil
+}
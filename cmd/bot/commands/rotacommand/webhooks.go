@@ -0,0 +1,452 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/models/history"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/rotadetails"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/webhook"
+	"alfred-bot/utils/formatter"
+	"alfred-bot/utils/metrics"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/slack-go/slack"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	webhookTimeout     = 10 * time.Second
+	recentDeliveries   = 10
+	maxDeliveryAttempt = 5
+)
+
+// webhookBackoff is how long to wait before each retry of a failed delivery.
+var webhookBackoff = []time.Duration{30 * time.Second, 2 * time.Minute, 10 * time.Minute, 30 * time.Minute}
+
+var errAddressNotAllowed = errors.New("webhooks can't be posted to private or local addresses")
+
+var webhookDeliveriesTotal = metrics.NewCounter(
+	"alfred_webhook_deliveries_total",
+	"Attempts to post rota events to webhooks, by outcome.",
+	"outcome",
+)
+
+// emit records an event in the rota's history and posts it to each of the
+// rota's webhooks in the background, so a slow or failing subscriber never holds
+// up a handover. The event describes the rota as it is after the change.
+func (c *RotaCommand) emit(event webhook.Event) {
+	logger := rotaLogger(event.ChannelId, event.RotaName).With("event", event.Type)
+
+	rotaDetails, err := c.handler.GetRotaDetails(event.ChannelId, event.RotaName)
+	if err != nil {
+		logger.Error("Could not load webhooks", "error", err)
+		return
+	}
+
+	if rotaDetails == nil {
+		return
+	}
+
+	event.Id, err = randomToken(8)
+	if err != nil {
+		logger.Error("Could not create event", "error", err)
+		return
+	}
+	now := c.clock.Now()
+	event.OnCallMember = rotaDetails.CurrOnCallMember
+	event.Members = rotaDetails.Members
+	event.StartOfShift = rfc3339(rotaDetails.StartOfShift)
+	event.EndOfShift = rfc3339(rotaDetails.EndOfShift)
+	event.OccurredAt = now.UTC().Format(time.RFC3339)
+
+	err = c.handler.SaveHistoryEntry(&history.Entry{
+		Pk:                   history.Key(event.ChannelId, event.RotaName),
+		Sk:                   history.SortKey(now.UnixNano(), event.Id),
+		Type:                 event.Type,
+		OnCallMember:         event.OnCallMember,
+		PreviousOnCallMember: event.PreviousOnCallMember,
+		Actor:                event.Actor,
+		Change:               event.Change,
+		RequestedBy:          event.RequestedBy,
+		At:                   event.OccurredAt,
+		ExpiresAt:            now.Add(history.Retention).Unix(),
+	})
+	if err != nil {
+		logger.Error("Could not record history", "error", err)
+	}
+
+	if len(rotaDetails.Webhooks) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("Could not encode event", "error", err)
+		return
+	}
+
+	for _, v := range rotaDetails.Webhooks {
+		subscription := v
+		c.background(func() { c.deliver(event, subscription, body, 1) })
+	}
+}
+
+// deliver makes one attempt to post an event, records it and, if it failed,
+// schedules the next attempt.
+func (c *RotaCommand) deliver(event webhook.Event, subscription webhook.Webhook, body []byte, attempt int) {
+	logger := rotaLogger(event.ChannelId, event.RotaName).With("event", event.Type, "url", subscription.URL, "attempt", attempt)
+
+	now := c.clock.Now()
+	delivery := &webhook.Delivery{
+		Pk:        webhook.DeliveryKey(event.ChannelId, event.RotaName),
+		Sk:        webhook.DeliverySortKey(now.UnixNano(), event.Id, attempt),
+		EventId:   event.Id,
+		EventType: event.Type,
+		URL:       subscription.URL,
+		Attempt:   attempt,
+		At:        formatter.FormatTime(now),
+		ExpiresAt: now.Add(webhook.DeliveryRetention).Unix(),
+	}
+
+	var err error
+	delivery.StatusCode, err = c.post(subscription, event, body, now)
+	if err == nil && (delivery.StatusCode < 200 || delivery.StatusCode > 299) {
+		err = fmt.Errorf("unexpected status %d", delivery.StatusCode)
+	}
+
+	switch {
+	case err == nil:
+		delivery.Outcome = webhook.OutcomeDelivered
+	case attempt < maxDeliveryAttempt:
+		delivery.Outcome = webhook.OutcomeRetrying
+		delivery.Error = err.Error()
+	default:
+		delivery.Outcome = webhook.OutcomeFailed
+		delivery.Error = err.Error()
+	}
+
+	webhookDeliveriesTotal.Inc(delivery.Outcome)
+	if err != nil {
+		logger.Warn("Could not deliver webhook", "outcome", delivery.Outcome, "error", err)
+	}
+
+	err = c.handler.SaveDelivery(delivery)
+	if err != nil {
+		logger.Error("Could not record webhook delivery", "error", err)
+	}
+
+	retryId := webhook.RetryId(event.Id, subscription.URL)
+	if delivery.Outcome == webhook.OutcomeRetrying {
+		next := now.Add(webhookBackoff[attempt-1])
+		r := &webhook.Retry{
+			Pk:            webhook.RetryKey,
+			Sk:            retryId,
+			Body:          string(body),
+			URL:           subscription.URL,
+			Attempt:       attempt + 1,
+			NextAttemptAt: formatter.FormatTime(next),
+		}
+
+		// Retry even if it can't be stored; it just won't survive a restart.
+		if err := c.handler.SaveWebhookRetry(r); err != nil {
+			logger.Error("Could not store webhook retry", "error", err)
+		}
+
+		c.scheduleRetry(r, next)
+		return
+	}
+
+	if attempt > 1 {
+		if err := c.handler.DeleteWebhookRetry(retryId); err != nil {
+			logger.Error("Could not remove webhook retry", "error", err)
+		}
+	}
+}
+
+// resumeWebhookRetries schedules the deliveries that were waiting to be
+// retried when the bot last stopped. Those whose rota or webhook has gone in the
+// meantime are given up on straight away.
+func (c *RotaCommand) resumeWebhookRetries() {
+	retries, err := c.handler.GetWebhookRetries()
+	if err != nil {
+		slog.Error("Could not load webhook retries", "error", err)
+	}
+
+	for _, r := range retries {
+		if _, _, ok := c.retryTarget(r); !ok {
+			continue
+		}
+
+		at := c.clock.Now()
+		if nextAttemptAt, err := formatter.ParseTime(r.NextAttemptAt); err == nil && nextAttemptAt.After(at) {
+			at = nextAttemptAt
+		}
+
+		c.scheduleRetry(r, at)
+	}
+}
+
+func (c *RotaCommand) scheduleRetry(r *webhook.Retry, at time.Time) {
+	c.scheduler.Schedule("webhook:"+r.Sk, at, func() {
+		// The scheduler runs jobs one at a time, so don't make it wait on
+		// the network.
+		c.background(func() {
+			if event, subscription, ok := c.retryTarget(r); ok {
+				c.deliver(event, subscription, []byte(r.Body), r.Attempt)
+			}
+		})
+	})
+}
+
+// retryTarget finds the event a stored delivery is for and the webhook to post
+// it to, with its current secret. If the delivery can't be made, it's given up
+// on, or tried again later if the webhook couldn't be looked up.
+func (c *RotaCommand) retryTarget(r *webhook.Retry) (webhook.Event, webhook.Webhook, bool) {
+	logger := slog.With("retry_id", r.Sk, "url", r.URL, "attempt", r.Attempt)
+
+	var event webhook.Event
+	err := json.Unmarshal([]byte(r.Body), &event)
+	if err != nil {
+		logger.Error("Could not decode webhook retry, giving up", "error", err)
+		c.dropRetry(r, logger)
+		return event, webhook.Webhook{}, false
+	}
+
+	rotaDetails, err := c.handler.GetRotaDetails(event.ChannelId, event.RotaName)
+	if err != nil {
+		logger.Error("Could not load webhooks", "error", err)
+		c.scheduleRetry(r, c.clock.Now().Add(webhookBackoff[0]))
+		return event, webhook.Webhook{}, false
+	}
+
+	if rotaDetails != nil {
+		for _, v := range rotaDetails.Webhooks {
+			if v.URL == r.URL {
+				return event, v, true
+			}
+		}
+	}
+
+	c.giveUp(event, r, "the webhook was removed")
+	return event, webhook.Webhook{}, false
+}
+
+// giveUp records that a stored delivery won't be retried.
+func (c *RotaCommand) giveUp(event webhook.Event, r *webhook.Retry, reason string) {
+	logger := rotaLogger(event.ChannelId, event.RotaName).With("event", event.Type, "url", r.URL, "attempt", r.Attempt)
+
+	now := c.clock.Now()
+	webhookDeliveriesTotal.Inc(webhook.OutcomeFailed)
+	err := c.handler.SaveDelivery(&webhook.Delivery{
+		Pk:        webhook.DeliveryKey(event.ChannelId, event.RotaName),
+		Sk:        webhook.DeliverySortKey(now.UnixNano(), event.Id, r.Attempt),
+		EventId:   event.Id,
+		EventType: event.Type,
+		URL:       r.URL,
+		Attempt:   r.Attempt,
+		At:        formatter.FormatTime(now),
+		Outcome:   webhook.OutcomeFailed,
+		Error:     reason,
+		ExpiresAt: now.Add(webhook.DeliveryRetention).Unix(),
+	})
+	if err != nil {
+		logger.Error("Could not record webhook delivery", "error", err)
+	}
+
+	c.dropRetry(r, logger)
+}
+
+func (c *RotaCommand) dropRetry(r *webhook.Retry, logger *slog.Logger) {
+	if err := c.handler.DeleteWebhookRetry(r.Sk); err != nil {
+		logger.Error("Could not remove webhook retry", "error", err)
+	}
+}
+
+func (c *RotaCommand) post(subscription webhook.Webhook, event webhook.Event, body []byte, now time.Time) (int, error) {
+	request, err := http.NewRequest(http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(webhook.EventHeader, event.Type)
+	request.Header.Set(webhook.DeliveryHeader, event.Id)
+	request.Header.Set(webhook.TimestampHeader, timestamp)
+	request.Header.Set(webhook.SignatureHeader, webhook.Sign(subscription.Secret, timestamp, body))
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	return response.StatusCode, nil
+}
+
+// newWebhookClient builds the client that posts to webhooks. Addresses are
+// checked as they're connected to, since a webhook's host may resolve somewhere
+// else by then than when it was subscribed, and redirects aren't followed, so a
+// subscriber can't send the bot anywhere it couldn't subscribe.
+func (c *RotaCommand) newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(_ string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip := net.ParseIP(host)
+			if ip == nil || !c.webhookAddressAllowed(ip) {
+				return errAddressNotAllowed
+			}
+
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: webhookTimeout,
+		// No proxy, since the address checked would be the proxy's rather than
+		// the webhook's.
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// publicAddress reports whether ip is on the internet, rather than the bot's
+// own host or network.
+func publicAddress(ip net.IP) bool {
+	return !ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsUnspecified()
+}
+
+// checkWebhookURL returns why webhooks can't be posted to url, if they can't.
+func (c *RotaCommand) checkWebhookURL(url string) string {
+	parsed, err := neturl.Parse(url)
+	if err != nil || parsed.Hostname() == "" {
+		return fmt.Sprintf("%s isn't a valid URL.", url)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, parsed.Hostname())
+	if err != nil {
+		return fmt.Sprintf("I couldn't find %s.", parsed.Hostname())
+	}
+
+	for _, v := range addresses {
+		if !c.webhookAddressAllowed(v.IP) {
+			return fmt.Sprintf("Webhooks can't be posted to %s, since it's a private or local address.", parsed.Hostname())
+		}
+	}
+
+	return ""
+}
+
+// rfc3339 converts a stored time into the format other tools expect, or
+// returns an empty string if there isn't one.
+func rfc3339(formattedTime string) string {
+	t, err := formatter.ParseTime(formattedTime)
+	if err != nil {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// subscribe adds a webhook to the rota, or gives an existing one a new secret.
+func (c *RotaCommand) subscribe(rotaDetails *rotadetails.RotaDetails, url string) (*slack.Attachment, error) {
+	if reason := c.checkWebhookURL(url); reason != "" {
+		return errorAttachment(fmt.Sprintf("[%v] %s", rotaDetails.RotaName(), reason)), nil
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := append(webhook.Without(rotaDetails.Webhooks, url), webhook.Webhook{URL: url, Secret: secret})
+	err = c.handler.SaveWebhooks(rotaDetails.Pk, rotaDetails.RotaName(), webhooks)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment := slack.Attachment{}
+	attachment.Text = fmt.Sprintf(
+		"[%v] Changes to the rota will be posted to %s. Requests are signed with the secret `%s`: the `%s` header holds the hex HMAC-SHA256 of `<%s>.<body>`.",
+		rotaDetails.RotaName(), url, secret, webhook.SignatureHeader, webhook.TimestampHeader,
+	)
+	attachment.Color = "#4af030"
+
+	return &attachment, nil
+}
+
+func (c *RotaCommand) unsubscribe(rotaDetails *rotadetails.RotaDetails, url string) (*slack.Attachment, error) {
+	webhooks := webhook.Without(rotaDetails.Webhooks, url)
+	if len(webhooks) == len(rotaDetails.Webhooks) {
+		return errorAttachment(fmt.Sprintf("[%v] %s isn't subscribed to the rota.", rotaDetails.RotaName(), url)), nil
+	}
+
+	err := c.handler.SaveWebhooks(rotaDetails.Pk, rotaDetails.RotaName(), webhooks)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment := slack.Attachment{}
+	attachment.Text = fmt.Sprintf("[%v] Changes to the rota will no longer be posted to %s.", rotaDetails.RotaName(), url)
+	attachment.Color = "#4af030"
+
+	return &attachment, nil
+}
+
+// listWebhooks shows the rota's webhooks and how its latest deliveries went.
+func (c *RotaCommand) listWebhooks(rotaDetails *rotadetails.RotaDetails) (*slack.Attachment, error) {
+	if len(rotaDetails.Webhooks) == 0 {
+		return &slack.Attachment{Text: fmt.Sprintf("[%v] No webhooks yet. Add one with `/rota subscribe %s <url>`.", rotaDetails.RotaName(), rotaDetails.RotaName())}, nil
+	}
+
+	lines := []string{fmt.Sprintf("[%v] Webhooks:", rotaDetails.RotaName())}
+	for _, v := range rotaDetails.Webhooks {
+		lines = append(lines, fmt.Sprintf("• %s", v.URL))
+	}
+
+	deliveries, err := c.handler.GetDeliveries(rotaDetails.Pk, rotaDetails.RotaName(), recentDeliveries)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(deliveries) > 0 {
+		lines = append(lines, "Recent deliveries:")
+	}
+	for _, v := range deliveries {
+		result := v.Outcome
+		if v.Error != "" {
+			result += ": " + v.Error
+		}
+		lines = append(lines, fmt.Sprintf("• %s %s to %s, attempt %d: %s", v.At, v.EventType, v.URL, v.Attempt, result))
+	}
+
+	return &slack.Attachment{Text: strings.Join(lines, "\n")}, nil
+}
@@ -0,0 +1,287 @@
+package rotacommand
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand/handler"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/history"
+	"alfred-bot/cmd/bot/commands/rotacommand/models/webhook"
+	"alfred-bot/utils/clock"
+	"encoding/json"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/slack-go/slack"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+var _ = Describe("Webhooks", func() {
+	var fakeClock *clock.Fake
+	var store *handler.MemoryHandler
+	var mockSlackClient *MockSlackClient
+	var rotaCommand *RotaCommand
+	var stop func()
+	var server *httptest.Server
+	// The server records what it's sent, so the specs can check it from their
+	// own goroutine.
+	var mu sync.Mutex
+	var headers []http.Header
+	var bodies [][]byte
+	var failures int
+
+	startOfShift := time.Date(2022, time.March, 7, 9, 0, 0, 0, time.UTC)
+
+	events := func() []webhook.Event {
+		mu.Lock()
+		sent := append([]http.Header{}, headers...)
+		payloads := append([][]byte{}, bodies...)
+		mu.Unlock()
+
+		var events []webhook.Event
+		for i, header := range sent {
+			rotaDetails, err := store.GetRotaDetails(testChannelId, testRotaName)
+			Expect(err).To(BeNil())
+			Expect(webhook.Verify(rotaDetails.Webhooks[0].Secret, header.Get(webhook.TimestampHeader), payloads[i], header.Get(webhook.SignatureHeader))).To(BeTrue())
+
+			var event webhook.Event
+			Expect(json.Unmarshal(payloads[i], &event)).To(Succeed())
+			Expect(header.Get(webhook.EventHeader)).To(Equal(event.Type))
+			events = append(events, event)
+		}
+		return events
+	}
+
+	run := func(text string) string {
+		payload, err := rotaCommand.Prompt(slack.SlashCommand{ChannelID: testChannelId, UserID: "Evan", Text: text})
+		Expect(err).To(BeNil())
+		attachment, _ := payload.(*slack.Attachment)
+		if attachment == nil {
+			return ""
+		}
+		return attachment.Text
+	}
+
+	subscribe := func() {
+		Expect(run("subscribe " + testRotaName + " <" + server.URL + ">")).To(ContainSubstring("will be posted to " + server.URL))
+
+		rotaDetails, err := store.GetRotaDetails(testChannelId, testRotaName)
+		Expect(err).To(BeNil())
+		Expect(rotaDetails.Webhooks).To(HaveLen(1))
+	}
+
+	start := func() {
+		rotaCommand = New(store, mockSlackClient, fakeClock, testRotaConfig)
+		// The test server is on localhost.
+		rotaCommand.webhookAddressAllowed = func(net.IP) bool { return true }
+		stop = runInBackground(rotaCommand)
+	}
+
+	retries := func() []*webhook.Retry {
+		r, err := store.GetWebhookRetries()
+		Expect(err).To(BeNil())
+		return r
+	}
+
+	BeforeEach(func() {
+		headers = nil
+		bodies = nil
+		failures = 0
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if failures > 0 {
+				failures--
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			headers = append(headers, r.Header.Clone())
+			bodies = append(bodies, body)
+		}))
+		DeferCleanup(server.Close)
+
+		fakeClock = clock.NewFake(startOfShift)
+		store = handler.NewMemoryHandler(fakeClock)
+		mockSlackClient = &MockSlackClient{}
+		start()
+
+		Expect(store.SaveRotaDetails(testChannelId, testRotaName, []string{"Evan", "Sia"}, "1")).To(Succeed())
+	})
+
+	AfterEach(func() {
+		stop()
+	})
+
+	It("Posts signed events for shifts starting, handing over and stopping", func() {
+		subscribe()
+
+		run("start " + testRotaName + " <@Evan>")
+		Eventually(events).Should(HaveLen(1))
+
+		fakeClock.Advance(7 * 24 * time.Hour)
+		Eventually(events).Should(HaveLen(2))
+
+		run("stop " + testRotaName)
+		Eventually(events).Should(HaveLen(3))
+
+		received := events()
+		Expect(received[0].Type).To(Equal(webhook.EventStart))
+		Expect(received[0].OnCallMember).To(Equal("Evan"))
+		Expect(received[0].Members).To(Equal([]string{"Evan", "Sia"}))
+		Expect(received[0].StartOfShift).To(Equal("2022-03-07T09:00:00Z"))
+		Expect(received[0].EndOfShift).To(Equal("2022-03-14T09:00:00Z"))
+
+		Expect(received[1].Type).To(Equal(webhook.EventHandover))
+		Expect(received[1].OnCallMember).To(Equal("Sia"))
+		Expect(received[1].PreviousOnCallMember).To(Equal("Evan"))
+
+		Expect(received[2].Type).To(Equal(webhook.EventStop))
+		Expect(received[2].OnCallMember).To(BeEmpty())
+		Expect(received[2].PreviousOnCallMember).To(Equal("Sia"))
+	})
+
+	It("Retries failed deliveries with backoff and logs each attempt", func() {
+		subscribe()
+		mu.Lock()
+		failures = 2
+		mu.Unlock()
+
+		run("start " + testRotaName + " <@Evan>")
+		Eventually(func() string { return run("webhooks " + testRotaName) }).Should(ContainSubstring("attempt 1: retrying: unexpected status 503"))
+
+		fakeClock.Advance(webhookBackoff[0])
+		Eventually(func() string { return run("webhooks " + testRotaName) }).Should(ContainSubstring("attempt 2: retrying"))
+		Consistently(events, 50*time.Millisecond).Should(BeEmpty())
+
+		fakeClock.Advance(webhookBackoff[1])
+		Eventually(events).Should(HaveLen(1))
+		Eventually(func() string { return run("webhooks " + testRotaName) }).Should(ContainSubstring("attempt 3: delivered"))
+	})
+
+	It("Resumes retries after a restart", func() {
+		subscribe()
+		mu.Lock()
+		failures = 1
+		mu.Unlock()
+
+		run("start " + testRotaName + " <@Evan>")
+		Eventually(retries).Should(HaveLen(1))
+		Expect(retries()[0].Attempt).To(Equal(2))
+
+		stop()
+		start()
+
+		fakeClock.Advance(webhookBackoff[0])
+		Eventually(events).Should(HaveLen(1))
+		Expect(events()[0].Type).To(Equal(webhook.EventStart))
+		Eventually(retries).Should(BeEmpty())
+		Expect(run("webhooks " + testRotaName)).To(ContainSubstring("attempt 2: delivered"))
+	})
+
+	It("Gives up on retries whose webhook was removed while the bot was down", func() {
+		subscribe()
+		mu.Lock()
+		failures = 1
+		mu.Unlock()
+
+		run("start " + testRotaName + " <@Evan>")
+		Eventually(retries).Should(HaveLen(1))
+
+		stop()
+		Expect(store.SaveWebhooks(testChannelId, testRotaName, nil)).To(Succeed())
+		start()
+
+		Eventually(retries).Should(BeEmpty())
+		deliveries, err := store.GetDeliveries(testChannelId, testRotaName, 10)
+		Expect(err).To(BeNil())
+		Expect(deliveries[0].Attempt).To(Equal(2))
+		Expect(deliveries[0].Outcome).To(Equal(webhook.OutcomeFailed))
+		Expect(deliveries[0].Error).To(Equal("the webhook was removed"))
+
+		fakeClock.Advance(webhookBackoff[0])
+		Consistently(events, 50*time.Millisecond).Should(BeEmpty())
+	})
+
+	It("Lets old deliveries and history expire", func() {
+		subscribe()
+		run("start " + testRotaName + " <@Evan>")
+		Eventually(events).Should(HaveLen(1))
+
+		deliveries := func() []*webhook.Delivery {
+			d, err := store.GetDeliveries(testChannelId, testRotaName, 10)
+			Expect(err).To(BeNil())
+			return d
+		}
+		Eventually(deliveries).Should(HaveLen(1))
+		Expect(deliveries()[0].ExpiresAt).To(Equal(startOfShift.Add(webhook.DeliveryRetention).Unix()))
+
+		entries, err := store.GetHistory(testChannelId, testRotaName, 10)
+		Expect(err).To(BeNil())
+		Expect(entries[0].ExpiresAt).To(Equal(startOfShift.Add(history.Retention).Unix()))
+	})
+
+	It("Stops posting once unsubscribed", func() {
+		subscribe()
+		Expect(run("unsubscribe " + testRotaName + " " + server.URL)).To(ContainSubstring("will no longer be posted"))
+
+		run("start " + testRotaName + " <@Evan>")
+		Consistently(events, 50*time.Millisecond).Should(BeEmpty())
+		Expect(run("webhooks " + testRotaName)).To(ContainSubstring("No webhooks yet"))
+	})
+
+	It("Won't reveal a secret in a channel thread", func() {
+		Expect(rotaCommand.HandleMention(testChannelId, "Evan", "<@UBOT> subscribe "+testRotaName+" <"+server.URL+">", "123.456")).To(Succeed())
+		Expect(mockSlackClient.Replies["123.456"][0]).To(ContainSubstring("Run `/rota subscribe` instead"))
+
+		rotaDetails, err := store.GetRotaDetails(testChannelId, testRotaName)
+		Expect(err).To(BeNil())
+		Expect(rotaDetails.Webhooks).To(BeEmpty())
+	})
+
+	It("Won't post to the bot's own network", func() {
+		rotaCommand.webhookAddressAllowed = publicAddress
+
+		Expect(run("subscribe " + testRotaName + " <http://169.254.169.254/latest/meta-data>")).To(ContainSubstring("private or local address"))
+		Expect(run("subscribe " + testRotaName + " <" + server.URL + ">")).To(ContainSubstring("private or local address"))
+
+		rotaDetails, err := store.GetRotaDetails(testChannelId, testRotaName)
+		Expect(err).To(BeNil())
+		Expect(rotaDetails.Webhooks).To(BeEmpty())
+	})
+
+	It("Checks addresses again when posting, in case the host has moved", func() {
+		subscribe()
+		rotaCommand.webhookAddressAllowed = publicAddress
+
+		run("start " + testRotaName + " <@Evan>")
+		Eventually(func() string { return run("webhooks " + testRotaName) }).Should(ContainSubstring(errAddressNotAllowed.Error()))
+		Expect(events()).To(BeEmpty())
+	})
+
+	It("Doesn't follow redirects", func() {
+		redirect := httptest.NewServer(http.RedirectHandler(server.URL, http.StatusFound))
+		DeferCleanup(redirect.Close)
+		Expect(run("subscribe " + testRotaName + " <" + redirect.URL + ">")).To(ContainSubstring("will be posted to"))
+
+		run("start " + testRotaName + " <@Evan>")
+		Eventually(func() string { return run("webhooks " + testRotaName) }).Should(ContainSubstring("unexpected status 302"))
+		Expect(events()).To(BeEmpty())
+	})
+
+	It("Only posts to public addresses", func() {
+		for _, v := range []string{"127.0.0.1", "::1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "fe80::1", "fd00::1", "0.0.0.0"} {
+			Expect(publicAddress(net.ParseIP(v))).To(BeFalse(), v)
+		}
+		for _, v := range []string{"203.0.113.10", "8.8.8.8", "2001:4860:4860::8888"} {
+			Expect(publicAddress(net.ParseIP(v))).To(BeTrue(), v)
+		}
+	})
+})
@@ -0,0 +1,165 @@
+package bot
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand"
+	"alfred-bot/config"
+	"alfred-bot/utils/clock"
+	"alfred-bot/utils/db"
+	"alfred-bot/utils/fakeslack"
+	"context"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/slack-go/slack"
+	"net"
+	"net/url"
+	"time"
+)
+
+// reachable reports whether something is listening at endpoint, such as
+// DynamoDB Local.
+func reachable(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+
+	conn, err := net.DialTimeout("tcp", u.Host, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+
+	return true
+}
+
+var _ = Describe("End to end", func() {
+	const channelId = "C0123"
+	const owner = "U0001"
+
+	var fakeClock *clock.Fake
+	var fake *fakeslack.Server
+
+	// lastView is the latest modal or Home tab the bot showed.
+	lastView := func() fakeslack.View {
+		views := fake.Views()
+		if len(views) == 0 {
+			return fakeslack.View{}
+		}
+		return views[len(views)-1]
+	}
+
+	// Interactions are acknowledged before they're handled, so wait for what
+	// they do.
+	callbackId := func() string { return lastView().View.CallbackID }
+	ephemerals := func() int { return len(fake.Ephemerals()) }
+	messages := func() []string { return fake.MessagesTo(channelId) }
+
+	BeforeEach(func() {
+		fakeClock = clock.NewFake(time.Date(2022, time.March, 7, 9, 0, 0, 0, time.UTC))
+		fake = fakeslack.New()
+		DeferCleanup(fake.Close)
+
+		cfg, err := config.LoadForTesting()
+		Expect(err).To(BeNil())
+		if !reachable(cfg.DB.Endpoint) {
+			Skip("DynamoDB isn't running at " + cfg.DB.Endpoint)
+		}
+
+		// The handler tests drop their table as they go, and may run alongside.
+		cfg.DB.TableName += "-e2e"
+		database, err := db.New(cfg.DB)
+		Expect(err).To(BeNil())
+		DeferCleanup(database.DeleteTable)
+
+		cfg.Slack = config.SlackConfig{
+			AuthToken: "xoxb-test",
+			AppToken:  "xapp-test",
+			Transport: config.TransportSocket,
+			APIURL:    fake.APIURL(),
+		}
+		cfg.HTTPAddr = ""
+		cfg.ShutdownTimeout = 5 * time.Second
+		cfg.EventWorkers = 2
+		cfg.Rota = config.RotaConfig{
+			PageAckTimeout: 5 * time.Minute,
+			EditRole:       config.RoleOwners,
+			StartRole:      config.RoleMembers,
+			SwapRole:       config.RoleMembers,
+		}
+
+		newClock = func() clock.Clock { return fakeClock }
+		DeferCleanup(func() {
+			newClock = clock.New
+		})
+
+		b, err := New(cfg)
+		Expect(err).To(BeNil())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan error, 1)
+		go func() {
+			stopped <- b.Start(ctx)
+		}()
+		DeferCleanup(func() {
+			cancel()
+			Eventually(stopped, 10*time.Second).Should(Receive(BeNil()))
+		})
+	})
+
+	It("Creates a rota, starts it and hands over", func() {
+		response, err := fake.SlashCommand(slack.SlashCommand{Command: "/rota", ChannelID: channelId, UserID: owner})
+		Expect(err).To(BeNil())
+		Expect(string(response)).To(ContainSubstring("Looks like this channel does not have any rotas."))
+
+		err = fake.BlockAction(channelId, owner, slack.BlockAction{ActionID: rotacommand.CreateRotaPromptAction})
+		Expect(err).To(BeNil())
+		Eventually(callbackId).Should(Equal(rotacommand.CreateRotaCallback))
+		createModal := lastView().View
+
+		err = fake.Submit(owner, createModal, fakeslack.Fill(createModal, "Support", "U0001,U0002", "1"))
+		Expect(err).To(BeNil())
+		Eventually(ephemerals).Should(Equal(1))
+		Expect(fake.Ephemerals()[0].User).To(Equal(owner))
+
+		err = fake.BlockAction(channelId, owner, slack.BlockAction{ActionID: rotacommand.StartRotaAction, Value: "Support"})
+		Expect(err).To(BeNil())
+		Eventually(callbackId).Should(Equal(rotacommand.StartRotaCallback))
+		startModal := lastView().View
+
+		err = fake.Submit(owner, startModal, fakeslack.Fill(startModal, "U0001"))
+		Expect(err).To(BeNil())
+		Eventually(messages).Should(Equal([]string{"[Support] <@U0001> is now on duty!"}))
+
+		// Handovers are announced in the background, through the outbox.
+		fakeClock.Advance(7 * 24 * time.Hour)
+		Eventually(messages).Should(Equal([]string{
+			"[Support] <@U0001> is now on duty!",
+			"[Support] <@U0002> now on duty!",
+		}))
+
+		err = fake.Event(map[string]string{"type": "app_home_opened", "user": "U0002", "tab": "home"})
+		Expect(err).To(BeNil())
+		Eventually(func() string { return lastView().UserId }).Should(Equal("U0002"))
+		Expect(lastView().View.Type).To(Equal(slack.VTHomeTab))
+	})
+
+	It("Tells users when they aren't allowed to do something", func() {
+		_, err := fake.SlashCommand(slack.SlashCommand{Command: "/rota", ChannelID: channelId, UserID: owner})
+		Expect(err).To(BeNil())
+		err = fake.BlockAction(channelId, owner, slack.BlockAction{ActionID: rotacommand.CreateRotaPromptAction})
+		Expect(err).To(BeNil())
+		Eventually(callbackId).Should(Equal(rotacommand.CreateRotaCallback))
+		createModal := lastView().View
+		err = fake.Submit(owner, createModal, fakeslack.Fill(createModal, "Support", "U0001,U0002", "1"))
+		Expect(err).To(BeNil())
+		Eventually(ephemerals).Should(Equal(1))
+
+		// Only members may start the rota's shifts.
+		err = fake.BlockAction(channelId, "U0003", slack.BlockAction{ActionID: rotacommand.StartRotaAction, Value: "Support"})
+		Expect(err).To(BeNil())
+		Eventually(ephemerals).Should(Equal(2))
+		Expect(fake.Ephemerals()[1].User).To(Equal("U0003"))
+		Expect(fake.Views()).To(HaveLen(1))
+		Expect(fake.MessagesTo(channelId)).To(BeEmpty())
+	})
+})
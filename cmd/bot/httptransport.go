@@ -0,0 +1,115 @@
+package bot
+
+import (
+	"alfred-bot/cmd/bot/router"
+	"encoding/json"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// With the http transport, Slack sends the bot's events, slash commands and
+// interactions to these paths, as set in the app's config. Each request must
+// be signed with the app's signing secret.
+const (
+	EventsPath        = "/slack/events"
+	CommandsPath      = "/slack/commands"
+	InteractivityPath = "/slack/interactivity"
+)
+
+// eventsHandler acknowledges Events API events straight away and handles them
+// afterwards, as Socket Mode does, since Slack retries events that take more
+// than three seconds. Events that arrive while the bot is shutting down are
+// turned away, for Slack to retry.
+func (b *Bot) eventsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "could not read event", http.StatusBadRequest)
+			return
+		}
+
+		// The request's signature has been checked, so there's no need for the
+		// deprecated verification token as well.
+		event, err := slackevents.ParseEvent(body, slackevents.OptionNoVerifyToken())
+		if err != nil {
+			slog.Warn("Could not parse event", "error", err)
+			http.Error(w, "could not parse event", http.StatusBadRequest)
+			return
+		}
+
+		// Slack checks the URL works when it's saved in the app's config.
+		if event.Type == slackevents.URLVerification {
+			var challenge slackevents.ChallengeResponse
+			if err := json.Unmarshal(body, &challenge); err != nil {
+				http.Error(w, "could not parse challenge", http.StatusBadRequest)
+				return
+			}
+
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte(challenge.Challenge))
+			return
+		}
+
+		if req := router.NewEventRequest(event); req != nil {
+			if err := b.submit(req, nil); err != nil {
+				http.Error(w, "shutting down", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// commandsHandler answers slash commands with the same payload Socket Mode
+// acknowledges them with, which is an error message if they failed, or a
+// holding message if the answer isn't ready in time.
+func (b *Bot) commandsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		command, err := slack.SlashCommandParse(r)
+		if err != nil {
+			http.Error(w, "could not parse slash command", http.StatusBadRequest)
+			return
+		}
+
+		acked := make(chan interface{}, 1)
+		err = b.answerCommand(command, func(payload interface{}) { acked <- payload })
+		if err != nil {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+
+		payload := <-acked
+		if payload == nil {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(payload)
+	})
+}
+
+// interactivityHandler acknowledges button presses and modal submissions
+// straight away and handles them afterwards. An empty 200 closes a submitted
+// modal, as an empty Socket Mode ack does, and failures are reported to the
+// user separately.
+func (b *Bot) interactivityHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var interaction slack.InteractionCallback
+		if err := json.Unmarshal([]byte(r.FormValue("payload")), &interaction); err != nil {
+			http.Error(w, "could not parse interaction", http.StatusBadRequest)
+			return
+		}
+
+		if err := b.submit(router.NewInteractionRequest(interaction), nil); err != nil {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
@@ -0,0 +1,106 @@
+package installation
+
+import (
+	"alfred-bot/utils/db"
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Handler is a Store that keeps installations in the rotas' table.
+type Handler struct {
+	db *db.Database
+}
+
+func New(db *db.Database) *Handler {
+	return &Handler{db: db}
+}
+
+func (h *Handler) SaveInstallation(i *Installation) error {
+	item, err := attributevalue.MarshalMap(i)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.Client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(h.db.TableName),
+		Item:      item,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (h *Handler) GetInstallation(teamId string) (*Installation, error) {
+	out, err := h.db.Client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: Key},
+			"sk": &types.AttributeValueMemberS{Value: teamId},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(out.Item) == 0 {
+		return nil, nil
+	}
+
+	var i Installation
+	err = attributevalue.UnmarshalMap(out.Item, &i)
+	if err != nil {
+		return nil, err
+	}
+
+	return &i, nil
+}
+
+func (h *Handler) GetInstallations() ([]*Installation, error) {
+	paginator := dynamodb.NewQueryPaginator(h.db.Client, &dynamodb.QueryInput{
+		TableName:              aws.String(h.db.TableName),
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: Key},
+		},
+	})
+
+	var installations []*Installation
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range out.Items {
+			var i Installation
+			err = attributevalue.UnmarshalMap(v, &i)
+			if err != nil {
+				return nil, err
+			}
+
+			installations = append(installations, &i)
+		}
+	}
+
+	return installations, nil
+}
+
+func (h *Handler) DeleteInstallation(teamId string) error {
+	_, err := h.db.Client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(h.db.TableName),
+		Key: map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: Key},
+			"sk": &types.AttributeValueMemberS{Value: teamId},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
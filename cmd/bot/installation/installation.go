@@ -0,0 +1,29 @@
+package installation
+
+// Key is the partition that every workspace's installation is stored under.
+const Key = "installation"
+
+// Installation is the bot token for a workspace the bot was installed in
+// through OAuth. Org-wide installs on Enterprise Grid are stored under the
+// enterprise's ID, and serve every workspace in it.
+type Installation struct {
+	Pk          string `dynamodbav:"pk"`
+	Sk          string `dynamodbav:"sk"` // Slack team ID, or enterprise ID
+	TeamName    string `dynamodbav:"teamName"`
+	BotToken    string `dynamodbav:"botToken"`
+	BotUserId   string `dynamodbav:"botUserId"`
+	InstalledBy string `dynamodbav:"installedBy"`
+	InstalledAt string `dynamodbav:"installedAt"`
+}
+
+func (i *Installation) TeamId() string {
+	return i.Sk
+}
+
+// Store keeps the bot token of each workspace the bot is installed in.
+type Store interface {
+	SaveInstallation(i *Installation) error
+	GetInstallation(teamId string) (*Installation, error)
+	GetInstallations() ([]*Installation, error)
+	DeleteInstallation(teamId string) error
+}
@@ -0,0 +1,137 @@
+package installation
+
+import (
+	"alfred-bot/config"
+	"alfred-bot/utils/clock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestInstallation(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Installation Suite")
+}
+
+// rewriteHost sends every request to the fake Slack instead.
+type rewriteHost struct {
+	target *url.URL
+}
+
+func (t rewriteHost) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+var _ = Describe("OAuth", func() {
+	var store *MemoryHandler
+	var installed []*Installation
+	var oauth *OAuth
+	var slackResponse string
+	var exchanged url.Values
+
+	install := func() *http.Cookie {
+		recorder := httptest.NewRecorder()
+		oauth.InstallHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, InstallPath, nil))
+		Expect(recorder.Code).To(Equal(http.StatusFound))
+
+		location, err := url.Parse(recorder.Header().Get("Location"))
+		Expect(err).To(BeNil())
+		Expect(location.Query().Get("client_id")).To(Equal("123.456"))
+		Expect(location.Query().Get("scope")).To(ContainSubstring("commands"))
+		Expect(location.Query().Get("redirect_uri")).To(Equal("https://alfred.example.com/slack/oauth_redirect"))
+
+		cookie := recorder.Result().Cookies()[0]
+		Expect(cookie.Value).To(Equal(location.Query().Get("state")))
+		Expect(cookie.Secure).To(BeTrue())
+		return cookie
+	}
+
+	redirect := func(cookie *http.Cookie, query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, RedirectPath+"?"+query, nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		recorder := httptest.NewRecorder()
+		oauth.RedirectHandler().ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	BeforeEach(func() {
+		store = NewMemoryHandler()
+		installed = nil
+		exchanged = nil
+		slackResponse = `{"ok": true, "access_token": "xoxb-partner", "token_type": "bot", "bot_user_id": "UBOT", "team": {"id": "T0456", "name": "Partner"}, "authed_user": {"id": "U0789"}}`
+
+		fakeSlack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Path).To(Equal("/api/oauth.v2.access"))
+			Expect(r.ParseForm()).To(Succeed())
+			exchanged = r.PostForm
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(slackResponse))
+		}))
+		DeferCleanup(fakeSlack.Close)
+		target, _ := url.Parse(fakeSlack.URL)
+
+		cfg := config.SlackConfig{ClientID: "123.456", ClientSecret: "shh", RedirectURL: "https://alfred.example.com/slack/oauth_redirect"}
+		oauth = NewOAuth(cfg, store, clock.NewFake(time.Date(2022, time.March, 7, 9, 0, 0, 0, time.UTC)), func(i *Installation) {
+			installed = append(installed, i)
+		})
+		oauth.httpClient = &http.Client{Transport: rewriteHost{target}}
+	})
+
+	It("Stores the workspace's bot token", func() {
+		cookie := install()
+
+		recorder := redirect(cookie, "code=abc&state="+cookie.Value)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(ContainSubstring("Alfred is installed in Partner."))
+		Expect(exchanged.Get("code")).To(Equal("abc"))
+		Expect(exchanged.Get("client_secret")).To(Equal("shh"))
+
+		i, err := store.GetInstallation("T0456")
+		Expect(err).To(BeNil())
+		Expect(i).To(Equal(&Installation{
+			Pk:          Key,
+			Sk:          "T0456",
+			TeamName:    "Partner",
+			BotToken:    "xoxb-partner",
+			BotUserId:   "UBOT",
+			InstalledBy: "U0789",
+			InstalledAt: "Mon, 07 Mar 2022 09:00:00 +0000",
+		}))
+		Expect(installed).To(HaveLen(1))
+	})
+
+	It("Stores org-wide installs under the enterprise", func() {
+		slackResponse = `{"ok": true, "access_token": "xoxb-grid", "token_type": "bot", "team": null, "enterprise": {"id": "E0123", "name": "Grid"}}`
+		cookie := install()
+
+		Expect(redirect(cookie, "code=abc&state="+cookie.Value).Code).To(Equal(http.StatusOK))
+		Expect(store.GetInstallation("E0123")).ToNot(BeNil())
+	})
+
+	It("Only finishes installs it started", func() {
+		cookie := install()
+
+		Expect(redirect(nil, "code=abc&state="+cookie.Value).Code).To(Equal(http.StatusBadRequest))
+		Expect(redirect(cookie, "code=abc&state=forged").Code).To(Equal(http.StatusBadRequest))
+		Expect(redirect(cookie, "error=access_denied&state="+cookie.Value).Code).To(Equal(http.StatusForbidden))
+		Expect(exchanged).To(BeNil())
+		Expect(store.GetInstallations()).To(BeEmpty())
+	})
+
+	It("Fails when Slack won't give a token", func() {
+		slackResponse = `{"ok": false, "error": "invalid_code"}`
+		cookie := install()
+
+		Expect(redirect(cookie, "code=abc&state="+cookie.Value).Code).To(Equal(http.StatusBadGateway))
+		Expect(installed).To(BeEmpty())
+	})
+})
@@ -0,0 +1,64 @@
+package installation
+
+import (
+	"sort"
+	"sync"
+)
+
+// MemoryHandler is a Store that keeps installations in memory, for tests.
+type MemoryHandler struct {
+	mu            sync.Mutex
+	installations map[string]*Installation
+}
+
+func NewMemoryHandler() *MemoryHandler {
+	return &MemoryHandler{installations: map[string]*Installation{}}
+}
+
+func (h *MemoryHandler) SaveInstallation(i *Installation) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	installationCopy := *i
+	h.installations[i.Sk] = &installationCopy
+
+	return nil
+}
+
+func (h *MemoryHandler) GetInstallation(teamId string) (*Installation, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i, ok := h.installations[teamId]
+	if !ok {
+		return nil, nil
+	}
+
+	installationCopy := *i
+	return &installationCopy, nil
+}
+
+func (h *MemoryHandler) GetInstallations() ([]*Installation, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var installations []*Installation
+	for _, i := range h.installations {
+		installationCopy := *i
+		installations = append(installations, &installationCopy)
+	}
+	sort.Slice(installations, func(i, j int) bool {
+		return installations[i].Sk < installations[j].Sk
+	})
+
+	return installations, nil
+}
+
+func (h *MemoryHandler) DeleteInstallation(teamId string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.installations, teamId)
+
+	return nil
+}
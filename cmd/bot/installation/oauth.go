@@ -0,0 +1,184 @@
+package installation
+
+import (
+	"alfred-bot/config"
+	"alfred-bot/utils/clock"
+	"alfred-bot/utils/formatter"
+	"alfred-bot/utils/metrics"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"github.com/slack-go/slack"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// InstallPath sends whoever opens it to Slack to install the bot in their
+	// workspace, and Slack sends them back to RedirectPath afterwards.
+	InstallPath  = "/slack/install"
+	RedirectPath = "/slack/oauth_redirect"
+
+	authorizeURL = "https://slack.com/oauth/v2/authorize"
+	stateCookie  = "alfred_oauth_state"
+	stateTimeout = 10 * time.Minute
+)
+
+// Scopes are the bot token scopes the bot asks for when it's installed.
+var Scopes = []string{
+	"app_mentions:read",
+	"chat:write",
+	"chat:write.customize",
+	"commands",
+	"users:read",
+	"users:read.email",
+}
+
+var installsTotal = metrics.NewCounter(
+	"alfred_installs_total",
+	"Attempts to install the bot in a workspace, by outcome.",
+	"outcome",
+)
+
+// OAuth installs the bot in a workspace with Slack's OAuth v2 flow, and
+// keeps the bot token it's given.
+type OAuth struct {
+	config     config.SlackConfig
+	store      Store
+	clock      clock.Clock
+	httpClient *http.Client
+	// onInstall is told about each new or renewed installation, so the bot can
+	// start serving the workspace straight away.
+	onInstall func(i *Installation)
+}
+
+func NewOAuth(config config.SlackConfig, store Store, clock clock.Clock, onInstall func(i *Installation)) *OAuth {
+	return &OAuth{
+		config:     config,
+		store:      store,
+		clock:      clock,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		onInstall:  onInstall,
+	}
+}
+
+// InstallHandler redirects to Slack to ask for the bot's scopes. A random
+// state, kept in a cookie, ties Slack's redirect back to this browser.
+func (o *OAuth) InstallHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state, err := randomState()
+		if err != nil {
+			slog.Error("Could not start install", "error", err)
+			http.Error(w, "could not start install", http.StatusInternalServerError)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     stateCookie,
+			Value:    state,
+			Path:     RedirectPath,
+			MaxAge:   int(stateTimeout.Seconds()),
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(o.config.RedirectURL, "https://"),
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		query := url.Values{
+			"client_id": {o.config.ClientID},
+			"scope":     {strings.Join(Scopes, ",")},
+			"state":     {state},
+		}
+		if o.config.RedirectURL != "" {
+			query.Set("redirect_uri", o.config.RedirectURL)
+		}
+
+		http.Redirect(w, r, authorizeURL+"?"+query.Encode(), http.StatusFound)
+	})
+}
+
+// RedirectHandler swaps the code Slack sends back for the workspace's bot
+// token, and stores it.
+func (o *OAuth) RedirectHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		// Slack sends an error instead of a code if the user cancelled.
+		if reason := query.Get("error"); reason != "" {
+			installsTotal.Inc("cancelled")
+			http.Error(w, fmt.Sprintf("Alfred wasn't installed: %s", reason), http.StatusForbidden)
+			return
+		}
+
+		cookie, err := r.Cookie(stateCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(query.Get("state"))) != 1 {
+			installsTotal.Inc("invalid_state")
+			http.Error(w, fmt.Sprintf("This install link has expired. Start again from %s.", InstallPath), http.StatusBadRequest)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: RedirectPath, MaxAge: -1})
+
+		i, err := o.exchange(r, query.Get("code"))
+		if err != nil {
+			installsTotal.Inc("failed")
+			slog.Error("Could not install", "error", err)
+			http.Error(w, "Alfred couldn't be installed. Please try again.", http.StatusBadGateway)
+			return
+		}
+
+		installsTotal.Inc("installed")
+		slog.Info("Installed in workspace", "team_id", i.TeamId(), "team_name", i.TeamName, "user_id", i.InstalledBy)
+		if o.onInstall != nil {
+			o.onInstall(i)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "Alfred is installed in %s. Type /rota in any channel to get started.\n", i.TeamName)
+	})
+}
+
+func (o *OAuth) exchange(r *http.Request, code string) (*Installation, error) {
+	resp, err := slack.GetOAuthV2ResponseContext(r.Context(), o.httpClient, o.config.ClientID, o.config.ClientSecret, code, o.config.RedirectURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.TokenType != "bot" || resp.AccessToken == "" {
+		return nil, fmt.Errorf("expected a bot token, got a %q token", resp.TokenType)
+	}
+
+	// Org-wide installs have no team, and serve every workspace in the org.
+	teamId, teamName := resp.Team.ID, resp.Team.Name
+	if teamId == "" {
+		teamId, teamName = resp.Enterprise.ID, resp.Enterprise.Name
+	}
+
+	i := &Installation{
+		Pk:          Key,
+		Sk:          teamId,
+		TeamName:    teamName,
+		BotToken:    resp.AccessToken,
+		BotUserId:   resp.BotUserID,
+		InstalledBy: resp.AuthedUser.ID,
+		InstalledAt: formatter.FormatTime(o.clock.Now()),
+	}
+
+	err = o.store.SaveInstallation(i)
+	if err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,11 @@
+package bot
+
+import "alfred-bot/cmd/bot/router"
+
+// modules are the command modules that serve a workspace's slash commands,
+// interactions and events. A new module only needs adding here.
+func (t *team) modules() []router.Module {
+	return []router.Module{
+		t.rotaCommand,
+	}
+}
@@ -0,0 +1,146 @@
+package router
+
+import (
+	"alfred-bot/utils/metrics"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+)
+
+var (
+	slashCommandsTotal = metrics.NewCounter(
+		"alfred_slash_commands_total",
+		"Slash commands handled, by command and outcome.",
+		"command", "outcome",
+	)
+	slashCommandDuration = metrics.NewHistogram(
+		"alfred_slash_command_duration_seconds",
+		"Time taken to handle a slash command, by command.",
+		metrics.DefBuckets,
+		"command",
+	)
+	interactionsTotal = metrics.NewCounter(
+		"alfred_interactions_total",
+		"Interactions handled, by type, action or callback ID, and outcome.",
+		"type", "action_id", "outcome",
+	)
+	interactionDuration = metrics.NewHistogram(
+		"alfred_interaction_duration_seconds",
+		"Time taken to handle an interaction, by action or callback ID.",
+		metrics.DefBuckets,
+		"action_id",
+	)
+	eventsTotal = metrics.NewCounter(
+		"alfred_events_total",
+		"Events API events handled, by outcome.",
+		"outcome",
+	)
+)
+
+// Logging tags each request's Logger with its correlation ID and the
+// attributes describe gives for it, and logs the request's errors.
+func Logging(describe func(req *Request) []any) Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request) (interface{}, error) {
+			req.Logger = slog.With(describe(req)...).With("correlation_id", req.Id)
+
+			noun := "interaction"
+			switch req.Kind {
+			case KindCommand:
+				noun = "slash command"
+				req.Logger.Debug("Handling slash command", "text", req.Command.Text)
+			case KindEvent:
+				noun = "event"
+			default:
+				req.Logger.Debug("Handling interaction")
+			}
+
+			payload, err := next(req)
+			if err != nil {
+				req.Logger.Error("Could not handle "+noun, "error", err)
+			}
+
+			return payload, err
+		}
+	}
+}
+
+// ReportErrors lets report tell the user about each request that failed. A
+// failed slash command is answered with whatever report returns, rather than
+// leaving Slack to time out.
+func ReportErrors(report func(req *Request, err error) interface{}) Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request) (interface{}, error) {
+			payload, err := next(req)
+			if err != nil {
+				payload = report(req, err)
+			}
+
+			return payload, err
+		}
+	}
+}
+
+// Metrics counts requests by route and outcome, and times slash commands and
+// interactions.
+func Metrics() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request) (interface{}, error) {
+			start := time.Now()
+			payload, err := next(req)
+
+			switch req.Kind {
+			case KindCommand:
+				slashCommandDuration.Observe(metrics.Since(start), req.Route)
+				slashCommandsTotal.Inc(req.Route, metrics.Outcome(err))
+			case KindEvent:
+				eventsTotal.Inc(metrics.Outcome(err))
+			default:
+				interactionDuration.Observe(metrics.Since(start), req.Route)
+				interactionsTotal.Inc(string(req.Kind), req.Route, metrics.Outcome(err))
+			}
+
+			return payload, err
+		}
+	}
+}
+
+// Recover turns a panic while handling a request into an error, so that one
+// bad request can't take the bot down.
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request) (payload interface{}, err error) {
+			defer func() {
+				if v := recover(); v != nil {
+					logger := req.Logger
+					if logger == nil {
+						logger = slog.Default()
+					}
+					logger.Error("Recovered from panic", "panic", v, "stack", string(debug.Stack()))
+
+					payload, err = nil, fmt.Errorf("panic: %v", v)
+				}
+			}()
+
+			return next(req)
+		}
+	}
+}
+
+// Authorize finds the routes of the workspace each request came from with
+// resolve, which fails for workspaces the bot isn't installed in. If resolve
+// finds no routes, the request has been dealt with.
+func Authorize(resolve func(req *Request) (*Routes, error)) Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request) (interface{}, error) {
+			routes, err := resolve(req)
+			if err != nil || routes == nil {
+				return nil, err
+			}
+
+			req.Routes = routes
+			return next(req)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package router
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"log/slog"
+)
+
+// Kind is what sort of request Slack sent.
+type Kind string
+
+const (
+	KindCommand        Kind = "command"
+	KindEvent          Kind = "event"
+	KindBlockAction    Kind = Kind(slack.InteractionTypeBlockActions)
+	KindViewSubmission Kind = Kind(slack.InteractionTypeViewSubmission)
+)
+
+// Request is a slash command, interaction or event on its way to the handler
+// of its route.
+type Request struct {
+	// Id is a random correlation ID, which is logged with the request and
+	// shown to its user if it fails, so the two can be matched up.
+	Id   string
+	Kind Kind
+	// Route is the slash command, action ID, view callback ID or event type
+	// the request is handled by.
+	Route        string
+	TeamId       string
+	EnterpriseId string
+	// ChannelId is empty for requests from outside a channel, such as the Home
+	// tab and modals.
+	ChannelId string
+	UserId    string
+	// Only the field for the request's kind is set. Action is the first of a
+	// block action's actions.
+	Command     *slack.SlashCommand
+	Interaction *slack.InteractionCallback
+	Action      *slack.BlockAction
+	Event       *slackevents.EventsAPIEvent
+	// Routes are the routes of the workspace the request came from, as found
+	// by Authorize.
+	Routes *Routes
+	// Logger is tagged with what the request is about, by Logging.
+	Logger *slog.Logger
+}
+
+// Handler handles a request, and returns what to acknowledge it with.
+type Handler func(req *Request) (interface{}, error)
+
+// Middleware wraps every request's handler, to do something before or after
+// it, or instead of it.
+type Middleware func(next Handler) Handler
+
+// Router sends each request from Slack through the middleware, then to the
+// handler of its route in the routes of the workspace it came from.
+// Requests with no route are acknowledged and otherwise ignored.
+type Router struct {
+	handler Handler
+}
+
+// New builds a router whose middleware wraps requests in the order given, so
+// the first sees them first.
+func New(middleware ...Middleware) *Router {
+	h := Handler(route)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+
+	return &Router{handler: h}
+}
+
+func route(req *Request) (interface{}, error) {
+	if req.Routes == nil {
+		return nil, nil
+	}
+
+	h, ok := req.Routes.handler(req.Kind, req.Route)
+	if !ok {
+		return nil, nil
+	}
+
+	return h(req)
+}
+
+// Handle sends a request built by NewCommandRequest, NewInteractionRequest or
+// NewEventRequest through the router.
+func (r *Router) Handle(req *Request) (interface{}, error) {
+	return r.handler(req)
+}
+
+func (r *Router) HandleCommand(command slack.SlashCommand) (interface{}, error) {
+	return r.Handle(NewCommandRequest(command))
+}
+
+func (r *Router) HandleInteraction(interaction slack.InteractionCallback) error {
+	_, err := r.Handle(NewInteractionRequest(interaction))
+	return err
+}
+
+// HandleEvent handles the events apps subscribe to. Slack's other Events API
+// requests, such as rate limit warnings, are ignored.
+func (r *Router) HandleEvent(event slackevents.EventsAPIEvent) error {
+	req := NewEventRequest(event)
+	if req == nil {
+		return nil
+	}
+
+	_, err := r.Handle(req)
+	return err
+}
+
+func NewCommandRequest(command slack.SlashCommand) *Request {
+	return &Request{
+		Id:           newId(),
+		Kind:         KindCommand,
+		Route:        command.Command,
+		TeamId:       command.TeamID,
+		EnterpriseId: command.EnterpriseID,
+		ChannelId:    command.ChannelID,
+		UserId:       command.UserID,
+		Command:      &command,
+	}
+}
+
+func NewInteractionRequest(interaction slack.InteractionCallback) *Request {
+	req := &Request{
+		Id:           newId(),
+		Kind:         Kind(interaction.Type),
+		TeamId:       interaction.Team.ID,
+		EnterpriseId: interaction.Enterprise.ID,
+		ChannelId:    interaction.Channel.ID,
+		UserId:       interaction.User.ID,
+		Interaction:  &interaction,
+	}
+
+	switch interaction.Type {
+	case slack.InteractionTypeBlockActions:
+		if len(interaction.ActionCallback.BlockActions) > 0 {
+			req.Action = interaction.ActionCallback.BlockActions[0]
+			req.Route = req.Action.ActionID
+		}
+	case slack.InteractionTypeViewSubmission:
+		req.Route = interaction.View.CallbackID
+	}
+
+	return req
+}
+
+// NewEventRequest returns nil for Events API requests that aren't events the
+// app subscribed to.
+func NewEventRequest(event slackevents.EventsAPIEvent) *Request {
+	if event.Type != slackevents.CallbackEvent {
+		return nil
+	}
+
+	req := &Request{
+		Id:           newId(),
+		Kind:         KindEvent,
+		Route:        event.InnerEvent.Type,
+		TeamId:       event.TeamID,
+		EnterpriseId: event.EnterpriseID,
+		Event:        &event,
+	}
+
+	switch e := event.InnerEvent.Data.(type) {
+	case *slackevents.AppMentionEvent:
+		req.ChannelId, req.UserId = e.Channel, e.User
+	case *slackevents.AppHomeOpenedEvent:
+		req.UserId = e.User
+	}
+
+	return req
+}
+
+func newId() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,220 @@
+package router
+
+import (
+	"alfred-bot/utils/metrics"
+	"bytes"
+	"errors"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Router Suite")
+}
+
+// echoModule records what it was asked to handle.
+type echoModule struct {
+	handled []string
+}
+
+func (m *echoModule) Routes(r *Routes) {
+	r.Command("/echo", func(command slack.SlashCommand) (interface{}, error) {
+		m.handled = append(m.handled, "command "+command.Text)
+		return command.Text, nil
+	})
+	r.BlockAction("echo-button", func(interaction *slack.InteractionCallback, action *slack.BlockAction) error {
+		m.handled = append(m.handled, "button "+action.Value)
+		return nil
+	})
+	r.ViewSubmission("echo-modal", func(interaction *slack.InteractionCallback) error {
+		m.handled = append(m.handled, "modal "+interaction.View.PrivateMetadata)
+		return nil
+	})
+	r.Event(slackevents.AppMention, func(event slackevents.EventsAPIEvent) error {
+		m.handled = append(m.handled, "mention")
+		return nil
+	})
+	r.Command("/boom", func(command slack.SlashCommand) (interface{}, error) {
+		var action *slack.BlockAction
+		return action.Value, nil
+	})
+}
+
+// OrderingKey keys buttons by their value.
+func (m *echoModule) OrderingKey(req *Request) (string, bool) {
+	if req.Action == nil {
+		return "", false
+	}
+	return req.Action.Value, true
+}
+
+func buttonPress(actionId string, value string) slack.InteractionCallback {
+	interaction := slack.InteractionCallback{Type: slack.InteractionTypeBlockActions}
+	interaction.Team.ID = "T0123"
+	interaction.ActionCallback.BlockActions = []*slack.BlockAction{{ActionID: actionId, Value: value}}
+	return interaction
+}
+
+func renderMetrics() string {
+	var buf bytes.Buffer
+	metrics.DefaultRegistry.Render(&buf)
+	return buf.String()
+}
+
+var _ = Describe("Router", func() {
+	var module *echoModule
+	var routes *Routes
+	var teams []string
+
+	authorize := Authorize(func(req *Request) (*Routes, error) {
+		teams = append(teams, req.TeamId)
+		if req.TeamId != "T0123" {
+			return nil, errors.New("not installed in team " + req.TeamId)
+		}
+		return routes, nil
+	})
+
+	BeforeEach(func() {
+		module = &echoModule{}
+		routes = NewRoutes(module)
+		teams = nil
+	})
+
+	It("Sends each request to its route in the workspace's routes", func() {
+		r := New(authorize)
+
+		payload, err := r.HandleCommand(slack.SlashCommand{Command: "/echo", Text: "hello", TeamID: "T0123"})
+		Expect(err).To(BeNil())
+		Expect(payload).To(Equal("hello"))
+
+		Expect(r.HandleInteraction(buttonPress("echo-button", "Support"))).To(Succeed())
+
+		submission := slack.InteractionCallback{Type: slack.InteractionTypeViewSubmission}
+		submission.Team.ID = "T0123"
+		submission.View.CallbackID = "echo-modal"
+		submission.View.PrivateMetadata = "C0123"
+		Expect(r.HandleInteraction(submission)).To(Succeed())
+
+		Expect(r.HandleEvent(slackevents.EventsAPIEvent{
+			Type:       slackevents.CallbackEvent,
+			TeamID:     "T0123",
+			InnerEvent: slackevents.EventsAPIInnerEvent{Type: slackevents.AppMention},
+		})).To(Succeed())
+
+		Expect(module.handled).To(Equal([]string{"command hello", "button Support", "modal C0123", "mention"}))
+		Expect(teams).To(Equal([]string{"T0123", "T0123", "T0123", "T0123"}))
+	})
+
+	It("Ignores requests no module handles", func() {
+		r := New(authorize)
+
+		payload, err := r.HandleCommand(slack.SlashCommand{Command: "/unknown", TeamID: "T0123"})
+		Expect(err).To(BeNil())
+		Expect(payload).To(BeNil())
+		Expect(r.HandleInteraction(buttonPress("unknown-button", ""))).To(Succeed())
+		Expect(r.HandleEvent(slackevents.EventsAPIEvent{Type: slackevents.AppRateLimited, TeamID: "T0123"})).To(Succeed())
+
+		Expect(module.handled).To(BeEmpty())
+		// Rate limit warnings aren't from a workspace.
+		Expect(teams).To(Equal([]string{"T0123", "T0123"}))
+	})
+
+	It("Turns away requests from workspaces it isn't installed in", func() {
+		r := New(authorize)
+
+		_, err := r.HandleCommand(slack.SlashCommand{Command: "/echo", TeamID: "T0456"})
+		Expect(err).To(MatchError("not installed in team T0456"))
+		Expect(module.handled).To(BeEmpty())
+	})
+
+	It("Runs middleware in the order given", func() {
+		var order []string
+		record := func(name string) Middleware {
+			return func(next Handler) Handler {
+				return func(req *Request) (interface{}, error) {
+					order = append(order, name+" before")
+					payload, err := next(req)
+					order = append(order, name+" after")
+					return payload, err
+				}
+			}
+		}
+
+		r := New(record("first"), record("second"), authorize)
+		_, err := r.HandleCommand(slack.SlashCommand{Command: "/echo", TeamID: "T0123"})
+		Expect(err).To(BeNil())
+		Expect(order).To(Equal([]string{"first before", "second before", "second after", "first after"}))
+	})
+
+	It("Turns panics into errors", func() {
+		r := New(Recover(), authorize)
+
+		_, err := r.HandleCommand(slack.SlashCommand{Command: "/boom", TeamID: "T0123"})
+		Expect(err).To(MatchError(ContainSubstring("panic: runtime error: invalid memory address")))
+
+		_, err = r.HandleCommand(slack.SlashCommand{Command: "/echo", Text: "still here", TeamID: "T0123"})
+		Expect(err).To(BeNil())
+	})
+
+	It("Reports failed requests with their correlation ID", func() {
+		var reported []string
+		r := New(ReportErrors(func(req *Request, err error) interface{} {
+			reported = append(reported, req.Id+" "+err.Error())
+			return "Sorry, " + req.Id
+		}), Recover(), authorize)
+
+		payload, err := r.HandleCommand(slack.SlashCommand{Command: "/boom", TeamID: "T0123"})
+		Expect(err).ToNot(BeNil())
+		Expect(reported).To(HaveLen(1))
+		Expect(reported[0]).To(MatchRegexp(`^[0-9a-f]{12} panic: `))
+		Expect(payload).To(Equal("Sorry, " + reported[0][:12]))
+
+		payload, err = r.HandleCommand(slack.SlashCommand{Command: "/echo", Text: "fine", TeamID: "T0123"})
+		Expect(err).To(BeNil())
+		Expect(payload).To(Equal("fine"))
+		Expect(reported).To(HaveLen(1))
+	})
+
+	It("Counts requests by route and outcome", func() {
+		r := New(Metrics(), Recover(), authorize)
+
+		_, _ = r.HandleCommand(slack.SlashCommand{Command: "/boom", TeamID: "T0123"})
+		_ = r.HandleInteraction(buttonPress("echo-button", "Support"))
+
+		Expect(renderMetrics()).To(ContainSubstring(`alfred_slash_commands_total{command="/boom",outcome="error"} 1`))
+		Expect(renderMetrics()).To(ContainSubstring(`alfred_interactions_total{type="block_actions",action_id="echo-button",outcome="ok"}`))
+	})
+
+	It("Tags each request's logger", func() {
+		var logged *Request
+		r := New(Logging(func(req *Request) []any {
+			return []any{"route", req.Route}
+		}), func(next Handler) Handler {
+			return func(req *Request) (interface{}, error) {
+				logged = req
+				return next(req)
+			}
+		})
+
+		_, _ = r.HandleCommand(slack.SlashCommand{Command: "/echo", TeamID: "T0123"})
+		Expect(logged.Logger).ToNot(BeNil())
+	})
+
+	It("Asks the module that handles a request what it's about", func() {
+		Expect(routes.OrderingKey(NewInteractionRequest(buttonPress("echo-button", "rota")))).To(Equal("rota"))
+		Expect(routes.OrderingKey(NewCommandRequest(slack.SlashCommand{Command: "/echo", ChannelID: "C0123", UserID: "U0123"}))).To(Equal("C0123"))
+		Expect(routes.OrderingKey(NewCommandRequest(slack.SlashCommand{Command: "/echo", UserID: "U0123"}))).To(Equal("U0123"))
+		Expect(routes.OrderingKey(NewInteractionRequest(buttonPress("unknown", "rota")))).To(Equal(""))
+
+		var none *Routes
+		Expect(none.OrderingKey(NewCommandRequest(slack.SlashCommand{Command: "/echo", ChannelID: "C0123"}))).To(Equal("C0123"))
+	})
+
+	It("Won't let two modules handle the same route", func() {
+		Expect(func() { NewRoutes(&echoModule{}, &echoModule{}) }).To(PanicWith("router: command /echo is already handled"))
+	})
+})
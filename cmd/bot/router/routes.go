@@ -0,0 +1,112 @@
+package router
+
+import (
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Module is a set of slash commands, interactions and events that are served
+// together, such as /rota and its buttons and modals.
+type Module interface {
+	Routes(r *Routes)
+	// OrderingKey says what a request for one of the module's routes is
+	// about, such as a rota, as far as can be told without looking anything
+	// up. Requests with the same key are handled one at a time, in the order
+	// they came in. If it returns false, the request is ordered with its
+	// channel's, or its user's outside a channel.
+	OrderingKey(req *Request) (string, bool)
+}
+
+// Routes are what a workspace's modules handle, by slash command, block
+// action ID, view callback ID and event type.
+type Routes struct {
+	routes map[Kind]map[string]Handler
+	// modules are the modules that added each route.
+	modules map[Kind]map[string]Module
+	adding  Module
+}
+
+func NewRoutes(modules ...Module) *Routes {
+	r := &Routes{
+		routes:  map[Kind]map[string]Handler{},
+		modules: map[Kind]map[string]Module{},
+	}
+	for _, v := range modules {
+		r.adding = v
+		v.Routes(r)
+	}
+	r.adding = nil
+
+	return r
+}
+
+// OrderingKey is what a request is about, as told by the module that handles
+// its route, so that one request doesn't act on what another is halfway
+// through changing. Requests no module handles are ordered by their channel,
+// or by their user outside a channel.
+func (r *Routes) OrderingKey(req *Request) string {
+	if r != nil {
+		if m, ok := r.modules[req.Kind][req.Route]; ok {
+			if key, ok := m.OrderingKey(req); ok {
+				return key
+			}
+		}
+	}
+
+	if req.ChannelId != "" {
+		return req.ChannelId
+	}
+	return req.UserId
+}
+
+// Command handles a slash command, such as /rota. Its payload is what Slack
+// shows the user who ran it.
+func (r *Routes) Command(name string, h func(command slack.SlashCommand) (interface{}, error)) {
+	r.add(KindCommand, name, func(req *Request) (interface{}, error) {
+		return h(*req.Command)
+	})
+}
+
+// BlockAction handles presses of buttons and picks from menus with the action
+// ID.
+func (r *Routes) BlockAction(actionId string, h func(interaction *slack.InteractionCallback, action *slack.BlockAction) error) {
+	r.add(KindBlockAction, actionId, func(req *Request) (interface{}, error) {
+		return nil, h(req.Interaction, req.Action)
+	})
+}
+
+// ViewSubmission handles submissions of modals with the callback ID.
+func (r *Routes) ViewSubmission(callbackId string, h func(interaction *slack.InteractionCallback) error) {
+	r.add(KindViewSubmission, callbackId, func(req *Request) (interface{}, error) {
+		return nil, h(req.Interaction)
+	})
+}
+
+// Event handles Events API events of the type, such as app_mention.
+func (r *Routes) Event(eventType string, h func(event slackevents.EventsAPIEvent) error) {
+	r.add(KindEvent, eventType, func(req *Request) (interface{}, error) {
+		return nil, h(*req.Event)
+	})
+}
+
+// add panics if two modules claim the same route, since only one of them
+// could ever be called.
+func (r *Routes) add(kind Kind, route string, h Handler) {
+	if r.routes[kind] == nil {
+		r.routes[kind] = map[string]Handler{}
+		r.modules[kind] = map[string]Module{}
+	}
+
+	if _, ok := r.routes[kind][route]; ok {
+		panic("router: " + string(kind) + " " + route + " is already handled")
+	}
+	r.routes[kind][route] = h
+	if r.adding != nil {
+		r.modules[kind][route] = r.adding
+	}
+}
+
+func (r *Routes) handler(kind Kind, route string) (Handler, bool) {
+	h, ok := r.routes[kind][route]
+	return h, ok
+}
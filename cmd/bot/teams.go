@@ -0,0 +1,264 @@
+package bot
+
+import (
+	"alfred-bot/cmd/bot/commands/rotacommand"
+	"alfred-bot/cmd/bot/installation"
+	"alfred-bot/cmd/bot/router"
+	"alfred-bot/utils/logger"
+	"alfred-bot/utils/slackclient"
+	"context"
+	"fmt"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// team is everything the bot needs to serve one workspace: a Slack client with
+// the workspace's bot token, and rotas stored under the workspace's own keys.
+type team struct {
+	id          string
+	client      slackclient.SlackClient
+	rotaCommand *rotacommand.RotaCommand
+	// routes are the slash commands, interactions and events the workspace's
+	// modules handle.
+	routes *router.Routes
+	// stop ends the workspace's handovers and escalations.
+	stop context.CancelFunc
+}
+
+// newTeam builds a team whose rotas are stored under partition, which is empty
+// for the workspace of SLACK_AUTH_TOKEN.
+func (b *Bot) newTeam(id string, partition string, token string) *team {
+	client := slackclient.New(slack.New(token, b.slackOptions()...))
+
+	t := &team{
+		id:          id,
+		client:      client,
+		rotaCommand: rotacommand.New(b.rotas.ForTeam(partition), client, b.clock, b.rotaConfig),
+	}
+	t.routes = router.NewRoutes(t.modules()...)
+
+	return t
+}
+
+// slackOptions are the options of every Slack client the bot makes.
+func (b *Bot) slackOptions() []slack.Option {
+	options := []slack.Option{
+		slack.OptionDebug(b.debug),
+		slack.OptionLog(logger.Std(slog.Default(), "slack")),
+	}
+	if b.slackAPIURL != "" {
+		options = append(options, slack.OptionAPIURL(b.slackAPIURL))
+	}
+
+	return options
+}
+
+// addTeam starts serving a workspace the bot was installed in, replacing the
+// workspace's old token if it was installed before.
+func (b *Bot) addTeam(i *installation.Installation) *team {
+	partition := i.TeamId()
+	if partition == b.defaultTeamId {
+		partition = ""
+	}
+	t := b.newTeam(i.TeamId(), partition, i.BotToken)
+
+	b.teamsMu.Lock()
+	defer b.teamsMu.Unlock()
+
+	if previous, ok := b.teams[t.id]; ok && previous.stop != nil {
+		previous.stop()
+	}
+	b.teams[t.id] = t
+	b.runTeam(t)
+
+	return t
+}
+
+// removeTeam stops serving a workspace the bot was uninstalled from. Its rotas
+// are kept, in case it's installed again.
+func (b *Bot) removeTeam(teamId string, enterpriseId string) error {
+	for _, id := range []string{teamId, enterpriseId} {
+		if id == "" {
+			continue
+		}
+
+		err := b.installations.DeleteInstallation(id)
+		if err != nil {
+			return err
+		}
+
+		// SLACK_AUTH_TOKEN's workspace is served for as long as it's set.
+		if id == b.defaultTeamId {
+			continue
+		}
+
+		b.teamsMu.Lock()
+		if t, ok := b.teams[id]; ok {
+			if t.stop != nil {
+				t.stop()
+			}
+			delete(b.teams, id)
+			slog.Info("Uninstalled from workspace", "team_id", id)
+		}
+		b.teamsMu.Unlock()
+	}
+
+	return nil
+}
+
+// runTeam starts a team's background tasks once the bot is running. The
+// caller holds teamsMu.
+func (b *Bot) runTeam(t *team) {
+	if b.runCtx == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(b.runCtx)
+	t.stop = cancel
+
+	b.tasks.Add(1)
+	go func() {
+		defer b.tasks.Done()
+		t.rotaCommand.HandleEndOfOnCallShifts(ctx)
+	}()
+}
+
+// routesFor finds the routes of the workspace a request came from. Requests
+// from workspaces the bot isn't installed in are turned away.
+func (b *Bot) routesFor(req *router.Request) (*router.Routes, error) {
+	if req.Kind == router.KindEvent && b.multiWorkspace {
+		if _, ok := req.Event.InnerEvent.Data.(*slackevents.AppUninstalledEvent); ok {
+			return nil, b.removeTeam(req.TeamId, req.EnterpriseId)
+		}
+	}
+
+	t, err := b.teamFor(req.TeamId, req.EnterpriseId)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.routes, nil
+}
+
+// teamFor returns the team of the workspace an event came from. Org-wide
+// installs serve every workspace in their enterprise.
+func (b *Bot) teamFor(teamId string, enterpriseId string) (*team, error) {
+	b.teamsMu.Lock()
+	if !b.multiWorkspace {
+		defer b.teamsMu.Unlock()
+		return b.teams[b.defaultTeamId], nil
+	}
+
+	for _, id := range []string{teamId, enterpriseId} {
+		if t, ok := b.teams[id]; ok && id != "" {
+			b.teamsMu.Unlock()
+			return t, nil
+		}
+	}
+	b.teamsMu.Unlock()
+
+	// Another instance of the bot may have been installed there since this one
+	// started.
+	for _, id := range []string{teamId, enterpriseId} {
+		if id == "" {
+			continue
+		}
+
+		i, err := b.installations.GetInstallation(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if i != nil {
+			return b.addTeam(i), nil
+		}
+	}
+
+	return nil, fmt.Errorf("not installed in team %s", teamId)
+}
+
+// rotaCommands returns every workspace's RotaCommand, in a stable order.
+func (b *Bot) rotaCommands() []*rotacommand.RotaCommand {
+	b.teamsMu.Lock()
+	defer b.teamsMu.Unlock()
+
+	ids := make([]string, 0, len(b.teams))
+	for id := range b.teams {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	rotaCommands := make([]*rotacommand.RotaCommand, 0, len(ids))
+	for _, id := range ids {
+		rotaCommands = append(rotaCommands, b.teams[id].rotaCommand)
+	}
+
+	return rotaCommands
+}
+
+// teamHandler serves an HTTP endpoint with the RotaCommand of the workspace
+// teamOf finds for the request, such as the one its routing key or API token
+// was made in.
+func (b *Bot) teamHandler(teamOf func(r *http.Request) (string, bool, error), handler func(c *rotacommand.RotaCommand) http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		teamId, ok, err := teamOf(r)
+		if err != nil {
+			slog.Error("Could not find the request's workspace", "path", r.URL.Path, "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		rotaCommands := b.rotaCommands()
+		if len(rotaCommands) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+
+		// Requests no workspace owns are turned away by the first.
+		rotaCommand := rotaCommands[0]
+		if ok {
+			if teamId == "" {
+				teamId = b.defaultTeamId
+			}
+
+			b.teamsMu.Lock()
+			if t, ok := b.teams[teamId]; ok {
+				rotaCommand = t.rotaCommand
+			}
+			b.teamsMu.Unlock()
+		}
+
+		handler(rotaCommand).ServeHTTP(w, r)
+	})
+}
+
+// routingKeyTeam finds the workspace an alert's routing key was made in, with
+// a single read.
+func (b *Bot) routingKeyTeam(r *http.Request) (string, bool, error) {
+	k, err := b.rotas.GetRoutingKey(strings.TrimPrefix(r.URL.Path, rotacommand.AlertsPath))
+	if err != nil || k == nil {
+		return "", false, err
+	}
+
+	return k.TeamId, true, nil
+}
+
+// apiTokenTeam finds the workspace an API request's token was made in, with a
+// single read.
+func (b *Bot) apiTokenTeam(r *http.Request) (string, bool, error) {
+	hash, ok := rotacommand.APITokenHash(r)
+	if !ok {
+		return "", false, nil
+	}
+
+	t, err := b.rotas.GetAPITokenTeam(hash)
+	if err != nil || t == nil {
+		return "", false, err
+	}
+
+	return t.TeamId, true, nil
+}
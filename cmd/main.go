@@ -3,15 +3,50 @@ package main
 import (
 	"alfred-bot/cmd/bot"
 	"alfred-bot/config"
+	"alfred-bot/utils/logger"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	config.BootstrapEnv(false)
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
 
-	token := os.Getenv("SLACK_AUTH_TOKEN")
-	appToken := os.Getenv("SLACK_APP_TOKEN")
+	if err = logger.Setup(os.Stdout, cfg.Log.Level, cfg.Log.Format); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not set up logging: %v\n", err)
+		os.Exit(2)
+	}
 
-	b := bot.New(token, appToken)
-	b.Start()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Once shutdown has started, let a second signal kill the process straight
+	// away rather than waiting for in-flight work.
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	b, err := bot.New(cfg)
+	if err != nil {
+		slog.Error("Could not start bot", "error", err)
+		os.Exit(1)
+	}
+
+	if err = b.Start(ctx); err != nil {
+		slog.Error("Bot stopped", "error", err)
+		os.Exit(1)
+	}
 }
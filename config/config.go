@@ -1,26 +1,484 @@
 package config
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/joho/godotenv"
 	"os"
-	"regexp"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
-const projectDirName = "alfred-bot"
+const (
+	defaultConfigFile = ".env"
+	testConfigFile    = ".env.test"
+	testTableName     = "alfred-bot-test"
+)
+
+type Config struct {
+	Slack           SlackConfig
+	DB              DBConfig
+	HTTPAddr        string
+	Log             LogConfig
+	Rota            RotaConfig
+	Debug           bool
+	ShutdownTimeout time.Duration
+	// EventWorkers is how many requests from Slack are handled at once.
+	EventWorkers int
+}
+
+type SlackConfig struct {
+	AuthToken string
+	AppToken  string
+	// ClientID and ClientSecret let the bot be installed in other workspaces
+	// through OAuth, each with its own bot token.
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is where Slack sends installs back to. It's only needed if
+	// the app has more than one redirect URL.
+	RedirectURL string
+	// TeamID picks the workspace alfred-admin works on.
+	TeamID string
+	// Transport is how Slack delivers events, commands and interactions to the
+	// bot.
+	Transport Transport
+	// SigningSecret verifies requests Slack sends over the HTTP transport.
+	SigningSecret string
+	// APIURL is where the Slack Web API is reached, if not at Slack, such as
+	// a fake Slack in tests.
+	APIURL string
+}
+
+// MultiWorkspace reports whether the bot can be installed in workspaces other
+// than SLACK_AUTH_TOKEN's.
+func (c SlackConfig) MultiWorkspace() bool {
+	return c.ClientID != ""
+}
+
+// Transport is how Slack reaches the bot.
+type Transport string
+
+const (
+	// TransportSocket connects out to Slack over Socket Mode.
+	TransportSocket Transport = "socket"
+	// TransportHTTP has Slack send requests to the bot's HTTP server.
+	TransportHTTP Transport = "http"
+)
+
+type DBConfig struct {
+	TableName string
+	// Endpoint overrides the DynamoDB endpoint, e.g. for DynamoDB Local. When
+	// empty, the AWS SDK's default endpoint and credential chain are used.
+	Endpoint string
+	Region   string
+}
+
+type LogConfig struct {
+	Level  string
+	Format string
+}
+
+type RotaConfig struct {
+	// PageAckTimeout is how long a page waits to be acknowledged before it
+	// escalates to the next responder.
+	PageAckTimeout time.Duration
+	// AlertWebhooks accepts alerts from monitoring systems on the HTTP server.
+	AlertWebhooks bool
+	// RestAPI serves the read-only REST API on the HTTP server.
+	RestAPI bool
+	// EditRole may change a rota's members, duration and integrations.
+	EditRole Role
+	// StartRole may start and stop a rota's shifts.
+	StartRole Role
+	// SwapRole may swap, skip and override shifts from the Home tab.
+	SwapRole Role
+}
+
+// Role is who may do something to a rota. Each role includes the ones after
+// it, and workspace admins may do everything.
+type Role string
+
+const (
+	RoleAnyone  Role = "anyone"
+	RoleMembers Role = "members"
+	RoleOwners  Role = "owners"
+	RoleAdmins  Role = "admins"
+)
+
+type setting struct {
+	env          string
+	flag         string
+	defaultValue string
+	usage        string
+	isBool       bool
+}
+
+// stringFlag records a flag's raw value so it can be validated alongside the
+// environment. Boolean settings can be passed as a bare -flag.
+type stringFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *stringFlag) String() string { return f.value }
+
+func (f *stringFlag) Set(value string) error {
+	f.value = value
+	return nil
+}
+
+func (f *stringFlag) IsBoolFlag() bool { return f.isBool }
+
+var settings = []setting{
+	{env: "SLACK_AUTH_TOKEN", flag: "slack-auth-token", usage: "Slack bot token (xoxb-...)"},
+	{env: "SLACK_APP_TOKEN", flag: "slack-app-token", usage: "Slack app-level token for Socket Mode (xapp-...)"},
+	{env: "SLACK_CLIENT_ID", flag: "slack-client-id", usage: "Slack app's client ID, to install the bot in several workspaces (needs -http-addr)"},
+	{env: "SLACK_CLIENT_SECRET", flag: "slack-client-secret", usage: "Slack app's client secret"},
+	{env: "SLACK_REDIRECT_URL", flag: "slack-redirect-url", usage: "URL Slack sends installs back to, e.g. https://alfred.example.com/slack/oauth_redirect"},
+	{env: "SLACK_TRANSPORT", flag: "slack-transport", defaultValue: "socket", usage: "how Slack reaches the bot: socket for Socket Mode, or http for the Events API (needs -http-addr)"},
+	{env: "SLACK_SIGNING_SECRET", flag: "slack-signing-secret", usage: "Slack app's signing secret, to verify requests over the http transport"},
+	{env: "SLACK_TEAM_ID", flag: "slack-team-id", usage: "workspace alfred-admin works on (default SLACK_AUTH_TOKEN's)"},
+	{env: "SLACK_API_URL", flag: "slack-api-url", usage: "Slack Web API URL, to test against a fake Slack (default Slack's)"},
+	{env: "DB_TABLE_NAME", flag: "db-table-name", usage: "DynamoDB table to store rotas in"},
+	{env: "DB_ENDPOINT", flag: "db-endpoint", defaultValue: "http://localhost:8000", usage: "DynamoDB endpoint; set to empty to use AWS"},
+	{env: "DB_REGION", flag: "db-region", defaultValue: "eu-central-1", usage: "DynamoDB region"},
+	{env: "HTTP_ADDR", flag: "http-addr", usage: "address to serve health checks and metrics on, e.g. :8080"},
+	{env: "LOG_LEVEL", flag: "log-level", usage: "debug, info, warn or error (default info, or debug with -debug)"},
+	{env: "LOG_FORMAT", flag: "log-format", defaultValue: "text", usage: "text or json"},
+	{env: "DEBUG", flag: "debug", defaultValue: "false", usage: "log every Slack API and Socket Mode request", isBool: true},
+	{env: "SHUTDOWN_TIMEOUT", flag: "shutdown-timeout", defaultValue: "30s", usage: "how long to wait for in-flight work when stopping"},
+	{env: "EVENT_WORKERS", flag: "event-workers", defaultValue: "8", usage: "how many events, slash commands and interactions to handle at once"},
+	{env: "PAGE_ACK_TIMEOUT", flag: "page-ack-timeout", defaultValue: "5m", usage: "how long a page waits for an acknowledgement before escalating"},
+	{env: "ALERT_WEBHOOKS", flag: "alert-webhooks", defaultValue: "false", usage: "accept Alertmanager and JSON alerts on /alerts/ (needs -http-addr)", isBool: true},
+	{env: "REST_API", flag: "rest-api", defaultValue: "false", usage: "serve the read-only REST API on /api/v1/ (needs -http-addr)", isBool: true},
+	{env: "EDIT_ROLE", flag: "edit-role", defaultValue: "owners", usage: "who may change a rota's members, duration and integrations: anyone, members, owners or admins"},
+	{env: "START_ROLE", flag: "start-role", defaultValue: "members", usage: "who may start and stop a rota's shifts: anyone, members, owners or admins"},
+	{env: "SWAP_ROLE", flag: "swap-role", defaultValue: "members", usage: "who may swap, skip and override shifts: members, owners or admins"},
+}
+
+// Load builds the configuration from, in increasing order of precedence,
+// defaults, an optional config file, environment variables and command-line
+// flags. The config file is the one named by -config, or .env if it exists.
+func Load(args []string) (*Config, error) {
+	cfg, _, err := load("alfred-bot", args)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// LoadStore reads the configuration in the same way as Load, for tools that
+// only need the store, so Slack tokens aren't required. Flags end at the first
+// argument that isn't one, which is returned along with the rest.
+func LoadStore(name string, args []string) (*Config, []string, error) {
+	cfg, rest, err := load(name, args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cfg.DB.TableName == "" {
+		return nil, nil, errors.New("DB_TABLE_NAME is required")
+	}
+
+	return cfg, rest, nil
+}
+
+func load(name string, args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := fs.String("config", "", "path to a .env style config file (default .env, if present)")
+	flagEnvs := map[string]string{}
+	for _, s := range settings {
+		fs.Var(&stringFlag{isBool: s.isBool}, s.flag, s.usage)
+		flagEnvs[s.flag] = s.env
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	flagOverrides := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		if env, ok := flagEnvs[f.Name]; ok {
+			flagOverrides[env] = f.Value.String()
+		}
+	})
+
+	fileName := *configFile
+	required := fileName != ""
+	if fileName == "" {
+		fileName = defaultConfigFile
+	}
+
+	values, err := resolve(fileName, required, flagOverrides)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg, err := parse(values)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, fs.Args(), nil
+}
+
+// LoadForTesting reads .env.test from the module root, if there is one, on top
+// of the defaults and environment. Slack tokens aren't required, and the table
+// name defaults to a throwaway one.
+func LoadForTesting() (*Config, error) {
+	fileName := testConfigFile
+	if root, err := moduleRoot(); err == nil {
+		fileName = filepath.Join(root, testConfigFile)
+	}
+
+	values, err := resolve(fileName, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if values["DB_TABLE_NAME"] == "" {
+		values["DB_TABLE_NAME"] = testTableName
+	}
+
+	return parse(values)
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Slack.AuthToken == "" && !c.Slack.MultiWorkspace() {
+		errs = append(errs, errors.New("SLACK_AUTH_TOKEN is required, unless SLACK_CLIENT_ID is set"))
+	}
+
+	if (c.Slack.ClientID == "") != (c.Slack.ClientSecret == "") {
+		errs = append(errs, errors.New("SLACK_CLIENT_ID and SLACK_CLIENT_SECRET must be set together"))
+	}
 
-func BootstrapEnv(testing bool) {
-	var envFileName string
-	if testing {
-		projectName := regexp.MustCompile(`^(.*` + projectDirName + `)`)
-		currentWorkDirectory, _ := os.Getwd()
-		rootPath := projectName.Find([]byte(currentWorkDirectory))
-		envFileName = string(rootPath) + "/.env.test"
-	} else {
-		envFileName = ".env"
+	if c.Slack.MultiWorkspace() && c.HTTPAddr == "" {
+		errs = append(errs, errors.New("SLACK_CLIENT_ID needs HTTP_ADDR to be set, to serve the install pages"))
 	}
 
-	err := godotenv.Load(envFileName)
+	switch c.Slack.Transport {
+	case TransportSocket:
+		if c.Slack.AppToken == "" {
+			errs = append(errs, errors.New("SLACK_APP_TOKEN is required"))
+		} else if !strings.HasPrefix(c.Slack.AppToken, "xapp-") {
+			errs = append(errs, errors.New("SLACK_APP_TOKEN must be an app-level token starting with xapp-"))
+		}
+	case TransportHTTP:
+		if c.Slack.SigningSecret == "" {
+			errs = append(errs, errors.New("SLACK_TRANSPORT=http needs SLACK_SIGNING_SECRET to be set"))
+		}
+
+		if c.HTTPAddr == "" {
+			errs = append(errs, errors.New("SLACK_TRANSPORT=http needs HTTP_ADDR to be set"))
+		}
+	}
+
+	if c.DB.TableName == "" {
+		errs = append(errs, errors.New("DB_TABLE_NAME is required"))
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("SHUTDOWN_TIMEOUT must be positive"))
+	}
+
+	if c.EventWorkers <= 0 {
+		errs = append(errs, errors.New("EVENT_WORKERS must be positive"))
+	}
+
+	if c.Rota.PageAckTimeout <= 0 {
+		errs = append(errs, errors.New("PAGE_ACK_TIMEOUT must be positive"))
+	}
+
+	if c.Rota.AlertWebhooks && c.HTTPAddr == "" {
+		errs = append(errs, errors.New("ALERT_WEBHOOKS needs HTTP_ADDR to be set"))
+	}
+
+	if c.Rota.RestAPI && c.HTTPAddr == "" {
+		errs = append(errs, errors.New("REST_API needs HTTP_ADDR to be set"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func resolve(fileName string, required bool, flagOverrides map[string]string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, s := range settings {
+		values[s.env] = s.defaultValue
+	}
+
+	fileValues, err := godotenv.Read(fileName)
+	if err != nil && (required || !errors.Is(err, os.ErrNotExist)) {
+		return nil, fmt.Errorf("could not read config file %s: %w", fileName, err)
+	}
+
+	for _, s := range settings {
+		if v, ok := fileValues[s.env]; ok {
+			values[s.env] = v
+		}
+		if v, ok := os.LookupEnv(s.env); ok {
+			values[s.env] = v
+		}
+		if v, ok := flagOverrides[s.env]; ok {
+			values[s.env] = v
+		}
+	}
+
+	return values, nil
+}
+
+func parse(values map[string]string) (*Config, error) {
+	var errs []error
+
+	debug, err := strconv.ParseBool(values["DEBUG"])
+	if err != nil {
+		errs = append(errs, fmt.Errorf("DEBUG must be true or false, got %q", values["DEBUG"]))
+	}
+
+	shutdownTimeout, err := time.ParseDuration(values["SHUTDOWN_TIMEOUT"])
+	if err != nil {
+		errs = append(errs, fmt.Errorf("SHUTDOWN_TIMEOUT must be a duration such as 30s, got %q", values["SHUTDOWN_TIMEOUT"]))
+	}
+
+	eventWorkers, err := strconv.Atoi(values["EVENT_WORKERS"])
+	if err != nil {
+		errs = append(errs, fmt.Errorf("EVENT_WORKERS must be a whole number, got %q", values["EVENT_WORKERS"]))
+	}
+
+	alertWebhooks, err := strconv.ParseBool(values["ALERT_WEBHOOKS"])
+	if err != nil {
+		errs = append(errs, fmt.Errorf("ALERT_WEBHOOKS must be true or false, got %q", values["ALERT_WEBHOOKS"]))
+	}
+
+	restAPI, err := strconv.ParseBool(values["REST_API"])
+	if err != nil {
+		errs = append(errs, fmt.Errorf("REST_API must be true or false, got %q", values["REST_API"]))
+	}
+
+	pageAckTimeout, err := time.ParseDuration(values["PAGE_ACK_TIMEOUT"])
+	if err != nil {
+		errs = append(errs, fmt.Errorf("PAGE_ACK_TIMEOUT must be a duration such as 5m, got %q", values["PAGE_ACK_TIMEOUT"]))
+	}
+
+	editRole, err := parseRole("EDIT_ROLE", values["EDIT_ROLE"], RoleAnyone, RoleMembers, RoleOwners, RoleAdmins)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	startRole, err := parseRole("START_ROLE", values["START_ROLE"], RoleAnyone, RoleMembers, RoleOwners, RoleAdmins)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	// Only members have shifts to swap.
+	swapRole, err := parseRole("SWAP_ROLE", values["SWAP_ROLE"], RoleMembers, RoleOwners, RoleAdmins)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	transport := Transport(strings.ToLower(values["SLACK_TRANSPORT"]))
+	switch transport {
+	case TransportSocket, TransportHTTP:
+	default:
+		errs = append(errs, fmt.Errorf("SLACK_TRANSPORT must be socket or http, got %q", values["SLACK_TRANSPORT"]))
+	}
+
+	logLevel := values["LOG_LEVEL"]
+	if logLevel == "" && debug {
+		logLevel = "debug"
+	} else if logLevel == "" {
+		logLevel = "info"
+	}
+
+	switch strings.ToLower(logLevel) {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, got %q", logLevel))
+	}
+
+	switch strings.ToLower(values["LOG_FORMAT"]) {
+	case "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("LOG_FORMAT must be text or json, got %q", values["LOG_FORMAT"]))
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return &Config{
+		Slack: SlackConfig{
+			AuthToken:     values["SLACK_AUTH_TOKEN"],
+			AppToken:      values["SLACK_APP_TOKEN"],
+			ClientID:      values["SLACK_CLIENT_ID"],
+			ClientSecret:  values["SLACK_CLIENT_SECRET"],
+			RedirectURL:   values["SLACK_REDIRECT_URL"],
+			TeamID:        values["SLACK_TEAM_ID"],
+			Transport:     transport,
+			SigningSecret: values["SLACK_SIGNING_SECRET"],
+			APIURL:        values["SLACK_API_URL"],
+		},
+		DB: DBConfig{
+			TableName: values["DB_TABLE_NAME"],
+			Endpoint:  values["DB_ENDPOINT"],
+			Region:    values["DB_REGION"],
+		},
+		HTTPAddr: values["HTTP_ADDR"],
+		Log: LogConfig{
+			Level:  logLevel,
+			Format: values["LOG_FORMAT"],
+		},
+		Rota: RotaConfig{
+			PageAckTimeout: pageAckTimeout,
+			AlertWebhooks:  alertWebhooks,
+			RestAPI:        restAPI,
+			EditRole:       editRole,
+			StartRole:      startRole,
+			SwapRole:       swapRole,
+		},
+		Debug:           debug,
+		ShutdownTimeout: shutdownTimeout,
+		EventWorkers:    eventWorkers,
+	}, nil
+}
+
+func parseRole(env string, value string, allowed ...Role) (Role, error) {
+	names := make([]string, 0, len(allowed))
+	for _, v := range allowed {
+		if Role(strings.ToLower(value)) == v {
+			return v, nil
+		}
+		names = append(names, string(v))
+	}
+
+	return "", fmt.Errorf("%s must be %s or %s, got %q", env, strings.Join(names[:len(names)-1], ", "), names[len(names)-1], value)
+}
+
+// moduleRoot walks up from the working directory to the one holding go.mod.
+func moduleRoot() (string, error) {
+	dir, err := os.Getwd()
 	if err != nil {
-		panic(err)
+		return "", err
+	}
+
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("could not find go.mod")
+		}
+		dir = parent
 	}
 }
@@ -0,0 +1,212 @@
+package config
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfig(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Config Suite")
+}
+
+var _ = Describe("Load", func() {
+	var configFile string
+
+	setEnv := func(key string, value string) {
+		previous, existed := os.LookupEnv(key)
+		Expect(os.Setenv(key, value)).To(Succeed())
+		DeferCleanup(func() {
+			if existed {
+				_ = os.Setenv(key, previous)
+			} else {
+				_ = os.Unsetenv(key)
+			}
+		})
+	}
+
+	BeforeEach(func() {
+		for _, s := range settings {
+			if previous, existed := os.LookupEnv(s.env); existed {
+				Expect(os.Unsetenv(s.env)).To(Succeed())
+				DeferCleanup(os.Setenv, s.env, previous)
+			}
+		}
+
+		configFile = filepath.Join(GinkgoT().TempDir(), "alfred.env")
+		Expect(os.WriteFile(configFile, []byte("SLACK_AUTH_TOKEN=xoxb-file\nSLACK_APP_TOKEN=xapp-file\nDB_TABLE_NAME=rotas\n"), 0o600)).To(Succeed())
+	})
+
+	It("Reads the config file and fills in defaults", func() {
+		cfg, err := Load([]string{"-config", configFile})
+		Expect(err).To(BeNil())
+		Expect(cfg.Slack.AuthToken).To(Equal("xoxb-file"))
+		Expect(cfg.Slack.AppToken).To(Equal("xapp-file"))
+		Expect(cfg.Slack.Transport).To(Equal(TransportSocket))
+		Expect(cfg.DB).To(Equal(DBConfig{TableName: "rotas", Endpoint: "http://localhost:8000", Region: "eu-central-1"}))
+		Expect(cfg.Log).To(Equal(LogConfig{Level: "info", Format: "text"}))
+		Expect(cfg.Debug).To(BeFalse())
+		Expect(cfg.ShutdownTimeout).To(Equal(30 * time.Second))
+		Expect(cfg.EventWorkers).To(Equal(8))
+		Expect(cfg.Rota.PageAckTimeout).To(Equal(5 * time.Minute))
+	})
+
+	It("Lets the environment override the file and flags override both", func() {
+		setEnv("DB_TABLE_NAME", "env-rotas")
+		setEnv("HTTP_ADDR", ":8080")
+
+		cfg, err := Load([]string{"-config", configFile, "-http-addr", ":9090", "-debug"})
+		Expect(err).To(BeNil())
+		Expect(cfg.DB.TableName).To(Equal("env-rotas"))
+		Expect(cfg.HTTPAddr).To(Equal(":9090"))
+		Expect(cfg.Debug).To(BeTrue())
+		Expect(cfg.Log.Level).To(Equal("debug"))
+	})
+
+	It("Allows the DynamoDB endpoint to be cleared to use AWS", func() {
+		setEnv("DB_ENDPOINT", "")
+
+		cfg, err := Load([]string{"-config", configFile})
+		Expect(err).To(BeNil())
+		Expect(cfg.DB.Endpoint).To(Equal(""))
+	})
+
+	It("Works without a config file when everything is in the environment", func() {
+		setEnv("SLACK_AUTH_TOKEN", "xoxb-env")
+		setEnv("SLACK_APP_TOKEN", "xapp-env")
+		setEnv("DB_TABLE_NAME", "rotas")
+		workingDir, err := os.Getwd()
+		Expect(err).To(BeNil())
+		Expect(os.Chdir(GinkgoT().TempDir())).To(Succeed())
+		DeferCleanup(os.Chdir, workingDir)
+
+		cfg, err := Load(nil)
+		Expect(err).To(BeNil())
+		Expect(cfg.Slack.AuthToken).To(Equal("xoxb-env"))
+	})
+
+	It("Fails when an explicitly named config file is missing", func() {
+		_, err := Load([]string{"-config", filepath.Join(GinkgoT().TempDir(), "missing.env")})
+		Expect(err).To(MatchError(ContainSubstring("could not read config file")))
+	})
+
+	It("Reports every invalid setting at once", func() {
+		setEnv("SLACK_APP_TOKEN", "xoxb-wrong")
+		setEnv("DB_TABLE_NAME", "")
+		setEnv("LOG_FORMAT", "xml")
+		setEnv("SHUTDOWN_TIMEOUT", "soon")
+		setEnv("EVENT_WORKERS", "lots")
+
+		_, err := Load([]string{"-config", configFile})
+		Expect(err).To(MatchError(ContainSubstring("LOG_FORMAT must be text or json")))
+		Expect(err).To(MatchError(ContainSubstring("SHUTDOWN_TIMEOUT must be a duration")))
+		Expect(err).To(MatchError(ContainSubstring("EVENT_WORKERS must be a whole number")))
+
+		setEnv("LOG_FORMAT", "json")
+		setEnv("SHUTDOWN_TIMEOUT", "10s")
+		setEnv("EVENT_WORKERS", "0")
+
+		_, err = Load([]string{"-config", configFile})
+		Expect(err).To(MatchError(ContainSubstring("SLACK_APP_TOKEN must be an app-level token")))
+		Expect(err).To(MatchError(ContainSubstring("DB_TABLE_NAME is required")))
+		Expect(err).To(MatchError(ContainSubstring("EVENT_WORKERS must be positive")))
+	})
+
+	It("Only accepts alerts when the HTTP server is on", func() {
+		_, err := Load([]string{"-config", configFile, "-alert-webhooks"})
+		Expect(err).To(MatchError(ContainSubstring("ALERT_WEBHOOKS needs HTTP_ADDR")))
+
+		cfg, err := Load([]string{"-config", configFile, "-alert-webhooks", "-http-addr", ":8080"})
+		Expect(err).To(BeNil())
+		Expect(cfg.Rota.AlertWebhooks).To(BeTrue())
+	})
+
+	It("Only serves the REST API when the HTTP server is on", func() {
+		_, err := Load([]string{"-config", configFile, "-rest-api"})
+		Expect(err).To(MatchError(ContainSubstring("REST_API needs HTTP_ADDR")))
+
+		cfg, err := Load([]string{"-config", configFile, "-rest-api", "-http-addr", ":8080"})
+		Expect(err).To(BeNil())
+		Expect(cfg.Rota.RestAPI).To(BeTrue())
+	})
+
+	It("Can be installed in several workspaces instead of needing a bot token", func() {
+		Expect(os.WriteFile(configFile, []byte("SLACK_APP_TOKEN=xapp-file\nDB_TABLE_NAME=rotas\n"), 0o600)).To(Succeed())
+
+		_, err := Load([]string{"-config", configFile, "-slack-client-id", "123.456"})
+		Expect(err).To(MatchError(ContainSubstring("SLACK_CLIENT_ID and SLACK_CLIENT_SECRET must be set together")))
+		Expect(err).To(MatchError(ContainSubstring("SLACK_CLIENT_ID needs HTTP_ADDR")))
+
+		cfg, err := Load([]string{"-config", configFile, "-slack-client-id", "123.456", "-slack-client-secret", "shh", "-http-addr", ":8080"})
+		Expect(err).To(BeNil())
+		Expect(cfg.Slack.MultiWorkspace()).To(BeTrue())
+
+		_, err = Load([]string{"-config", configFile})
+		Expect(err).To(MatchError(ContainSubstring("SLACK_AUTH_TOKEN is required")))
+	})
+
+	It("Can take requests from Slack over HTTP instead of Socket Mode", func() {
+		Expect(os.WriteFile(configFile, []byte("SLACK_AUTH_TOKEN=xoxb-file\nDB_TABLE_NAME=rotas\n"), 0o600)).To(Succeed())
+
+		_, err := Load([]string{"-config", configFile, "-slack-transport", "http"})
+		Expect(err).To(MatchError(ContainSubstring("SLACK_TRANSPORT=http needs SLACK_SIGNING_SECRET")))
+		Expect(err).To(MatchError(ContainSubstring("SLACK_TRANSPORT=http needs HTTP_ADDR")))
+		Expect(err).ToNot(MatchError(ContainSubstring("SLACK_APP_TOKEN")))
+
+		cfg, err := Load([]string{"-config", configFile, "-slack-transport", "HTTP", "-slack-signing-secret", "shh", "-http-addr", ":8080"})
+		Expect(err).To(BeNil())
+		Expect(cfg.Slack.Transport).To(Equal(TransportHTTP))
+
+		_, err = Load([]string{"-config", configFile, "-slack-transport", "webhook"})
+		Expect(err).To(MatchError(ContainSubstring(`SLACK_TRANSPORT must be socket or http, got "webhook"`)))
+
+		_, err = Load([]string{"-config", configFile})
+		Expect(err).To(MatchError(ContainSubstring("SLACK_APP_TOKEN is required")))
+	})
+
+	It("Defaults to owners editing rotas and members running them", func() {
+		cfg, err := Load([]string{"-config", configFile, "-start-role", "Owners"})
+		Expect(err).To(BeNil())
+		Expect(cfg.Rota.EditRole).To(Equal(RoleOwners))
+		Expect(cfg.Rota.StartRole).To(Equal(RoleOwners))
+		Expect(cfg.Rota.SwapRole).To(Equal(RoleMembers))
+
+		_, err = Load([]string{"-config", configFile, "-edit-role", "everyone", "-swap-role", "anyone"})
+		Expect(err).To(MatchError(ContainSubstring(`EDIT_ROLE must be anyone, members, owners or admins, got "everyone"`)))
+		Expect(err).To(MatchError(ContainSubstring(`SWAP_ROLE must be members, owners or admins, got "anyone"`)))
+	})
+})
+
+var _ = Describe("LoadStore", func() {
+	It("Only needs the store's settings, and returns the arguments after the flags", func() {
+		for _, env := range []string{"SLACK_AUTH_TOKEN", "SLACK_APP_TOKEN", "DB_TABLE_NAME"} {
+			if previous, existed := os.LookupEnv(env); existed {
+				Expect(os.Unsetenv(env)).To(Succeed())
+				DeferCleanup(os.Setenv, env, previous)
+			}
+		}
+
+		configFile := filepath.Join(GinkgoT().TempDir(), "alfred.env")
+		Expect(os.WriteFile(configFile, []byte("DB_TABLE_NAME=rotas\n"), 0o600)).To(Succeed())
+
+		cfg, rest, err := LoadStore("alfred-admin", []string{"-config", configFile, "show", "-json", "C0123", "payments"})
+		Expect(err).To(BeNil())
+		Expect(cfg.DB.TableName).To(Equal("rotas"))
+		Expect(rest).To(Equal([]string{"show", "-json", "C0123", "payments"}))
+
+		_, _, err = LoadStore("alfred-admin", []string{"-config", configFile, "-db-table-name", "", "list"})
+		Expect(err).To(MatchError("DB_TABLE_NAME is required"))
+	})
+})
+
+var _ = Describe("LoadForTesting", func() {
+	It("Does not require Slack tokens", func() {
+		cfg, err := LoadForTesting()
+		Expect(err).To(BeNil())
+		Expect(cfg.DB.TableName).ToNot(BeEmpty())
+	})
+})
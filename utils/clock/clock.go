@@ -0,0 +1,42 @@
+package clock
+
+import "time"
+
+// Clock is the source of the current time for anything that schedules or
+// calculates shifts, so that tests can control how time passes.
+type Clock interface {
+	Now() time.Time
+	// TimerAt returns a timer that fires once the clock reaches t.
+	TimerAt(t time.Time) Timer
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type realClock struct{}
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func New() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) TimerAt(t time.Time) Timer {
+	return &realTimer{timer: time.NewTimer(time.Until(t))}
+}
+
+func (t *realTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+func (t *realTimer) Stop() bool {
+	return t.timer.Stop()
+}
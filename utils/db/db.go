@@ -1,13 +1,37 @@
 package db
 
 import (
+	appconfig "alfred-bot/config"
+	"alfred-bot/utils/metrics"
 	"context"
+	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
-	"os"
+	"github.com/aws/smithy-go/middleware"
+	"log/slog"
+	"time"
+)
+
+// TTLAttribute is the attribute, in Unix seconds, after which DynamoDB deletes
+// an item, such as an old webhook delivery.
+const TTLAttribute = "expiresAt"
+
+var (
+	callDuration = metrics.NewHistogram(
+		"alfred_dynamodb_request_duration_seconds",
+		"Latency of DynamoDB calls, by operation.",
+		metrics.DefBuckets,
+		"operation",
+	)
+	callErrors = metrics.NewCounter(
+		"alfred_dynamodb_request_errors_total",
+		"DynamoDB calls that returned an error, by operation.",
+		"operation",
+	)
 )
 
 type Database struct {
@@ -15,20 +39,28 @@ type Database struct {
 	Client    *dynamodb.Client
 }
 
-func New() *Database {
-	tableName := os.Getenv("DB_TABLE_NAME")
+func New(cfg appconfig.DBConfig) (*Database, error) {
+	tableName := cfg.TableName
 
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+	awsCfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(cfg.Region))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	svc := dynamodb.NewFromConfig(cfg, func(options *dynamodb.Options) {
-		options.Region = "eu-central-1"
-		options.Credentials = credentials.StaticCredentialsProvider{
+	if cfg.Endpoint != "" && !hasCredentials(awsCfg) {
+		// DynamoDB Local accepts any credentials, so don't make developers
+		// set up a real AWS profile. Endpoints that need real credentials,
+		// such as a VPC endpoint, still get them from the default chain.
+		awsCfg.Credentials = credentials.StaticCredentialsProvider{
 			Value: aws.Credentials{AccessKeyID: "dummy", SecretAccessKey: "dummy"},
 		}
-		options.EndpointResolver = dynamodb.EndpointResolverFromURL("http://localhost:8000")
+	}
+
+	svc := dynamodb.NewFromConfig(awsCfg, func(options *dynamodb.Options) {
+		if cfg.Endpoint != "" {
+			options.EndpointResolver = dynamodb.EndpointResolverFromURL(cfg.Endpoint)
+		}
+		options.APIOptions = append(options.APIOptions, addMetricsMiddleware)
 	})
 
 	_, err = svc.DescribeTable(context.TODO(), &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
@@ -58,14 +90,66 @@ func New() *Database {
 			BillingMode: types.BillingModePayPerRequest,
 		})
 		if err != nil {
-			panic(err)
+			return nil, fmt.Errorf("could not create table %s: %w", tableName, err)
 		}
 	}
 
+	// The bot works without expiry, so a role that can't turn it on only
+	// means old items are kept.
+	err = enableTTL(svc, tableName)
+	if err != nil {
+		slog.Warn("Could not turn on expiry of old items", "table", tableName, "error", err)
+	}
+
 	return &Database{
 		Client:    svc,
 		TableName: tableName,
+	}, nil
+}
+
+// enableTTL turns on deleting items once their TTLAttribute has passed, unless
+// it's already on. A table can only expire items by one attribute, so if it's
+// already on for another, old items won't be deleted and an error is returned.
+func enableTTL(svc *dynamodb.Client, tableName string) error {
+	out, err := svc.DescribeTimeToLive(context.TODO(), &dynamodb.DescribeTimeToLiveInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return err
+	}
+
+	if d := out.TimeToLiveDescription; d != nil && d.TimeToLiveStatus != types.TimeToLiveStatusDisabled {
+		if name := aws.ToString(d.AttributeName); name != TTLAttribute {
+			return fmt.Errorf("expiry is on for %s rather than %s", name, TTLAttribute)
+		}
+		return nil
+	}
+
+	_, err = svc.UpdateTimeToLive(context.TODO(), &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(tableName),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String(TTLAttribute),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	return err
+}
+
+// hasCredentials reports whether the default credential chain found any.
+func hasCredentials(awsCfg aws.Config) bool {
+	if awsCfg.Credentials == nil {
+		return false
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := awsCfg.Credentials.Retrieve(ctx)
+	return err == nil
+}
+
+// Ping checks that the table can be reached.
+func (d *Database) Ping(ctx context.Context) error {
+	_, err := d.Client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(d.TableName)})
+	return err
 }
 
 func (d *Database) DeleteTable() {
@@ -74,3 +158,22 @@ func (d *Database) DeleteTable() {
 		panic(err)
 	}
 }
+
+func addMetricsMiddleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(
+		middleware.InitializeMiddlewareFunc("Metrics", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			operation := awsmiddleware.GetOperationName(ctx)
+			start := time.Now()
+
+			out, metadata, err := next.HandleInitialize(ctx, in)
+
+			callDuration.Observe(metrics.Since(start), operation)
+			if err != nil {
+				callErrors.Inc(operation)
+			}
+
+			return out, metadata, err
+		}),
+		middleware.After,
+	)
+}
@@ -0,0 +1,476 @@
+package fakeslack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/slack-go/slack"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TeamId is the workspace the fake Slack says the bot is installed in.
+const TeamId = "T0123"
+
+// ackTimeout is how long Send waits for the bot to acknowledge a request.
+const ackTimeout = 5 * time.Second
+
+// Message is a message the bot posted, updated or showed to one user.
+type Message struct {
+	Channel string
+	// User is who an ephemeral message was shown to.
+	User string
+	// Timestamp identifies the message, and ThreadTimestamp the thread it was
+	// posted in, if any.
+	Timestamp       string
+	ThreadTimestamp string
+	Attachments     []slack.Attachment
+}
+
+// Text is the text of the message's first attachment, which is where the bot
+// puts it.
+func (m Message) Text() string {
+	if len(m.Attachments) == 0 {
+		return ""
+	}
+	return m.Attachments[0].Text
+}
+
+// View is a modal the bot opened, or a Home tab it published.
+type View struct {
+	// TriggerId is set for modals, and UserId for Home tabs.
+	TriggerId string
+	UserId    string
+	View      slack.View
+}
+
+// Server is an in-process fake of the Slack Web API and Socket Mode. It records
+// what the bot posts and shows, and sends the bot slash commands,
+// interactions and events over Socket Mode as Slack would.
+type Server struct {
+	server *httptest.Server
+
+	mu         sync.Mutex
+	calls      []string
+	messages   []Message
+	ephemerals []Message
+	updates    []Message
+	views      []View
+	responses  []json.RawMessage
+	users      map[string]slack.User
+	nextTs     int
+	nextId     int
+	acks       map[string]chan json.RawMessage
+
+	// writeMu serialises writes to conn, which is set once the bot connects.
+	writeMu   sync.Mutex
+	conn      *websocket.Conn
+	connected chan struct{}
+}
+
+func New() *Server {
+	s := &Server{
+		users:     map[string]slack.User{},
+		acks:      map[string]chan json.RawMessage{},
+		connected: make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", s.handleAPI)
+	mux.HandleFunc("/link", s.handleLink)
+	mux.HandleFunc("/response", s.handleResponse)
+	s.server = httptest.NewServer(mux)
+
+	return s
+}
+
+func (s *Server) Close() {
+	s.writeMu.Lock()
+	if s.conn != nil {
+		_ = s.conn.Close()
+	}
+	s.writeMu.Unlock()
+
+	s.server.CloseClientConnections()
+	s.server.Close()
+}
+
+// APIURL is the fake's Web API, for slack.OptionAPIURL.
+func (s *Server) APIURL() string {
+	return s.server.URL + "/api/"
+}
+
+// ResponseURL is where the bot answers slash commands it couldn't answer in
+// time. SlashCommand uses it unless the command has its own.
+func (s *Server) ResponseURL() string {
+	return s.server.URL + "/response"
+}
+
+// AddUser sets what users.info returns for the user, such as whether they're
+// a workspace admin. Other users exist, but are nothing special.
+func (s *Server) AddUser(user slack.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[user.ID] = user
+}
+
+// Calls lists the Web API methods called, in order.
+func (s *Server) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.calls...)
+}
+
+// Messages lists the messages posted, in order.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Message(nil), s.messages...)
+}
+
+// MessagesTo lists the text of the messages posted to a channel, or sent to a
+// user, in order.
+func (s *Server) MessagesTo(channel string) []string {
+	var texts []string
+	for _, m := range s.Messages() {
+		if m.Channel == channel {
+			texts = append(texts, m.Text())
+		}
+	}
+
+	return texts
+}
+
+// Ephemerals lists the messages shown to one user, in order.
+func (s *Server) Ephemerals() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Message(nil), s.ephemerals...)
+}
+
+// Updates lists the changes made to messages, in order.
+func (s *Server) Updates() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Message(nil), s.updates...)
+}
+
+// Views lists the modals opened and Home tabs published, in order.
+func (s *Server) Views() []View {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]View(nil), s.views...)
+}
+
+// Responses lists what was posted to ResponseURL, in order.
+func (s *Server) Responses() []json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]json.RawMessage(nil), s.responses...)
+}
+
+func (s *Server) handleResponse(w http.ResponseWriter, r *http.Request) {
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses = append(s.responses, body)
+}
+
+func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/api/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, method)
+
+	var response interface{}
+	var err error
+	switch method {
+	case "apps.connections.open":
+		response = map[string]interface{}{"ok": true, "url": "ws" + strings.TrimPrefix(s.server.URL, "http") + "/link"}
+	case "auth.test":
+		response = map[string]interface{}{"ok": true, "team_id": TeamId, "user_id": "UBOT"}
+	case "chat.postMessage":
+		var m Message
+		m, err = s.message(r)
+		if err == nil {
+			s.messages = append(s.messages, m)
+			response = map[string]interface{}{"ok": true, "channel": m.Channel, "ts": m.Timestamp}
+		}
+	case "chat.postEphemeral":
+		var m Message
+		m, err = s.message(r)
+		if err == nil {
+			s.ephemerals = append(s.ephemerals, m)
+			response = map[string]interface{}{"ok": true, "message_ts": m.Timestamp}
+		}
+	case "chat.update":
+		var m Message
+		m, err = s.message(r)
+		if err == nil {
+			m.Timestamp = r.FormValue("ts")
+			s.updates = append(s.updates, m)
+			response = map[string]interface{}{"ok": true, "channel": m.Channel, "ts": m.Timestamp}
+		}
+	case "views.open", "views.publish":
+		var request struct {
+			TriggerId string     `json:"trigger_id"`
+			UserId    string     `json:"user_id"`
+			View      slack.View `json:"view"`
+		}
+		err = json.NewDecoder(r.Body).Decode(&request)
+		if err == nil {
+			s.views = append(s.views, View{TriggerId: request.TriggerId, UserId: request.UserId, View: request.View})
+			response = map[string]interface{}{"ok": true, "view": request.View}
+		}
+	case "users.info":
+		user, ok := s.users[r.FormValue("user")]
+		if !ok {
+			user = slack.User{ID: r.FormValue("user")}
+		}
+		response = map[string]interface{}{"ok": true, "user": user}
+	case "users.lookupByEmail":
+		response = map[string]interface{}{"ok": false, "error": "users_not_found"}
+	default:
+		response = map[string]interface{}{"ok": true}
+	}
+
+	if err != nil {
+		response = map[string]interface{}{"ok": false, "error": "invalid_arguments", "detail": err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// message reads a chat.* call's form. It must be called with mu held.
+func (s *Server) message(r *http.Request) (Message, error) {
+	s.nextTs++
+	m := Message{
+		Channel:         r.FormValue("channel"),
+		User:            r.FormValue("user"),
+		Timestamp:       fmt.Sprintf("1646643600.%06d", s.nextTs),
+		ThreadTimestamp: r.FormValue("thread_ts"),
+	}
+
+	if attachments := r.FormValue("attachments"); attachments != "" {
+		if err := json.Unmarshal([]byte(attachments), &m.Attachments); err != nil {
+			return Message{}, err
+		}
+	}
+
+	return m, nil
+}
+
+// upgrader lets slack-go connect, though it says it's from api.slack.com.
+var upgrader = websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+func (s *Server) handleLink(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	s.writeMu.Lock()
+	first := s.conn == nil
+	s.conn = conn
+	err = conn.WriteJSON(map[string]interface{}{
+		"type":            "hello",
+		"num_connections": 1,
+		"connection_info": map[string]string{"app_id": "A0123"},
+	})
+	s.writeMu.Unlock()
+	if err != nil {
+		return
+	}
+
+	if first {
+		close(s.connected)
+	}
+
+	// Pass each acknowledgement to whoever sent the request.
+	for {
+		var ack struct {
+			EnvelopeId string          `json:"envelope_id"`
+			Payload    json.RawMessage `json:"payload"`
+		}
+		if err := conn.ReadJSON(&ack); err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		c, ok := s.acks[ack.EnvelopeId]
+		delete(s.acks, ack.EnvelopeId)
+		s.mu.Unlock()
+
+		if ok {
+			c <- ack.Payload
+		}
+	}
+}
+
+// Send sends the bot a Socket Mode request of the given type, such as
+// "slash_commands", and returns the payload the bot acknowledged it with. It
+// waits for the bot to connect first.
+func (s *Server) Send(requestType string, payload interface{}) (json.RawMessage, error) {
+	select {
+	case <-s.connected:
+	case <-time.After(ackTimeout):
+		return nil, errors.New("the bot didn't connect over Socket Mode")
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.nextId++
+	envelopeId := fmt.Sprintf("envelope-%d", s.nextId)
+	ack := make(chan json.RawMessage, 1)
+	s.acks[envelopeId] = ack
+	s.mu.Unlock()
+
+	s.writeMu.Lock()
+	err = s.conn.WriteJSON(map[string]interface{}{
+		"type":                     requestType,
+		"envelope_id":              envelopeId,
+		"payload":                  json.RawMessage(body),
+		"accepts_response_payload": requestType == "slash_commands",
+	})
+	s.writeMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case payload := <-ack:
+		return payload, nil
+	case <-time.After(ackTimeout):
+		return nil, fmt.Errorf("the bot didn't acknowledge %s", envelopeId)
+	}
+}
+
+// SlashCommand runs a slash command in the fake's workspace, and returns the
+// response the bot acknowledged it with.
+func (s *Server) SlashCommand(command slack.SlashCommand) (json.RawMessage, error) {
+	if command.TeamID == "" {
+		command.TeamID = TeamId
+	}
+	if command.ResponseURL == "" {
+		command.ResponseURL = s.ResponseURL()
+	}
+
+	return s.Send("slash_commands", command)
+}
+
+// Interaction sends the bot an interaction, such as a button press or a
+// modal being submitted, from the fake's workspace.
+func (s *Server) Interaction(interaction slack.InteractionCallback) error {
+	if interaction.Team.ID == "" {
+		interaction.Team.ID = TeamId
+	}
+
+	_, err := s.Send("interactive", interaction)
+	return err
+}
+
+// BlockAction presses a button, or picks an option, in a channel's message.
+func (s *Server) BlockAction(channelId string, userId string, action slack.BlockAction) error {
+	interaction := slack.InteractionCallback{Type: slack.InteractionTypeBlockActions, TriggerID: s.triggerId()}
+	interaction.Channel.ID = channelId
+	interaction.User.ID = userId
+	// Slack always says which block an action was in, and slack-go needs it to
+	// tell block actions from legacy attachment actions.
+	if action.BlockID == "" {
+		action.BlockID = "block"
+	}
+	interaction.ActionCallback.BlockActions = []*slack.BlockAction{&action}
+
+	return s.Interaction(interaction)
+}
+
+// Submit submits a modal the bot opened, with its inputs set to values.
+func (s *Server) Submit(userId string, view slack.View, values map[string]map[string]slack.BlockAction) error {
+	interaction := slack.InteractionCallback{Type: slack.InteractionTypeViewSubmission}
+	interaction.User.ID = userId
+	interaction.View = view
+	interaction.View.State = &slack.ViewState{Values: values}
+
+	return s.Interaction(interaction)
+}
+
+// Fill answers a modal's inputs in order, as Submit expects them. Text inputs
+// take the answer as it is, user pickers a comma-separated list of user IDs,
+// and the rest the value of the option to pick.
+func Fill(view slack.View, answers ...string) map[string]map[string]slack.BlockAction {
+	values := map[string]map[string]slack.BlockAction{}
+	for _, block := range view.Blocks.BlockSet {
+		input, ok := block.(*slack.InputBlock)
+		if !ok || len(answers) == 0 {
+			continue
+		}
+		answer := answers[0]
+		answers = answers[1:]
+
+		var actionId string
+		var action slack.BlockAction
+		switch element := input.Element.(type) {
+		case *slack.PlainTextInputBlockElement:
+			actionId = element.ActionID
+			action.Value = answer
+		case *slack.MultiSelectBlockElement:
+			actionId = element.ActionID
+			action.SelectedUsers = strings.Split(answer, ",")
+		case *slack.SelectBlockElement:
+			actionId = element.ActionID
+			action.SelectedOption = slack.OptionBlockObject{Value: answer}
+		case *slack.RadioButtonsBlockElement:
+			actionId = element.ActionID
+			action.SelectedOption = slack.OptionBlockObject{Value: answer}
+		default:
+			continue
+		}
+
+		action.ActionID = actionId
+		action.BlockID = input.BlockID
+		values[input.BlockID] = map[string]slack.BlockAction{actionId: action}
+	}
+
+	return values
+}
+
+// Event sends the bot an Events API event, such as {"type": "app_mention",
+// ...}, from the fake's workspace.
+func (s *Server) Event(event interface{}) error {
+	_, err := s.Send("events_api", map[string]interface{}{
+		"type":    "event_callback",
+		"team_id": TeamId,
+		"event":   event,
+	})
+	return err
+}
+
+func (s *Server) triggerId() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextId++
+	return fmt.Sprintf("trigger-%d", s.nextId)
+}
@@ -0,0 +1,87 @@
+package fakeslack
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/socketmode"
+	"testing"
+)
+
+func TestFakeSlack(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "FakeSlack Suite")
+}
+
+var _ = Describe("Server", func() {
+	var server *Server
+	var client *slack.Client
+
+	BeforeEach(func() {
+		server = New()
+		DeferCleanup(server.Close)
+
+		client = slack.New("xoxb-test", slack.OptionAPIURL(server.APIURL()), slack.OptionAppLevelToken("xapp-test"))
+	})
+
+	It("Records messages", func() {
+		_, _, err := client.PostMessage("C0123", slack.MsgOptionAttachments(slack.Attachment{Text: "Hello"}))
+		Expect(err).To(BeNil())
+		_, err = client.PostEphemeral("C0123", "U0123", slack.MsgOptionAttachments(slack.Attachment{Text: "Just you"}))
+		Expect(err).To(BeNil())
+
+		Expect(server.MessagesTo("C0123")).To(Equal([]string{"Hello"}))
+		Expect(server.Ephemerals()).To(HaveLen(1))
+		Expect(server.Ephemerals()[0].User).To(Equal("U0123"))
+		Expect(server.Ephemerals()[0].Text()).To(Equal("Just you"))
+	})
+
+	It("Records views", func() {
+		_, err := client.OpenView("trigger-1", slack.ModalViewRequest{Type: slack.VTModal, CallbackID: "create"})
+		Expect(err).To(BeNil())
+		_, err = client.PublishView("U0123", slack.HomeTabViewRequest{Type: slack.VTHomeTab}, "")
+		Expect(err).To(BeNil())
+
+		views := server.Views()
+		Expect(views).To(HaveLen(2))
+		Expect(views[0].TriggerId).To(Equal("trigger-1"))
+		Expect(views[0].View.CallbackID).To(Equal("create"))
+		Expect(views[1].UserId).To(Equal("U0123"))
+	})
+
+	It("Sends slash commands over Socket Mode, and returns the response", func() {
+		socketClient := socketmode.New(client)
+		ctx, cancel := context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+		go func() {
+			_ = socketClient.RunContext(ctx)
+		}()
+		go func() {
+			for event := range socketClient.Events {
+				if event.Type == socketmode.EventTypeSlashCommand {
+					command := event.Data.(slack.SlashCommand)
+					socketClient.Ack(*event.Request, map[string]string{"text": "Ran " + command.Command})
+				}
+			}
+		}()
+
+		response, err := server.SlashCommand(slack.SlashCommand{Command: "/rota", ChannelID: "C0123", UserID: "U0123"})
+		Expect(err).To(BeNil())
+		Expect(response).To(MatchJSON(`{"text": "Ran /rota"}`))
+	})
+
+	It("Fills in modals", func() {
+		view := slack.View{Blocks: slack.Blocks{BlockSet: []slack.Block{
+			slack.NewInputBlock("name", nil, slack.NewPlainTextInputBlockElement(nil, "set_name")),
+			slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, "Skipped", false, false), nil, nil),
+			slack.NewInputBlock("members", nil, &slack.MultiSelectBlockElement{Type: slack.MultiOptTypeUser, ActionID: "set_members"}),
+			slack.NewInputBlock("length", nil, slack.NewRadioButtonsBlockElement("set_length")),
+		}}}
+
+		values := Fill(view, "Support", "U1,U2", "2")
+		Expect(values["name"]["set_name"].Value).To(Equal("Support"))
+		Expect(values["members"]["set_members"].SelectedUsers).To(Equal([]string{"U1", "U2"}))
+		Expect(values["length"]["set_length"].SelectedOption.Value).To(Equal("2"))
+	})
+})
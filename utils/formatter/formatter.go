@@ -19,5 +19,15 @@ func AtUserId(userId string) string {
 }
 
 func FormatTime(rawTime time.Time) string {
-	return rawTime.Format(time.RFC1123)
+	return rawTime.Format(time.RFC1123Z)
+}
+
+func ParseTime(formattedTime string) (time.Time, error) {
+	parsedTime, err := time.Parse(time.RFC1123Z, formattedTime)
+	if err != nil {
+		// Shifts used to be stored with a zone abbreviation rather than an
+		// offset, which only parses reliably in the server's own time zone.
+		return time.Parse(time.RFC1123, formattedTime)
+	}
+	return parsedTime, nil
 }
@@ -0,0 +1,126 @@
+package httpserver
+
+import (
+	"alfred-bot/utils/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	checkTimeout    = 2 * time.Second
+	shutdownTimeout = 5 * time.Second
+)
+
+// Check reports whether a dependency is ready to serve traffic.
+type Check func(ctx context.Context) error
+
+// Server is the bot's optional HTTP endpoint. It always serves /healthz,
+// /readyz and /metrics, and other packages can mount their own handlers on it.
+type Server struct {
+	server *http.Server
+	mux    *http.ServeMux
+	mu     sync.Mutex
+	checks map[string]Check
+}
+
+func New(addr string) *Server {
+	mux := http.NewServeMux()
+	s := &Server{
+		server: &http.Server{
+			Addr:              addr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+		mux:    mux,
+		checks: map[string]Check{},
+	}
+
+	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/readyz", s.readyz)
+	mux.Handle("/metrics", metrics.Handler())
+
+	return s
+}
+
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// AddReadinessCheck makes /readyz fail whenever check returns an error.
+func (s *Server) AddReadinessCheck(name string, check Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checks[name] = check
+}
+
+// Run serves requests until ctx is cancelled, then gives in-flight requests a
+// few seconds to finish.
+func (s *Server) Run(ctx context.Context) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := s.server.Shutdown(shutdownCtx)
+	if serveErr := <-errs; !errors.Is(serveErr, http.ErrServerClosed) && err == nil {
+		err = serveErr
+	}
+	return err
+}
+
+func (s *Server) healthz(w http.ResponseWriter, _ *http.Request) {
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.checks))
+	for name := range s.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	checks := make([]Check, 0, len(names))
+	for _, name := range names {
+		checks = append(checks, s.checks[name])
+	}
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	ready := true
+	var report strings.Builder
+	for i, check := range checks {
+		if err := check(ctx); err != nil {
+			ready = false
+			fmt.Fprintf(&report, "%s: %v\n", names[i], err)
+		} else {
+			fmt.Fprintf(&report, "%s: ok\n", names[i])
+		}
+	}
+
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = fmt.Fprint(w, report.String())
+}
@@ -0,0 +1,58 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHttpServer(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "HttpServer Suite")
+}
+
+var _ = Describe("Server", func() {
+	var server *Server
+
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder
+	}
+
+	BeforeEach(func() {
+		server = New(":0")
+	})
+
+	It("Reports itself as alive", func() {
+		res := get("/healthz")
+		Expect(res.Code).To(Equal(http.StatusOK))
+	})
+
+	It("Is ready when every check passes", func() {
+		server.AddReadinessCheck("db", func(ctx context.Context) error { return nil })
+
+		res := get("/readyz")
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Body.String()).To(Equal("db: ok\n"))
+	})
+
+	It("Is not ready when a check fails", func() {
+		server.AddReadinessCheck("db", func(ctx context.Context) error { return nil })
+		server.AddReadinessCheck("slack", func(ctx context.Context) error { return errors.New("not connected") })
+
+		res := get("/readyz")
+		Expect(res.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(res.Body.String()).To(Equal("db: ok\nslack: not connected\n"))
+	})
+
+	It("Serves metrics", func() {
+		res := get("/metrics")
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Header().Get("Content-Type")).To(ContainSubstring("text/plain"))
+	})
+})
@@ -0,0 +1,51 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// New builds a structured logger that writes records at or above level to w,
+// either as logfmt-style text or as one JSON object per line.
+func New(w io.Writer, level string, format string) (*slog.Logger, error) {
+	var slogLevel slog.Level
+	if err := slogLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	options := &slog.HandlerOptions{Level: slogLevel}
+	switch strings.ToLower(format) {
+	case FormatText, "":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q: expected %q or %q", format, FormatText, FormatJSON)
+	}
+}
+
+// Setup makes a logger built by New the process-wide default, including for
+// anything still writing through the standard log package.
+func Setup(w io.Writer, level string, format string) error {
+	l, err := New(w, level, format)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(l)
+	return nil
+}
+
+// Std adapts l for libraries that expect a *log.Logger, such as the Slack
+// client's debug output, logging every line at debug level.
+func Std(l *slog.Logger, component string) *log.Logger {
+	return slog.NewLogLogger(l.With("component", component).Handler(), slog.LevelDebug)
+}
@@ -0,0 +1,63 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Logger Suite")
+}
+
+var _ = Describe("New", func() {
+	var buf bytes.Buffer
+
+	BeforeEach(func() {
+		buf.Reset()
+	})
+
+	It("Writes JSON records with their attributes", func() {
+		l, err := New(&buf, "info", FormatJSON)
+		Expect(err).To(BeNil())
+
+		l.Info("Handed over", "channel_id", "C123", "rota_name", "payments")
+
+		var record map[string]interface{}
+		Expect(json.Unmarshal(buf.Bytes(), &record)).To(Succeed())
+		Expect(record["msg"]).To(Equal("Handed over"))
+		Expect(record["channel_id"]).To(Equal("C123"))
+		Expect(record["rota_name"]).To(Equal("payments"))
+	})
+
+	It("Drops records below the configured level", func() {
+		l, err := New(&buf, "warn", FormatText)
+		Expect(err).To(BeNil())
+
+		l.Info("Handed over")
+		Expect(buf.String()).To(BeEmpty())
+
+		l.Warn("Could not hand over")
+		Expect(buf.String()).To(ContainSubstring("Could not hand over"))
+	})
+
+	It("Rejects unknown levels and formats", func() {
+		_, err := New(&buf, "loud", FormatText)
+		Expect(err).ToNot(BeNil())
+
+		_, err = New(&buf, "info", "xml")
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("Adapts to a standard logger at debug level", func() {
+		l, err := New(&buf, "debug", FormatText)
+		Expect(err).To(BeNil())
+
+		Std(l, "socketmode").Println("Connecting")
+		Expect(buf.String()).To(ContainSubstring("level=DEBUG"))
+		Expect(buf.String()).To(ContainSubstring("component=socketmode"))
+	})
+})
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"bytes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Metrics Suite")
+}
+
+var _ = Describe("Registry", func() {
+	var registry *Registry
+
+	render := func() string {
+		var buf bytes.Buffer
+		registry.Render(&buf)
+		return buf.String()
+	}
+
+	BeforeEach(func() {
+		registry = NewRegistry()
+	})
+
+	It("Renders counters per label value", func() {
+		counter := registry.NewCounter("alfred_test_total", "Test counter.", "outcome")
+		counter.Inc("ok")
+		counter.Inc("ok")
+		counter.Inc("error")
+
+		Expect(render()).To(Equal(`# HELP alfred_test_total Test counter.
+# TYPE alfred_test_total counter
+alfred_test_total{outcome="error"} 1
+alfred_test_total{outcome="ok"} 2
+`))
+	})
+
+	It("Renders cumulative histogram buckets", func() {
+		histogram := registry.NewHistogram("alfred_test_seconds", "Test histogram.", []float64{1, 0.1})
+		histogram.Observe(0.05)
+		histogram.Observe(0.5)
+		histogram.Observe(5)
+
+		Expect(render()).To(Equal(`# HELP alfred_test_seconds Test histogram.
+# TYPE alfred_test_seconds histogram
+alfred_test_seconds_bucket{le="0.1"} 1
+alfred_test_seconds_bucket{le="1"} 2
+alfred_test_seconds_bucket{le="+Inf"} 3
+alfred_test_seconds_sum 5.55
+alfred_test_seconds_count 3
+`))
+	})
+
+	It("Escapes label values", func() {
+		counter := registry.NewCounter("alfred_test_total", "Test counter.", "rota")
+		counter.Inc("say \"hi\"\n")
+
+		Expect(render()).To(ContainSubstring(`alfred_test_total{rota="say \"hi\"\n"} 1`))
+	})
+
+	It("Rejects the wrong number of label values", func() {
+		counter := registry.NewCounter("alfred_test_total", "Test counter.", "outcome")
+		Expect(func() { counter.Inc() }).To(Panic())
+	})
+})
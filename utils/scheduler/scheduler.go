@@ -0,0 +1,158 @@
+package scheduler
+
+import (
+	"alfred-bot/utils/clock"
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// Scheduler runs jobs at the time they fall due. Jobs are kept in a min-heap
+// ordered by due time, so the run loop only wakes up when the earliest job is
+// due or when the schedule changes.
+type Scheduler struct {
+	clock clock.Clock
+	mu    sync.Mutex
+	queue jobQueue
+	jobs  map[string]*job
+	wake  chan struct{}
+}
+
+type job struct {
+	id    string
+	due   time.Time
+	fn    func()
+	index int
+}
+
+func New(clock clock.Clock) *Scheduler {
+	return &Scheduler{
+		clock: clock,
+		jobs:  map[string]*job{},
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// Schedule registers fn to run at due. Scheduling an id that is already
+// pending replaces the previous job.
+func (s *Scheduler) Schedule(id string, due time.Time, fn func()) {
+	s.mu.Lock()
+	if j, ok := s.jobs[id]; ok {
+		j.due = due
+		j.fn = fn
+		heap.Fix(&s.queue, j.index)
+	} else {
+		j = &job{id: id, due: due, fn: fn}
+		heap.Push(&s.queue, j)
+		s.jobs[id] = j
+	}
+	s.mu.Unlock()
+
+	s.notify()
+}
+
+// Cancel removes a pending job. It is a no-op if the job is unknown or has
+// already run.
+func (s *Scheduler) Cancel(id string) {
+	s.mu.Lock()
+	if j, ok := s.jobs[id]; ok {
+		heap.Remove(&s.queue, j.index)
+		delete(s.jobs, id)
+	}
+	s.mu.Unlock()
+
+	s.notify()
+}
+
+// Next returns the due time of the earliest pending job.
+func (s *Scheduler) Next() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queue) == 0 {
+		return time.Time{}, false
+	}
+	return s.queue[0].due, true
+}
+
+// Run executes jobs as they fall due until ctx is cancelled. Jobs run one at a
+// time on the calling goroutine.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		var timer clock.Timer
+		var timerC <-chan time.Time
+		if due, ok := s.Next(); ok {
+			timer = s.clock.TimerAt(due)
+			timerC = timer.C()
+		}
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-s.wake:
+		case <-timerC:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+
+		for ctx.Err() == nil {
+			j := s.popDue(s.clock.Now())
+			if j == nil {
+				break
+			}
+			j.fn()
+		}
+	}
+}
+
+func (s *Scheduler) popDue(now time.Time) *job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queue) == 0 || s.queue[0].due.After(now) {
+		return nil
+	}
+	j := heap.Pop(&s.queue).(*job)
+	delete(s.jobs, j.id)
+	return j
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+type jobQueue []*job
+
+func (q jobQueue) Len() int { return len(q) }
+
+func (q jobQueue) Less(i, j int) bool { return q[i].due.Before(q[j].due) }
+
+func (q jobQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *jobQueue) Push(x interface{}) {
+	j := x.(*job)
+	j.index = len(*q)
+	*q = append(*q, j)
+}
+
+func (q *jobQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	j := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return j
+}
@@ -0,0 +1,100 @@
+package scheduler
+
+import (
+	"alfred-bot/utils/clock"
+	"context"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Scheduler Suite")
+}
+
+var _ = Describe("Scheduler", func() {
+	var s *Scheduler
+	var fakeClock *clock.Fake
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var stopped chan struct{}
+	var mu sync.Mutex
+	var ran []string
+
+	record := func(id string) func() {
+		return func() {
+			mu.Lock()
+			defer mu.Unlock()
+			ran = append(ran, id)
+		}
+	}
+
+	ranJobs := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, ran...)
+	}
+
+	BeforeEach(func() {
+		fakeClock = clock.NewFake(time.Date(2022, time.May, 2, 9, 0, 0, 0, time.UTC))
+		s = New(fakeClock)
+		ran = nil
+		ctx, cancel = context.WithCancel(context.Background())
+		stopped = make(chan struct{})
+		go func() {
+			s.Run(ctx)
+			close(stopped)
+		}()
+	})
+
+	AfterEach(func() {
+		cancel()
+		Eventually(stopped).Should(BeClosed())
+	})
+
+	It("Runs jobs in the order they fall due", func() {
+		now := fakeClock.Now()
+		s.Schedule("b", now.Add(2*time.Hour), record("b"))
+		s.Schedule("a", now.Add(time.Hour), record("a"))
+		s.Schedule("c", now.Add(-time.Second), record("c"))
+
+		Eventually(ranJobs).Should(Equal([]string{"c"}))
+
+		fakeClock.Advance(time.Hour)
+		Eventually(ranJobs).Should(Equal([]string{"c", "a"}))
+
+		fakeClock.Advance(time.Hour)
+		Eventually(ranJobs).Should(Equal([]string{"c", "a", "b"}))
+	})
+
+	It("Replaces a pending job that is scheduled again", func() {
+		s.Schedule("a", fakeClock.Now().Add(time.Hour), record("first"))
+		s.Schedule("a", fakeClock.Now(), record("second"))
+
+		Eventually(ranJobs).Should(Equal([]string{"second"}))
+		_, pending := s.Next()
+		Expect(pending).To(BeFalse())
+	})
+
+	It("Does not run cancelled jobs", func() {
+		s.Schedule("a", fakeClock.Now().Add(time.Minute), record("a"))
+		s.Schedule("b", fakeClock.Now().Add(2*time.Minute), record("b"))
+		s.Cancel("a")
+
+		fakeClock.Advance(time.Hour)
+		Eventually(ranJobs).Should(Equal([]string{"b"}))
+		Consistently(ranJobs, 50*time.Millisecond).Should(Equal([]string{"b"}))
+	})
+
+	It("Stops once the context is cancelled", func() {
+		s.Schedule("a", fakeClock.Now().Add(time.Hour), record("a"))
+		cancel()
+		fakeClock.Advance(time.Hour)
+
+		Eventually(stopped).Should(BeClosed())
+		Expect(ranJobs()).To(BeEmpty())
+	})
+})
@@ -1,32 +1,227 @@
 package slackclient
 
-import "github.com/slack-go/slack"
+import (
+	"alfred-bot/utils/metrics"
+	"errors"
+	"github.com/slack-go/slack"
+	"log/slog"
+	"math/rand"
+	"net"
+	"time"
+)
+
+const (
+	// maxAttempts bounds how many times each call is made.
+	maxAttempts = 4
+	// retryBudget bounds how long a call waits between attempts in all. Calls
+	// are made while a user waits on them, or while a worker holds up the
+	// rest of a rota's requests, so it's better to fail than to wait out a
+	// long rate limit. Messages no one is waiting on are retried later by
+	// the outbox instead.
+	retryBudget = 2 * time.Second
+	// baseBackoff is how long to wait before retrying a transient error the
+	// first time. It doubles with each attempt.
+	baseBackoff = 500 * time.Millisecond
+)
+
+// transientErrors are the errors Slack returns for failures on its side, which
+// are worth trying again.
+var transientErrors = map[string]bool{
+	"internal_error":      true,
+	"fatal_error":         true,
+	"service_unavailable": true,
+	"request_timeout":     true,
+	"ratelimited":         true,
+}
+
+var (
+	callDuration = metrics.NewHistogram(
+		"alfred_slack_request_duration_seconds",
+		"Latency of Slack Web API calls, by method.",
+		metrics.DefBuckets,
+		"method",
+	)
+	callErrors = metrics.NewCounter(
+		"alfred_slack_request_errors_total",
+		"Slack Web API calls that returned an error, by method.",
+		"method",
+	)
+	callRetries = metrics.NewCounter(
+		"alfred_slack_request_retries_total",
+		"Slack Web API calls retried, by method and reason.",
+		"method", "reason",
+	)
+)
 
 type SlackClient interface {
 	PostMessage(channelID string, attachment slack.Attachment) (string, string, error)
 	PostEphemeral(channelID string, userID string, attachment slack.Attachment) (string, error)
+	PostReply(channelID string, threadTimestamp string, attachment slack.Attachment) (string, string, error)
+	UpdateMessage(channelID string, timestamp string, attachment slack.Attachment) (string, string, string, error)
 	OpenView(triggerID string, view slack.ModalViewRequest) (*slack.ViewResponse, error)
+	PublishView(userID string, view slack.HomeTabViewRequest) (*slack.ViewResponse, error)
+	GetUserInfo(userID string) (*slack.User, error)
+	GetUserByEmail(email string) (*slack.User, error)
 }
 
+// SlackWrapper retries calls that were rate limited, waiting as long as Slack
+// asks, and calls that failed on Slack's side or on the way there, backing off
+// with jitter between attempts, as long as that fits in retryBudget.
 type SlackWrapper struct {
 	client *slack.Client
+	sleep  func(d time.Duration)
 	_      func(channelID string, attachment slack.Attachment) (string, string, error)
 	_      func(channelID string, userID string, attachment slack.Attachment) (string, error)
+	_      func(channelID string, threadTimestamp string, attachment slack.Attachment) (string, string, error)
+	_      func(channelID string, timestamp string, attachment slack.Attachment) (string, string, string, error)
 	_      func(triggerID string, view slack.ModalViewRequest) (*slack.ViewResponse, error)
+	_      func(userID string, view slack.HomeTabViewRequest) (*slack.ViewResponse, error)
+	_      func(userID string) (*slack.User, error)
+	_      func(email string) (*slack.User, error)
 }
 
 func New(client *slack.Client) *SlackWrapper {
-	return &SlackWrapper{client: client}
+	return &SlackWrapper{client: client, sleep: time.Sleep}
+}
+
+func (w *SlackWrapper) PostMessage(channelID string, attachment slack.Attachment) (respChannel string, respTimestamp string, err error) {
+	err = w.call("chat.postMessage", func() (err error) {
+		respChannel, respTimestamp, err = w.client.PostMessage(channelID, slack.MsgOptionAttachments(attachment))
+		return err
+	})
+	return
+}
+
+func (w *SlackWrapper) PostEphemeral(channelID string, userID string, attachment slack.Attachment) (respTimestamp string, err error) {
+	err = w.call("chat.postEphemeral", func() (err error) {
+		respTimestamp, err = w.client.PostEphemeral(channelID, userID, slack.MsgOptionAttachments(attachment))
+		return err
+	})
+	return
+}
+
+func (w *SlackWrapper) PostReply(channelID string, threadTimestamp string, attachment slack.Attachment) (respChannel string, respTimestamp string, err error) {
+	err = w.call("chat.postMessage", func() (err error) {
+		respChannel, respTimestamp, err = w.client.PostMessage(channelID, slack.MsgOptionAttachments(attachment), slack.MsgOptionTS(threadTimestamp))
+		return err
+	})
+	return
+}
+
+func (w *SlackWrapper) UpdateMessage(channelID string, timestamp string, attachment slack.Attachment) (respChannel string, respTimestamp string, respText string, err error) {
+	err = w.call("chat.update", func() (err error) {
+		respChannel, respTimestamp, respText, err = w.client.UpdateMessage(channelID, timestamp, slack.MsgOptionAttachments(attachment))
+		return err
+	})
+	return
+}
+
+func (w *SlackWrapper) OpenView(triggerID string, view slack.ModalViewRequest) (resp *slack.ViewResponse, err error) {
+	// A trigger ID expires three seconds after the interaction that gave it,
+	// so a retry would be too late.
+	err = w.callOnce("views.open", func() (err error) {
+		resp, err = w.client.OpenView(triggerID, view)
+		return err
+	})
+	return
+}
+
+func (w *SlackWrapper) PublishView(userID string, view slack.HomeTabViewRequest) (resp *slack.ViewResponse, err error) {
+	err = w.call("views.publish", func() (err error) {
+		resp, err = w.client.PublishView(userID, view, "")
+		return err
+	})
+	return
+}
+
+func (w *SlackWrapper) GetUserInfo(userID string) (user *slack.User, err error) {
+	err = w.call("users.info", func() (err error) {
+		user, err = w.client.GetUserInfo(userID)
+		return err
+	})
+	return
+}
+
+func (w *SlackWrapper) GetUserByEmail(email string) (user *slack.User, err error) {
+	err = w.call("users.lookupByEmail", func() (err error) {
+		user, err = w.client.GetUserByEmail(email)
+		return err
+	})
+	return
 }
 
-func (w *SlackWrapper) PostMessage(channelID string, attachment slack.Attachment) (string, string, error) {
-	return w.client.PostMessage(channelID, slack.MsgOptionAttachments(attachment))
+// call makes a Slack call until it succeeds, fails for good, has been made
+// maxAttempts times, or would have to wait past retryBudget.
+func (w *SlackWrapper) call(method string, f func() error) error {
+	return w.callWithin(method, retryBudget, f)
 }
 
-func (w *SlackWrapper) PostEphemeral(channelID string, userID string, attachment slack.Attachment) (string, error) {
-	return w.client.PostEphemeral(channelID, userID, slack.MsgOptionAttachments(attachment))
+// callOnce makes a Slack call that isn't worth retrying.
+func (w *SlackWrapper) callOnce(method string, f func() error) error {
+	return w.callWithin(method, 0, f)
 }
 
-func (w *SlackWrapper) OpenView(triggerID string, view slack.ModalViewRequest) (*slack.ViewResponse, error) {
-	return w.client.OpenView(triggerID, view)
+func (w *SlackWrapper) callWithin(method string, budget time.Duration, f func() error) error {
+	var err error
+	var waited time.Duration
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err = f()
+		callDuration.Observe(metrics.Since(start), method)
+		if err == nil {
+			return nil
+		}
+
+		callErrors.Inc(method)
+
+		wait, reason := retryAfter(err, attempt)
+		if reason == "" || attempt == maxAttempts || waited+wait > budget {
+			return err
+		}
+		waited += wait
+
+		callRetries.Inc(method, reason)
+		slog.Warn("Retrying Slack call", "method", method, "attempt", attempt, "wait", wait, "error", err)
+		w.sleep(wait)
+	}
+}
+
+// retryAfter returns how long to wait before trying a failed call again, and
+// why. The reason is empty if the call shouldn't be tried again.
+func retryAfter(err error, attempt int) (time.Duration, string) {
+	var rateLimited *slack.RateLimitedError
+	if errors.As(err, &rateLimited) {
+		return rateLimited.RetryAfter, "rate_limited"
+	}
+
+	if !Temporary(err) {
+		return 0, ""
+	}
+
+	// Wait somewhere between half and all of the backoff, so that calls which
+	// failed together don't all retry together.
+	backoff := baseBackoff << (attempt - 1)
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1)), "transient"
+}
+
+// Temporary reports whether a call that failed with err might succeed if it's
+// made again later: it was rate limited, or failed on Slack's side or on the
+// way there.
+func Temporary(err error) bool {
+	var rateLimited *slack.RateLimitedError
+	var slackErr slack.SlackErrorResponse
+	var retryable interface{ Retryable() bool }
+	var netErr net.Error
+	switch {
+	case errors.As(err, &rateLimited):
+		return true
+	case errors.As(err, &slackErr):
+		return transientErrors[slackErr.Err]
+	case errors.As(err, &retryable):
+		return retryable.Retryable()
+	case errors.As(err, &netErr):
+		return true
+	}
+
+	return false
 }
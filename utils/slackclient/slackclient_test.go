@@ -0,0 +1,129 @@
+package slackclient
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/slack-go/slack"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSlackClient(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "SlackClient Suite")
+}
+
+var _ = Describe("SlackWrapper", func() {
+	var responses []func(w http.ResponseWriter)
+	var calls int
+	var waits []time.Duration
+	var client *SlackWrapper
+
+	ok := func(w http.ResponseWriter) {
+		_, _ = w.Write([]byte(`{"ok": true, "channel": "C0123", "ts": "1646643600.000100"}`))
+	}
+	slackError := func(code string) func(w http.ResponseWriter) {
+		return func(w http.ResponseWriter) {
+			_, _ = w.Write([]byte(`{"ok": false, "error": "` + code + `"}`))
+		}
+	}
+	rateLimited := func(retryAfter string) func(w http.ResponseWriter) {
+		return func(w http.ResponseWriter) {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}
+	unavailable := func(w http.ResponseWriter) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	BeforeEach(func() {
+		responses = nil
+		calls = 0
+		waits = nil
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			respond := ok
+			if calls < len(responses) {
+				respond = responses[calls]
+			}
+			calls++
+			respond(w)
+		}))
+		DeferCleanup(server.Close)
+
+		client = New(slack.New("xoxb-test", slack.OptionAPIURL(server.URL+"/api/")))
+		client.sleep = func(d time.Duration) {
+			waits = append(waits, d)
+		}
+	})
+
+	post := func() error {
+		_, _, err := client.PostMessage("C0123", slack.Attachment{Text: "Hello"})
+		return err
+	}
+
+	It("Waits as long as Slack asks when rate limited", func() {
+		responses = append(responses, rateLimited("1"), rateLimited("1"))
+
+		Expect(post()).To(Succeed())
+		Expect(calls).To(Equal(3))
+		Expect(waits).To(Equal([]time.Duration{time.Second, time.Second}))
+	})
+
+	It("Backs off with jitter after errors on Slack's side", func() {
+		responses = append(responses, unavailable, slackError("internal_error"))
+
+		Expect(post()).To(Succeed())
+		Expect(calls).To(Equal(3))
+		Expect(waits).To(HaveLen(2))
+		for i, wait := range waits {
+			backoff := baseBackoff << i
+			Expect(wait).To(BeNumerically(">=", backoff/2))
+			Expect(wait).To(BeNumerically("<=", backoff))
+		}
+	})
+
+	It("Gives up rather than wait long", func() {
+		responses = append(responses, unavailable, unavailable, unavailable, unavailable, unavailable)
+
+		Expect(post()).ToNot(Succeed())
+		Expect(calls).To(BeNumerically("<=", maxAttempts))
+		var waited time.Duration
+		for _, wait := range waits {
+			waited += wait
+		}
+		Expect(waited).To(BeNumerically("<=", retryBudget))
+	})
+
+	It("Never retries opening modals, since the trigger will have expired", func() {
+		responses = append(responses, unavailable)
+
+		_, err := client.OpenView("trigger", slack.ModalViewRequest{Type: slack.VTModal})
+		Expect(err).ToNot(BeNil())
+		Expect(calls).To(Equal(1))
+		Expect(waits).To(BeEmpty())
+	})
+
+	It("Doesn't retry errors that would only happen again", func() {
+		responses = append(responses, slackError("channel_not_found"))
+
+		err := post()
+		Expect(err).To(MatchError("channel_not_found"))
+		Expect(Temporary(err)).To(BeFalse())
+		Expect(calls).To(Equal(1))
+	})
+
+	It("Doesn't wait out long rate limits, but reports they'll pass", func() {
+		responses = append(responses, rateLimited("30"))
+
+		err := post()
+		Expect(err).ToNot(BeNil())
+		Expect(Temporary(err)).To(BeTrue())
+		Expect(calls).To(Equal(1))
+		Expect(waits).To(BeEmpty())
+	})
+})
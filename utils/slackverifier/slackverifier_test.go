@@ -0,0 +1,92 @@
+package slackverifier
+
+import (
+	"alfred-bot/utils/clock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlackVerifier(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "SlackVerifier Suite")
+}
+
+var _ = Describe("Verifier", func() {
+	var fakeClock *clock.Fake
+	var handler http.Handler
+	var received []string
+
+	send := func(timestamp time.Time, body string, secret string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/slack/events", strings.NewReader(body))
+		ts := strconv.FormatInt(timestamp.Unix(), 10)
+		r.Header.Set(TimestampHeader, ts)
+		r.Header.Set(SignatureHeader, Sign(secret, ts, []byte(body)))
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	BeforeEach(func() {
+		fakeClock = clock.NewFake(time.Date(2022, time.March, 7, 9, 0, 0, 0, time.UTC))
+		received = nil
+		handler = New("shh", fakeClock).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			received = append(received, string(body))
+		}))
+	})
+
+	It("Passes on signed requests with their body", func() {
+		Expect(send(fakeClock.Now(), `{"type":"event_callback"}`, "shh").Code).To(Equal(http.StatusOK))
+		Expect(received).To(Equal([]string{`{"type":"event_callback"}`}))
+	})
+
+	It("Turns away requests signed with another secret or tampered with", func() {
+		Expect(send(fakeClock.Now(), "token=abc", "guess").Code).To(Equal(http.StatusUnauthorized))
+
+		r := httptest.NewRequest(http.MethodPost, "/slack/commands", strings.NewReader("text=stop"))
+		ts := strconv.FormatInt(fakeClock.Now().Unix(), 10)
+		r.Header.Set(TimestampHeader, ts)
+		r.Header.Set(SignatureHeader, Sign("shh", ts, []byte("text=start")))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+
+		Expect(received).To(BeEmpty())
+	})
+
+	It("Turns away requests signed too long ago or in the future", func() {
+		Expect(send(fakeClock.Now().Add(-6*time.Minute), "a=1", "shh").Code).To(Equal(http.StatusUnauthorized))
+		Expect(send(fakeClock.Now().Add(6*time.Minute), "a=2", "shh").Code).To(Equal(http.StatusUnauthorized))
+		Expect(send(fakeClock.Now().Add(-4*time.Minute), "a=3", "shh").Code).To(Equal(http.StatusOK))
+		Expect(received).To(Equal([]string{"a=3"}))
+	})
+
+	It("Only lets each request through once", func() {
+		sentAt := fakeClock.Now()
+		Expect(send(sentAt, "a=1", "shh").Code).To(Equal(http.StatusOK))
+
+		fakeClock.Advance(time.Minute)
+		Expect(send(sentAt, "a=1", "shh").Code).To(Equal(http.StatusUnauthorized))
+		Expect(send(fakeClock.Now(), "a=1", "shh").Code).To(Equal(http.StatusOK))
+
+		// Long after, the first is turned away for its age instead.
+		fakeClock.Advance(10 * time.Minute)
+		Expect(send(sentAt, "a=1", "shh").Code).To(Equal(http.StatusUnauthorized))
+		Expect(send(fakeClock.Now(), "a=1", "shh").Code).To(Equal(http.StatusOK))
+		Expect(received).To(HaveLen(3))
+	})
+
+	It("Only accepts POSTs", func() {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/slack/events", nil))
+		Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
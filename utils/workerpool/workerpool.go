@@ -0,0 +1,130 @@
+package workerpool
+
+import (
+	"alfred-bot/utils/metrics"
+	"errors"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// ErrStopped is returned for jobs submitted once the pool has been stopped.
+var ErrStopped = errors.New("worker pool is stopped")
+
+var waitDuration = metrics.NewHistogram(
+	"alfred_worker_wait_seconds",
+	"Time jobs spent waiting for a worker.",
+	metrics.DefBuckets,
+)
+
+// Pool runs jobs on a fixed number of workers. Jobs with the same key run one
+// at a time, in the order they were submitted, while jobs with different keys
+// run in parallel. Keys take turns, one job at a time, so a key with a long
+// queue can't hold up the others.
+type Pool struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	// pending holds each key's jobs that haven't started, oldest first.
+	pending map[string][]job
+	// ready holds the keys with pending jobs and none running, in the order
+	// they'll be picked up.
+	ready   []string
+	stopped bool
+	workers sync.WaitGroup
+}
+
+type job struct {
+	run         func()
+	submittedAt time.Time
+}
+
+func New(workers int) *Pool {
+	p := &Pool{pending: map[string][]job{}}
+	p.cond = sync.NewCond(&p.mu)
+
+	p.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+// Submit queues run behind the other jobs with the same key.
+func (p *Pool) Submit(key string, run func()) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return ErrStopped
+	}
+
+	_, waiting := p.pending[key]
+	p.pending[key] = append(p.pending[key], job{run: run, submittedAt: time.Now()})
+
+	// A key that's already waiting, or running, is picked up again in turn.
+	if !waiting {
+		p.ready = append(p.ready, key)
+		p.cond.Signal()
+	}
+
+	return nil
+}
+
+// Stop turns away new jobs, and returns once every job already submitted has
+// run.
+func (p *Pool) Stop() {
+	p.mu.Lock()
+	p.stopped = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	p.workers.Wait()
+}
+
+func (p *Pool) work() {
+	defer p.workers.Done()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		for len(p.ready) == 0 && !p.stopped {
+			p.cond.Wait()
+		}
+
+		// Keys still running are finished by the worker running them.
+		if len(p.ready) == 0 {
+			return
+		}
+
+		key := p.ready[0]
+		p.ready = p.ready[1:]
+		next := p.pending[key][0]
+		p.pending[key] = p.pending[key][1:]
+
+		p.mu.Unlock()
+		waitDuration.Observe(metrics.Since(next.submittedAt))
+		run(key, next.run)
+		p.mu.Lock()
+
+		if len(p.pending[key]) > 0 {
+			p.ready = append(p.ready, key)
+			p.cond.Signal()
+		} else {
+			delete(p.pending, key)
+		}
+	}
+}
+
+// run keeps a worker going if its job panics.
+func run(key string, f func()) {
+	defer func() {
+		if v := recover(); v != nil {
+			slog.Error("Recovered from panic in worker", "key", key, "panic", v, "stack", string(debug.Stack()))
+		}
+	}()
+
+	f()
+}
@@ -0,0 +1,161 @@
+package workerpool
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "WorkerPool Suite")
+}
+
+// recorder notes the order jobs ran in.
+type recorder struct {
+	mu  sync.Mutex
+	ran []string
+}
+
+func (r *recorder) job(name string) func() {
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.ran = append(r.ran, name)
+	}
+}
+
+func (r *recorder) jobs() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.ran...)
+}
+
+func indexOf(jobs []string, name string) int {
+	for i, job := range jobs {
+		if job == name {
+			return i
+		}
+	}
+	return -1
+}
+
+var _ = Describe("Pool", func() {
+	var ran *recorder
+
+	BeforeEach(func() {
+		ran = &recorder{}
+	})
+
+	It("Runs jobs with the same key one at a time, in order", func() {
+		pool := New(8)
+
+		var mu sync.Mutex
+		running := 0
+		overlapped := false
+		for i := 0; i < 50; i++ {
+			name := string(rune('a' + i%26))
+			Expect(pool.Submit("C0123/Support", func() {
+				mu.Lock()
+				running++
+				overlapped = overlapped || running > 1
+				mu.Unlock()
+
+				time.Sleep(time.Millisecond)
+				ran.job(name)()
+
+				mu.Lock()
+				running--
+				mu.Unlock()
+			})).To(Succeed())
+		}
+		pool.Stop()
+
+		Expect(overlapped).To(BeFalse())
+		var expected []string
+		for i := 0; i < 50; i++ {
+			expected = append(expected, string(rune('a'+i%26)))
+		}
+		Expect(ran.jobs()).To(Equal(expected))
+	})
+
+	It("Runs jobs with different keys in parallel", func() {
+		pool := New(2)
+		defer pool.Stop()
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		Expect(pool.Submit("C0123/Support", func() {
+			started <- struct{}{}
+			<-release
+		})).To(Succeed())
+		Expect(pool.Submit("C0123/Releases", func() {
+			started <- struct{}{}
+			<-release
+		})).To(Succeed())
+
+		Eventually(started).Should(Receive())
+		Eventually(started).Should(Receive())
+		close(release)
+	})
+
+	It("Takes turns between keys, so a busy one can't hold up the others", func() {
+		pool := New(1)
+
+		// Hold the only worker while the queues fill up.
+		release := make(chan struct{})
+		Expect(pool.Submit("blocker", func() { <-release })).To(Succeed())
+		for _, name := range []string{"a1", "a2", "a3", "a4"} {
+			Expect(pool.Submit("a", ran.job(name))).To(Succeed())
+		}
+		Expect(pool.Submit("b", ran.job("b1"))).To(Succeed())
+		Expect(pool.Submit("c", ran.job("c1"))).To(Succeed())
+		Expect(pool.Submit("b", ran.job("b2"))).To(Succeed())
+		close(release)
+		pool.Stop()
+
+		Expect(ran.jobs()).To(Equal([]string{"a1", "b1", "c1", "a2", "b2", "a3", "a4"}))
+	})
+
+	It("Runs the jobs already submitted before stopping, and turns away new ones", func() {
+		pool := New(2)
+
+		release := make(chan struct{})
+		Expect(pool.Submit("a", func() { <-release })).To(Succeed())
+		for _, name := range []string{"a1", "a2", "b1", "c1"} {
+			Expect(pool.Submit(name[:1], ran.job(name))).To(Succeed())
+		}
+
+		stopped := make(chan struct{})
+		go func() {
+			pool.Stop()
+			close(stopped)
+		}()
+
+		Eventually(func() bool {
+			pool.mu.Lock()
+			defer pool.mu.Unlock()
+			return pool.stopped
+		}).Should(BeTrue())
+		Expect(pool.Submit("d", ran.job("d1"))).To(MatchError(ErrStopped))
+		Consistently(stopped, 50*time.Millisecond).ShouldNot(BeClosed())
+
+		close(release)
+		Eventually(stopped).Should(BeClosed())
+		jobs := ran.jobs()
+		Expect(jobs).To(ConsistOf("a1", "a2", "b1", "c1"))
+		Expect(indexOf(jobs, "a1")).To(BeNumerically("<", indexOf(jobs, "a2")))
+	})
+
+	It("Keeps going after a job panics", func() {
+		pool := New(1)
+
+		Expect(pool.Submit("a", func() { panic("boom") })).To(Succeed())
+		Expect(pool.Submit("a", ran.job("a1"))).To(Succeed())
+		pool.Stop()
+
+		Expect(ran.jobs()).To(Equal([]string{"a1"}))
+	})
+})